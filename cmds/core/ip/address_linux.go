@@ -11,21 +11,25 @@ import (
 	"strconv"
 
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 const addressHelp = `Usage: ip address {add|replace} ADDR dev IFNAME [ LIFETIME ]
+                                                      [ CONFFLAG-LIST ]
 
        ip address del IFADDR dev IFNAME 
 
        ip address flush dev IFNAME [ scope SCOPE-ID ] [ label LABEL ]
 
-       ip address [ show [ dev IFNAME ] [ type TYPE ]
+       ip address [ show [ dev IFNAME ] [ type TYPE ] [ up ] [ primary | secondary ] ]
 
 	   ip address help
 
 SCOPE-ID := [ host | link | global | NUMBER ]
 LIFETIME := [ valid_lft LFT ] [ preferred_lft LFT ]
 LFT := forever | SECONDS
+CONFFLAG-LIST := [ CONFFLAG-LIST ] CONFFLAG
+CONFFLAG  := [ home | nodad | mngtmpaddr ]
 TYPE := { bareudp | bond | bond_slave | bridge | bridge_slave |
           dummy | erspan | geneve | gre | gretap | ifb |
           ip6erspan | ip6gre | ip6gretap | ip6tnl |
@@ -42,6 +46,27 @@ var stringScope = map[string]netlink.Scope{
 	"link":   netlink.SCOPE_LINK,
 }
 
+// addrConfFlags are the IPv6 address flags that can be set on add, by their
+// names in show output, in the order iproute2 shows them.
+var addrConfFlags = []struct {
+	name string
+	flag int
+}{
+	{"nodad", unix.IFA_F_NODAD},
+	{"home", unix.IFA_F_HOMEADDRESS},
+	{"mngtmpaddr", unix.IFA_F_MANAGETEMPADDR},
+}
+
+// addrConfFlag returns the flag named name, or 0 if there is none.
+func addrConfFlag(name string) int {
+	for _, f := range addrConfFlags {
+		if f.name == name {
+			return f.flag
+		}
+	}
+	return 0
+}
+
 func (cmd *cmd) address() error {
 	if !cmd.tokenRemains() {
 		return cmd.showAllLinks(true)
@@ -107,7 +132,12 @@ func (cmd *cmd) parseAddrAddReplace() (netlink.Link, *netlink.Addr, error) {
 	}
 
 	for cmd.tokenRemains() {
-		switch cmd.nextToken("valid_lft", "preferred_lft") {
+		switch token := cmd.nextToken("valid_lft", "preferred_lft", "nodad", "home", "mngtmpaddr"); token {
+		case "nodad", "home", "mngtmpaddr":
+			if addr.IP.To4() != nil {
+				return nil, nil, fmt.Errorf("%s is only valid for IPv6 addresses", token)
+			}
+			addr.Flags |= addrConfFlag(token)
 		case "valid_lft":
 			validLft := cmd.nextToken("LFT")
 			if validLft != "forever" {
@@ -140,6 +170,9 @@ func (cmd *cmd) addressShow() error {
 	device, typeName, err := cmd.parseAddrShow()
 	if err != nil {
 		if errors.Is(err, ErrNotFound) {
+			if typeName != "" {
+				return cmd.showAllLinks(true, typeName)
+			}
 			return cmd.showAllLinks(true)
 		}
 
@@ -149,14 +182,34 @@ func (cmd *cmd) addressShow() error {
 	return cmd.showLink(device, true, typeName)
 }
 
+// parseAddrShow parses the device, type, up, and primary or secondary filters
+// of `ip addr show`.
+// ErrNotFound is returned if no device is given.
 func (cmd *cmd) parseAddrShow() (netlink.Link, string, error) {
-	device, err := cmd.parseDeviceName(false)
-	if err != nil {
-		return nil, "", err
+	var (
+		device   netlink.Link
+		typeName string
+		err      error
+	)
+	for cmd.tokenRemains() {
+		switch cmd.peekToken("dev", "type", "up", "primary", "secondary", "device-name") {
+		case "up":
+			cmd.Cursor++
+			cmd.upOnly = true
+		case "primary", "secondary":
+			cmd.addrRole = cmd.nextToken()
+		case "type":
+			if typeName, err = cmd.parseType(); err != nil {
+				return nil, "", err
+			}
+		default:
+			if device, err = cmd.parseDeviceName(false); err != nil {
+				return nil, "", err
+			}
+		}
 	}
-	typeName, err := cmd.parseType()
-	if err != nil {
-		return nil, "", err
+	if device == nil {
+		return nil, typeName, ErrNotFound
 	}
 
 	return device, typeName, nil
@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 func TestParseAddrAddReplace(t *testing.T) {
@@ -18,6 +19,7 @@ func TestParseAddrAddReplace(t *testing.T) {
 		cmd              cmd
 		wantValidLft     int
 		wantPreferredLft int
+		wantFlags        int
 		wantErr          bool
 	}{
 		{
@@ -64,6 +66,24 @@ func TestParseAddrAddReplace(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "conf flags",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "addr", "add", "2001:db8::1/64", "dev", "lo", "nodad", "home", "mngtmpaddr"},
+				Out:    new(bytes.Buffer),
+			},
+			wantFlags: unix.IFA_F_NODAD | unix.IFA_F_HOMEADDRESS | unix.IFA_F_MANAGETEMPADDR,
+		},
+		{
+			name: "nodad on IPv4",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "addr", "add", "127.0.0.1/24", "dev", "lo", "nodad"},
+				Out:    new(bytes.Buffer),
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid addr",
 			cmd: cmd{
@@ -98,6 +118,9 @@ func TestParseAddrAddReplace(t *testing.T) {
 				if addr.PreferedLft != tt.wantPreferredLft {
 					t.Errorf("preferred_lft = %v, want %v", addr.PreferedLft, tt.wantPreferredLft)
 				}
+				if addr.Flags != tt.wantFlags {
+					t.Errorf("flags = %#x, want %#x", addr.Flags, tt.wantFlags)
+				}
 
 			}
 		})
@@ -110,6 +133,8 @@ func TestParseAddrShow(t *testing.T) {
 		cmd      cmd
 		dev      string
 		typeName string
+		upOnly   bool
+		addrRole string
 		wantErr  bool
 	}{
 		{
@@ -131,11 +156,47 @@ func TestParseAddrShow(t *testing.T) {
 			dev:      "lo",
 			typeName: "bridge",
 		},
+		{
+			name: "up",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "addr", "show", "up", "lo"},
+				Out:    new(bytes.Buffer),
+			},
+			dev:    "lo",
+			upOnly: true,
+		},
+		{
+			name: "secondary",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "addr", "show", "dev", "lo", "secondary"},
+				Out:    new(bytes.Buffer),
+			},
+			dev:      "lo",
+			addrRole: "secondary",
+		},
+		{
+			name: "up without device",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "addr", "show", "type", "bridge", "up"},
+				Out:    new(bytes.Buffer),
+			},
+			upOnly:  true,
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			link, typeStr, err := tt.cmd.parseAddrShow()
+			if tt.cmd.upOnly != tt.upOnly {
+				t.Errorf("parseAddrShow() upOnly = %t, want %t", tt.cmd.upOnly, tt.upOnly)
+			}
+			if tt.cmd.addrRole != tt.addrRole {
+				t.Errorf("parseAddrShow() addrRole = %q, want %q", tt.cmd.addrRole, tt.addrRole)
+			}
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseAddrShow() error = %v, wantErr %t", err, tt.wantErr)
 			}
@@ -8,16 +8,19 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/u-root/u-root/pkg/uroot/unixflag"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
 	"github.com/vishvananda/netns"
 	"golang.org/x/sys/unix"
 )
@@ -36,6 +39,10 @@ type flags struct {
 	Iec            bool
 	JSON           bool
 	Prettify       bool
+	Indent         string
+	Sorted         bool
+	Envelope       bool
+	DryRun         bool
 	Brief          bool
 	Resolve        bool
 	Color          string
@@ -52,15 +59,16 @@ type flags struct {
 
 const ipHelp = `Usage: ip [ OPTIONS ] OBJECT { COMMAND | help }
 where  OBJECT := { address |  help | link | monitor | neighbor | neighbour |
-				   route | rule | tap | tcpmetrics |
+				   netns | nexthop | route | rule | tap | tcpmetrics |
                    token | tunnel | tuntap | vrf | xfrm }
        OPTIONS := { -s[tatistics] | -d[etails] | -r[esolve] |
                     -h[uman-readable] | -iec | -j[son] | -p[retty] |
+                    -indent STRING | -sorted | -envelope | -dry-run |
                     -f[amily] { inet | inet6 | mpls | bridge | link } |
                     -4 | -6 | -M | -B | -0 |
                     -l[oops] { maximum-addr-flush-attempts } | -br[ief] |
                     -o[neline] | -t[imestamp] | -ts[hort] | -b[atch] [filename] |
-                    -rc[vbuf] [size] | -n[etns] name | -N[umeric] | -a[ll] |
+                    -rc[vbuf] [size] | -n[etns] { name | path } | -N[umeric] | -a[ll] |
                     -c[olor]}`
 
 // The language implemented by the standard 'ip' is not super consistent
@@ -124,8 +132,12 @@ func parseFlags(args []string, out io.Writer) (cmd, error) {
 	fs.BoolVar(&cmd.Opts.Brief, "brief", false, "Brief output")
 	fs.BoolVar(&cmd.Opts.JSON, "j", false, "Output in JSON format")
 	fs.BoolVar(&cmd.Opts.JSON, "json", false, "Output in JSON format")
-	fs.BoolVar(&cmd.Opts.Prettify, "p", false, "Make JSON output pretty")
-	fs.BoolVar(&cmd.Opts.Prettify, "pretty", false, "Make JSON output pretty")
+	fs.BoolVar(&cmd.Opts.Prettify, "p", false, "Output in indented JSON format")
+	fs.BoolVar(&cmd.Opts.Prettify, "pretty", false, "Output in indented JSON format")
+	fs.StringVar(&cmd.Opts.Indent, "indent", "", "Indentation of pretty JSON output (default 4 spaces)")
+	fs.BoolVar(&cmd.Opts.Sorted, "sorted", false, "Sort JSON output: links by ifindex, routes by destination and table, neighbors by address")
+	fs.BoolVar(&cmd.Opts.Envelope, "envelope", false, `Wrap JSON output as {"kind": OBJECT, "items": [...]}`)
+	fs.BoolVar(&cmd.Opts.DryRun, "dry-run", false, "Print the link that link add or delete would change as JSON, without changing it")
 	fs.StringVar(&cmd.Opts.Color, "c", "", "Use color output")
 	fs.StringVar(&cmd.Opts.Color, "color", "", "Use color output")
 	fs.StringVar(&cmd.Opts.RcvBuf, "rc", "", "Set the netlink socket receive buffer size, defaults to 1MB")
@@ -143,8 +155,8 @@ func parseFlags(args []string, out io.Writer) (cmd, error) {
 	fs.BoolVar(&cmd.Opts.Force, "force", false, "Don't terminate ip on errors in batch mode.  If there were any errors during execution of the commands, the application return code will be non zero.")
 	fs.BoolVar(&cmd.Opts.Oneline, "o", false, "Output each record on a single line")
 	fs.BoolVar(&cmd.Opts.Oneline, "oneline", false, "Output each record on a single line")
-	fs.StringVar(&cmd.Opts.Netns, "n", "", "Switch to network namespace")
-	fs.StringVar(&cmd.Opts.Netns, "netns", "", "Switch to network namespace")
+	fs.StringVar(&cmd.Opts.Netns, "n", "", "Switch to the network namespace NAME in /run/netns, or at a PATH")
+	fs.StringVar(&cmd.Opts.Netns, "netns", "", "Switch to the network namespace NAME in /run/netns, or at a PATH")
 
 	fs.Usage = func() {
 		fmt.Fprintf(out, "%s\n\n", ipHelp)
@@ -155,6 +167,12 @@ func parseFlags(args []string, out io.Writer) (cmd, error) {
 	fs.Parse(unixflag.ArgsToGoArgs(args[1:]))
 	cmd.Args = fs.Args()
 
+	// -p alone implies JSON output: -j is compact, -p and -j -p are
+	// indented.
+	if cmd.Opts.Prettify {
+		cmd.Opts.JSON = true
+	}
+
 	cmd.Family = netlink.FAMILY_ALL
 
 	if cmd.Opts.Inet4 {
@@ -182,6 +200,8 @@ func parseFlags(args []string, out io.Writer) (cmd, error) {
 			cmd.Family = netlink.FAMILY_V4
 		case "inet6":
 			cmd.Family = netlink.FAMILY_V6
+		case "link":
+			cmd.Opts.Link = true
 		default:
 			return cmd, fmt.Errorf("invalid family %q", cmd.Opts.Family)
 		}
@@ -195,45 +215,116 @@ func parseFlags(args []string, out io.Writer) (cmd, error) {
 		return cmd, fmt.Errorf("color output is unsupported")
 	}
 
-	if cmd.Opts.Oneline {
-		return cmd, fmt.Errorf("outputting each record on a single line is unsupported")
-	}
-
 	var (
 		err    error
 		handle *netlink.Handle
 	)
 
 	if cmd.Opts.Netns != "" {
-		nsHandle, err := netns.GetFromName(cmd.Opts.Netns)
+		nsHandle, err := netns.GetFromPath(netnsPath(cmd.Opts.Netns))
 		if err != nil {
 			return cmd, fmt.Errorf("failed to find network namespace %q: %v", cmd.Opts.Netns, err)
 		}
-		defer nsHandle.Close()
+		cmd.ns = &nsHandle
 
 		handle, err = netlink.NewHandleAt(nsHandle, unix.NETLINK_ROUTE)
 		if err != nil {
+			cmd.close()
 			return cmd, fmt.Errorf("failed to create netlink handle in network namespace %q: %v", cmd.Opts.Netns, err)
 		}
+		cmd.handle = handle
+		s, err := nl.GetNetlinkSocketAt(nsHandle, netns.None(), unix.NETLINK_ROUTE)
+		if err != nil {
+			cmd.close()
+			return cmd, fmt.Errorf("failed to create netlink socket in network namespace %q: %v", cmd.Opts.Netns, err)
+		}
+		cmd.sockets = map[int]*nl.SocketHandle{unix.NETLINK_ROUTE: {Socket: s}}
 	} else {
 		handle, err = netlink.NewHandle(unix.NETLINK_ROUTE)
 		if err != nil {
 			return cmd, fmt.Errorf("failed to create netlink handle: %v", err)
 		}
+		cmd.handle = handle
 	}
 
-	if cmd.Opts.RcvBuf != "" {
-		bufSize, err := strconv.Atoi(cmd.Opts.RcvBuf)
-		if err != nil {
-			return cmd, fmt.Errorf("failed to parse rcvbuf flag: %v", err)
-		}
+	bufSize, err := parseRcvBuf(cmd.Opts.RcvBuf)
+	if err != nil {
+		cmd.close()
+		return cmd, err
+	}
+	if err := setRcvBuf(handle, cmd.sockets, bufSize); err != nil {
+		cmd.close()
+		return cmd, fmt.Errorf("failed to set the netlink receive buffer size to %d: %v", bufSize, err)
+	}
+
+	return cmd, nil
+}
 
-		handle.SetSocketReceiveBufferSize(bufSize, true)
+// close closes the netlink handle and sockets of cmd, and its network
+// namespace.
+func (cmd *cmd) close() {
+	if cmd.handle != nil {
+		cmd.handle.Close()
+	}
+	for _, s := range cmd.sockets {
+		s.Close()
+	}
+	if cmd.ns != nil {
+		cmd.ns.Close()
 	}
+}
 
-	cmd.handle = handle
+// netnsPath returns the path of the network namespace given with -netns: a
+// name in netnsRunDir, or a path such as /proc/PID/ns/net if it has a slash,
+// as in iproute2.
+func netnsPath(name string) string {
+	if strings.Contains(name, "/") {
+		return name
+	}
+	return filepath.Join(netnsRunDir, name)
+}
 
-	return cmd, nil
+// defaultRcvBuf is the netlink socket receive buffer size used unless -rcvbuf
+// is given, as in iproute2. The default of the kernel is too small for dumps
+// of large routing tables, which then fail with ENOBUFS.
+const defaultRcvBuf = 1 << 20
+
+// parseRcvBuf returns the receive buffer size given with -rcvbuf, or
+// defaultRcvBuf if none is.
+func parseRcvBuf(s string) (int, error) {
+	if s == "" {
+		return defaultRcvBuf, nil
+	}
+	size, err := strconv.Atoi(s)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid rcvbuf size %q", s)
+	}
+	return size, nil
+}
+
+// setRcvBuf sets the receive buffer size of the sockets of handle, and of
+// sockets, see newRequest(). It uses SO_RCVBUFFORCE to exceed
+// net.core.rmem_max, and falls back to SO_RCVBUF, which the kernel caps at
+// net.core.rmem_max, without CAP_NET_ADMIN.
+func setRcvBuf(handle *netlink.Handle, sockets map[int]*nl.SocketHandle, size int) error {
+	err := handle.SetSocketReceiveBufferSize(size, true)
+	if errors.Is(err, unix.EPERM) {
+		err = handle.SetSocketReceiveBufferSize(size, false)
+	}
+	if err != nil {
+		return err
+	}
+	for _, s := range sockets {
+		fd := s.Socket.GetFd()
+		err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, size)
+		if errors.Is(err, unix.EPERM) {
+			err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, size)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type cmd struct {
@@ -241,6 +332,10 @@ type cmd struct {
 	Out io.Writer
 	// Netlink handle for all netlink ops
 	handle *netlink.Handle
+	// Network namespace of -netns, or nil for the current one
+	ns *netns.NsHandle
+	// Sockets in ns for the requests the handle cannot send, see newRequest()
+	sockets map[int]*nl.SocketHandle
 	// Cursor is our next token pointer
 	Cursor int
 	// Options
@@ -251,9 +346,29 @@ type cmd struct {
 	ExpectedValues []string
 	// Selected protocol Family
 	Family int
+	// Links of the current command, see links()
+	linkCache *linkCache
+	// Only show links that are up, see `ip link show up`
+	upOnly bool
+	// Only show links enslaved to the link with this index, see
+	// `ip link show master`
+	master int
+	// Only show primary or secondary addresses if "primary" or
+	// "secondary", see `ip addr show primary`
+	addrRole string
+}
+
+// newRequest returns a raw netlink request for what the netlink package has no
+// call for. Like the requests of cmd.handle, it is sent in the namespace of
+// -netns.
+func (cmd *cmd) newRequest(proto, flags int) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(proto, flags)
+	req.Sockets = cmd.sockets
+	return req
 }
 
 func (cmd *cmd) run() error {
+	defer cmd.close()
 	defer func() {
 		switch err := recover().(type) {
 		case nil:
@@ -311,14 +426,84 @@ func (cmd *cmd) batchCmds() error {
 	return nil
 }
 
+// iprouteObjects are the objects of iproute2, in the order in which it
+// matches abbreviations against them, with the object each selects here, or
+// "" if ip lacks it.
+var iprouteObjects = []struct{ name, object string }{
+	{"address", "address"},
+	{"addrlabel", ""},
+	{"maddress", ""},
+	{"route", "route"},
+	{"rule", "rule"},
+	{"neighbor", "neigh"},
+	{"neighbour", "neigh"},
+	{"ntable", ""},
+	{"ntbl", ""},
+	{"link", "link"},
+	{"l2tp", ""},
+	{"fou", ""},
+	{"ila", ""},
+	{"macsec", ""},
+	{"tunnel", "tunnel"},
+	{"tunl", "tunnel"},
+	{"tuntap", "tuntap"},
+	{"tap", "tap"},
+	{"token", ""},
+	{"tcpmetrics", "tcpmetrics"},
+	{"tcp_metrics", "tcp_metrics"},
+	{"monitor", "monitor"},
+	{"xfrm", "xfrm"},
+	{"mroute", ""},
+	{"mrule", ""},
+	{"netns", "netns"},
+	{"netconf", ""},
+	{"vrf", "vrf"},
+	{"sr", ""},
+	{"nexthop", "nexthop"},
+	{"mptcp", ""},
+	{"ioam", ""},
+	{"stats", ""},
+	{"help", "help"},
+}
+
+// expandObject returns the object abbrev selects. Like iproute2, that is the
+// first of iprouteObjects it is a prefix of, so that e.g. "t" is a tunnel.
+// If ip lacks that object, it returns "", leaving abbrev to findPrefix.
+func expandObject(abbrev string) string {
+	if abbrev == "" {
+		return ""
+	}
+	for _, o := range iprouteObjects {
+		if strings.HasPrefix(o.name, abbrev) {
+			return o.object
+		}
+	}
+	return ""
+}
+
 func (cmd *cmd) runSubCommand() error {
 	cmd.Cursor = -1
+	// Each command of a batch sees the links as they are now.
+	cmd.linkCache = nil
 
 	if !cmd.tokenRemains() {
 		fmt.Fprint(cmd.Out, ipHelp)
 	}
 
-	switch c := cmd.findPrefix("address", "route", "link", "monitor", "neigh", "tunnel", "tuntap", "tap", "tcp_metrics", "tcpmetrics", "vrf", "xfrm", "help"); c {
+	// As in iproute2, an abbreviation shared by several objects selects
+	// the first one in its table.
+	if cmd.tokenRemains() {
+		if object := expandObject(cmd.peekToken()); object != "" {
+			cmd.Args[cmd.Cursor+1] = object
+		}
+	}
+
+	c := cmd.findPrefix(objects...)
+	if cmd.Opts.Oneline && c != "monitor" && c != "" {
+		return fmt.Errorf("outputting each record on a single line is only supported by monitor")
+	}
+
+	switch c {
 	case "address":
 		return cmd.address()
 	case "link":
@@ -327,6 +512,12 @@ func (cmd *cmd) runSubCommand() error {
 		return cmd.route()
 	case "neigh":
 		return cmd.neigh()
+	case "rule":
+		return cmd.rule()
+	case "nexthop":
+		return cmd.nexthop()
+	case "netns":
+		return cmd.netns()
 	case "monitor":
 		return cmd.monitor()
 	case "tunnel":
@@ -344,8 +535,55 @@ func (cmd *cmd) runSubCommand() error {
 
 		return nil
 	default:
-		return cmd.usage()
+		return unknownObject(cmd.Args[cmd.Cursor])
+	}
+}
+
+// objects are the objects ip knows.
+var objects = []string{"address", "route", "link", "monitor", "neigh", "rule", "nexthop", "netns", "tunnel", "tuntap", "tap", "tcp_metrics", "tcpmetrics", "vrf", "xfrm", "help"}
+
+// unknownObject returns the error for an object that is neither one of
+// objects nor a prefix of one, see expandObject. Like iproute2, it suggests
+// the closest object for a typo.
+func unknownObject(object string) error {
+	best, bestDist := "", 0
+	for _, o := range objects {
+		if d := editDistance(object, o); best == "" || d < bestDist {
+			best, bestDist = o, d
+		}
+	}
+	// Anything further away is no typo.
+	if bestDist <= 2 && bestDist < len(object) {
+		return fmt.Errorf("object %q is unknown, did you mean %q?", object, best)
+	}
+	return fmt.Errorf("object %q is unknown, try \"ip help\"", object)
+}
+
+// editDistance returns the number of single character insertions, deletions,
+// substitutions, and transpositions of adjacent characters turning a into b.
+func editDistance(a, b string) int {
+	// d[i][j] is the distance between a[:i] and b[:j].
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
 	}
+	return d[len(a)][len(b)]
 }
 
 func main() {
@@ -7,13 +7,21 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
 )
 
 func TestParseFlags(t *testing.T) {
@@ -78,6 +86,78 @@ func TestParseFlags(t *testing.T) {
 				Family: netlink.FAMILY_ALL,
 			},
 		},
+		{
+			name: "json",
+			args: []string{"ip", "-j"},
+			wantCmd: cmd{
+				Opts: flags{
+					Loops: 1,
+					JSON:  true,
+				},
+				Family: netlink.FAMILY_ALL,
+			},
+		},
+		{
+			name: "pretty implies json",
+			args: []string{"ip", "-p"},
+			wantCmd: cmd{
+				Opts: flags{
+					Loops:    1,
+					JSON:     true,
+					Prettify: true,
+				},
+				Family: netlink.FAMILY_ALL,
+			},
+		},
+		{
+			name: "json pretty",
+			args: []string{"ip", "--json", "--pretty"},
+			wantCmd: cmd{
+				Opts: flags{
+					Loops:    1,
+					JSON:     true,
+					Prettify: true,
+				},
+				Family: netlink.FAMILY_ALL,
+			},
+		},
+		{
+			name: "indent",
+			args: []string{"ip", "-p", "--indent=\t"},
+			wantCmd: cmd{
+				Opts: flags{
+					Loops:    1,
+					JSON:     true,
+					Prettify: true,
+					Indent:   "\t",
+				},
+				Family: netlink.FAMILY_ALL,
+			},
+		},
+		{
+			name: "envelope",
+			args: []string{"ip", "-j", "--envelope"},
+			wantCmd: cmd{
+				Opts: flags{
+					Loops:    1,
+					JSON:     true,
+					Envelope: true,
+				},
+				Family: netlink.FAMILY_ALL,
+			},
+		},
+		{
+			name: "sorted",
+			args: []string{"ip", "-j", "--sorted"},
+			wantCmd: cmd{
+				Opts: flags{
+					Loops:  1,
+					JSON:   true,
+					Sorted: true,
+				},
+				Family: netlink.FAMILY_ALL,
+			},
+		},
 		{
 			name: "family",
 			args: []string{"ip", "--family=inet"},
@@ -116,9 +196,15 @@ func TestParseFlags(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			name:    "oneline",
-			args:    []string{"ip", "-o"},
-			wantErr: true,
+			name: "oneline",
+			args: []string{"ip", "-o"},
+			wantCmd: cmd{
+				Opts: flags{
+					Loops:   1,
+					Oneline: true,
+				},
+				Family: netlink.FAMILY_ALL,
+			},
 		},
 		{
 			name: "rcvBuf",
@@ -131,6 +217,11 @@ func TestParseFlags(t *testing.T) {
 				Family: netlink.FAMILY_ALL,
 			},
 		},
+		{
+			name:    "rcvBuf err",
+			args:    []string{"ip", "--rcvbuf=abc"},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range testcases {
@@ -145,7 +236,7 @@ func TestParseFlags(t *testing.T) {
 			}
 
 			if !tt.wantErr {
-				diff := cmp.Diff(cmd, tt.wantCmd, cmpopts.IgnoreFields(cmd, "Args", "Out", "handle"))
+				diff := cmp.Diff(cmd, tt.wantCmd, cmpopts.IgnoreFields(cmd, "Args", "Out", "handle", "ns", "sockets", "linkCache", "upOnly", "addrRole", "master"))
 				if diff != "" {
 					t.Errorf("got diff between cmds:\n%v", diff)
 				}
@@ -154,6 +245,72 @@ func TestParseFlags(t *testing.T) {
 	}
 }
 
+func TestParseRcvBuf(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{in: "", want: defaultRcvBuf},
+		{in: "4194304", want: 4194304},
+		{in: "0", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "1M", wantErr: true},
+	} {
+		got, err := parseRcvBuf(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseRcvBuf(%q) = %d, %v, want %d, error %t", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNetnsPath(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"blue", "/run/netns/blue"},
+		{"/proc/1/ns/net", "/proc/1/ns/net"},
+		{"./ns", "./ns"},
+	} {
+		if got := netnsPath(tt.in); got != tt.want {
+			t.Errorf("netnsPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSetRcvBuf(t *testing.T) {
+	handle, err := netlink.NewHandle(unix.NETLINK_ROUTE)
+	if err != nil {
+		t.Skipf("no netlink handle: %v", err)
+	}
+	defer handle.Close()
+	s, err := nl.GetNetlinkSocketAt(netns.None(), netns.None(), unix.NETLINK_ROUTE)
+	if err != nil {
+		t.Skipf("no netlink socket: %v", err)
+	}
+	defer s.Close()
+
+	// Small enough not to be capped by net.core.rmem_max.
+	const size = 64 << 10
+	if err := setRcvBuf(handle, map[int]*nl.SocketHandle{unix.NETLINK_ROUTE: {Socket: s}}, size); err != nil {
+		t.Fatalf("setRcvBuf(%d) = %v", size, err)
+	}
+	sizes, err := handle.GetSocketReceiveBufferSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	socketSize, err := unix.GetsockoptInt(s.GetFd(), unix.SOL_SOCKET, unix.SO_RCVBUF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, got := range append(sizes, socketSize) {
+		// The kernel doubles the size to allow for its bookkeeping.
+		if got != 2*size {
+			t.Errorf("receive buffer size = %d, want %d", got, 2*size)
+		}
+	}
+}
+
 func TestRunSubCommand(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -590,3 +747,224 @@ func TestRun(t *testing.T) {
 		})
 	}
 }
+
+func TestUnknownObject(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"rotue", "show"}, `object "rotue" is unknown, did you mean "route"?`},
+		{[]string{"lnik"}, `object "lnik" is unknown, did you mean "link"?`},
+		{[]string{"adress"}, `object "adress" is unknown, did you mean "address"?`},
+		{[]string{"bogus"}, `object "bogus" is unknown, try "ip help"`},
+		{[]string{"z"}, `object "z" is unknown, try "ip help"`},
+	} {
+		cmd := cmd{Args: tt.args, Out: new(bytes.Buffer)}
+		if err := cmd.runSubCommand(); err == nil || err.Error() != tt.want {
+			t.Errorf("runSubCommand(%q) = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestExpandObject(t *testing.T) {
+	for _, tt := range []struct {
+		abbrev, want string
+	}{
+		{"a", "address"},
+		{"r", "route"},
+		{"ru", "rule"},
+		{"n", "neigh"},
+		{"neighbour", "neigh"},
+		{"net", "netns"},
+		{"nex", "nexthop"},
+		{"l", "link"},
+		{"t", "tunnel"},
+		{"tu", "tunnel"},
+		{"tunt", "tuntap"},
+		{"ta", "tap"},
+		{"tc", "tcpmetrics"},
+		{"mo", "monitor"},
+		{"x", "xfrm"},
+		{"h", "help"},
+		// iproute2 selects an object ip lacks.
+		{"m", ""},
+		{"s", ""},
+		{"bogus", ""},
+		{"", ""},
+	} {
+		if got := expandObject(tt.abbrev); got != tt.want {
+			t.Errorf("expandObject(%q) = %q, want %q", tt.abbrev, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"route", "route", 0},
+		{"rotue", "route", 1},
+		{"rout", "route", 1},
+		{"ruote", "route", 1},
+		{"", "vrf", 3},
+		{"neigh", "nexthop", 4},
+	} {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// TestNetnsRequests runs the requests that do not go through cmd.handle with
+// -netns, and checks that they reach the namespace. The test itself runs in a
+// throwaway namespace, so that a request missing it does not touch the host.
+func TestNetnsRequests(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skipf("skipping; must be root")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	orig, err := netns.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orig.Close()
+	defer netns.Set(orig)
+
+	current, err := netns.New()
+	if err != nil {
+		t.Skipf("can't create a network namespace: %v", err)
+	}
+	defer current.Close()
+	target, err := netns.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	if err := netns.Set(current); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/proc/self/fd/%d", target)
+
+	ip := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		cmd, err := parseFlags(append([]string{"ip", "-n", path}, args...), &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cmd.close()
+		if err := cmd.runSubCommand(); err != nil {
+			t.Fatalf("ip %q: %v", args, err)
+		}
+		return out.String()
+	}
+	// Only the target namespace has ifb0, which is not the second link of
+	// the current one either.
+	ip("link", "add", "ifb0", "type", "ifb")
+
+	// Subscribe before the changes below, to monitor them.
+	cmd, err := parseFlags([]string{"ip", "-n", path, "monitor"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.close()
+	updates := monitorUpdates{
+		addr:    make(chan netlink.AddrUpdate, 64),
+		link:    make(chan netlink.LinkUpdate, 64),
+		neigh:   make(chan netlink.NeighUpdate, 64),
+		route:   make(chan netlink.RouteUpdate, 64),
+		rule:    make(chan syscall.NetlinkMessage, 64),
+		nexthop: make(chan syscall.NetlinkMessage, 64),
+	}
+	done := make(chan struct{})
+	defer close(done)
+	for _, object := range monitorObjects {
+		if err := updates.subscribe(cmd.ns, object, done); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ip("link", "set", "ifb0", "up")
+	ip("link", "set", "ifb0", "gso_max_size", "32768")
+	ip("addr", "add", "192.0.2.1/24", "dev", "ifb0")
+	ip("neigh", "add", "192.0.2.2", "lladdr", "02:00:00:00:00:02", "dev", "ifb0")
+	ip("nexthop", "add", "id", "1", "dev", "ifb0")
+	ip("route", "add", "198.51.100.0/24", "nhid", "1")
+	ip("-6", "route", "add", "2001:db8::/64", "dev", "ifb0", "expires", "60")
+	ip("rule", "add", "from", "192.0.2.1", "table", "100")
+
+	h, err := netlink.NewHandleAt(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if err := h.SetNetNsIdByFd(int(current), 7); err != nil {
+		t.Fatal(err)
+	}
+	link, err := h.LinkByName("ifb0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := link.Attrs().GSOMaxSize; got != 32768 {
+		t.Errorf("gso_max_size = %d, want 32768", got)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-d", "link", "show", "ifb0"}, "brd ff:ff:ff:ff:ff:ff"},
+		{[]string{"nexthop", "show"}, "id 1 dev ifb0"},
+		{[]string{"route", "show"}, "198.51.100.0/24 nhid 1"},
+		{[]string{"-6", "route", "show"}, "expires"},
+		{[]string{"rule", "show"}, "from 192.0.2.1 lookup 100"},
+		{[]string{"netns", "list-id"}, "nsid 7"},
+	} {
+		if got := ip(tt.args...); !strings.Contains(got, tt.want) {
+			t.Errorf("ip %q = %q, want %q in it", tt.args, got, tt.want)
+		}
+	}
+
+	// Wait for the first update of each object. The links of the address,
+	// neigh, and route updates are looked up in the namespace too.
+	pending := map[string]bool{"address": true, "link": true, "neigh": true, "route": true, "rule": true, "nexthop": true}
+	timeout := time.After(5 * time.Second)
+	for len(pending) > 0 {
+		var (
+			object string
+			ev     = MonitorEvent{Dev: "ifb0"}
+			err    error
+		)
+		select {
+		case u := <-updates.addr:
+			object = "address"
+			ev, _, err = cmd.addrEvent(u)
+		case u := <-updates.neigh:
+			object = "neigh"
+			ev, _, err = cmd.neighEvent(u)
+		case u := <-updates.route:
+			if u.Route.LinkIndex != link.Attrs().Index {
+				continue
+			}
+			object = "route"
+			ev, _, err = cmd.routeEvent(u)
+		case u := <-updates.link:
+			object = "link"
+			ev, _ = linkEvent(u)
+		case <-updates.rule:
+			object = "rule"
+		case <-updates.nexthop:
+			object = "nexthop"
+		case <-timeout:
+			t.Fatalf("no updates of %v", pending)
+		}
+		if err != nil {
+			t.Errorf("%s update: %v", object, err)
+		} else if ev.Dev != "ifb0" {
+			t.Errorf("%s update of dev %q, want ifb0", object, ev.Dev)
+		}
+		delete(pending, object)
+	}
+}
@@ -6,10 +6,18 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"net"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 )
 
 const linkHelp = `Usage: ip link add  [ name ] NAME
@@ -31,9 +39,10 @@ const linkHelp = `Usage: ip link add  [ name ] NAME
 		[ allmulticast { on | off } ]
 		[ promisc { on | off } ]
 		[ txqueuelen PACKETS ]
-		[ name NEWNAME ]
+		[ name NEWNAME [ verify ] ]
 		[ address LLADDR ]
 		[ mtu MTU ]
+		[ gso_max_size BYTES ] [ gso_max_segs SEGMENTS ]
 		[ netns { PID | NAME } ]
 		[ alias NAME ]
 		[ vf NUM [ mac LLADDR ]
@@ -47,7 +56,7 @@ const linkHelp = `Usage: ip link add  [ name ] NAME
 			 [ node_guid EUI64 ]
 			 [ port_guid EUI64 ] ]
 
-	ip link show [ DEVICE | group GROUP ] [type TYPE]
+	ip link show [ DEVICE | group GROUP ] [type TYPE] [ address LLADDR ] [ master DEVICE ] [ up ]
 
 	ip link help
 
@@ -56,7 +65,11 @@ TYPE := { bareudp | bond |bridge | dummy |
           ip6gre | ip6gretap | ip6tnl | ipip |
           ipoib | ipvlan | ipvtap | macvlan |
           macvlan | sit | vlan | vrf |
-          vti | vxlan | xfrm }
+          vti | vti6 | vxlan | xfrm }
+
+vti and vti6 ARGS := [ local ADDR ] [ remote ADDR ] [ dev PHYS_DEV ]
+                     [ key KEY ] [ ikey KEY ] [ okey KEY ]
+KEY := { DOTTED_QUAD | NUMBER }
 
 `
 
@@ -67,7 +80,7 @@ func (cmd *cmd) linkSet() error {
 	}
 
 	for cmd.tokenRemains() {
-		token := cmd.nextToken("address", "up", "down", "arp", "promisc", "multicast", "allmulticast", "mtu", "name", "alias", "vf", "master", "nomaster", "netns", "txqueuelen", "txqlen", "group")
+		token := cmd.nextToken("address", "up", "down", "arp", "promisc", "multicast", "allmulticast", "mtu", "name", "alias", "vf", "master", "nomaster", "netns", "txqueuelen", "txqlen", "gso_max_size", "gso_max_segs", "group")
 		switch token {
 		case "address":
 			return cmd.setLinkHardwareAddress(iface)
@@ -116,7 +129,7 @@ func (cmd *cmd) linkSet() error {
 		case "vf":
 			return cmd.setLinkVf(iface)
 		case "master":
-			master, err := cmd.handle.LinkByName(cmd.nextToken("device name"))
+			master, err := cmd.lookupLink(cmd.nextToken("device name"))
 			if err != nil {
 				return err
 			}
@@ -127,6 +140,10 @@ func (cmd *cmd) linkSet() error {
 			return cmd.setLinkNetns(iface)
 		case "txqueuelen", "txqlen":
 			return cmd.setLinkTxQLen(iface)
+		case "gso_max_size", "gso_max_segs":
+			if err := cmd.setLinkGSO(iface, token); err != nil {
+				return err
+			}
 		case "group":
 
 		}
@@ -172,7 +189,53 @@ func (cmd *cmd) setLinkGroup(iface netlink.Link) error {
 }
 
 func (cmd *cmd) setLinkName(iface netlink.Link) error {
-	return cmd.handle.LinkSetName(iface, cmd.nextToken("name"))
+	name := cmd.nextToken("name")
+	verify := cmd.tokenRemains() && cmd.peekToken("verify") == "verify"
+	if !verify {
+		return cmd.handle.LinkSetName(iface, name)
+	}
+	cmd.Cursor++
+
+	before, err := cmd.handle.AddrList(iface, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("can't get addresses for link %s: %v", iface.Attrs().Name, err)
+	}
+	if err := cmd.handle.LinkSetName(iface, name); err != nil {
+		return err
+	}
+	renamed, err := cmd.handle.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("renamed %s to %s, but can't find it: %v", iface.Attrs().Name, name, err)
+	}
+	after, err := cmd.handle.AddrList(renamed, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("can't get addresses for link %s: %v", name, err)
+	}
+	return verifyRename(iface, renamed, name, before, after)
+}
+
+// verifyRename checks that the link renamed to name is the link it was
+// before, with the same addresses.
+func verifyRename(old, renamed netlink.Link, name string, before, after []netlink.Addr) error {
+	if renamed.Attrs().Name != name {
+		return fmt.Errorf("renamed %s to %s, but it is named %s", old.Attrs().Name, name, renamed.Attrs().Name)
+	}
+	if renamed.Attrs().Index != old.Attrs().Index {
+		return fmt.Errorf("renamed %s to %s, but its index changed from %d to %d", old.Attrs().Name, name, old.Attrs().Index, renamed.Attrs().Index)
+	}
+
+	addrSet := func(addrs []netlink.Addr) []string {
+		set := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			set = append(set, addr.IPNet.String())
+		}
+		slices.Sort(set)
+		return set
+	}
+	if b, a := addrSet(before), addrSet(after); !slices.Equal(b, a) {
+		return fmt.Errorf("renamed %s to %s, but its addresses changed from %v to %v", old.Attrs().Name, name, b, a)
+	}
+	return nil
 }
 
 func (cmd *cmd) setLinkAlias(iface netlink.Link) error {
@@ -189,6 +252,49 @@ func (cmd *cmd) setLinkTxQLen(iface netlink.Link) error {
 	return cmd.handle.LinkSetTxQLen(iface, qlen)
 }
 
+// gsoLimits are the attributes of gso_max_size and gso_max_segs, and their
+// upper bounds in the kernel, GSO_MAX_SIZE with BIG TCP and GSO_MAX_SEGS.
+// Drivers may support less, which the kernel rejects.
+var gsoLimits = map[string]struct {
+	attr int
+	max  int
+}{
+	"gso_max_size": {unix.IFLA_GSO_MAX_SIZE, 512*1024 - 8},
+	"gso_max_segs": {unix.IFLA_GSO_MAX_SEGS, 65535},
+}
+
+// parseGSOLimit parses the value of gso_max_size or gso_max_segs.
+func (cmd *cmd) parseGSOLimit(name string) (uint32, error) {
+	token := cmd.nextToken("BYTES", "SEGMENTS")
+	n, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %v: %v", name, token, err)
+	}
+	if max := gsoLimits[name].max; n < 1 || n > max {
+		return 0, fmt.Errorf("invalid %s %d: must be between 1 and %d", name, n, max)
+	}
+	return uint32(n), nil
+}
+
+// setLinkGSO sets gso_max_size or gso_max_segs of iface. The netlink package
+// has no setter for them, so the RTM_SETLINK request is built here.
+func (cmd *cmd) setLinkGSO(iface netlink.Link, name string) error {
+	n, err := cmd.parseGSOLimit(name)
+	if err != nil {
+		return err
+	}
+
+	req := cmd.newRequest(unix.RTM_SETLINK, unix.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(iface.Attrs().Index)
+	req.AddData(msg)
+	req.AddData(nl.NewRtAttr(gsoLimits[name].attr, nl.Uint32Attr(n)))
+	if _, err := req.Execute(unix.NETLINK_ROUTE, 0); err != nil {
+		return fmt.Errorf("%v can't set %s %d: %v", iface.Attrs().Name, name, n, err)
+	}
+	return nil
+}
+
 func (cmd *cmd) setLinkNetns(iface netlink.Link) error {
 	token := cmd.nextToken("PID", "NAME")
 
@@ -206,164 +312,464 @@ func (cmd *cmd) setLinkNetns(iface netlink.Link) error {
 	return nil
 }
 
-func (cmd *cmd) setLinkVf(iface netlink.Link) error {
-	vf, err := cmd.parseInt("VF")
+// vfLinkStates maps the IFLA_VF_LINK_STATE values to their names.
+var vfLinkStates = []string{"auto", "enable", "disable"}
+
+// vfConfig holds the settings of an `ip link set vf` command. Nil fields are
+// left unchanged.
+type vfConfig struct {
+	vf        int
+	mac       net.HardwareAddr
+	vlan      *int
+	qos       int
+	rate      *int
+	maxTxRate *int
+	minTxRate *int
+	state     *uint32
+	spoofchk  *bool
+	trust     *bool
+	nodeGUID  net.HardwareAddr
+	portGUID  net.HardwareAddr
+}
+
+// parseLinkVf parses the VF number and any number of VF settings.
+func (cmd *cmd) parseLinkVf() (vfConfig, error) {
+	var (
+		cfg vfConfig
+		err error
+	)
+
+	cfg.vf, err = cmd.parseInt("VF")
 	if err != nil {
-		return err
+		return cfg, err
 	}
 
 	for cmd.tokenRemains() {
 		switch cmd.nextToken("vlan", "mac", "qos", "rate", "max_tx_rate", "min_tx_rate", "state", "spoofchk", "trust", "node_guid", "port_guid") {
 		case "mac":
-			addr, err := cmd.parseHardwareAddress()
-			if err != nil {
-				return err
+			if cfg.mac, err = cmd.parseHardwareAddress(); err != nil {
+				return cfg, err
 			}
-
-			return cmd.handle.LinkSetVfHardwareAddr(iface, vf, addr)
 		case "vlan":
 			vlan, err := cmd.parseInt("VLANID")
 			if err != nil {
-				return err
+				return cfg, err
 			}
-
-			if !cmd.tokenRemains() {
-				return cmd.handle.LinkSetVfVlan(iface, vf, vlan)
+			if vlan < 0 || vlan > 4095 {
+				return cfg, fmt.Errorf("invalid VLANID %d: must be in 0-4095", vlan)
 			}
-
-			switch cmd.nextToken("qos") {
-			case "qos":
-				qos, err := cmd.parseInt("VLAN-QOS")
-				if err != nil {
-					return err
-				}
-
-				return cmd.handle.LinkSetVfVlanQos(iface, vf, vlan, qos)
-			default:
-				return cmd.usage()
+			cfg.vlan = &vlan
+		case "qos":
+			if cfg.vlan == nil {
+				return cfg, fmt.Errorf("qos requires a vlan")
+			}
+			if cfg.qos, err = cmd.parseInt("VLAN-QOS"); err != nil {
+				return cfg, err
 			}
 		case "rate":
 			rate, err := cmd.parseInt("TXRATE")
 			if err != nil {
-				return err
+				return cfg, err
 			}
-
-			return cmd.handle.LinkSetVfTxRate(iface, vf, rate)
+			cfg.rate = &rate
 		case "max_tx_rate":
 			rate, err := cmd.parseInt("TXRATE")
 			if err != nil {
-				return err
+				return cfg, err
 			}
-
-			return cmd.handle.LinkSetVfRate(iface, vf, int(iface.Attrs().Vfs[0].MinTxRate), rate)
+			cfg.maxTxRate = &rate
 		case "min_tx_rate":
 			rate, err := cmd.parseInt("TXRATE")
 			if err != nil {
-				return err
+				return cfg, err
 			}
-
-			return cmd.handle.LinkSetVfRate(iface, vf, rate, int(iface.Attrs().Vfs[0].MaxTxRate))
+			cfg.minTxRate = &rate
 		case "state":
-			state, err := cmd.parseUint32("STATE")
-			if err != nil {
-				return err
+			token := cmd.nextToken(vfLinkStates...)
+			state := uint32(slices.Index(vfLinkStates, token))
+			if !slices.Contains(vfLinkStates, token) {
+				n, err := strconv.ParseUint(token, 10, 32)
+				if err != nil {
+					return cfg, fmt.Errorf("invalid state %q: %w", token, err)
+				}
+				state = uint32(n)
 			}
-
-			return cmd.handle.LinkSetVfState(iface, vf, state)
+			cfg.state = &state
 		case "spoofchk":
 			check, err := cmd.parseBool("on", "off")
 			if err != nil {
-				return err
+				return cfg, err
 			}
-
-			return cmd.handle.LinkSetVfSpoofchk(iface, vf, check)
+			cfg.spoofchk = &check
 		case "trust":
 			trust, err := cmd.parseBool("on", "off")
 			if err != nil {
-				return err
+				return cfg, err
 			}
-
-			return cmd.handle.LinkSetVfTrust(iface, vf, trust)
+			cfg.trust = &trust
 		case "node_guid":
-			nodeguid, err := cmd.parseHardwareAddress()
-			if err != nil {
-				return err
+			if cfg.nodeGUID, err = cmd.parseHardwareAddress(); err != nil {
+				return cfg, err
 			}
-
-			return netlink.LinkSetVfNodeGUID(iface, vf, nodeguid)
-
 		case "port_guid":
-			portguid, err := cmd.parseHardwareAddress()
-			if err != nil {
-				return err
+			if cfg.portGUID, err = cmd.parseHardwareAddress(); err != nil {
+				return cfg, err
 			}
+		default:
+			return cfg, cmd.usage()
+		}
+	}
 
-			return netlink.LinkSetVfPortGUID(iface, vf, portguid)
+	if cfg.mac == nil && cfg.vlan == nil && cfg.rate == nil && cfg.maxTxRate == nil && cfg.minTxRate == nil &&
+		cfg.state == nil && cfg.spoofchk == nil && cfg.trust == nil && cfg.nodeGUID == nil && cfg.portGUID == nil {
+		return cfg, fmt.Errorf("no VF settings given for vf %d", cfg.vf)
+	}
+
+	return cfg, nil
+}
+
+func (cmd *cmd) setLinkVf(iface netlink.Link) error {
+	cfg, err := cmd.parseLinkVf()
+	if err != nil {
+		return err
+	}
+
+	name, vf := iface.Attrs().Name, cfg.vf
+
+	if cfg.mac != nil {
+		if err := cmd.handle.LinkSetVfHardwareAddr(iface, vf, cfg.mac); err != nil {
+			return fmt.Errorf("%v vf %d: can't set mac %v: %v", name, vf, cfg.mac, err)
+		}
+	}
+	if cfg.vlan != nil {
+		if err := cmd.handle.LinkSetVfVlanQos(iface, vf, *cfg.vlan, cfg.qos); err != nil {
+			return fmt.Errorf("%v vf %d: can't set vlan %d qos %d: %v", name, vf, *cfg.vlan, cfg.qos, err)
+		}
+	}
+	if cfg.rate != nil {
+		if err := cmd.handle.LinkSetVfTxRate(iface, vf, *cfg.rate); err != nil {
+			return fmt.Errorf("%v vf %d: can't set rate %d: %v", name, vf, *cfg.rate, err)
+		}
+	}
+	if cfg.minTxRate != nil || cfg.maxTxRate != nil {
+		// The kernel sets both at once, keep the one not given.
+		var minRate, maxRate int
+		for _, info := range iface.Attrs().Vfs {
+			if info.ID == vf {
+				minRate, maxRate = int(info.MinTxRate), int(info.MaxTxRate)
+			}
+		}
+		if cfg.minTxRate != nil {
+			minRate = *cfg.minTxRate
+		}
+		if cfg.maxTxRate != nil {
+			maxRate = *cfg.maxTxRate
+		}
+		if err := cmd.handle.LinkSetVfRate(iface, vf, minRate, maxRate); err != nil {
+			return fmt.Errorf("%v vf %d: can't set min_tx_rate %d max_tx_rate %d: %v", name, vf, minRate, maxRate, err)
+		}
+	}
+	if cfg.state != nil {
+		if err := cmd.handle.LinkSetVfState(iface, vf, *cfg.state); err != nil {
+			return fmt.Errorf("%v vf %d: can't set state %d: %v", name, vf, *cfg.state, err)
+		}
+	}
+	if cfg.spoofchk != nil {
+		if err := cmd.handle.LinkSetVfSpoofchk(iface, vf, *cfg.spoofchk); err != nil {
+			return fmt.Errorf("%v vf %d: can't set spoofchk: %v", name, vf, err)
+		}
+	}
+	if cfg.trust != nil {
+		if err := cmd.handle.LinkSetVfTrust(iface, vf, *cfg.trust); err != nil {
+			return fmt.Errorf("%v vf %d: can't set trust: %v", name, vf, err)
 		}
 	}
-	return cmd.usage()
+	if cfg.nodeGUID != nil {
+		if err := cmd.handle.LinkSetVfGUID(iface, vf, cfg.nodeGUID, nl.IFLA_VF_IB_NODE_GUID); err != nil {
+			return fmt.Errorf("%v vf %d: can't set node_guid: %v", name, vf, err)
+		}
+	}
+	if cfg.portGUID != nil {
+		if err := cmd.handle.LinkSetVfGUID(iface, vf, cfg.portGUID, nl.IFLA_VF_IB_PORT_GUID); err != nil {
+			return fmt.Errorf("%v vf %d: can't set port_guid: %v", name, vf, err)
+		}
+	}
+
+	return nil
 }
 
 func (cmd *cmd) linkAdd() error {
-	typeName, attrs, err := cmd.parseLinkAttrs()
+	link, err := cmd.parseLinkAdd()
 	if err != nil {
 		return err
 	}
 
+	if cmd.Opts.DryRun {
+		planned, err := cmd.plannedLink(link)
+		if err != nil {
+			return err
+		}
+		return printJSON(*cmd, planned)
+	}
+
+	if err := cmd.handle.LinkAdd(link); err != nil {
+		if _, ok := link.(*netlink.Vti); ok {
+			return vtiAddError(link.Type(), err)
+		}
+		return err
+	}
+	return nil
+}
+
+// parseLinkAdd parses and validates the arguments of ip link add, returning
+// the link to create.
+func (cmd *cmd) parseLinkAdd() (netlink.Link, error) {
+	typeName, attrs, err := cmd.parseLinkAttrs()
+	if err != nil {
+		return nil, err
+	}
+	if err := validateLinkAttrs(attrs); err != nil {
+		return nil, err
+	}
+
 	switch typeName {
 	case "dummy":
-		return cmd.handle.LinkAdd(&netlink.Dummy{LinkAttrs: attrs})
+		return &netlink.Dummy{LinkAttrs: attrs}, nil
 	case "ifb":
-		return cmd.handle.LinkAdd(&netlink.Ifb{LinkAttrs: attrs})
+		return &netlink.Ifb{LinkAttrs: attrs}, nil
 	case "vlan":
-		return cmd.handle.LinkAdd(&netlink.Vlan{LinkAttrs: attrs})
+		return &netlink.Vlan{LinkAttrs: attrs}, nil
 	case "macvlan":
-		return cmd.handle.LinkAdd(&netlink.Macvlan{LinkAttrs: attrs})
+		return &netlink.Macvlan{LinkAttrs: attrs}, nil
 	case "veth":
-		return cmd.handle.LinkAdd(&netlink.Veth{LinkAttrs: attrs})
+		return &netlink.Veth{LinkAttrs: attrs}, nil
 	case "vxlan":
-		return cmd.handle.LinkAdd(&netlink.Vxlan{LinkAttrs: attrs})
+		return &netlink.Vxlan{LinkAttrs: attrs}, nil
 	case "ipvlan":
-		return cmd.handle.LinkAdd(&netlink.IPVlan{LinkAttrs: attrs})
+		return &netlink.IPVlan{LinkAttrs: attrs}, nil
 	case "ipvtap":
-		return cmd.handle.LinkAdd(&netlink.IPVtap{IPVlan: netlink.IPVlan{LinkAttrs: attrs}})
+		return &netlink.IPVtap{IPVlan: netlink.IPVlan{LinkAttrs: attrs}}, nil
 	case "bond":
-		return cmd.handle.LinkAdd(netlink.NewLinkBond(attrs))
+		return netlink.NewLinkBond(attrs), nil
 	case "geneve":
-		return cmd.handle.LinkAdd(&netlink.Geneve{LinkAttrs: attrs})
+		return &netlink.Geneve{LinkAttrs: attrs}, nil
 	case "gretap":
-		return cmd.handle.LinkAdd(&netlink.Gretap{LinkAttrs: attrs})
+		return &netlink.Gretap{LinkAttrs: attrs}, nil
 	case "ipip":
-		return cmd.handle.LinkAdd(&netlink.Iptun{LinkAttrs: attrs})
+		return &netlink.Iptun{LinkAttrs: attrs}, nil
 	case "ip6tln":
-		return cmd.handle.LinkAdd(&netlink.Ip6tnl{LinkAttrs: attrs})
+		return &netlink.Ip6tnl{LinkAttrs: attrs}, nil
 	case "sit":
-		return cmd.handle.LinkAdd(&netlink.Sittun{LinkAttrs: attrs})
-	case "vti":
-		return cmd.handle.LinkAdd(&netlink.Vti{LinkAttrs: attrs})
+		return &netlink.Sittun{LinkAttrs: attrs}, nil
+	case "vti", "vti6":
+		vti, dev, err := cmd.parseVti(typeName, attrs)
+		if err != nil {
+			return nil, err
+		}
+		if dev != "" {
+			link, err := cmd.lookupLink(dev)
+			if err != nil {
+				return nil, fmt.Errorf("cannot find device %q: %w", dev, err)
+			}
+			vti.Link = uint32(link.Attrs().Index)
+		}
+		return vti, nil
 	case "gre":
-		return cmd.handle.LinkAdd(&netlink.Gretun{LinkAttrs: attrs})
+		return &netlink.Gretun{LinkAttrs: attrs}, nil
 	case "vrf":
 		if cmd.nextToken("table") != "table" {
-			return cmd.usage()
+			return nil, cmd.usage()
 		}
 		tableID, err := cmd.parseUint32("TABLE")
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		return cmd.handle.LinkAdd(&netlink.Vrf{LinkAttrs: attrs, Table: tableID})
+		return &netlink.Vrf{LinkAttrs: attrs, Table: tableID}, nil
 	case "bridge":
-		return cmd.handle.LinkAdd(&netlink.Bridge{LinkAttrs: attrs})
+		return &netlink.Bridge{LinkAttrs: attrs}, nil
 	case "xfrm":
-		return cmd.handle.LinkAdd(&netlink.Xfrmi{LinkAttrs: attrs})
+		return &netlink.Xfrmi{LinkAttrs: attrs}, nil
 	case "ipoib":
-		return cmd.handle.LinkAdd(&netlink.IPoIB{LinkAttrs: attrs})
+		return &netlink.IPoIB{LinkAttrs: attrs}, nil
 	case "bareudp":
-		return cmd.handle.LinkAdd(&netlink.BareUDP{LinkAttrs: attrs})
+		return &netlink.BareUDP{LinkAttrs: attrs}, nil
 	default:
-		return fmt.Errorf("unsupported link type %s", typeName)
+		return nil, fmt.Errorf("unsupported link type %s", typeName)
+	}
+}
+
+// validateLinkAttrs checks attrs of a link to add as the kernel would: the
+// name must fit IFNAMSIZ and be a valid file name without colons or
+// spaces, and the numbers must not be negative.
+func validateLinkAttrs(attrs netlink.LinkAttrs) error {
+	name := attrs.Name
+	switch {
+	case name == "":
+		return fmt.Errorf("device name not specified")
+	case len(name) >= unix.IFNAMSIZ:
+		return fmt.Errorf("invalid device name %q: longer than %d bytes", name, unix.IFNAMSIZ-1)
+	case name == "." || name == ".." || strings.ContainsAny(name, "/: \t\n\v\f\r"):
+		return fmt.Errorf("invalid device name %q", name)
+	}
+	for _, n := range []struct {
+		name  string
+		value int
+	}{
+		{"mtu", attrs.MTU},
+		{"txqueuelen", attrs.TxQLen},
+		{"index", attrs.Index},
+	} {
+		if n.value < 0 {
+			return fmt.Errorf("invalid %s %d: must not be negative", n.name, n.value)
+		}
+	}
+	return nil
+}
+
+// plannedLink returns link, which -dry-run does not create, as ip -json
+// link show would print it once created.
+func (cmd *cmd) plannedLink(link netlink.Link) (Link, error) {
+	attrs := link.Attrs()
+	planned := Link{
+		IfIndex:     attrs.Index,
+		IfName:      attrs.Name,
+		Flags:       []string{},
+		MTU:         attrs.MTU,
+		Operstate:   attrs.OperState.String(),
+		Txqlen:      attrs.TxQLen,
+		LinkType:    link.Type(),
+		NumTxQueues: attrs.NumTxQueues,
+		NumRxQueues: attrs.NumRxQueues,
+	}
+	if attrs.HardwareAddr != nil {
+		planned.Address = attrs.HardwareAddr.String()
 	}
+	var err error
+	planned.LinkInfo, err = cmd.linkInfo(link)
+	return planned, err
+}
+
+// linkTypeArgs holds the link types that take arguments after type TYPE.
+var linkTypeArgs = map[string]bool{"vrf": true, "vti": true, "vti6": true}
+
+// vtiModules holds the kernel modules implementing vti links.
+var vtiModules = map[string]string{"vti": "ip_vti", "vti6": "ip6_vti"}
+
+// vtiAddError explains the error of a kernel without the vti module.
+func vtiAddError(typeName string, err error) error {
+	if errors.Is(err, unix.EOPNOTSUPP) {
+		return fmt.Errorf("adding %s link: %w (is the %s kernel module missing?)", typeName, err, vtiModules[typeName])
+	}
+	return err
+}
+
+// parseVti parses the arguments of a vti or vti6 link. It returns the link
+// and the name of its lower device, if any.
+func (cmd *cmd) parseVti(typeName string, attrs netlink.LinkAttrs) (*netlink.Vti, string, error) {
+	vti := &netlink.Vti{LinkAttrs: attrs}
+	var dev string
+
+	for cmd.tokenRemains() {
+		switch token := cmd.nextToken("local", "remote", "dev", "key", "ikey", "okey"); token {
+		case "local", "remote":
+			ip, err := parseVtiAddr(typeName, cmd.nextToken("ADDR"))
+			if err != nil {
+				return nil, "", fmt.Errorf("%s: %w", token, err)
+			}
+			if token == "local" {
+				vti.Local = ip
+			} else {
+				vti.Remote = ip
+			}
+		case "dev":
+			dev = cmd.nextToken("PHYS_DEV")
+		case "key", "ikey", "okey":
+			key, err := parseVtiKey(cmd.nextToken("KEY"))
+			if err != nil {
+				return nil, "", err
+			}
+			if token != "okey" {
+				vti.IKey = key
+			}
+			if token != "ikey" {
+				vti.OKey = key
+			}
+		default:
+			return nil, "", cmd.usage()
+		}
+	}
+
+	// The family of the link follows that of its local address.
+	if vti.Local == nil {
+		vti.Local = net.IPv4zero
+		if typeName == "vti6" {
+			vti.Local = net.IPv6unspecified
+		}
+	}
+	return vti, dev, nil
+}
+
+// parseVtiAddr parses an endpoint of a vti link, which must be of the
+// family of the link.
+func parseVtiAddr(typeName, s string) (net.IP, error) {
+	if s == "any" {
+		if typeName == "vti6" {
+			return net.IPv6unspecified, nil
+		}
+		return net.IPv4zero, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	if (ip.To4() != nil) != (typeName == "vti") {
+		return nil, fmt.Errorf("address %s is not of the %s family", s, typeName)
+	}
+	return ip, nil
+}
+
+// parseVtiKey parses a vti key, either a dotted quad or a 32-bit number.
+func parseVtiKey(s string) (uint32, error) {
+	if strings.Contains(s, ".") {
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			return 0, fmt.Errorf("invalid key %q", s)
+		}
+		return binary.BigEndian.Uint32(ip), nil
+	}
+	key, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid key %q: want a dotted quad or a number below 2^32", s)
+	}
+	return uint32(key), nil
+}
+
+// formatVtiKey formats a vti key as a dotted quad.
+func formatVtiKey(key uint32) string {
+	return net.IPv4(byte(key>>24), byte(key>>16), byte(key>>8), byte(key)).String()
+}
+
+// vtiAddr returns ip, or "" if it is unset or unspecified.
+func vtiAddr(ip net.IP) string {
+	if ip == nil || ip.IsUnspecified() {
+		return ""
+	}
+	return ip.String()
+}
+
+// parseQueueCount parses the QUEUE_COUNT of numtxqueues or numrxqueues. A
+// count of 0 would not be sent to the kernel, leaving its default in place,
+// so only positive counts are accepted.
+func (cmd *cmd) parseQueueCount(name string) (int, error) {
+	n, err := cmd.parseInt("QUEUE_COUNT")
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("invalid %s %d: must be positive", name, n)
+	}
+	return n, nil
 }
 
 func (cmd *cmd) parseLinkAttrs() (string, netlink.LinkAttrs, error) {
@@ -397,14 +803,14 @@ func (cmd *cmd) parseLinkAttrs() (string, netlink.LinkAttrs, error) {
 			}
 			attrs.Index = index
 		case "numtxqueues":
-			numtxqueues, err := cmd.parseInt("QUEUE_COUNT")
+			numtxqueues, err := cmd.parseQueueCount("numtxqueues")
 			if err != nil {
 				return "", netlink.LinkAttrs{}, err
 			}
 
 			attrs.NumTxQueues = numtxqueues
 		case "numrxqueues":
-			numrxqueues, err := cmd.parseInt("QUEUE_COUNT")
+			numrxqueues, err := cmd.parseQueueCount("numrxqueues")
 			if err != nil {
 				return "", netlink.LinkAttrs{}, err
 			}
@@ -412,6 +818,10 @@ func (cmd *cmd) parseLinkAttrs() (string, netlink.LinkAttrs, error) {
 			attrs.NumRxQueues = numrxqueues
 		case "type":
 			typeName = cmd.nextToken("TYPE")
+			// The rest are the arguments of the type.
+			if linkTypeArgs[typeName] {
+				return typeName, attrs, nil
+			}
 		default:
 			return "", netlink.LinkAttrs{}, cmd.usage()
 		}
@@ -430,15 +840,31 @@ func (cmd *cmd) linkDel() error {
 		return err
 	}
 
+	if cmd.Opts.DryRun {
+		return cmd.printLinkJSON([]netlink.Link{link}, nil, nil)
+	}
+
 	return cmd.handle.LinkDel(link)
 }
 
 func (cmd *cmd) linkShow() error {
-	dev, typeName, err := cmd.parseLinkShow()
+	dev, typeName, hwAddr, err := cmd.parseLinkShow()
 	if err != nil {
 		return err
 	}
 
+	if hwAddr != nil {
+		links, err := cmd.handle.LinkList()
+		if err != nil {
+			return fmt.Errorf("can't enumerate interfaces: %v", err)
+		}
+		if dev != nil {
+			links = []netlink.Link{dev}
+		}
+		links = filterLinksByHardwareAddr(links, hwAddr)
+		return cmd.showLinks(make([][]netlink.Addr, len(links)), links, typeName...)
+	}
+
 	if dev == nil {
 		return cmd.showAllLinks(false, typeName...)
 	}
@@ -446,25 +872,50 @@ func (cmd *cmd) linkShow() error {
 	return cmd.showLink(dev, false, typeName...)
 }
 
-func (cmd *cmd) parseLinkShow() (netlink.Link, []string, error) {
+// filterLinksByHardwareAddr returns the links whose hardware address is addr.
+func filterLinksByHardwareAddr(links []netlink.Link, addr net.HardwareAddr) []netlink.Link {
+	var filtered []netlink.Link
+	for _, link := range links {
+		if bytes.Equal(link.Attrs().HardwareAddr, addr) {
+			filtered = append(filtered, link)
+		}
+	}
+	return filtered
+}
+
+func (cmd *cmd) parseLinkShow() (netlink.Link, []string, net.HardwareAddr, error) {
 	var (
 		device netlink.Link
+		hwAddr net.HardwareAddr
 		err    error
 	)
 
 	typeNames := []string{}
 
 	for cmd.tokenRemains() {
-		switch c := cmd.nextToken("device", "type"); c {
+		switch c := cmd.nextToken("device", "type", "address", "master", "up"); c {
+		case "up":
+			cmd.upOnly = true
+		case "master":
+			master, err := cmd.lookupLink(cmd.nextToken("master name"))
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			cmd.master = master.Attrs().Index
 		case "dev":
 			devName := cmd.nextToken("device name")
-			device, err = netlink.LinkByName(devName)
+			device, err = cmd.lookupLink(devName)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		case "address":
+			hwAddr, err = cmd.parseHardwareAddress()
 			if err != nil {
-				return nil, nil, fmt.Errorf("failed to get link %v: %v", device, err)
+				return nil, nil, nil, err
 			}
 		case "type":
 			for cmd.tokenRemains() {
-				if cmd.peekToken("dev") == "dev" {
+				if next := cmd.peekToken("dev", "address", "master", "up"); next == "dev" || next == "address" || next == "master" || next == "up" {
 					break
 				}
 				typeNames = append(typeNames, cmd.nextToken("type name"))
@@ -472,7 +923,7 @@ func (cmd *cmd) parseLinkShow() (netlink.Link, []string, error) {
 		}
 	}
 
-	return device, typeNames, nil
+	return device, typeNames, hwAddr, nil
 }
 
 func (cmd *cmd) link() error {
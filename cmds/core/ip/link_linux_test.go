@@ -7,21 +7,27 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 func TestParseLinkShow(t *testing.T) {
 	tests := []struct {
-		name      string
-		cmd       cmd
-		wantDev   netlink.Link
-		wantTypes []string
-		wantErr   bool
+		name       string
+		cmd        cmd
+		wantDev    netlink.Link
+		wantTypes  []string
+		wantAddr   net.HardwareAddr
+		wantUpOnly bool
+		wantMaster int
+		wantErr    bool
 	}{
 		{
 			name: "Successful parsing",
@@ -33,6 +39,54 @@ func TestParseLinkShow(t *testing.T) {
 			wantDev:   &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "lo"}},
 			wantTypes: []string{"dummy", "abc"},
 		},
+		{
+			name: "Address filter",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "link", "show", "type", "veth", "address", "aa:bb:cc:dd:ee:ff"},
+				Out:    new(bytes.Buffer),
+			},
+			wantTypes: []string{"veth"},
+			wantAddr:  net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		},
+		{
+			name: "Up",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "link", "show", "type", "veth", "up"},
+				Out:    new(bytes.Buffer),
+			},
+			wantTypes:  []string{"veth"},
+			wantUpOnly: true,
+		},
+		{
+			name: "Master",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "link", "show", "type", "veth", "master", "lo"},
+				Out:    new(bytes.Buffer),
+			},
+			wantTypes:  []string{"veth"},
+			wantMaster: 1,
+		},
+		{
+			name: "Invalid master",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "link", "show", "master", "xyz"},
+				Out:    new(bytes.Buffer),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid address",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "link", "show", "address", "xyz"},
+				Out:    new(bytes.Buffer),
+			},
+			wantErr: true,
+		},
 		{
 			name: "Successful parsing",
 			cmd: cmd{
@@ -47,7 +101,7 @@ func TestParseLinkShow(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cmd := tt.cmd
-			gotDev, gotType, err := cmd.parseLinkShow()
+			gotDev, gotType, gotAddr, err := cmd.parseLinkShow()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseLinkShow() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -61,6 +115,15 @@ func TestParseLinkShow(t *testing.T) {
 				if c := cmp.Diff(gotType, tt.wantTypes); c != "" {
 					t.Errorf("parseLinkShow() diff:\n%v", c)
 				}
+				if gotAddr.String() != tt.wantAddr.String() {
+					t.Errorf("parseLinkShow() gotAddr = %v, want %v", gotAddr, tt.wantAddr)
+				}
+				if cmd.upOnly != tt.wantUpOnly {
+					t.Errorf("parseLinkShow() upOnly = %t, want %t", cmd.upOnly, tt.wantUpOnly)
+				}
+				if cmd.master != tt.wantMaster {
+					t.Errorf("parseLinkShow() master = %d, want %d", cmd.master, tt.wantMaster)
+				}
 			}
 		})
 	}
@@ -147,6 +210,24 @@ func TestParseLinkAttrs(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "zero numtxqueues",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "link", "add", "name", "veth0", "numtxqueues", "0", "type", "veth"},
+				Out:    new(bytes.Buffer),
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative numrxqueues",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "link", "add", "name", "veth0", "numrxqueues", "-1", "type", "veth"},
+				Out:    new(bytes.Buffer),
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid arg",
 			cmd: cmd{
@@ -175,3 +256,362 @@ func TestParseLinkAttrs(t *testing.T) {
 		})
 	}
 }
+
+func TestParseLinkVf(t *testing.T) {
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	vlan, rate, state := 100, 1000, uint32(2)
+	on, off := true, false
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    vfConfig
+		wantErr bool
+	}{
+		{
+			name: "All settings",
+			args: []string{"ip", "link", "set", "dev", "eth0", "vf", "0", "mac", "aa:bb:cc:dd:ee:ff", "vlan", "100", "qos", "3", "max_tx_rate", "1000", "state", "disable", "spoofchk", "off", "trust", "on"},
+			want: vfConfig{vf: 0, mac: mac, vlan: &vlan, qos: 3, maxTxRate: &rate, state: &state, spoofchk: &off, trust: &on},
+		},
+		{
+			name: "Numeric state",
+			args: []string{"ip", "link", "set", "dev", "eth0", "vf", "1", "state", "2"},
+			want: vfConfig{vf: 1, state: &state},
+		},
+		{
+			name:    "No settings",
+			args:    []string{"ip", "link", "set", "dev", "eth0", "vf", "1"},
+			wantErr: true,
+		},
+		{
+			name:    "Qos without vlan",
+			args:    []string{"ip", "link", "set", "dev", "eth0", "vf", "1", "qos", "3"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid vlan",
+			args:    []string{"ip", "link", "set", "dev", "eth0", "vf", "1", "vlan", "5000"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid state",
+			args:    []string{"ip", "link", "set", "dev", "eth0", "vf", "1", "state", "x"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := cmd{Cursor: 5, Args: tt.args, Out: new(bytes.Buffer)}
+			got, err := cmd.parseLinkVf()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLinkVf() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if c := cmp.Diff(tt.want, got, cmp.AllowUnexported(vfConfig{})); c != "" {
+				t.Errorf("parseLinkVf() diff:\n%v", c)
+			}
+		})
+	}
+}
+
+func TestParseGSOLimit(t *testing.T) {
+	for _, tt := range []struct {
+		name, value string
+		want        uint32
+		wantErr     bool
+	}{
+		{name: "gso_max_size", value: "65536", want: 65536},
+		{name: "gso_max_size", value: "524280", want: 524280},
+		{name: "gso_max_size", value: "524281", wantErr: true},
+		{name: "gso_max_size", value: "0", wantErr: true},
+		{name: "gso_max_segs", value: "64", want: 64},
+		{name: "gso_max_segs", value: "65536", wantErr: true},
+		{name: "gso_max_segs", value: "many", wantErr: true},
+	} {
+		t.Run(tt.name+"_"+tt.value, func(t *testing.T) {
+			cmd := cmd{Cursor: 5, Args: []string{"ip", "link", "set", "dev", "eth0", tt.name, tt.value}, Out: new(bytes.Buffer)}
+			got, err := cmd.parseGSOLimit(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseGSOLimit(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseGSOLimit(%q) = %d, want %d", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVfInfo(t *testing.T) {
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	got := vfInfo([]netlink.VfInfo{
+		{ID: 0, Mac: mac, Vlan: 100, Qos: 3, MaxTxRate: 1000, Spoofchk: true, LinkState: 1, Trust: 1},
+		{ID: 1, Mac: mac, LinkState: 7},
+	})
+	want := []VfInfo{
+		{VF: 0, Address: "aa:bb:cc:dd:ee:ff", VlanList: []VfVlan{{Vlan: 100, Qos: 3}}, Rate: VfRate{MaxTx: 1000}, Spoofchk: true, LinkState: "enable", Trust: true},
+		{VF: 1, Address: "aa:bb:cc:dd:ee:ff", LinkState: "7"},
+	}
+	if c := cmp.Diff(want, got); c != "" {
+		t.Errorf("vfInfo() diff:\n%v", c)
+	}
+}
+
+func TestFilterLinksByHardwareAddr(t *testing.T) {
+	mac := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	links := []netlink.Link{
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "lo"}},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", HardwareAddr: mac}},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", HardwareAddr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x00}}},
+	}
+	got := filterLinksByHardwareAddr(links, mac)
+	if len(got) != 1 || got[0].Attrs().Name != "eth0" {
+		t.Errorf("filterLinksByHardwareAddr() = %v, want eth0", got)
+	}
+}
+
+func TestVerifyRename(t *testing.T) {
+	old := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}}
+	addr := func(s string) netlink.Addr {
+		a, err := netlink.ParseAddr(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *a
+	}
+	before := []netlink.Addr{addr("10.0.0.1/24"), addr("2001:db8::1/64")}
+
+	for _, tt := range []struct {
+		name    string
+		renamed netlink.Link
+		after   []netlink.Addr
+		wantErr bool
+	}{
+		{
+			name:    "Unchanged",
+			renamed: &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "lan0", Index: 2}},
+			after:   []netlink.Addr{addr("2001:db8::1/64"), addr("10.0.0.1/24")},
+		},
+		{
+			name:    "Wrong name",
+			renamed: &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}},
+			after:   before,
+			wantErr: true,
+		},
+		{
+			name:    "Index changed",
+			renamed: &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "lan0", Index: 3}},
+			after:   before,
+			wantErr: true,
+		},
+		{
+			name:    "Address lost",
+			renamed: &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "lan0", Index: 2}},
+			after:   before[:1],
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyRename(old, tt.renamed, "lan0", before, tt.after); (err != nil) != tt.wantErr {
+				t.Errorf("verifyRename() = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseVti(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		want    *netlink.Vti
+		wantDev string
+		wantErr bool
+	}{
+		{
+			name: "vti",
+			args: []string{"ip", "link", "add", "vti0", "type", "vti", "local", "10.0.0.1", "remote", "10.0.0.2", "key", "42", "dev", "eth0"},
+			want: &netlink.Vti{
+				LinkAttrs: netlink.LinkAttrs{Name: "vti0"},
+				Local:     net.ParseIP("10.0.0.1"),
+				Remote:    net.ParseIP("10.0.0.2"),
+				IKey:      42,
+				OKey:      42,
+			},
+			wantDev: "eth0",
+		},
+		{
+			name: "vti6 keys",
+			args: []string{"ip", "link", "add", "vti1", "type", "vti6", "remote", "2001:db8::2", "ikey", "0.0.0.1", "okey", "0x2"},
+			want: &netlink.Vti{
+				LinkAttrs: netlink.LinkAttrs{Name: "vti1"},
+				Local:     net.IPv6unspecified,
+				Remote:    net.ParseIP("2001:db8::2"),
+				IKey:      1,
+				OKey:      2,
+			},
+		},
+		{
+			name: "No args",
+			args: []string{"ip", "link", "add", "vti0", "mtu", "1400", "type", "vti"},
+			want: &netlink.Vti{LinkAttrs: netlink.LinkAttrs{Name: "vti0", MTU: 1400}, Local: net.IPv4zero},
+		},
+		{
+			name:    "Wrong family",
+			args:    []string{"ip", "link", "add", "vti0", "type", "vti", "remote", "2001:db8::2"},
+			wantErr: true,
+		},
+		{
+			name:    "Key too large",
+			args:    []string{"ip", "link", "add", "vti0", "type", "vti", "key", "4294967296"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid arg",
+			args:    []string{"ip", "link", "add", "vti0", "type", "vti", "ttl", "64"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := cmd{Cursor: 2, Args: tt.args, Out: new(bytes.Buffer)}
+			typeName, attrs, err := cmd.parseLinkAttrs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, dev, err := cmd.parseVti(typeName, attrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVti() = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) || dev != tt.wantDev {
+				t.Errorf("parseVti() = %+v, %q, want %+v, %q", got, dev, tt.want, tt.wantDev)
+			}
+			if got.Type() != typeName {
+				t.Errorf("parseVti() type = %s, want %s", got.Type(), typeName)
+			}
+		})
+	}
+}
+
+func TestParseVtiKey(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    uint32
+		wantErr bool
+	}{
+		{in: "42", want: 42},
+		{in: "0x100", want: 256},
+		{in: "1.2.3.4", want: 0x01020304},
+		{in: "4294967295", want: 1<<32 - 1},
+		{in: "-1", wantErr: true},
+		{in: "1.2.3", wantErr: true},
+		{in: "key", wantErr: true},
+	} {
+		got, err := parseVtiKey(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseVtiKey(%q) = %d, %v, want %d, wantErr %t", tt.in, got, err, tt.want, tt.wantErr)
+		}
+		if err == nil && tt.in == "1.2.3.4" && formatVtiKey(got) != tt.in {
+			t.Errorf("formatVtiKey(%d) = %s, want %s", got, formatVtiKey(got), tt.in)
+		}
+	}
+}
+
+func TestVtiAddError(t *testing.T) {
+	err := vtiAddError("vti6", unix.EOPNOTSUPP)
+	if !errors.Is(err, unix.EOPNOTSUPP) || !strings.Contains(err.Error(), "ip6_vti") {
+		t.Errorf("vtiAddError(EOPNOTSUPP) = %v, want mention of ip6_vti", err)
+	}
+	if err := vtiAddError("vti", unix.EEXIST); err != unix.EEXIST {
+		t.Errorf("vtiAddError(EEXIST) = %v, want %v", err, unix.EEXIST)
+	}
+}
+
+func TestLinkDryRun(t *testing.T) {
+	eth0 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2, MTU: 1500, Flags: net.FlagUp | net.FlagBroadcast}}
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "add dummy",
+			args: []string{"add", "dummy0", "type", "dummy", "mtu", "1400", "address", "02:00:00:00:00:01"},
+			want: `{"ifname":"dummy0","flags":[],"mtu":1400,"operstate":"unknown","link_type":"dummy","address":"02:00:00:00:00:01","linkinfo":{"info_kind":"dummy"}}`,
+		},
+		{
+			name: "add vrf",
+			args: []string{"add", "name", "vrf-blue", "type", "vrf", "table", "10"},
+			want: `{"ifname":"vrf-blue","flags":[],"operstate":"unknown","link_type":"vrf","address":"","linkinfo":{"info_kind":"vrf"}}`,
+		},
+		{
+			name: "add vti on eth0",
+			args: []string{"add", "vti1", "type", "vti", "remote", "192.0.2.1", "key", "5", "dev", "eth0"},
+			want: `{"ifname":"vti1","flags":[],"operstate":"unknown","link_type":"vti","address":"","linkinfo":{"info_kind":"vti","info_data":{"remote":"192.0.2.1","ikey":"0.0.0.5","okey":"0.0.0.5"}}}`,
+		},
+		{
+			name: "delete",
+			args: []string{"delete", "dev", "eth0"},
+			want: `[{"ifindex":2,"ifname":"eth0","flags":["up","broadcast"],"mtu":1500,"operstate":"unknown","group":"default","link_type":"device","address":""}]`,
+		},
+		{
+			name:    "name too long",
+			args:    []string{"add", "name", "a-very-long-name0", "type", "dummy"},
+			wantErr: "longer than 15 bytes",
+		},
+		{
+			name:    "name with slash",
+			args:    []string{"add", "a/b", "type", "dummy"},
+			wantErr: `invalid device name "a/b"`,
+		},
+		{
+			name:    "negative mtu",
+			args:    []string{"add", "dummy0", "type", "dummy", "mtu", "-1"},
+			wantErr: "invalid mtu -1",
+		},
+		{
+			name:    "unsupported type",
+			args:    []string{"add", "dummy0", "type", "nosuch"},
+			wantErr: "unsupported link type nosuch",
+		},
+		{
+			name:    "vti on missing device",
+			args:    []string{"add", "vti1", "type", "vti", "dev", "eth1"},
+			wantErr: `cannot find device "eth1"`,
+		},
+		{
+			name:    "delete missing device",
+			args:    []string{"delete", "eth1"},
+			wantErr: "eth1",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			// Without a netlink handle, committing the change would panic.
+			c := cmd{
+				Cursor:    1,
+				Args:      append([]string{"ip", "link"}, tt.args...),
+				Out:       &out,
+				Opts:      flags{DryRun: true},
+				linkCache: newLinkCache([]netlink.Link{eth0}),
+			}
+			err := c.link()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("link() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("link() = %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("link() printed\n%s\nwant\n%s", &out, tt.want)
+			}
+		})
+	}
+}
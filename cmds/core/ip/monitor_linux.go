@@ -7,6 +7,7 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"math"
 	"net"
 	"os"
@@ -16,6 +17,9 @@ import (
 	"time"
 
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
 )
 
 const monitorHelp = `Usage: ip monitor [ all | OBJECTS ] [ label ]
@@ -33,13 +37,49 @@ var (
 	nsidLabel    string
 	prefixLabel  string
 	routeLabel   string
+	ruleLabel    string
 )
 
+// monitorObjects are the objects `ip monitor all` subscribes to.
+var monitorObjects = []string{"link", "address", "route", "neigh", "rule", "nexthop"}
+
+// MonitorEvent is a single `ip -json monitor` event.
+type MonitorEvent struct {
+	Event    string `json:"event"`
+	Type     string `json:"type"`
+	IfIndex  int    `json:"ifindex,omitempty"`
+	Dev      string `json:"dev,omitempty"`
+	Flags    string `json:"flags,omitempty"`
+	Family   string `json:"family,omitempty"`
+	Local    string `json:"local,omitempty"`
+	Dst      string `json:"dst,omitempty"`
+	Src      string `json:"src,omitempty"`
+	Lladdr   string `json:"lladdr,omitempty"`
+	State    string `json:"state,omitempty"`
+	Table    uint32 `json:"table,omitempty"`
+	Priority uint32 `json:"priority,omitempty"`
+	ID       uint32 `json:"id,omitempty"`
+}
+
+// monitorUpdates holds the channels updates of each object arrive on.
+type monitorUpdates struct {
+	addr    chan netlink.AddrUpdate
+	link    chan netlink.LinkUpdate
+	neigh   chan netlink.NeighUpdate
+	route   chan netlink.RouteUpdate
+	rule    chan syscall.NetlinkMessage
+	nexthop chan syscall.NetlinkMessage
+}
+
 func (cmd *cmd) monitor() error {
-	addrUpdates := make(chan netlink.AddrUpdate)
-	linkUpdates := make(chan netlink.LinkUpdate)
-	neighUpdates := make(chan netlink.NeighUpdate)
-	routeUpdates := make(chan netlink.RouteUpdate)
+	updates := monitorUpdates{
+		addr:    make(chan netlink.AddrUpdate),
+		link:    make(chan netlink.LinkUpdate),
+		neigh:   make(chan netlink.NeighUpdate),
+		route:   make(chan netlink.RouteUpdate),
+		rule:    make(chan syscall.NetlinkMessage),
+		nexthop: make(chan syscall.NetlinkMessage),
+	}
 	done := make(chan struct{})
 	defer close(done)
 
@@ -47,36 +87,25 @@ func (cmd *cmd) monitor() error {
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 
-	var singleOptionSelected bool
+	var (
+		all     bool
+		objects []string
+	)
 
 	for cmd.tokenRemains() {
 		token := cmd.nextToken("all", "address", "link", "mroute", "neigh", "netconf", "nexthop", "nsid", "prefix", "route", "rule", "label", "help")
 
 		switch token {
 		case "all":
-			if singleOptionSelected {
+			if len(objects) > 0 {
 				return fmt.Errorf("all option can't be used with other options")
 			}
-		case "address":
-			singleOptionSelected = true
-			if err := netlink.AddrSubscribe(addrUpdates, done); err != nil {
-				return fmt.Errorf("failed to subscribe to address updates: %v", err)
-			}
-		case "link":
-			singleOptionSelected = true
-			if err := netlink.LinkSubscribe(linkUpdates, done); err != nil {
-				return fmt.Errorf("failed to subscribe to link updates: %v", err)
-			}
-		case "neigh":
-			singleOptionSelected = true
-			if err := netlink.NeighSubscribe(neighUpdates, done); err != nil {
-				return fmt.Errorf("failed to subscribe to neigh updates: %v", err)
-			}
-		case "route":
-			singleOptionSelected = true
-			if err := netlink.RouteSubscribe(routeUpdates, done); err != nil {
-				return fmt.Errorf("failed to subscribe to route updates: %v", err)
+			all = true
+		case "address", "link", "neigh", "route", "rule", "nexthop":
+			if all {
+				return fmt.Errorf("all option can't be used with other options")
 			}
+			objects = append(objects, token)
 		case "label":
 			addressLabel = "[ADDR]"
 			linkLabel = "[LINK]"
@@ -87,37 +116,97 @@ func (cmd *cmd) monitor() error {
 			nsidLabel = "[NSID]"
 			prefixLabel = "[PREFIX]"
 			routeLabel = "[ROUTE]"
-		case "mroute", "netconf", "nexthop", "nsid", "prefix", "rule":
+			ruleLabel = "[RULE]"
+		case "mroute", "netconf", "nsid", "prefix":
 			return fmt.Errorf("monitoring %s is not yet supported", cmd.currentToken())
 		case "help":
-			fmt.Fprint(cmd.Out, tunnelHelp)
+			fmt.Fprint(cmd.Out, monitorHelp)
 			return nil
 		default:
 			return cmd.usage()
 		}
-
 	}
 
 	// if either the all option was selected or no option was selected, subscribe to all
-	if !singleOptionSelected {
-		if err := netlink.AddrSubscribe(addrUpdates, done); err != nil {
-			return fmt.Errorf("failed to subscribe to address updates: %v", err)
-		}
-		if err := netlink.LinkSubscribe(linkUpdates, done); err != nil {
-			return fmt.Errorf("failed to subscribe to link updates: %v", err)
-		}
-		if err := netlink.NeighSubscribe(neighUpdates, done); err != nil {
-			return fmt.Errorf("failed to subscribe to neigh updates: %v", err)
-		}
-		if err := netlink.RouteSubscribe(routeUpdates, done); err != nil {
-			return fmt.Errorf("failed to subscribe to route updates: %v", err)
+	if len(objects) == 0 {
+		objects = monitorObjects
+	}
+
+	// A group the kernel does not support must not stop the others.
+	subscribed := 0
+	for _, object := range objects {
+		if err := updates.subscribe(cmd.ns, object, done); err != nil {
+			log.Printf("ip: %v", err)
+			continue
 		}
+		subscribed++
+	}
+	if subscribed == 0 {
+		return fmt.Errorf("failed to subscribe to any of %v", objects)
 	}
 
-	return cmd.printUpdates(addrUpdates, linkUpdates, neighUpdates, routeUpdates, done, sig)
+	return cmd.printUpdates(updates, done, sig)
+}
+
+// subscribe subscribes to the updates of object in the namespace ns, or in
+// the current one if ns is nil.
+func (u monitorUpdates) subscribe(ns *netns.NsHandle, object string, done chan struct{}) error {
+	var err error
+	switch object {
+	case "address":
+		err = netlink.AddrSubscribeWithOptions(u.addr, done, netlink.AddrSubscribeOptions{Namespace: ns})
+	case "link":
+		err = netlink.LinkSubscribeWithOptions(u.link, done, netlink.LinkSubscribeOptions{Namespace: ns})
+	case "neigh":
+		err = netlink.NeighSubscribeWithOptions(u.neigh, done, netlink.NeighSubscribeOptions{Namespace: ns})
+	case "route":
+		err = netlink.RouteSubscribeWithOptions(u.route, done, netlink.RouteSubscribeOptions{Namespace: ns})
+	case "rule":
+		err = subscribeGroups(ns, u.rule, done, unix.RTNLGRP_IPV4_RULE, unix.RTNLGRP_IPV6_RULE)
+	case "nexthop":
+		err = subscribeGroups(ns, u.nexthop, done, unix.RTNLGRP_NEXTHOP)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s updates: %v", object, err)
+	}
+	return nil
+}
+
+// subscribeGroups forwards the messages of the rtnetlink multicast groups to
+// ch until done is closed. It is used for the objects the netlink package has
+// no subscription for.
+func subscribeGroups(ns *netns.NsHandle, ch chan<- syscall.NetlinkMessage, done <-chan struct{}, groups ...uint) error {
+	at := netns.None()
+	if ns != nil {
+		at = *ns
+	}
+	s, err := nl.SubscribeAt(at, netns.None(), unix.NETLINK_ROUTE, groups...)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-done
+		s.Close()
+	}()
+	go func() {
+		for {
+			msgs, _, err := s.Receive()
+			if err != nil {
+				return
+			}
+			for _, m := range msgs {
+				select {
+				case ch <- m:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return nil
 }
 
-func (cmd *cmd) printUpdates(addrUpdates chan netlink.AddrUpdate, linkUpdates chan netlink.LinkUpdate, neighUpdates chan netlink.NeighUpdate, routeUpdates chan netlink.RouteUpdate, done chan struct{}, sig chan os.Signal) error {
+func (cmd *cmd) printUpdates(updates monitorUpdates, done chan struct{}, sig chan os.Signal) error {
 	timestamp := ""
 
 	for {
@@ -130,74 +219,263 @@ func (cmd *cmd) printUpdates(addrUpdates chan netlink.AddrUpdate, linkUpdates ch
 			timestamp = currentTime.Format("[2006-01-02T15:04:05.000000]")
 		}
 
+		var (
+			ev    MonitorEvent
+			label string
+			text  string
+			err   error
+		)
+
 		select {
-		case update := <-addrUpdates:
+		case update := <-updates.addr:
+			ev, text, err = cmd.addrEvent(update)
+			label = addressLabel
+		case update := <-updates.neigh:
+			ev, text, err = cmd.neighEvent(update)
+			label = neighLabel
+		case update := <-updates.route:
+			ev, text, err = cmd.routeEvent(update)
+			label = routeLabel
+		case update := <-updates.link:
+			ev, text = linkEvent(update)
+			label = linkLabel
+		case m := <-updates.rule:
+			ev, text, err = ruleEvent(m)
+			label = ruleLabel
+		case m := <-updates.nexthop:
+			ev, text, err = nexthopEvent(m)
+			label = nexthopLabel
+		case <-sig:
+			return nil
+		case <-done:
+			return nil
+		default:
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := cmd.printMonitorEvent(ev, timestamp+label+text); err != nil {
+			return err
+		}
+	}
+}
 
-			link, err := netlink.LinkByIndex(update.LinkIndex)
-			if err != nil {
-				return fmt.Errorf("failed to get link by index %d: %v", update.LinkIndex, err)
-			}
+// printMonitorEvent prints an event as JSON or as text. With -o, the lines of
+// the text, including any timestamp, are joined with a backslash as in
+// iproute2. JSON events are always a single line.
+func (cmd *cmd) printMonitorEvent(ev MonitorEvent, text string) error {
+	if !cmd.Opts.JSON {
+		if cmd.Opts.Oneline {
+			text = strings.ReplaceAll(strings.TrimSuffix(text, "\n"), "\n", "\\") + "\n"
+		}
+		_, err := fmt.Fprint(cmd.Out, text)
+		return err
+	}
+	if err := printJSON(*cmd, ev); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(cmd.Out)
+	return err
+}
 
-			var action string
-			if !update.NewAddr {
-				action = "Deleted"
-			}
+// eventName returns the JSON event name of a netlink message type.
+func eventName(deleted bool) string {
+	if deleted {
+		return "del"
+	}
+	return "new"
+}
 
-			fmt.Fprintf(cmd.Out, "%s%s%s %d: %s    %v %v scope %d %v\n", timestamp, addressLabel, action, update.LinkIndex, link.Attrs().Name, ipFamily(update.LinkAddress.IP), update.LinkAddress.String(), update.Scope, link.Attrs().Name)
+func (cmd *cmd) addrEvent(update netlink.AddrUpdate) (MonitorEvent, string, error) {
+	link, err := cmd.handle.LinkByIndex(update.LinkIndex)
+	if err != nil {
+		return MonitorEvent{}, "", fmt.Errorf("failed to get link by index %d: %v", update.LinkIndex, err)
+	}
 
-			validLft := fmt.Sprintf("%v", update.ValidLft)
-			preferedLft := fmt.Sprintf("%v", update.PreferedLft)
+	var action string
+	if !update.NewAddr {
+		action = "Deleted"
+	}
 
-			if update.ValidLft >= math.MaxInt32 {
-				validLft = "forever"
-			}
+	validLft := fmt.Sprintf("%v", update.ValidLft)
+	preferedLft := fmt.Sprintf("%v", update.PreferedLft)
 
-			if update.PreferedLft >= math.MaxInt32 {
-				preferedLft = "forever"
-			}
+	if update.ValidLft >= math.MaxInt32 {
+		validLft = "forever"
+	}
 
-			fmt.Fprintf(cmd.Out, "    valid_lft %s preferred_lft %s\n", validLft, preferedLft)
+	if update.PreferedLft >= math.MaxInt32 {
+		preferedLft = "forever"
+	}
 
-		case update := <-neighUpdates:
-			var action string
+	text := fmt.Sprintf("%s %d: %s    %v %v scope %d %v\n", action, update.LinkIndex, link.Attrs().Name, ipFamily(update.LinkAddress.IP), update.LinkAddress.String(), update.Scope, link.Attrs().Name) +
+		fmt.Sprintf("    valid_lft %s preferred_lft %s\n", validLft, preferedLft)
+
+	return MonitorEvent{
+		Event:   eventName(!update.NewAddr),
+		Type:    "address",
+		IfIndex: update.LinkIndex,
+		Dev:     link.Attrs().Name,
+		Family:  ipFamily(update.LinkAddress.IP),
+		Local:   update.LinkAddress.String(),
+	}, text, nil
+}
 
-			if update.Type == syscall.RTM_DELNEIGH {
-				action = "Deleted "
-			}
+func (cmd *cmd) neighEvent(update netlink.NeighUpdate) (MonitorEvent, string, error) {
+	var action string
 
-			link, err := netlink.LinkByIndex(update.Neigh.LinkIndex)
-			if err != nil {
-				return fmt.Errorf("failed to get link by index %d: %v", update.Neigh.LinkIndex, err)
-			}
+	if update.Type == syscall.RTM_DELNEIGH {
+		action = "Deleted "
+	}
 
-			fmt.Fprintf(cmd.Out, "%s%s%s%s dev %v lladdr %s %v\n", timestamp, neighLabel, action, update.Neigh.IP, link.Attrs().Name, update.Neigh.HardwareAddr.String(), neighStateToString(update.Neigh.State))
+	link, err := cmd.handle.LinkByIndex(update.Neigh.LinkIndex)
+	if err != nil {
+		return MonitorEvent{}, "", fmt.Errorf("failed to get link by index %d: %v", update.Neigh.LinkIndex, err)
+	}
 
-		case update := <-routeUpdates:
-			var action string
-			switch update.Type {
-			case syscall.RTM_NEWROUTE:
-				action = "Added"
-			case syscall.RTM_DELROUTE:
-				action = "Deleted"
-			}
+	text := fmt.Sprintf("%s%s dev %v lladdr %s %v\n", action, update.Neigh.IP, link.Attrs().Name, update.Neigh.HardwareAddr.String(), neighStateToString(update.Neigh.State))
+
+	return MonitorEvent{
+		Event:   eventName(update.Type == syscall.RTM_DELNEIGH),
+		Type:    "neigh",
+		IfIndex: update.Neigh.LinkIndex,
+		Dev:     link.Attrs().Name,
+		Dst:     update.Neigh.IP.String(),
+		Lladdr:  update.Neigh.HardwareAddr.String(),
+		State:   neighStateToString(update.Neigh.State),
+	}, text, nil
+}
 
-			link, err := netlink.LinkByIndex(update.Route.LinkIndex)
-			if err != nil {
-				return fmt.Errorf("failed to get link by index %d: %v", update.Route.LinkIndex, err)
-			}
+func (cmd *cmd) routeEvent(update netlink.RouteUpdate) (MonitorEvent, string, error) {
+	var action string
+	switch update.Type {
+	case syscall.RTM_NEWROUTE:
+		action = "Added"
+	case syscall.RTM_DELROUTE:
+		action = "Deleted"
+	}
 
-			fmt.Fprintf(cmd.Out, "%s%s%s %s dev %s table %d proto %s scope %s src %s\n", timestamp, routeLabel, action, update.Route.Dst, link.Attrs().Name, update.Route.Table, update.Route.Protocol.String(), update.Route.Scope.String(), update.Route.Src)
-		case update := <-linkUpdates:
-			fmt.Fprintf(cmd.Out, "%s%s%d: %s: <%s>\n", timestamp, linkLabel, update.Link.Attrs().Index, update.Link.Attrs().Name, strings.Replace(strings.ToUpper(net.Flags(update.Flags).String()), "|", ",", -1))
-			fmt.Fprintf(cmd.Out, "    link/%v\n", update.Link.Attrs().EncapType)
-		case <-sig:
-			return nil
-		case <-done:
-			return nil
-		default:
-			time.Sleep(50 * time.Millisecond)
+	link, err := cmd.handle.LinkByIndex(update.Route.LinkIndex)
+	if err != nil {
+		return MonitorEvent{}, "", fmt.Errorf("failed to get link by index %d: %v", update.Route.LinkIndex, err)
+	}
+
+	text := fmt.Sprintf("%s %s dev %s table %d proto %s scope %s src %s\n", action, update.Route.Dst, link.Attrs().Name, update.Route.Table, update.Route.Protocol.String(), update.Route.Scope.String(), update.Route.Src)
+
+	ev := MonitorEvent{
+		Event:   eventName(update.Type == syscall.RTM_DELROUTE),
+		Type:    "route",
+		IfIndex: update.Route.LinkIndex,
+		Dev:     link.Attrs().Name,
+		Table:   uint32(update.Route.Table),
+	}
+	if update.Route.Dst != nil {
+		ev.Dst = update.Route.Dst.String()
+	}
+	if update.Route.Src != nil {
+		ev.Src = update.Route.Src.String()
+	}
+	return ev, text, nil
+}
+
+func linkEvent(update netlink.LinkUpdate) (MonitorEvent, string) {
+	flags := strings.Replace(strings.ToUpper(net.Flags(update.Flags).String()), "|", ",", -1)
+	text := fmt.Sprintf("%d: %s: <%s>\n", update.Link.Attrs().Index, update.Link.Attrs().Name, flags) +
+		fmt.Sprintf("    link/%v\n", update.Link.Attrs().EncapType)
+
+	return MonitorEvent{
+		Event:   eventName(update.Header.Type == unix.RTM_DELLINK),
+		Type:    "link",
+		IfIndex: update.Link.Attrs().Index,
+		Dev:     update.Link.Attrs().Name,
+		Flags:   flags,
+	}, text
+}
+
+// familyName returns the name of an address family as printed by ip.
+func familyName(family uint8) string {
+	switch family {
+	case unix.AF_INET:
+		return "inet"
+	case unix.AF_INET6:
+		return "inet6"
+	}
+	return fmt.Sprintf("family %d", family)
+}
+
+// ruleEvent decodes an RTM_NEWRULE or RTM_DELRULE message.
+func ruleEvent(m syscall.NetlinkMessage) (MonitorEvent, string, error) {
+	if len(m.Data) < unix.SizeofRtMsg {
+		return MonitorEvent{}, "", fmt.Errorf("short rule message: %d bytes", len(m.Data))
+	}
+	// struct fib_rule_hdr has the layout of struct rtmsg.
+	msg := nl.DeserializeRtMsg(m.Data)
+	attrs, err := nl.ParseRouteAttr(m.Data[unix.SizeofRtMsg:])
+	if err != nil {
+		return MonitorEvent{}, "", fmt.Errorf("can't parse rule message: %v", err)
+	}
+
+	ev := MonitorEvent{
+		Event:  eventName(m.Header.Type == unix.RTM_DELRULE),
+		Type:   "rule",
+		Family: familyName(msg.Family),
+		Table:  uint32(msg.Table),
+	}
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case unix.FRA_TABLE:
+			ev.Table = nl.NativeEndian().Uint32(a.Value)
+		case unix.FRA_PRIORITY:
+			ev.Priority = nl.NativeEndian().Uint32(a.Value)
+		}
+	}
+
+	var action string
+	if ev.Event == "del" {
+		action = "Deleted "
+	}
+	text := fmt.Sprintf("%s%d:\t%s lookup %d\n", action, ev.Priority, ev.Family, ev.Table)
+	return ev, text, nil
+}
+
+// sizeofNhmsg is the size of struct nhmsg.
+const sizeofNhmsg = 8
+
+// nexthopEvent decodes an RTM_NEWNEXTHOP or RTM_DELNEXTHOP message.
+func nexthopEvent(m syscall.NetlinkMessage) (MonitorEvent, string, error) {
+	if len(m.Data) < sizeofNhmsg {
+		return MonitorEvent{}, "", fmt.Errorf("short nexthop message: %d bytes", len(m.Data))
+	}
+	attrs, err := nl.ParseRouteAttr(m.Data[sizeofNhmsg:])
+	if err != nil {
+		return MonitorEvent{}, "", fmt.Errorf("can't parse nexthop message: %v", err)
+	}
+
+	ev := MonitorEvent{
+		Event:  eventName(m.Header.Type == unix.RTM_DELNEXTHOP),
+		Type:   "nexthop",
+		Family: familyName(m.Data[0]),
+	}
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case unix.NHA_ID:
+			ev.ID = nl.NativeEndian().Uint32(a.Value)
+		case unix.NHA_OIF:
+			ev.IfIndex = int(nl.NativeEndian().Uint32(a.Value))
 		}
 	}
+
+	var action string
+	if ev.Event == "del" {
+		action = "Deleted "
+	}
+	text := fmt.Sprintf("%sid %d", action, ev.ID)
+	if ev.IfIndex != 0 {
+		text += fmt.Sprintf(" oif %d", ev.IfIndex)
+	}
+	return ev, text + "\n", nil
 }
 
 func neighStateToString(state int) string {
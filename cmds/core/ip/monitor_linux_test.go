@@ -6,8 +6,13 @@
 package main
 
 import (
+	"bytes"
 	"net"
+	"syscall"
 	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 )
 
 func TestNeighStateToString(t *testing.T) {
@@ -57,3 +62,118 @@ func TestIpFamily(t *testing.T) {
 		})
 	}
 }
+
+func TestMonitorAllExclusive(t *testing.T) {
+	for _, args := range [][]string{
+		{"ip", "monitor", "all", "link"},
+		{"ip", "monitor", "link", "all"},
+	} {
+		cmd := cmd{Cursor: 1, Args: args, Out: new(bytes.Buffer)}
+		if err := cmd.monitor(); err == nil {
+			t.Errorf("monitor(%q) = nil, want error", args)
+		}
+	}
+}
+
+// rtnlMessage builds a netlink message with a fixed header and u32 attributes.
+func rtnlMessage(typ uint16, hdr []byte, attrs map[uint16]uint32) syscall.NetlinkMessage {
+	data := append([]byte{}, hdr...)
+	for _, k := range []uint16{1, 5, 6, 15} {
+		v, ok := attrs[k]
+		if !ok {
+			continue
+		}
+		data = append(data, nl.NewRtAttr(int(k), nl.Uint32Attr(v)).Serialize()...)
+	}
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: typ}, Data: data}
+}
+
+func TestRuleEvent(t *testing.T) {
+	hdr := make([]byte, unix.SizeofRtMsg)
+	hdr[0] = unix.AF_INET
+	m := rtnlMessage(unix.RTM_DELRULE, hdr, map[uint16]uint32{unix.FRA_PRIORITY: 100, unix.FRA_TABLE: 1000})
+
+	ev, text, err := ruleEvent(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := MonitorEvent{Event: "del", Type: "rule", Family: "inet", Table: 1000, Priority: 100}
+	if ev != want {
+		t.Errorf("ruleEvent() = %+v, want %+v", ev, want)
+	}
+	if text != "Deleted 100:\tinet lookup 1000\n" {
+		t.Errorf("ruleEvent() text = %q", text)
+	}
+
+	if _, _, err := ruleEvent(syscall.NetlinkMessage{Data: []byte{1}}); err == nil {
+		t.Errorf("ruleEvent(short) = nil, want error")
+	}
+}
+
+func TestNexthopEvent(t *testing.T) {
+	hdr := make([]byte, sizeofNhmsg)
+	hdr[0] = unix.AF_INET6
+	m := rtnlMessage(unix.RTM_NEWNEXTHOP, hdr, map[uint16]uint32{unix.NHA_ID: 7, unix.NHA_OIF: 2})
+
+	ev, text, err := nexthopEvent(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := MonitorEvent{Event: "new", Type: "nexthop", Family: "inet6", ID: 7, IfIndex: 2}
+	if ev != want {
+		t.Errorf("nexthopEvent() = %+v, want %+v", ev, want)
+	}
+	if text != "id 7 oif 2\n" {
+		t.Errorf("nexthopEvent() text = %q", text)
+	}
+}
+
+func TestPrintMonitorEvent(t *testing.T) {
+	ev := MonitorEvent{Event: "new", Type: "link", IfIndex: 1, Dev: "lo"}
+
+	var out bytes.Buffer
+	cmd := cmd{Out: &out}
+	if err := cmd.printMonitorEvent(ev, "[LINK]1: lo\n"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "[LINK]1: lo\n" {
+		t.Errorf("text output = %q", &out)
+	}
+
+	out.Reset()
+	cmd.Opts.JSON = true
+	if err := cmd.printMonitorEvent(ev, "[LINK]1: lo\n"); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"event":"new","type":"link","ifindex":1,"dev":"lo"}` + "\n"; out.String() != want {
+		t.Errorf("JSON output = %q, want %q", &out, want)
+	}
+
+	// -o leaves JSON Lines alone.
+	out.Reset()
+	cmd.Opts.Oneline = true
+	if err := cmd.printMonitorEvent(ev, "[LINK]1: lo\n"); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"event":"new","type":"link","ifindex":1,"dev":"lo"}` + "\n"; out.String() != want {
+		t.Errorf("JSON output with -o = %q, want %q", &out, want)
+	}
+
+	out.Reset()
+	cmd.Opts.JSON = false
+	text := "Timestamp: Mon Jan 2 15:04:05 2006 000000 usec\n[LINK]1: lo: <UP>\n    link/loopback\n"
+	if err := cmd.printMonitorEvent(ev, text); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Timestamp: Mon Jan 2 15:04:05 2006 000000 usec\\[LINK]1: lo: <UP>\\    link/loopback\n"; out.String() != want {
+		t.Errorf("text output with -o = %q, want %q", &out, want)
+	}
+}
+
+func TestOnelineOnlyMonitor(t *testing.T) {
+	cmd := cmd{Args: []string{"link", "show"}, Out: new(bytes.Buffer)}
+	cmd.Opts.Oneline = true
+	if err := cmd.runSubCommand(); err == nil {
+		t.Errorf("runSubCommand(-o link show) = nil, want error")
+	}
+}
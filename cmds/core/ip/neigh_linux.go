@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"slices"
 	"strings"
 
 	"github.com/vishvananda/netlink"
@@ -210,46 +211,50 @@ func getState(state int) string {
 }
 
 func (cmd *cmd) showAllNeighbours(nud int, proxy bool) error {
-	ifaces, err := cmd.handle.LinkList()
-	if err != nil {
-		return err
-	}
-
-	return cmd.showNeighbours(nud, proxy, nil, ifaces...)
+	return cmd.showNeighbours(nud, proxy, nil)
 }
 
 type Neigh struct {
 	Dst    net.IP `json:"dst"`
-	Dev    string `json:"dev"`
+	Dev    string `json:"dev,omitempty"`
 	LLAddr string `json:"lladdr,omitempty"`
-	State  string `json:"state,omitempty"`
+	// Proxy is set for proxy ARP and NDP entries, which have no state.
+	Proxy bool   `json:"proxy,omitempty"`
+	State string `json:"state,omitempty"`
 }
 
+// showNeighbours shows the neighbours of ifaces, or of all links if none are
+// given. The kernel dumps the neighbours of all links anyway, so they are
+// fetched once and their devices resolved from the link cache.
 func (cmd *cmd) showNeighbours(nud int, proxy bool, address *net.IP, ifaces ...netlink.Link) error {
 	flags, state, err := cmd.neighFlagState(proxy, nud)
 	if err != nil {
 		return err
 	}
 
-	neighs := make([]netlink.Neigh, 0)
-	linkNames := make([]string, 0)
+	dumped, err := cmd.handle.NeighListExecute(netlink.Ndmsg{
+		Family: uint8(cmd.Family),
+		Flags:  flags,
+		State:  state,
+	})
+	if err != nil {
+		return err
+	}
 
-	for _, iface := range ifaces {
-		linkNeighs, err := cmd.handle.NeighListExecute(netlink.Ndmsg{
-			Family: uint8(cmd.Family),
-			Index:  uint32(iface.Attrs().Index),
-			Flags:  flags,
-			State:  state,
-		})
+	neighs := make([]netlink.Neigh, 0, len(dumped))
+	linkNames := make([]string, 0, len(dumped))
+	for _, neigh := range dumped {
+		if len(ifaces) > 0 && !slices.ContainsFunc(ifaces, func(iface netlink.Link) bool {
+			return iface.Attrs().Index == neigh.LinkIndex
+		}) {
+			continue
+		}
+		name, err := cmd.devName(neigh.LinkIndex)
 		if err != nil {
 			return err
 		}
-
-		neighs = append(neighs, linkNeighs...)
-
-		for range linkNeighs {
-			linkNames = append(linkNames, iface.Attrs().Name)
-		}
+		neighs = append(neighs, neigh)
+		linkNames = append(linkNames, name)
 	}
 
 	filteredNeighs, filteredLinkNames := filterNeighsByAddr(neighs, linkNames, address)
@@ -284,9 +289,10 @@ func (cmd *cmd) printNeighs(neighs []netlink.Neigh, ifacesNames []string) error
 				Dst:    v.IP,
 				Dev:    ifacesNames[idx],
 				LLAddr: v.HardwareAddr.String(),
+				Proxy:  v.Flags&netlink.NTF_PROXY != 0,
 			}
 
-			if !cmd.Opts.Brief {
+			if !cmd.Opts.Brief && !neigh.Proxy {
 				neigh.State = getState(v.State)
 			}
 
@@ -299,9 +305,20 @@ func (cmd *cmd) printNeighs(neighs []netlink.Neigh, ifacesNames []string) error
 	neighFmt := "%s dev %s%s%s %s\n"
 	neighBriefFmt := "%-39s %-13s %-9s\n"
 	for idx, v := range neighs {
-		if cmd.Opts.Brief {
+		switch {
+		case cmd.Opts.Brief:
 			fmt.Fprintf(cmd.Out, neighBriefFmt, v.IP, ifacesNames[idx], v.HardwareAddr)
-		} else {
+		case v.Flags&netlink.NTF_PROXY != 0:
+			// Proxy entries may have no device, and have no state.
+			fmt.Fprint(cmd.Out, v.IP)
+			if ifacesNames[idx] != "" {
+				fmt.Fprintf(cmd.Out, " dev %s", ifacesNames[idx])
+			}
+			if v.Flags&netlink.NTF_ROUTER != 0 {
+				fmt.Fprint(cmd.Out, " router")
+			}
+			fmt.Fprintln(cmd.Out, " proxy")
+		default:
 			llAddr := ""
 			routerStr := ""
 
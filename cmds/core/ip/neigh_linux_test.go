@@ -343,6 +343,24 @@ func TestPrintNeighs(t *testing.T) {
 			opts:        flags{JSON: true, Brief: false},
 			expected:    `[{"dst":"192.168.1.1","dev":"eth0","lladdr":"00:0c:29:3e:1e:4c","state":"REACHABLE"},{"dst":"192.168.1.2","dev":"eth1","lladdr":"00:0c:29:3e:1e:4d","state":"STALE"}]`,
 		},
+		{
+			name: "Print proxy neighbors",
+			neighs: []netlink.Neigh{
+				{IP: net.ParseIP("192.168.1.5"), Flags: netlink.NTF_PROXY},
+				{IP: net.ParseIP("2001:db8::5"), Flags: netlink.NTF_PROXY | netlink.NTF_ROUTER},
+			},
+			ifacesNames: []string{"eth0", ""},
+			expected:    "192.168.1.5 dev eth0 proxy\n2001:db8::5 router proxy\n",
+		},
+		{
+			name: "Print proxy neighbors in JSON format",
+			neighs: []netlink.Neigh{
+				{IP: net.ParseIP("192.168.1.5"), Flags: netlink.NTF_PROXY},
+			},
+			ifacesNames: []string{"eth0"},
+			opts:        flags{JSON: true},
+			expected:    `[{"dst":"192.168.1.5","dev":"eth0","proxy":true}]`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,212 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//go:build !tinygo || tinygo.enable
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+const netnsHelp = `Usage: ip netns list
+       ip netns list-id
+`
+
+// netnsRunDir holds the named network namespaces, as in iproute2.
+var netnsRunDir = "/run/netns"
+
+func (cmd *cmd) netns() error {
+	if !cmd.tokenRemains() {
+		return cmd.netnsList()
+	}
+
+	switch cmd.findPrefix("list", "show", "list-id", "help") {
+	case "list", "show":
+		return cmd.netnsList()
+	case "list-id":
+		return cmd.netnsListID()
+	case "help":
+		fmt.Fprint(cmd.Out, netnsHelp)
+		return nil
+	}
+	return cmd.usage()
+}
+
+// Netns is a named network namespace, or a namespace known by its nsid
+// only.
+type Netns struct {
+	Name string `json:"name,omitempty"`
+	// NSID is nil if no nsid is assigned to the namespace.
+	NSID *int `json:"nsid,omitempty"`
+}
+
+// netnsNames returns the sorted names of the namespaces in dir.
+func netnsNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+// netnsID returns the nsid of the namespace bound at path, or nil if none is
+// assigned.
+func (cmd *cmd) netnsID(path string) (*int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var id int
+	if cmd.handle != nil {
+		id, err = cmd.handle.GetNetNsIdByFd(int(f.Fd()))
+	} else {
+		id, err = netlink.GetNetNsIdByFd(int(f.Fd()))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting the nsid of %s: %w", path, err)
+	}
+	if id == unix.NETNSA_NSID_NOT_ASSIGNED {
+		return nil, nil
+	}
+	return &id, nil
+}
+
+// namedNetns returns the named namespaces with their nsids.
+func (cmd *cmd) namedNetns() ([]Netns, error) {
+	names, err := netnsNames(netnsRunDir)
+	if err != nil {
+		return nil, err
+	}
+	nss := make([]Netns, 0, len(names))
+	for _, name := range names {
+		id, err := cmd.netnsID(filepath.Join(netnsRunDir, name))
+		if err != nil {
+			return nil, err
+		}
+		nss = append(nss, Netns{Name: name, NSID: id})
+	}
+	return nss, nil
+}
+
+func (cmd *cmd) netnsList() error {
+	nss, err := cmd.namedNetns()
+	if err != nil {
+		return err
+	}
+	return cmd.printNetns(nss)
+}
+
+// parseNsidMsg returns the nsid of an RTM_NEWNSID message.
+func parseNsidMsg(b []byte) (int, error) {
+	msg := nl.NewRtGenMsg()
+	if len(b) < msg.Len() {
+		return 0, fmt.Errorf("short nsid message: %d bytes", len(b))
+	}
+	attrs, err := nl.ParseRouteAttr(b[msg.Len():])
+	if err != nil {
+		return 0, err
+	}
+	for _, attr := range attrs {
+		if attr.Attr.Type == unix.NETNSA_NSID && len(attr.Value) >= 4 {
+			return int(int32(nl.NativeEndian().Uint32(attr.Value))), nil
+		}
+	}
+	return 0, errors.New("nsid message without NETNSA_NSID")
+}
+
+// nsidList returns the nsids assigned to other namespaces, in the current
+// one.
+func (cmd *cmd) nsidList() ([]int, error) {
+	req := cmd.newRequest(unix.RTM_GETNSID, unix.NLM_F_DUMP)
+	req.AddData(nl.NewRtGenMsg())
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWNSID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(msgs))
+	for _, m := range msgs {
+		id, err := parseNsidMsg(m)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// resolveNsids maps ids to the named namespaces, sorted by nsid.
+func resolveNsids(ids []int, named []Netns) []Netns {
+	names := make(map[int]string)
+	for _, ns := range named {
+		if ns.NSID != nil {
+			names[*ns.NSID] = ns.Name
+		}
+	}
+	nss := make([]Netns, 0, len(ids))
+	for _, id := range ids {
+		id := id
+		nss = append(nss, Netns{Name: names[id], NSID: &id})
+	}
+	sort.Slice(nss, func(i, j int) bool { return *nss[i].NSID < *nss[j].NSID })
+	return nss
+}
+
+func (cmd *cmd) netnsListID() error {
+	ids, err := cmd.nsidList()
+	if err != nil {
+		return err
+	}
+	named, err := cmd.namedNetns()
+	if err != nil {
+		return err
+	}
+	return cmd.printNsids(resolveNsids(ids, named))
+}
+
+func (cmd *cmd) printNsids(nss []Netns) error {
+	if cmd.Opts.JSON {
+		return printJSON(*cmd, nss)
+	}
+
+	for _, ns := range nss {
+		fmt.Fprintf(cmd.Out, "nsid %d", *ns.NSID)
+		if ns.Name != "" {
+			fmt.Fprintf(cmd.Out, " (iproute2 netns name: %s)", ns.Name)
+		}
+		fmt.Fprintln(cmd.Out)
+	}
+	return nil
+}
+
+func (cmd *cmd) printNetns(nss []Netns) error {
+	if cmd.Opts.JSON {
+		return printJSON(*cmd, nss)
+	}
+
+	for _, ns := range nss {
+		fmt.Fprint(cmd.Out, ns.Name)
+		if ns.NSID != nil {
+			fmt.Fprintf(cmd.Out, " (id: %d)", *ns.NSID)
+		}
+		fmt.Fprintln(cmd.Out)
+	}
+	return nil
+}
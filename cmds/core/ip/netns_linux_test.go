@@ -0,0 +1,95 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//go:build !tinygo || tinygo.enable
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestNetnsNames(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"red", "blue"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := netnsNames(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cmp.Diff([]string{"blue", "red"}, got); c != "" {
+		t.Errorf("netnsNames() diff:\n%v", c)
+	}
+
+	if got, err := netnsNames(filepath.Join(dir, "missing")); err != nil || got != nil {
+		t.Errorf("netnsNames(missing) = %q, %v, want nil, nil", got, err)
+	}
+}
+
+func TestParseNsidMsg(t *testing.T) {
+	b := nl.NewRtGenMsg().Serialize()
+	id := make([]byte, 4)
+	nl.NativeEndian().PutUint32(id, 7)
+	b = append(b, nl.NewRtAttr(unix.NETNSA_NSID, id).Serialize()...)
+	if got, err := parseNsidMsg(b); err != nil || got != 7 {
+		t.Errorf("parseNsidMsg() = %d, %v, want 7, nil", got, err)
+	}
+
+	if _, err := parseNsidMsg(nl.NewRtGenMsg().Serialize()); err == nil {
+		t.Errorf("parseNsidMsg() without NETNSA_NSID = nil, want error")
+	}
+	if _, err := parseNsidMsg(b[:2]); err == nil {
+		t.Errorf("parseNsidMsg(short) = nil, want error")
+	}
+}
+
+func TestResolveNsids(t *testing.T) {
+	zero, one, two := 0, 1, 2
+	named := []Netns{{Name: "blue", NSID: &one}, {Name: "red"}, {Name: "green", NSID: &zero}}
+	got := resolveNsids([]int{2, 0, 1}, named)
+	want := []Netns{{Name: "green", NSID: &zero}, {Name: "blue", NSID: &one}, {NSID: &two}}
+	if c := cmp.Diff(want, got); c != "" {
+		t.Errorf("resolveNsids() diff:\n%v", c)
+	}
+}
+
+func TestPrintNetns(t *testing.T) {
+	zero, one := 0, 1
+	nss := []Netns{{Name: "blue", NSID: &one}, {Name: "red"}}
+
+	var out bytes.Buffer
+	cmd := cmd{Out: &out}
+	if err := cmd.printNetns(nss); err != nil {
+		t.Fatal(err)
+	}
+	if want := "blue (id: 1)\nred\n"; out.String() != want {
+		t.Errorf("printNetns() = %q, want %q", &out, want)
+	}
+
+	out.Reset()
+	if err := cmd.printNsids([]Netns{{Name: "green", NSID: &zero}, {NSID: &one}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "nsid 0 (iproute2 netns name: green)\nnsid 1\n"; out.String() != want {
+		t.Errorf("printNsids() = %q, want %q", &out, want)
+	}
+
+	out.Reset()
+	cmd.Opts.JSON = true
+	if err := cmd.printNetns(nss); err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"name":"blue","nsid":1},{"name":"red"}]`; out.String() != want {
+		t.Errorf("printNetns() JSON = %s, want %s", &out, want)
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//go:build !tinygo || tinygo.enable
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+const nexthopHelp = `Usage: ip nexthop { list | show } [ id ID ]
+       ip nexthop add id ID NH
+       ip nexthop del id ID
+
+NH := { group ID[,WEIGHT][/ID[,WEIGHT]]... | blackhole |
+        [ via ADDRESS ] dev DEV }
+`
+
+const (
+	// rtaNhID is RTA_NH_ID, the nexthop object of a route, which
+	// x/sys/unix lacks.
+	rtaNhID = 0x1e
+	// sizeofNexthopGrp is the size of struct nexthop_grp.
+	sizeofNexthopGrp = 8
+)
+
+func (cmd *cmd) nexthop() error {
+	if !cmd.tokenRemains() {
+		return cmd.nexthopShow()
+	}
+
+	switch cmd.findPrefix("list", "show", "add", "del", "help") {
+	case "list", "show":
+		return cmd.nexthopShow()
+	case "add":
+		nh, err := cmd.parseNexthopAdd()
+		if err != nil {
+			return err
+		}
+		return cmd.nexthopModify(unix.RTM_NEWNEXTHOP, unix.NLM_F_CREATE|unix.NLM_F_EXCL, nh)
+	case "del":
+		id, err := cmd.parseNexthopID()
+		if err != nil {
+			return err
+		}
+		return cmd.nexthopModify(unix.RTM_DELNEXTHOP, 0, nexthopConfig{id: id})
+	case "help":
+		fmt.Fprint(cmd.Out, nexthopHelp)
+		return nil
+	}
+	return cmd.usage()
+}
+
+// nexthopGroupEntry is a member of a nexthop group.
+type nexthopGroupEntry struct {
+	id     uint32
+	weight int
+}
+
+// nexthopConfig is a nexthop object to add or delete.
+type nexthopConfig struct {
+	id        uint32
+	family    int
+	gateway   net.IP
+	oif       int
+	blackhole bool
+	group     []nexthopGroupEntry
+}
+
+// parseNexthopID parses `id ID`.
+func (cmd *cmd) parseNexthopID() (uint32, error) {
+	if cmd.nextToken("id") != "id" {
+		return 0, cmd.usage()
+	}
+	return cmd.parseUint32("ID")
+}
+
+// parseNexthopAdd parses `id ID NH`.
+func (cmd *cmd) parseNexthopAdd() (nexthopConfig, error) {
+	nh := nexthopConfig{family: cmd.Family}
+	var err error
+	if nh.id, err = cmd.parseNexthopID(); err != nil {
+		return nh, err
+	}
+	if nh.id == 0 {
+		return nh, fmt.Errorf("nexthop id must not be 0")
+	}
+
+	for cmd.tokenRemains() {
+		switch cmd.nextToken("group", "blackhole", "via", "dev") {
+		case "group":
+			nh.group, err = parseNexthopGroup(cmd.nextToken("ID[,WEIGHT][/ID[,WEIGHT]]..."))
+		case "blackhole":
+			nh.blackhole = true
+		case "via":
+			token := cmd.nextToken("ADDRESS")
+			if nh.gateway = net.ParseIP(token); nh.gateway == nil {
+				err = fmt.Errorf("invalid gateway address %q", token)
+			}
+		case "dev":
+			nh.oif, err = cmd.resolveLink(cmd.nextToken("DEV"))
+		default:
+			return nh, cmd.usage()
+		}
+		if err != nil {
+			return nh, err
+		}
+	}
+
+	switch {
+	case nh.group != nil:
+		if nh.blackhole || nh.gateway != nil || nh.oif != 0 {
+			return nh, fmt.Errorf("a nexthop group cannot have a gateway, device, or blackhole")
+		}
+		// Groups have no family of their own.
+		nh.family = netlink.FAMILY_ALL
+		return nh, nil
+	case nh.blackhole:
+		if nh.gateway != nil || nh.oif != 0 {
+			return nh, fmt.Errorf("a blackhole nexthop cannot have a gateway or device")
+		}
+	case nh.oif == 0:
+		return nh, fmt.Errorf("dev is required for a nexthop without group or blackhole")
+	}
+
+	if nh.gateway != nil {
+		family := netlink.FAMILY_V4
+		if nh.gateway.To4() == nil {
+			family = netlink.FAMILY_V6
+		}
+		if nh.family != netlink.FAMILY_ALL && nh.family != family {
+			return nh, fmt.Errorf("gateway %v does not match the address family", nh.gateway)
+		}
+		nh.family = family
+	}
+	if nh.family == netlink.FAMILY_ALL {
+		nh.family = netlink.FAMILY_V4
+	}
+	return nh, nil
+}
+
+// parseNexthopGroup parses the members of a group, e.g. "1,2/3": nexthop 1 of
+// weight 2 and nexthop 3 of weight 1.
+func parseNexthopGroup(token string) ([]nexthopGroupEntry, error) {
+	var group []nexthopGroupEntry
+	for _, member := range strings.Split(token, "/") {
+		id, weight, hasWeight := strings.Cut(member, ",")
+		e := nexthopGroupEntry{weight: 1}
+		n, err := strconv.ParseUint(id, 10, 32)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid nexthop id %q in group", id)
+		}
+		e.id = uint32(n)
+		if hasWeight {
+			e.weight, err = strconv.Atoi(weight)
+			if err != nil || e.weight < 1 || e.weight > 256 {
+				return nil, fmt.Errorf("invalid weight %q in group, must be 1 to 256", weight)
+			}
+		}
+		group = append(group, e)
+	}
+	return group, nil
+}
+
+// nhMsg is struct nhmsg.
+type nhMsg struct {
+	family   uint8
+	scope    uint8
+	protocol uint8
+	flags    uint32
+}
+
+func (m nhMsg) Len() int { return sizeofNhmsg }
+
+func (m nhMsg) Serialize() []byte {
+	b := make([]byte, sizeofNhmsg)
+	b[0], b[1], b[2] = m.family, m.scope, m.protocol
+	nl.NativeEndian().PutUint32(b[4:], m.flags)
+	return b
+}
+
+// nexthopModify sends an RTM_NEWNEXTHOP or RTM_DELNEXTHOP request for nh.
+func (cmd *cmd) nexthopModify(proto, flags int, nh nexthopConfig) error {
+	req := cmd.newRequest(proto, flags|unix.NLM_F_ACK)
+	msg := nhMsg{family: uint8(nh.family)}
+	if proto == unix.RTM_NEWNEXTHOP {
+		msg.protocol = unix.RTPROT_BOOT
+	}
+	req.AddData(msg)
+	for _, attr := range nexthopAttrs(nh) {
+		req.AddData(attr)
+	}
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
+
+// nexthopAttrs returns the NHA_* attributes of nh.
+func nexthopAttrs(nh nexthopConfig) []*nl.RtAttr {
+	id := make([]byte, 4)
+	nl.NativeEndian().PutUint32(id, nh.id)
+	attrs := []*nl.RtAttr{nl.NewRtAttr(unix.NHA_ID, id)}
+
+	if nh.group != nil {
+		b := make([]byte, sizeofNexthopGrp*len(nh.group))
+		for i, e := range nh.group {
+			nl.NativeEndian().PutUint32(b[i*sizeofNexthopGrp:], e.id)
+			// The kernel stores the weight minus one.
+			b[i*sizeofNexthopGrp+4] = uint8(e.weight - 1)
+		}
+		attrs = append(attrs, nl.NewRtAttr(unix.NHA_GROUP, b))
+	}
+	if nh.blackhole {
+		attrs = append(attrs, nl.NewRtAttr(unix.NHA_BLACKHOLE, nil))
+	}
+	if nh.oif != 0 {
+		b := make([]byte, 4)
+		nl.NativeEndian().PutUint32(b, uint32(nh.oif))
+		attrs = append(attrs, nl.NewRtAttr(unix.NHA_OIF, b))
+	}
+	if nh.gateway != nil {
+		gw := nh.gateway.To4()
+		if gw == nil {
+			gw = nh.gateway.To16()
+		}
+		attrs = append(attrs, nl.NewRtAttr(unix.NHA_GATEWAY, gw))
+	}
+	return attrs
+}
+
+// NexthopGroupMember is a member of a nexthop group. Weight is omitted for
+// the default weight of 1, as in iproute2.
+type NexthopGroupMember struct {
+	ID     uint32 `json:"id"`
+	Weight int    `json:"weight,omitempty"`
+}
+
+// Nexthop is a nexthop object as shown by ip nexthop.
+type Nexthop struct {
+	ID        uint32               `json:"id"`
+	Group     []NexthopGroupMember `json:"group,omitempty"`
+	Gateway   string               `json:"gateway,omitempty"`
+	Dev       string               `json:"dev,omitempty"`
+	Blackhole bool                 `json:"blackhole,omitempty"`
+	Protocol  string               `json:"protocol,omitempty"`
+	// ifIndex is the index of Dev, which the caller resolves.
+	ifIndex int
+}
+
+// parseNexthopMsg decodes the data of an RTM_NEWNEXTHOP message.
+func parseNexthopMsg(b []byte) (Nexthop, error) {
+	if len(b) < sizeofNhmsg {
+		return Nexthop{}, fmt.Errorf("short nexthop message: %d bytes", len(b))
+	}
+	attrs, err := nl.ParseRouteAttr(b[sizeofNhmsg:])
+	if err != nil {
+		return Nexthop{}, fmt.Errorf("can't parse nexthop message: %v", err)
+	}
+
+	var nh Nexthop
+	if proto := int(b[2]); proto != unix.RTPROT_UNSPEC {
+		nh.Protocol = rtProto[proto]
+		if nh.Protocol == "" {
+			nh.Protocol = strconv.Itoa(proto)
+		}
+	}
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case unix.NHA_ID:
+			nh.ID = nl.NativeEndian().Uint32(a.Value)
+		case unix.NHA_GROUP:
+			for i := 0; i+sizeofNexthopGrp <= len(a.Value); i += sizeofNexthopGrp {
+				m := NexthopGroupMember{ID: nl.NativeEndian().Uint32(a.Value[i:])}
+				if w := int(a.Value[i+4]) + 1; w > 1 {
+					m.Weight = w
+				}
+				nh.Group = append(nh.Group, m)
+			}
+		case unix.NHA_BLACKHOLE:
+			nh.Blackhole = true
+		case unix.NHA_OIF:
+			nh.ifIndex = int(nl.NativeEndian().Uint32(a.Value))
+		case unix.NHA_GATEWAY:
+			nh.Gateway = net.IP(a.Value).String()
+		}
+	}
+	return nh, nil
+}
+
+// nexthopList dumps the nexthop objects of family.
+func (cmd *cmd) nexthopList(family int) ([]Nexthop, error) {
+	req := cmd.newRequest(unix.RTM_GETNEXTHOP, unix.NLM_F_DUMP)
+	req.AddData(nhMsg{family: uint8(family)})
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWNEXTHOP)
+	if err != nil {
+		return nil, err
+	}
+
+	nhs := make([]Nexthop, 0, len(msgs))
+	for _, m := range msgs {
+		nh, err := parseNexthopMsg(m)
+		if err != nil {
+			return nil, err
+		}
+		nhs = append(nhs, nh)
+	}
+	return nhs, nil
+}
+
+func (cmd *cmd) nexthopShow() error {
+	var id uint32
+	if cmd.tokenRemains() {
+		var err error
+		if id, err = cmd.parseNexthopID(); err != nil {
+			return err
+		}
+	}
+
+	nhs, err := cmd.nexthopList(cmd.Family)
+	if err != nil {
+		return err
+	}
+	shown := make([]Nexthop, 0, len(nhs))
+	for _, nh := range nhs {
+		if id != 0 && nh.ID != id {
+			continue
+		}
+		if nh.Dev, err = cmd.devName(nh.ifIndex); err != nil {
+			return err
+		}
+		shown = append(shown, nh)
+	}
+	return cmd.printNexthops(shown)
+}
+
+func (cmd *cmd) printNexthops(nhs []Nexthop) error {
+	if cmd.Opts.JSON {
+		return printJSON(*cmd, nhs)
+	}
+
+	for _, nh := range nhs {
+		fmt.Fprintf(cmd.Out, "id %d", nh.ID)
+		if nh.Group != nil {
+			members := make([]string, 0, len(nh.Group))
+			for _, m := range nh.Group {
+				s := strconv.FormatUint(uint64(m.ID), 10)
+				if m.Weight > 1 {
+					s += "," + strconv.Itoa(m.Weight)
+				}
+				members = append(members, s)
+			}
+			fmt.Fprintf(cmd.Out, " group %s", strings.Join(members, "/"))
+		}
+		if nh.Gateway != "" {
+			fmt.Fprintf(cmd.Out, " via %s", nh.Gateway)
+		}
+		if nh.Dev != "" {
+			fmt.Fprintf(cmd.Out, " dev %s", nh.Dev)
+		}
+		if nh.Blackhole {
+			fmt.Fprint(cmd.Out, " blackhole")
+		}
+		if nh.Protocol != "" {
+			fmt.Fprintf(cmd.Out, " proto %s", nh.Protocol)
+		}
+		fmt.Fprintln(cmd.Out)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//go:build !tinygo || tinygo.enable
+
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vishvananda/netlink"
+)
+
+func TestParseNexthopAdd(t *testing.T) {
+	eth0 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    nexthopConfig
+		wantErr bool
+	}{
+		{
+			name: "Gateway",
+			args: []string{"ip", "nexthop", "add", "id", "1", "via", "10.0.0.1", "dev", "eth0"},
+			want: nexthopConfig{id: 1, family: netlink.FAMILY_V4, gateway: net.ParseIP("10.0.0.1"), oif: 2},
+		},
+		{
+			name: "Group",
+			args: []string{"ip", "nexthop", "add", "id", "5", "group", "1/2,3"},
+			want: nexthopConfig{id: 5, group: []nexthopGroupEntry{{id: 1, weight: 1}, {id: 2, weight: 3}}},
+		},
+		{
+			name: "Blackhole",
+			args: []string{"ip", "nexthop", "add", "id", "3", "blackhole"},
+			want: nexthopConfig{id: 3, family: netlink.FAMILY_V4, blackhole: true},
+		},
+		{
+			name:    "No device",
+			args:    []string{"ip", "nexthop", "add", "id", "1", "via", "10.0.0.1"},
+			wantErr: true,
+		},
+		{
+			name:    "Group with device",
+			args:    []string{"ip", "nexthop", "add", "id", "5", "group", "1/2", "dev", "eth0"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid weight",
+			args:    []string{"ip", "nexthop", "add", "id", "5", "group", "1,300"},
+			wantErr: true,
+		},
+		{
+			name:    "Zero id",
+			args:    []string{"ip", "nexthop", "add", "id", "0", "blackhole"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := cmd{Cursor: 2, Args: tt.args, Out: new(bytes.Buffer), linkCache: newLinkCache([]netlink.Link{eth0})}
+			got, err := cmd.parseNexthopAdd()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNexthopAdd() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if c := cmp.Diff(tt.want, got, cmp.AllowUnexported(nexthopConfig{}, nexthopGroupEntry{})); c != "" {
+				t.Errorf("parseNexthopAdd() diff:\n%v", c)
+			}
+		})
+	}
+}
+
+func TestParseNexthopMsg(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		nh   nexthopConfig
+		want Nexthop
+	}{
+		{
+			name: "Gateway",
+			nh:   nexthopConfig{id: 1, family: netlink.FAMILY_V4, gateway: net.ParseIP("10.0.0.1"), oif: 2},
+			want: Nexthop{ID: 1, Gateway: "10.0.0.1", Protocol: "boot", ifIndex: 2},
+		},
+		{
+			name: "Group",
+			nh:   nexthopConfig{id: 5, group: []nexthopGroupEntry{{id: 1, weight: 1}, {id: 2, weight: 3}}},
+			want: Nexthop{ID: 5, Group: []NexthopGroupMember{{ID: 1}, {ID: 2, Weight: 3}}, Protocol: "boot"},
+		},
+		{
+			name: "Blackhole",
+			nh:   nexthopConfig{id: 3, family: netlink.FAMILY_V6, blackhole: true},
+			want: Nexthop{ID: 3, Blackhole: true, Protocol: "boot"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := nhMsg{family: uint8(tt.nh.family), protocol: 3}.Serialize()
+			for _, attr := range nexthopAttrs(tt.nh) {
+				b = append(b, attr.Serialize()...)
+			}
+			got, err := parseNexthopMsg(b)
+			if err != nil {
+				t.Fatalf("parseNexthopMsg() = %v", err)
+			}
+			if c := cmp.Diff(tt.want, got, cmp.AllowUnexported(Nexthop{})); c != "" {
+				t.Errorf("parseNexthopMsg() diff:\n%v", c)
+			}
+		})
+	}
+}
+
+func TestPrintNexthops(t *testing.T) {
+	nhs := []Nexthop{
+		{ID: 1, Gateway: "10.0.0.1", Dev: "eth0", Protocol: "boot"},
+		{ID: 3, Blackhole: true},
+		{ID: 5, Group: []NexthopGroupMember{{ID: 1}, {ID: 2, Weight: 3}}},
+	}
+
+	var out bytes.Buffer
+	cmd := cmd{Out: &out}
+	if err := cmd.printNexthops(nhs); err != nil {
+		t.Fatal(err)
+	}
+	want := "id 1 via 10.0.0.1 dev eth0 proto boot\nid 3 blackhole\nid 5 group 1/2,3\n"
+	if got := out.String(); got != want {
+		t.Errorf("printNexthops() = %q, want %q", got, want)
+	}
+
+	out.Reset()
+	cmd.Opts.JSON = true
+	if err := cmd.printNexthops(nhs[2:]); err != nil {
+		t.Fatal(err)
+	}
+	wantJSON := `[{"id":5,"group":[{"id":1},{"id":2,"weight":3}]}]`
+	if got := out.String(); got != wantJSON {
+		t.Errorf("printNexthops() JSON = %s, want %s", got, wantJSON)
+	}
+}
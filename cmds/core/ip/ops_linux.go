@@ -8,36 +8,60 @@ package main
 import (
 	"fmt"
 	"math"
+	"net"
+	"strconv"
 	"strings"
 
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 )
 
 func (cmd *cmd) showAllLinks(withAddresses bool, filterByType ...string) error {
-	links, err := netlink.LinkList()
+	// The link family shows link-layer information only.
+	withAddresses = withAddresses && !cmd.Opts.Link
+
+	links, err := cmd.handle.LinkList()
 	if err != nil {
 		return fmt.Errorf("can't enumerate interfaces: %v", err)
 	}
 
+	// Resolve masters and the like from this dump rather than another.
+	if cmd.linkCache == nil {
+		cmd.linkCache = newLinkCache(links)
+	}
+
 	addresses := make([][]netlink.Addr, len(links))
 	if withAddresses {
-		for idx, link := range links {
-			addrs, err := netlink.AddrList(link, cmd.Family)
-			if err != nil {
-				return fmt.Errorf("can't get addresses for link %s: %v", link.Attrs().Name, err)
-			}
-
-			addresses[idx] = addrs
+		// The kernel dumps the addresses of all links for each query, so
+		// query once and split them by link.
+		addrs, err := cmd.handle.AddrList(nil, cmd.Family)
+		if err != nil {
+			return fmt.Errorf("can't get addresses: %v", err)
 		}
+		addresses = addrsByLink(links, addrs)
 	}
 
 	return cmd.showLinks(addresses, links, filterByType...)
 }
 
+// addrsByLink returns the addresses of each of links.
+func addrsByLink(links []netlink.Link, addrs []netlink.Addr) [][]netlink.Addr {
+	byIndex := make(map[int][]netlink.Addr)
+	for _, addr := range addrs {
+		byIndex[addr.LinkIndex] = append(byIndex[addr.LinkIndex], addr)
+	}
+	addresses := make([][]netlink.Addr, len(links))
+	for idx, link := range links {
+		addresses[idx] = byIndex[link.Attrs().Index]
+	}
+	return addresses
+}
+
 func (cmd *cmd) showLink(link netlink.Link, withAddresses bool, filterByType ...string) error {
 	addresses := make([][]netlink.Addr, 1)
-	if withAddresses {
-		addrs, err := netlink.AddrList(link, cmd.Family)
+	if withAddresses && !cmd.Opts.Link {
+		addrs, err := cmd.handle.AddrList(link, cmd.Family)
 		if err != nil {
 			return fmt.Errorf("can't get addresses for link %s: %v", link.Attrs().Name, err)
 		}
@@ -52,12 +76,130 @@ type Link struct {
 	IfName    string     `json:"ifname"`
 	Flags     []string   `json:"flags"`
 	MTU       int        `json:"mtu,omitempty"`
+	Master    string     `json:"master,omitempty"`
 	Operstate string     `json:"operstate"`
 	Group     string     `json:"group,omitempty"`
 	Txqlen    int        `json:"txqlen,omitempty"`
 	LinkType  string     `json:"link_type,omitempty"`
 	Address   string     `json:"address"`
+	Broadcast string     `json:"broadcast,omitempty"`
+	PermAddr  string     `json:"permaddr,omitempty"`
 	AddrInfo  []AddrInfo `json:"addr_info,omitempty"`
+	VfInfo    []VfInfo   `json:"vfinfo_list,omitempty"`
+	LinkInfo  *LinkInfo  `json:"linkinfo,omitempty"`
+
+	// NumTxQueues, NumRxQueues, and the GSO limits are only shown with
+	// details.
+	NumTxQueues int    `json:"num_tx_queues,omitempty"`
+	NumRxQueues int    `json:"num_rx_queues,omitempty"`
+	GSOMaxSize  uint32 `json:"gso_max_size,omitempty"`
+	GSOMaxSegs  uint32 `json:"gso_max_segs,omitempty"`
+	Xdp         *Xdp   `json:"xdp,omitempty"`
+}
+
+// Xdp is the XDP program attached to a link.
+type Xdp struct {
+	Mode string `json:"mode"`
+	// ProgID is 0 for programs attached in several modes.
+	ProgID uint32 `json:"prog_id,omitempty"`
+}
+
+// LinkInfo holds the kind of a virtual link, and for an enslaved link the
+// kind of its master, e.g. bridge or bond.
+type LinkInfo struct {
+	InfoKind      string   `json:"info_kind,omitempty"`
+	InfoData      *VtiData `json:"info_data,omitempty"`
+	InfoSlaveKind string   `json:"info_slave_kind,omitempty"`
+}
+
+// VtiData holds the endpoints and keys of a vti or vti6 link. As in
+// iproute2, keys are shown as dotted quads.
+type VtiData struct {
+	Local  string `json:"local,omitempty"`
+	Remote string `json:"remote,omitempty"`
+	IKey   string `json:"ikey"`
+	OKey   string `json:"okey"`
+}
+
+type VfInfo struct {
+	VF        int      `json:"vf"`
+	Address   string   `json:"address"`
+	VlanList  []VfVlan `json:"vlan_list,omitempty"`
+	Rate      VfRate   `json:"rate"`
+	Spoofchk  bool     `json:"spoofchk"`
+	LinkState string   `json:"link_state"`
+	Trust     bool     `json:"trust"`
+}
+
+type VfVlan struct {
+	Vlan int `json:"vlan"`
+	Qos  int `json:"qos,omitempty"`
+}
+
+type VfRate struct {
+	MaxTx uint32 `json:"max_tx"`
+	MinTx uint32 `json:"min_tx"`
+}
+
+// vfLinkState returns the name of a VF link state.
+func vfLinkState(state uint32) string {
+	if int(state) < len(vfLinkStates) {
+		return vfLinkStates[state]
+	}
+	return strconv.FormatUint(uint64(state), 10)
+}
+
+// onOff formats b the way ip prints flags.
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// vfInfo converts the VFs of a link for JSON output.
+func vfInfo(vfs []netlink.VfInfo) []VfInfo {
+	var infos []VfInfo
+	for _, vf := range vfs {
+		info := VfInfo{
+			VF:        vf.ID,
+			Address:   vf.Mac.String(),
+			Rate:      VfRate{MaxTx: vf.MaxTxRate, MinTx: vf.MinTxRate},
+			Spoofchk:  vf.Spoofchk,
+			LinkState: vfLinkState(vf.LinkState),
+			Trust:     vf.Trust != 0,
+		}
+		if vf.Vlan != 0 {
+			info.VlanList = []VfVlan{{Vlan: vf.Vlan, Qos: vf.Qos}}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// xdpAttachedMulti is the IFLA_XDP_ATTACHED mode of programs attached in
+// several modes at once, which netlink lacks.
+const xdpAttachedMulti = nl.XDP_ATTACHED_HW + 1
+
+// xdpModes names the IFLA_XDP_ATTACHED modes.
+var xdpModes = map[uint32]string{
+	nl.XDP_ATTACHED_DRV: "native",
+	nl.XDP_ATTACHED_SKB: "generic",
+	nl.XDP_ATTACHED_HW:  "offload",
+	xdpAttachedMulti:    "multi",
+}
+
+// xdpInfo converts the XDP program attached to a link for output, or returns
+// nil if none is.
+func xdpInfo(xdp *netlink.LinkXdp) *Xdp {
+	if xdp == nil || !xdp.Attached {
+		return nil
+	}
+	mode, ok := xdpModes[xdp.AttachMode]
+	if !ok {
+		mode = strconv.FormatUint(uint64(xdp.AttachMode), 10)
+	}
+	return &Xdp{Mode: mode, ProgID: xdp.ProgId}
 }
 
 type AddrInfo struct {
@@ -66,14 +208,132 @@ type AddrInfo struct {
 	PrefixLen         string `json:"prefixlen"`
 	Broadcast         string `json:"broadcast,omitempty"`
 	Scope             string `json:"scope,omitempty"`
+	Secondary         bool   `json:"secondary,omitempty"`
+	NoDAD             bool   `json:"nodad,omitempty"`
+	Home              bool   `json:"home,omitempty"`
+	MngTmpAddr        bool   `json:"mngtmpaddr,omitempty"`
 	Label             string `json:"label,omitempty"`
 	ValidLifeTime     string `json:"valid_life_time,omitempty"`
 	PreferredLifeTime string `json:"preferred_life_time,omitempty"`
 }
 
+// llAddrs are the link-layer addresses of a link that netlink.LinkAttrs
+// lacks.
+type llAddrs struct {
+	broadcast net.HardwareAddr
+	perm      net.HardwareAddr
+}
+
+// linkLLAddrs returns the broadcast and permanent hardware addresses of all
+// links, by index.
+func (cmd *cmd) linkLLAddrs() (map[int]llAddrs, error) {
+	req := cmd.newRequest(unix.RTM_GETLINK, unix.NLM_F_DUMP)
+	req.AddData(nl.NewIfInfomsg(unix.AF_UNSPEC))
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWLINK)
+	if err != nil {
+		return nil, fmt.Errorf("can't dump link-layer addresses: %v", err)
+	}
+
+	addrs := make(map[int]llAddrs, len(msgs))
+	for _, m := range msgs {
+		index, a, err := parseLLAddrs(m)
+		if err != nil {
+			return nil, err
+		}
+		addrs[index] = a
+	}
+	return addrs, nil
+}
+
+// parseLLAddrs parses the link-layer addresses of an RTM_NEWLINK message.
+func parseLLAddrs(m []byte) (int, llAddrs, error) {
+	var a llAddrs
+	if len(m) < unix.SizeofIfInfomsg {
+		return 0, a, fmt.Errorf("short link message: %d bytes", len(m))
+	}
+	msg := nl.DeserializeIfInfomsg(m)
+	attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+	if err != nil {
+		return 0, a, fmt.Errorf("can't parse link message: %v", err)
+	}
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case unix.IFLA_BROADCAST:
+			a.broadcast = net.HardwareAddr(attr.Value)
+		case unix.IFLA_PERM_ADDRESS:
+			a.perm = net.HardwareAddr(attr.Value)
+		}
+	}
+	return int(msg.Index), a, nil
+}
+
+// filterLinksUp returns the links that are up, and their addresses.
+func filterLinksUp(links []netlink.Link, addresses [][]netlink.Addr) ([]netlink.Link, [][]netlink.Addr) {
+	var upLinks []netlink.Link
+	upAddrs := make([][]netlink.Addr, 0, len(addresses))
+	for idx, link := range links {
+		if link.Attrs().Flags&net.FlagUp == 0 {
+			continue
+		}
+		upLinks = append(upLinks, link)
+		upAddrs = append(upAddrs, addresses[idx])
+	}
+	return upLinks, upAddrs
+}
+
+// filterLinksByMaster returns the links enslaved to the link with index
+// master, and their addresses.
+func filterLinksByMaster(links []netlink.Link, addresses [][]netlink.Addr, master int) ([]netlink.Link, [][]netlink.Addr) {
+	var ports []netlink.Link
+	portAddrs := make([][]netlink.Addr, 0, len(addresses))
+	for idx, link := range links {
+		if link.Attrs().MasterIndex != master {
+			continue
+		}
+		ports = append(ports, link)
+		portAddrs = append(portAddrs, addresses[idx])
+	}
+	return ports, portAddrs
+}
+
+// filterAddrsSecondary returns the secondary addresses of each link if
+// secondary is set, else the primary ones.
+func filterAddrsSecondary(addresses [][]netlink.Addr, secondary bool) [][]netlink.Addr {
+	filtered := make([][]netlink.Addr, 0, len(addresses))
+	for _, addrs := range addresses {
+		var kept []netlink.Addr
+		for _, addr := range addrs {
+			if (addr.Flags&unix.IFA_F_SECONDARY != 0) == secondary {
+				kept = append(kept, addr)
+			}
+		}
+		filtered = append(filtered, kept)
+	}
+	return filtered
+}
+
 func (cmd *cmd) showLinks(addresses [][]netlink.Addr, links []netlink.Link, filterByType ...string) error {
+	if cmd.upOnly {
+		links, addresses = filterLinksUp(links, addresses)
+	}
+	if cmd.master != 0 {
+		links, addresses = filterLinksByMaster(links, addresses, cmd.master)
+	}
+	if cmd.addrRole != "" {
+		addresses = filterAddrsSecondary(addresses, cmd.addrRole == "secondary")
+	}
+
+	// With details, show the broadcast and permanent hardware addresses.
+	var lladdrs map[int]llAddrs
+	if cmd.Opts.Details {
+		var err error
+		if lladdrs, err = cmd.linkLLAddrs(); err != nil {
+			return err
+		}
+	}
+
 	if cmd.Opts.JSON {
-		return cmd.printLinkJSON(links, addresses)
+		return cmd.printLinkJSON(links, addresses, lladdrs)
 	}
 
 	for idx, v := range links {
@@ -123,11 +383,11 @@ func (cmd *cmd) showLinks(addresses [][]netlink.Addr, links []netlink.Link, filt
 
 		master := ""
 		if l.MasterIndex != 0 {
-			link, err := netlink.LinkByIndex(l.MasterIndex)
+			name, err := cmd.linkName(l.MasterIndex)
 			if err != nil {
-				return fmt.Errorf("can't get link with index %d: %v", l.MasterIndex, err)
+				return err
 			}
-			master = fmt.Sprintf("master %s ", link.Attrs().Name)
+			master = fmt.Sprintf("master %s ", name)
 		}
 
 		group := fmt.Sprintf("%v", l.Group)
@@ -140,7 +400,16 @@ func (cmd *cmd) showLinks(addresses [][]netlink.Addr, links []netlink.Link, filt
 			strings.Replace(strings.ToUpper(l.Flags.String()), "|", ",", -1),
 			l.MTU, master, strings.ToUpper(l.OperState.String()), group)
 
-		fmt.Fprintf(cmd.Out, "    link/%s %s\n", l.EncapType, l.HardwareAddr)
+		fmt.Fprintf(cmd.Out, "    link/%s %s", l.EncapType, l.HardwareAddr)
+		if a, ok := lladdrs[l.Index]; ok {
+			if a.broadcast != nil {
+				fmt.Fprintf(cmd.Out, " brd %s", a.broadcast)
+			}
+			if a.perm != nil {
+				fmt.Fprintf(cmd.Out, " permaddr %s", a.perm)
+			}
+		}
+		fmt.Fprintln(cmd.Out)
 
 		if cmd.Opts.Details {
 			switch v := v.(type) {
@@ -206,6 +475,29 @@ func (cmd *cmd) showLinks(addresses [][]netlink.Addr, links []netlink.Link, filt
 				fmt.Fprintf(cmd.Out, "    port %d ethertype %d srcport %d min multi_proto %t\n", v.Port, v.EtherType, v.SrcPortMin, v.MultiProto)
 
 			}
+
+			if xdp := xdpInfo(l.Xdp); xdp != nil {
+				fmt.Fprintf(cmd.Out, "    xdp mode %s", xdp.Mode)
+				if xdp.ProgID != 0 {
+					fmt.Fprintf(cmd.Out, " prog_id %d", xdp.ProgID)
+				}
+				fmt.Fprintln(cmd.Out)
+			}
+
+			for _, vf := range l.Vfs {
+				fmt.Fprintf(cmd.Out, "    vf %d link/%s %s", vf.ID, l.EncapType, vf.Mac)
+				if vf.Vlan != 0 {
+					fmt.Fprintf(cmd.Out, ", vlan %d", vf.Vlan)
+					if vf.Qos != 0 {
+						fmt.Fprintf(cmd.Out, ", qos %d", vf.Qos)
+					}
+				}
+				if vf.MaxTxRate != 0 || vf.MinTxRate != 0 {
+					fmt.Fprintf(cmd.Out, ", max_tx_rate %dMbps, min_tx_rate %dMbps", vf.MaxTxRate, vf.MinTxRate)
+				}
+				fmt.Fprintf(cmd.Out, ", spoof checking %s, link-state %s, trust %s\n",
+					onOff(vf.Spoofchk), vfLinkState(vf.LinkState), onOff(vf.Trust != 0))
+			}
 		}
 
 		if cmd.Opts.Stats {
@@ -225,7 +517,7 @@ func (cmd *cmd) showLinks(addresses [][]netlink.Addr, links []netlink.Link, filt
 	return nil
 }
 
-func (cmd *cmd) printLinkJSON(links []netlink.Link, addresses [][]netlink.Addr) error {
+func (cmd *cmd) printLinkJSON(links []netlink.Link, addresses [][]netlink.Addr, lladdrs map[int]llAddrs) error {
 	linkObs := make([]Link, 0)
 
 	for idx, v := range links {
@@ -247,6 +539,34 @@ func (cmd *cmd) printLinkJSON(links []netlink.Link, addresses [][]netlink.Addr)
 			}
 
 			link.Txqlen = v.Attrs().TxQLen
+
+			var err error
+			if link.LinkInfo, err = cmd.linkInfo(v); err != nil {
+				return err
+			}
+			if v.Attrs().MasterIndex != 0 {
+				if link.Master, err = cmd.linkName(v.Attrs().MasterIndex); err != nil {
+					return err
+				}
+			}
+		}
+
+		if cmd.Opts.Details {
+			link.NumTxQueues = v.Attrs().NumTxQueues
+			link.NumRxQueues = v.Attrs().NumRxQueues
+			link.GSOMaxSize = v.Attrs().GSOMaxSize
+			link.GSOMaxSegs = v.Attrs().GSOMaxSegs
+			link.VfInfo = vfInfo(v.Attrs().Vfs)
+			link.Xdp = xdpInfo(v.Attrs().Xdp)
+		}
+
+		if a, ok := lladdrs[v.Attrs().Index]; ok {
+			if a.broadcast != nil {
+				link.Broadcast = a.broadcast.String()
+			}
+			if a.perm != nil {
+				link.PermAddr = a.perm.String()
+			}
 		}
 
 		if addresses != nil {
@@ -260,8 +580,12 @@ func (cmd *cmd) printLinkJSON(links []netlink.Link, addresses [][]netlink.Addr)
 				}
 
 				addrInfo := AddrInfo{
-					Local:     addr.IPNet.IP.String(),
-					PrefixLen: addr.IPNet.Mask.String(),
+					Local:      addr.IPNet.IP.String(),
+					PrefixLen:  addr.IPNet.Mask.String(),
+					Secondary:  addr.Flags&unix.IFA_F_SECONDARY != 0,
+					NoDAD:      addr.Flags&unix.IFA_F_NODAD != 0,
+					Home:       addr.Flags&unix.IFA_F_HOMEADDRESS != 0,
+					MngTmpAddr: addr.Flags&unix.IFA_F_MANAGETEMPADDR != 0,
 				}
 
 				if !cmd.Opts.Brief {
@@ -288,6 +612,47 @@ func (cmd *cmd) printLinkJSON(links []netlink.Link, addresses [][]netlink.Addr)
 	return printJSON(*cmd, linkObs)
 }
 
+// linkInfo returns the kind of link and of its master, or nil for a physical
+// link without a master. Netlink only reports the slave kind of bond and VRF
+// ports, so that of other ports, e.g. bridge ports, is the kind of their
+// master.
+func (cmd *cmd) linkInfo(link netlink.Link) (*LinkInfo, error) {
+	var info LinkInfo
+	if link.Type() != "device" {
+		info.InfoKind = link.Type()
+	}
+	if v, ok := link.(*netlink.Vti); ok {
+		info.InfoData = &VtiData{
+			Local:  vtiAddr(v.Local),
+			Remote: vtiAddr(v.Remote),
+			IKey:   formatVtiKey(v.IKey),
+			OKey:   formatVtiKey(v.OKey),
+		}
+	}
+
+	l := link.Attrs()
+	switch {
+	case l.MasterIndex == 0:
+	case l.Slave != nil:
+		info.InfoSlaveKind = l.Slave.SlaveType()
+	default:
+		c, err := cmd.links()
+		if err != nil {
+			return nil, err
+		}
+		master, ok := c.byIndex[l.MasterIndex]
+		if !ok {
+			return nil, fmt.Errorf("cannot find device with index %d", l.MasterIndex)
+		}
+		info.InfoSlaveKind = master.Type()
+	}
+
+	if info == (LinkInfo{}) {
+		return nil, nil
+	}
+	return &info, nil
+}
+
 func (cmd *cmd) showLinkAddresses(addrs []netlink.Addr) error {
 	for _, addr := range addrs {
 
@@ -305,7 +670,16 @@ func (cmd *cmd) showLinkAddresses(addrs []netlink.Addr) error {
 			fmt.Fprintf(cmd.Out, " brd %s", addr.Broadcast)
 		}
 
-		fmt.Fprintf(cmd.Out, " scope %s %s\n", addrScopes[netlink.Scope(addr.Scope)], addr.Label)
+		fmt.Fprintf(cmd.Out, " scope %s", addrScopes[netlink.Scope(addr.Scope)])
+		if addr.Flags&unix.IFA_F_SECONDARY != 0 {
+			fmt.Fprint(cmd.Out, " secondary")
+		}
+		for _, f := range addrConfFlags {
+			if addr.Flags&f.flag != 0 {
+				fmt.Fprintf(cmd.Out, " %s", f.name)
+			}
+		}
+		fmt.Fprintf(cmd.Out, " %s\n", addr.Label)
 
 		var validLft, preferredLft string
 		// TODO: fix vishnavanda/netlink. *Lft should be uint32, not int.
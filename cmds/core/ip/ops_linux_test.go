@@ -7,11 +7,16 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 )
 
 func TestShowLinkAddresses(t *testing.T) {
@@ -54,6 +59,35 @@ func TestShowLinkAddresses(t *testing.T) {
 			},
 			expected: "    inet6 2001:db8::1 scope host eth0\n       valid_lft 7200sec preferred_lft 3600sec\n",
 		},
+		{
+			name: "Secondary address",
+			addrs: []netlink.Addr{
+				{
+					IPNet: &net.IPNet{
+						IP:   net.IPv4(192, 168, 1, 2),
+						Mask: net.CIDRMask(24, 32),
+					},
+					Scope: int(netlink.SCOPE_UNIVERSE),
+					Label: "eth0",
+					Flags: unix.IFA_F_SECONDARY,
+				},
+			},
+			expected: "    inet 192.168.1.2 scope global secondary eth0\n       valid_lft 0sec preferred_lft 0sec\n",
+		},
+		{
+			name: "IPv6 address flags",
+			addrs: []netlink.Addr{
+				{
+					IPNet: &net.IPNet{
+						IP:   net.ParseIP("2001:db8::2"),
+						Mask: net.CIDRMask(64, 128),
+					},
+					Scope: int(netlink.SCOPE_UNIVERSE),
+					Flags: unix.IFA_F_MANAGETEMPADDR | unix.IFA_F_NODAD | unix.IFA_F_HOMEADDRESS,
+				},
+			},
+			expected: "    inet6 2001:db8::2 scope global nodad home mngtmpaddr \n       valid_lft 0sec preferred_lft 0sec\n",
+		},
 	}
 
 	for _, tt := range tests {
@@ -211,7 +245,7 @@ func TestPrintLinkJSON(t *testing.T) {
 				Opts: tt.opts,
 			}
 
-			err := cmd.printLinkJSON(tt.links, tt.addresses)
+			err := cmd.printLinkJSON(tt.links, tt.addresses, nil)
 			if err != nil {
 				t.Fatalf("printLinkJSON() error = %v", err)
 			}
@@ -485,3 +519,308 @@ func TestShowLinks(t *testing.T) {
 		})
 	}
 }
+
+func TestParseLLAddrs(t *testing.T) {
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = 3
+	b := msg.Serialize()
+	b = append(b, nl.NewRtAttr(unix.IFLA_ADDRESS, []byte{0x02, 0, 0, 0, 0, 1}).Serialize()...)
+	b = append(b, nl.NewRtAttr(unix.IFLA_BROADCAST, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}).Serialize()...)
+	b = append(b, nl.NewRtAttr(unix.IFLA_PERM_ADDRESS, []byte{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}).Serialize()...)
+
+	index, got, err := parseLLAddrs(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 3 || got.broadcast.String() != "ff:ff:ff:ff:ff:ff" || got.perm.String() != "00:1a:2b:3c:4d:5e" {
+		t.Errorf("parseLLAddrs() = %d, %+v", index, got)
+	}
+
+	if _, _, err := parseLLAddrs(b[:4]); err == nil {
+		t.Errorf("parseLLAddrs(short) = nil error, want error")
+	}
+}
+
+func TestPrintLinkJSONLLAddrs(t *testing.T) {
+	links := []netlink.Link{&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}}}
+	lladdrs := map[int]llAddrs{2: {
+		broadcast: net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		perm:      net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e},
+	}}
+
+	var out bytes.Buffer
+	cmd := cmd{Out: &out, Opts: flags{JSON: true, Brief: true}}
+	if err := cmd.printLinkJSON(links, nil, lladdrs); err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"ifname":"eth0","flags":["0"],"operstate":"unknown","address":"","broadcast":"ff:ff:ff:ff:ff:ff","permaddr":"00:1a:2b:3c:4d:5e"}]`
+	if c := cmp.Diff(out.String(), want); c != "" {
+		t.Errorf("printLinkJSON() = %v", c)
+	}
+}
+
+func TestPrintLinkJSONMaster(t *testing.T) {
+	links := []netlink.Link{
+		&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0", Index: 3}},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2, MasterIndex: 3}},
+		&netlink.Bond{LinkAttrs: netlink.LinkAttrs{Name: "bond0", Index: 4}},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", Index: 5, MasterIndex: 4, Slave: &netlink.BondSlave{}}},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth2", Index: 6}},
+	}
+
+	var out bytes.Buffer
+	cmd := cmd{Out: &out, Opts: flags{JSON: true}, linkCache: newLinkCache(links)}
+	if err := cmd.printLinkJSON(links, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var got []Link
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		master string
+		info   *LinkInfo
+	}{
+		{info: &LinkInfo{InfoKind: "bridge"}},
+		{master: "br0", info: &LinkInfo{InfoSlaveKind: "bridge"}},
+		{info: &LinkInfo{InfoKind: "bond"}},
+		{master: "bond0", info: &LinkInfo{InfoSlaveKind: "bond"}},
+		{},
+	}
+	for i, l := range got {
+		if l.Master != want[i].master || !cmp.Equal(l.LinkInfo, want[i].info) {
+			t.Errorf("%s: master %q, linkinfo %+v, want %q, %+v", l.IfName, l.Master, l.LinkInfo, want[i].master, want[i].info)
+		}
+	}
+	// Without a master, the fields are left out.
+	if n := strings.Count(out.String(), `"master"`); n != 2 {
+		t.Errorf("printLinkJSON() has %d master fields, want 2:\n%s", n, &out)
+	}
+}
+
+func TestFilterAddrsSecondary(t *testing.T) {
+	primary := netlink.Addr{IPNet: &net.IPNet{IP: net.IPv4(10, 0, 0, 1), Mask: net.CIDRMask(24, 32)}}
+	secondary := netlink.Addr{IPNet: &net.IPNet{IP: net.IPv4(10, 0, 0, 2), Mask: net.CIDRMask(24, 32)}, Flags: unix.IFA_F_SECONDARY}
+	addresses := [][]netlink.Addr{{primary, secondary}, nil}
+
+	if got, want := filterAddrsSecondary(addresses, false), [][]netlink.Addr{{primary}, nil}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filterAddrsSecondary(primary) = %v, want %v", got, want)
+	}
+	if got, want := filterAddrsSecondary(addresses, true), [][]netlink.Addr{{secondary}, nil}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filterAddrsSecondary(secondary) = %v, want %v", got, want)
+	}
+}
+
+func TestAddrsByLink(t *testing.T) {
+	links := []netlink.Link{
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "lo", Index: 1}},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", Index: 3}},
+	}
+	addr := func(s string, index int) netlink.Addr {
+		a, err := netlink.ParseAddr(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.LinkIndex = index
+		return *a
+	}
+	addrs := []netlink.Addr{addr("127.0.0.1/8", 1), addr("10.0.0.1/24", 2), addr("2001:db8::1/64", 2), addr("10.9.0.1/24", 9)}
+
+	got := addrsByLink(links, addrs)
+	want := [][]netlink.Addr{addrs[:1], addrs[1:3], nil}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("addrsByLink() = %v, want %v", got, want)
+	}
+}
+
+func TestPrintLinkJSONVti(t *testing.T) {
+	links := []netlink.Link{&netlink.Vti{
+		LinkAttrs: netlink.LinkAttrs{Name: "vti0", Index: 7},
+		Local:     net.IPv4zero,
+		Remote:    net.ParseIP("10.0.0.2"),
+		IKey:      1,
+		OKey:      0x01020304,
+	}}
+
+	var out bytes.Buffer
+	cmd := cmd{Out: &out, Opts: flags{JSON: true}}
+	if err := cmd.printLinkJSON(links, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var got []Link
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := &LinkInfo{
+		InfoKind: "vti",
+		InfoData: &VtiData{Remote: "10.0.0.2", IKey: "0.0.0.1", OKey: "1.2.3.4"},
+	}
+	if len(got) != 1 || !cmp.Equal(got[0].LinkInfo, want) {
+		t.Errorf("printLinkJSON() = %s, want linkinfo %+v", &out, want)
+	}
+}
+
+func TestPrintLinkJSONQueues(t *testing.T) {
+	links := []netlink.Link{&netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "veth0", Index: 7, NumTxQueues: 4, NumRxQueues: 2, GSOMaxSize: 65536, GSOMaxSegs: 64},
+	}}
+
+	for _, tt := range []struct {
+		details                  bool
+		wantTx, wantRx           int
+		wantGSOSize, wantGSOSegs uint32
+	}{
+		{false, 0, 0, 0, 0},
+		{true, 4, 2, 65536, 64},
+	} {
+		var out bytes.Buffer
+		cmd := cmd{Out: &out, Opts: flags{JSON: true, Details: tt.details}}
+		if err := cmd.printLinkJSON(links, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		var got []Link
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].NumTxQueues != tt.wantTx || got[0].NumRxQueues != tt.wantRx {
+			t.Errorf("printLinkJSON() with details %t = %s, want %d tx and %d rx queues", tt.details, &out, tt.wantTx, tt.wantRx)
+		}
+		if len(got) == 1 && (got[0].GSOMaxSize != tt.wantGSOSize || got[0].GSOMaxSegs != tt.wantGSOSegs) {
+			t.Errorf("printLinkJSON() with details %t = %s, want gso_max_size %d and gso_max_segs %d", tt.details, &out, tt.wantGSOSize, tt.wantGSOSegs)
+		}
+	}
+}
+
+func TestPrintLinkJSONAddrFlags(t *testing.T) {
+	links := []netlink.Link{&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}}}
+	addrs := [][]netlink.Addr{{{
+		IPNet: &net.IPNet{IP: net.ParseIP("2001:db8::2"), Mask: net.CIDRMask(64, 128)},
+		Flags: unix.IFA_F_NODAD | unix.IFA_F_MANAGETEMPADDR,
+	}}}
+	var out bytes.Buffer
+	cmd := cmd{Out: &out, Opts: flags{JSON: true}}
+	if err := cmd.printLinkJSON(links, addrs, nil); err != nil {
+		t.Fatal(err)
+	}
+	var got []Link
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := AddrInfo{Local: "2001:db8::2", PrefixLen: "ffffffffffffffff0000000000000000", NoDAD: true, MngTmpAddr: true}
+	if len(got) != 1 || len(got[0].AddrInfo) != 1 || got[0].AddrInfo[0] != want {
+		t.Errorf("printLinkJSON() = %s, want the address %+v", &out, want)
+	}
+}
+
+func TestShowLinksUp(t *testing.T) {
+	links := []netlink.Link{
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2, Flags: net.FlagUp, OperState: netlink.OperUp}},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", Index: 3, OperState: netlink.OperDown}},
+	}
+	addresses := [][]netlink.Addr{
+		{{IPNet: &net.IPNet{IP: net.IPv4(10, 0, 0, 1), Mask: net.CIDRMask(24, 32)}}},
+		{{IPNet: &net.IPNet{IP: net.IPv4(10, 0, 1, 1), Mask: net.CIDRMask(24, 32)}}},
+	}
+
+	var out bytes.Buffer
+	cmd := cmd{Out: &out, Opts: flags{Brief: true}, upOnly: true}
+	if err := cmd.showLinks(addresses, links); err != nil {
+		t.Fatal(err)
+	}
+	if want := "eth0                 up         10.0.0.1\n"; out.String() != want {
+		t.Errorf("showLinks() = %q, want %q", &out, want)
+	}
+
+	out.Reset()
+	cmd.Opts.JSON = true
+	if err := cmd.showLinks(addresses, links); err != nil {
+		t.Fatal(err)
+	}
+	var got []Link
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].IfName != "eth0" || len(got[0].AddrInfo) != 1 || got[0].AddrInfo[0].Local != "10.0.0.1" {
+		t.Errorf("showLinks() JSON = %s, want only eth0", &out)
+	}
+}
+
+func TestShowLinksMaster(t *testing.T) {
+	links := []netlink.Link{
+		&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0", Index: 2, OperState: netlink.OperUp}},
+		&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0", Index: 3, MasterIndex: 2, OperState: netlink.OperUp}},
+		&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth1", Index: 4, OperState: netlink.OperUp}},
+	}
+	addresses := make([][]netlink.Addr, len(links))
+
+	var out bytes.Buffer
+	cmd := cmd{Out: &out, Opts: flags{Brief: true}, master: 2}
+	if err := cmd.showLinks(addresses, links); err != nil {
+		t.Fatal(err)
+	}
+	if want := "veth0                up        \n"; out.String() != want {
+		t.Errorf("showLinks() = %q, want %q", &out, want)
+	}
+
+	out.Reset()
+	cmd.Opts.JSON = true
+	if err := cmd.showLinks(addresses, links); err != nil {
+		t.Fatal(err)
+	}
+	var got []Link
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].IfName != "veth0" {
+		t.Errorf("showLinks() JSON = %s, want only veth0", &out)
+	}
+}
+
+func TestShowLinksXdp(t *testing.T) {
+	links := []netlink.Link{
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 1002, EncapType: "ether", Xdp: &netlink.LinkXdp{Attached: true, AttachMode: nl.XDP_ATTACHED_SKB, ProgId: 42}}},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", Index: 1003, EncapType: "ether", Xdp: &netlink.LinkXdp{Attached: true, AttachMode: xdpAttachedMulti}}},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth2", Index: 1004, EncapType: "ether", Xdp: &netlink.LinkXdp{}}},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth3", Index: 1005, EncapType: "ether"}},
+	}
+	addresses := make([][]netlink.Addr, len(links))
+
+	var out bytes.Buffer
+	cmd := cmd{Out: &out, Opts: flags{Details: true}}
+	if err := cmd.showLinks(addresses, links); err != nil {
+		t.Fatal(err)
+	}
+	var xdps []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "    xdp ") {
+			xdps = append(xdps, line)
+		}
+	}
+	if want := []string{"    xdp mode generic prog_id 42", "    xdp mode multi"}; !reflect.DeepEqual(xdps, want) {
+		t.Errorf("showLinks() xdp lines = %q, want %q:\n%s", xdps, want, &out)
+	}
+
+	out.Reset()
+	cmd.Opts.JSON = true
+	if err := cmd.printLinkJSON(links, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var got []Link
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []*Xdp{{Mode: "generic", ProgID: 42}, {Mode: "multi"}, nil, nil}
+	if len(got) != len(want) {
+		t.Fatalf("printLinkJSON() = %s, want %d links", &out, len(want))
+	}
+	for i, link := range got {
+		if !reflect.DeepEqual(link.Xdp, want[i]) {
+			t.Errorf("printLinkJSON() %s xdp = %+v, want %+v", link.IfName, link.Xdp, want[i])
+		}
+	}
+	if strings.Count(out.String(), `"xdp"`) != 2 {
+		t.Errorf("printLinkJSON() = %s, want xdp only for eth0 and eth1", &out)
+	}
+}
@@ -83,7 +83,7 @@ func (cmd *cmd) parseDeviceName(mandatory bool) (netlink.Link, error) {
 		}
 
 		cmd.ExpectedValues = []string{"device-name"}
-		return netlink.LinkByName(cmd.currentToken())
+		return cmd.lookupLink(cmd.currentToken())
 	default:
 		if !cmd.tokenRemains() {
 			return nil, ErrNotFound
@@ -94,7 +94,7 @@ func (cmd *cmd) parseDeviceName(mandatory bool) (netlink.Link, error) {
 		}
 
 		cmd.ExpectedValues = []string{"device-name"}
-		return netlink.LinkByName(cmd.currentToken())
+		return cmd.lookupLink(cmd.currentToken())
 	}
 }
 
@@ -6,10 +6,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"syscall"
 
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
 	"golang.org/x/sys/unix"
 )
 
@@ -23,14 +29,15 @@ const routeHelp = `Usage: ip route { list | flush } SELECTOR
 
 	   ip route help
 SELECTOR := [ root PREFIX ] [ match PREFIX ] [ exact PREFIX ]
+            [ from PREFIX ] [ iif STRING ] [ oif STRING ]
             [ table TABLE_ID ] [ proto RTPROTO ]
             [ type TYPE ] [ scope SCOPE ]
 ROUTE := NODE_SPEC [ INFO_SPEC ]
 NODE_SPEC := [ TYPE ] PREFIX [ tos TOS ]
              [ table TABLE_ID ] [ proto RTPROTO ]
              [ scope SCOPE ] [ metric METRIC ] OPTIONS
-INFO_SPEC := [ nexthop NH ]...
-NH := [ via ADDRESS ]
+INFO_SPEC := [ nexthop NH ]... | nhid ID
+NH := [ via ADDRESS ] [ onlink ]
 FAMILY := [ inet | inet6 | mpls | bridge | link ]
 OPTIONS := FLAGS [ mtu NUMBER ] [ advmss NUMBER ]
            [ rtt TIME ] [ rttvar TIME ] [ reordering NUMBER ]
@@ -38,8 +45,8 @@ OPTIONS := FLAGS [ mtu NUMBER ] [ advmss NUMBER ]
            [ ssthresh NUMBER ] [ realms REALM ] [ src ADDRESS ]
            [ rto_min TIME ] [ hoplimit NUMBER ] [ initrwnd NUMBER ]
            [ features FEATURES ] [ quickack BOOL ] [ congctl NAME ]
-		   [ fastopen_no_cookie BOOL ]
-TYPE := { unicast | local | broadcast | multicast | throw |
+		   [ fastopen_no_cookie BOOL ] [ expires TIME ]
+TYPE := { unicast | local | broadcast | anycast | multicast | throw |
           unreachable | prohibit | blackhole | nat }
 TABLE_ID := [ local | main | default | all | NUMBER ]
 SCOPE := [ host | link | global | NUMBER ]
@@ -52,6 +59,7 @@ var (
 		"unicast":     unix.RTN_UNICAST,
 		"local":       unix.RTN_LOCAL,
 		"broadcast":   unix.RTN_BROADCAST,
+		"anycast":     unix.RTN_ANYCAST,
 		"multicast":   unix.RTN_MULTICAST,
 		"throw":       unix.RTN_THROW,
 		"unreachable": unix.RTN_UNREACHABLE,
@@ -69,6 +77,51 @@ var (
 	}
 )
 
+// parseScope parses SCOPE, the name of a scope as shown or its number.
+func (cmd *cmd) parseScope() (netlink.Scope, error) {
+	token := cmd.nextToken("SCOPE")
+	for scope, name := range addrScopes {
+		if token == name {
+			return scope, nil
+		}
+	}
+	scope, err := strconv.ParseUint(token, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid scope %q: %w", token, err)
+	}
+	return netlink.Scope(scope), nil
+}
+
+// routeTables maps the names of the reserved routing tables to their IDs.
+var routeTables = map[string]uint32{
+	"default": unix.RT_TABLE_DEFAULT,
+	"main":    unix.RT_TABLE_MAIN,
+	"local":   unix.RT_TABLE_LOCAL,
+}
+
+// parseTableID parses a TABLE_ID: a reserved table name or a number. Routes
+// and rules share it.
+func parseTableID(token string) (uint32, error) {
+	if id, ok := routeTables[token]; ok {
+		return id, nil
+	}
+	id, err := strconv.ParseUint(token, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid table ID %q", token)
+	}
+	return uint32(id), nil
+}
+
+// tableName returns the name of a reserved table, or the table ID.
+func tableName(id uint32) string {
+	for name, tid := range routeTables {
+		if tid == id {
+			return name
+		}
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
 func routeTypeToString(routeType int) string {
 	for key, value := range routeTypes {
 		if value == routeType {
@@ -78,121 +131,540 @@ func routeTypeToString(routeType int) string {
 	return "unknown"
 }
 
-func (cmd *cmd) routeAdddefault() error {
-	nh, nhval, err := cmd.parseNextHop()
+// routeTypePrefix returns the type of r and a space, to start its line with:
+// with details always, as iproute2, and otherwise only if it is not unicast.
+func (cmd *cmd) routeTypePrefix(r netlink.Route) string {
+	if cmd.Opts.Details || r.Type > unix.RTN_UNICAST {
+		return routeTypeToString(r.Type) + " "
+	}
+	return ""
+}
+
+func (cmd *cmd) routeAdd() error {
+	ns := cmd.nextToken("default", "CIDR")
+	if cmd.tokenRemains() && cmd.peekToken("dev", "nhid") == "nhid" {
+		r, err := cmd.parseRouteNhid(ns)
+		if err != nil {
+			return err
+		}
+		if err := cmd.routeAddNhid(r); err != nil {
+			return fmt.Errorf("error adding route %s nhid %d: %v", ns, r.nhid, err)
+		}
+		return nil
+	}
+
+	route, d, expires, err := cmd.parseRouteAddAppendReplaceDel(ns, false)
 	if err != nil {
 		return err
 	}
-	// TODO: NHFLAGS.
-	l, err := cmd.parseDeviceName(true)
+
+	route.LinkIndex, err = cmd.resolveLink(d)
 	if err != nil {
 		return err
 	}
-	switch nh {
-	case "via":
-		fmt.Fprintf(cmd.Out, "Add default route %v via %v", nhval, l.Attrs().Name)
-		r := &netlink.Route{LinkIndex: l.Attrs().Index, Gw: nhval}
-		if err := cmd.handle.RouteAdd(r); err != nil {
-			return fmt.Errorf("error adding default route to %v: %v", l.Attrs().Name, err)
+
+	if expires != 0 {
+		err = cmd.routeAddExpires(route, expires, unix.NLM_F_CREATE|unix.NLM_F_EXCL)
+	} else {
+		err = cmd.handle.RouteAdd(route)
+	}
+	if err != nil {
+		return fmt.Errorf("error adding route %s -> %s: %v", dstText(route), d, cmd.onlinkHint(err, route))
+	}
+	return nil
+}
+
+// nhidRoute is a route using a nexthop object, which netlink.Route cannot
+// express.
+type nhidRoute struct {
+	family int
+	// dst is nil for the default route.
+	dst    *net.IPNet
+	nhid   uint32
+	table  int
+	metric int
+}
+
+// parseRouteNhid parses `nhid ID [ table TABLE_ID ] [ metric METRIC ]` of
+// the route to ns.
+func (cmd *cmd) parseRouteNhid(ns string) (nhidRoute, error) {
+	r := nhidRoute{family: cmd.Family}
+	if ns != "default" {
+		var err error
+		if _, r.dst, err = net.ParseCIDR(ns); err != nil {
+			return r, err
+		}
+		r.family = netlink.FAMILY_V4
+		if r.dst.IP.To4() == nil {
+			r.family = netlink.FAMILY_V6
 		}
-		return nil
 	}
-	return cmd.usage()
+	if r.family == netlink.FAMILY_ALL {
+		r.family = netlink.FAMILY_V4
+	}
+
+	for cmd.tokenRemains() {
+		var err error
+		switch cmd.nextToken("nhid", "table", "metric") {
+		case "nhid":
+			r.nhid, err = cmd.parseUint32("ID")
+		case "table":
+			var table uint32
+			table, err = parseTableID(cmd.nextToken("TABLE_ID"))
+			r.table = int(table)
+		case "metric":
+			r.metric, err = cmd.parseInt("METRIC")
+		default:
+			return r, cmd.usage()
+		}
+		if err != nil {
+			return r, err
+		}
+	}
+	if r.nhid == 0 {
+		return r, fmt.Errorf("nhid must not be 0")
+	}
+	return r, nil
 }
 
-func (cmd *cmd) routeAdd() error {
-	ns := cmd.nextToken("default", "CIDR")
-	switch ns {
-	case "default":
-		return cmd.routeAdddefault()
-	default:
-		route, d, err := cmd.parseRouteAddAppendReplaceDel(ns)
+// routeAddNhid adds r with an RTM_NEWROUTE request.
+func (cmd *cmd) routeAddNhid(r nhidRoute) error {
+	u32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		nl.NativeEndian().PutUint32(b, v)
+		return b
+	}
+
+	req := cmd.newRequest(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_EXCL|unix.NLM_F_ACK)
+	msg := nl.NewRtMsg()
+	msg.Family = uint8(r.family)
+	if r.table > 0 && r.table < 256 {
+		msg.Table = uint8(r.table)
+	}
+	if r.dst != nil {
+		ones, _ := r.dst.Mask.Size()
+		msg.Dst_len = uint8(ones)
+	}
+	req.AddData(msg)
+	if r.dst != nil {
+		dst := r.dst.IP.To4()
+		if dst == nil {
+			dst = r.dst.IP.To16()
+		}
+		req.AddData(nl.NewRtAttr(unix.RTA_DST, dst))
+	}
+	req.AddData(nl.NewRtAttr(rtaNhID, u32(r.nhid)))
+	if r.table >= 256 {
+		req.AddData(nl.NewRtAttr(unix.RTA_TABLE, u32(uint32(r.table))))
+	}
+	if r.metric > 0 {
+		req.AddData(nl.NewRtAttr(unix.RTA_PRIORITY, u32(uint32(r.metric))))
+	}
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
+
+// routeKey identifies a route in both netlink.Route and route messages, but
+// for the attributes netlink.Route lacks.
+type routeKey struct {
+	family, table, priority int
+	protocol, scope, typ    int
+	oif, iif                int
+	// dst is "" for the default route.
+	dst string
+}
+
+func keyOfRoute(r netlink.Route) routeKey {
+	k := routeKey{
+		family:   r.Family,
+		table:    r.Table,
+		priority: r.Priority,
+		protocol: int(r.Protocol),
+		scope:    int(r.Scope),
+		typ:      r.Type,
+		oif:      r.LinkIndex,
+		iif:      r.ILinkIndex,
+	}
+	if r.Dst != nil {
+		k.dst = r.Dst.String()
+	}
+	return k
+}
+
+// routeAttrs are the attributes of a route that netlink.Route lacks.
+type routeAttrs struct {
+	// src is the source prefix of a source-specific route, or nil.
+	src  *net.IPNet
+	nhid uint32
+	// expires is the remaining lifetime in seconds, or 0.
+	expires int
+}
+
+// routeDumpTries bounds how often routeList dumps the routes again when they
+// change between its two dumps.
+const routeDumpTries = 5
+
+// routeList returns the routes of family in all tables, with the nexthop
+// objects, lifetimes, and source prefixes netlink.Route lacks. netlink does
+// not return the messages it decodes, so the routes are dumped twice, and
+// again until both dumps list the same routes in the same order.
+func (cmd *cmd) routeList(family int) ([]netlink.Route, []routeAttrs, error) {
+	for i := 0; i < routeDumpTries; i++ {
+		routes, err := cmd.handle.RouteListFiltered(family, &netlink.Route{}, netlink.RT_FILTER_TABLE)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 
-		link, err := netlink.LinkByName(d)
+		req := cmd.newRequest(unix.RTM_GETROUTE, unix.NLM_F_DUMP)
+		req.AddData(&nl.RtMsg{RtMsg: unix.RtMsg{Family: uint8(family)}})
+		msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWROUTE)
 		if err != nil {
-			return fmt.Errorf("error getting link %s: %v", d, err)
+			return nil, nil, err
 		}
 
-		route.LinkIndex = link.Attrs().Index
+		if attrs, ok := pairRouteAttrs(routes, msgs); ok {
+			return routes, attrs, nil
+		}
+	}
+	return nil, nil, errors.New("routes kept changing while listing them")
+}
 
-		if err := cmd.handle.RouteAdd(route); err != nil {
-			return fmt.Errorf("error adding route %s -> %s: %v", route.Dst.IP, d, err)
+// pairRouteAttrs returns the attributes of routes from msgs, a dump of the
+// same routes. It reports false if msgs lists other routes, or the same in
+// another order, as happens when routes change between the dumps.
+func pairRouteAttrs(routes []netlink.Route, msgs [][]byte) ([]routeAttrs, bool) {
+	attrs := make([]routeAttrs, 0, len(routes))
+	for _, m := range msgs {
+		k, a, ok := parseRouteAttrs(m)
+		if !ok {
+			continue
+		}
+		if len(attrs) == len(routes) || keyOfRoute(routes[len(attrs)]) != k {
+			return nil, false
+		}
+		attrs = append(attrs, a)
+	}
+	return attrs, len(attrs) == len(routes)
+}
+
+// parseRouteMsg returns the key and attributes of the route in an
+// RTM_NEWROUTE message. Like netlink.RouteList, it skips cloned routes.
+func parseRouteMsg(b []byte) (routeKey, []syscall.NetlinkRouteAttr, bool) {
+	if len(b) < unix.SizeofRtMsg {
+		return routeKey{}, nil, false
+	}
+	msg := nl.DeserializeRtMsg(b)
+	if msg.Flags&unix.RTM_F_CLONED != 0 {
+		return routeKey{}, nil, false
+	}
+	attrs, err := nl.ParseRouteAttr(b[unix.SizeofRtMsg:])
+	if err != nil {
+		return routeKey{}, nil, false
+	}
+
+	k := routeKey{
+		family:   int(msg.Family),
+		table:    int(msg.Table),
+		protocol: int(msg.Protocol),
+		scope:    int(msg.Scope),
+		typ:      int(msg.Type),
+	}
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case unix.RTA_TABLE:
+			k.table = int(nl.NativeEndian().Uint32(a.Value))
+		case unix.RTA_PRIORITY:
+			k.priority = int(nl.NativeEndian().Uint32(a.Value))
+		case unix.RTA_OIF:
+			k.oif = int(nl.NativeEndian().Uint32(a.Value))
+		case unix.RTA_IIF:
+			k.iif = int(nl.NativeEndian().Uint32(a.Value))
+		case unix.RTA_DST:
+			k.dst = (&net.IPNet{IP: a.Value, Mask: net.CIDRMask(int(msg.Dst_len), 8*len(a.Value))}).String()
+		}
+	}
+	return k, attrs, true
+}
+
+// parseRouteAttrs returns the key and the attributes netlink.Route lacks of
+// the route in an RTM_NEWROUTE message.
+func parseRouteAttrs(b []byte) (routeKey, routeAttrs, bool) {
+	k, _, ok := parseRouteMsg(b)
+	if !ok {
+		return k, routeAttrs{}, false
+	}
+	var attrs routeAttrs
+	_, attrs.nhid, _ = parseRouteNhid(b)
+	_, attrs.expires, _ = parseRouteExpires(b)
+	_, attrs.src, _ = parseRouteSrc(b)
+	return k, attrs, true
+}
+
+// parseRouteNhid returns the key and nexthop object of the route in an
+// RTM_NEWROUTE message, if it uses one.
+func parseRouteNhid(b []byte) (routeKey, uint32, bool) {
+	k, attrs, ok := parseRouteMsg(b)
+	if !ok {
+		return k, 0, false
+	}
+	for _, a := range attrs {
+		if a.Attr.Type == rtaNhID && len(a.Value) >= 4 {
+			nhid := nl.NativeEndian().Uint32(a.Value)
+			return k, nhid, nhid != 0
+		}
+	}
+	return k, 0, false
+}
+
+// userHZ is the unit of the clock_t times netlink reports, USER_HZ, which is
+// fixed on Linux.
+const userHZ = 100
+
+// parseRouteExpires returns the key and remaining lifetime in seconds of the
+// route in an RTM_NEWROUTE message, if it expires. The lifetime is the
+// rta_expires field of struct rta_cacheinfo, after rta_clntref and
+// rta_lastuse.
+func parseRouteExpires(b []byte) (routeKey, int, bool) {
+	k, attrs, ok := parseRouteMsg(b)
+	if !ok {
+		return k, 0, false
+	}
+	for _, a := range attrs {
+		if a.Attr.Type == unix.RTA_CACHEINFO && len(a.Value) >= 12 {
+			expires := int(int32(nl.NativeEndian().Uint32(a.Value[8:]))) / userHZ
+			return k, expires, expires > 0
 		}
-		return nil
 	}
+	return k, 0, false
+}
+
+// parseRouteSrc returns the key and source prefix of the route in an
+// RTM_NEWROUTE message, if it is source-specific.
+func parseRouteSrc(b []byte) (routeKey, *net.IPNet, bool) {
+	k, attrs, ok := parseRouteMsg(b)
+	if !ok {
+		return k, nil, false
+	}
+	srcLen := int(nl.DeserializeRtMsg(b).Src_len)
+	for _, a := range attrs {
+		if a.Attr.Type == unix.RTA_SRC && srcLen > 0 {
+			return k, &net.IPNet{IP: a.Value, Mask: net.CIDRMask(srcLen, 8*len(a.Value))}, true
+		}
+	}
+	return k, nil, false
+}
+
+// routeSrc returns the source prefix of route r with attributes a. A route
+// that is not source-specific has the zero-length prefix of its family.
+func routeSrc(r netlink.Route, a routeAttrs) *net.IPNet {
+	if a.src != nil {
+		return a.src
+	}
+	dst := routeDst(r)
+	return &net.IPNet{IP: make(net.IP, len(dst.IP)), Mask: net.CIDRMask(0, 8*len(dst.IP))}
+}
+
+// fromText returns " from PREFIX" for a source-specific route, or "".
+func (a routeAttrs) fromText() string {
+	if a.src != nil {
+		return " from " + a.src.String()
+	}
+	return ""
+}
+
+// nhidText returns " nhid ID" for a route using a nexthop object, or "".
+func (a routeAttrs) nhidText() string {
+	if a.nhid != 0 {
+		return fmt.Sprintf(" nhid %d", a.nhid)
+	}
+	return ""
+}
+
+// expiresText returns " expires Nsec" for a route with a lifetime, or "".
+func (a routeAttrs) expiresText() string {
+	if a.expires > 0 {
+		return fmt.Sprintf(" expires %dsec", a.expires)
+	}
+	return ""
+}
+
+// expiresOptions are the options of a route that can expire beside expires
+// itself, those routeAddExpires sends.
+var expiresOptions = map[string]bool{
+	"via":     true,
+	"onlink":  true,
+	"table":   true,
+	"proto":   true,
+	"scope":   true,
+	"metric":  true,
+	"src":     true,
+	"expires": true,
+}
+
+// checkExpires returns an error unless route, given with options, can
+// expire. The kernel only honours a lifetime for IPv6 routes, as iproute2
+// documents, and routeAddExpires only adds plain unicast routes.
+func checkExpires(route *netlink.Route, options []string) error {
+	if routeDst(*route).IP.To4() != nil {
+		return errors.New("expires is only supported for IPv6 routes")
+	}
+	if route.Type != unix.RTN_UNSPEC && route.Type != unix.RTN_UNICAST {
+		return fmt.Errorf("expires is only supported for unicast routes, not %s routes", routeTypeToString(route.Type))
+	}
+	for _, option := range options {
+		if !expiresOptions[option] {
+			return fmt.Errorf("expires can't be combined with %s", option)
+		}
+	}
+	return nil
+}
+
+// routeAddExpires adds route with a lifetime of expires seconds with an
+// RTM_NEWROUTE request, as netlink.Route cannot express it. route is an IPv6
+// unicast route with the options of expiresOptions, see checkExpires. flags
+// select between add, append, and replace.
+func (cmd *cmd) routeAddExpires(route *netlink.Route, expires uint32, flags int) error {
+	req := cmd.newRequest(unix.RTM_NEWROUTE, flags|unix.NLM_F_ACK)
+	msg := nl.NewRtMsg()
+	msg.Family = netlink.FAMILY_V6
+	dst := routeDst(*route)
+	ones, _ := dst.Mask.Size()
+	msg.Dst_len = uint8(ones)
+	msg.Scope = uint8(route.Scope)
+	msg.Flags = uint32(route.Flags)
+	if route.Table > 0 && route.Table < 256 {
+		msg.Table = uint8(route.Table)
+	}
+	if route.Protocol > 0 {
+		msg.Protocol = uint8(route.Protocol)
+	}
+	req.AddData(msg)
+
+	if ones > 0 {
+		req.AddData(nl.NewRtAttr(unix.RTA_DST, dst.IP.To16()))
+	}
+	if route.Gw != nil {
+		req.AddData(nl.NewRtAttr(unix.RTA_GATEWAY, route.Gw.To16()))
+	}
+	if route.Src != nil {
+		req.AddData(nl.NewRtAttr(unix.RTA_PREFSRC, route.Src.To16()))
+	}
+	if route.Table >= 256 {
+		req.AddData(nl.NewRtAttr(unix.RTA_TABLE, nl.Uint32Attr(uint32(route.Table))))
+	}
+	if route.Priority > 0 {
+		req.AddData(nl.NewRtAttr(unix.RTA_PRIORITY, nl.Uint32Attr(uint32(route.Priority))))
+	}
+	req.AddData(nl.NewRtAttr(unix.RTA_OIF, nl.Uint32Attr(uint32(route.LinkIndex))))
+	req.AddData(nl.NewRtAttr(unix.RTA_EXPIRES, nl.Uint32Attr(expires)))
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
 }
 
 func (cmd *cmd) routeAppend() error {
 	ns := cmd.nextToken("default", "CIDR")
-	route, d, err := cmd.parseRouteAddAppendReplaceDel(ns)
+	route, d, expires, err := cmd.parseRouteAddAppendReplaceDel(ns, false)
 	if err != nil {
 		return err
 	}
 
-	link, err := netlink.LinkByName(d)
+	route.LinkIndex, err = cmd.resolveLink(d)
 	if err != nil {
-		return fmt.Errorf("error getting link %s: %v", d, err)
+		return err
 	}
 
-	route.LinkIndex = link.Attrs().Index
-
-	if err := cmd.handle.RouteAppend(route); err != nil {
-		return fmt.Errorf("error appending route %s -> %s: %v", route.Dst.IP, d, err)
+	if expires != 0 {
+		err = cmd.routeAddExpires(route, expires, unix.NLM_F_CREATE|unix.NLM_F_APPEND)
+	} else {
+		err = cmd.handle.RouteAppend(route)
+	}
+	if err != nil {
+		return fmt.Errorf("error appending route %s -> %s: %v", dstText(route), d, cmd.onlinkHint(err, route))
 	}
 	return nil
 }
 
 func (cmd *cmd) routeReplace() error {
 	ns := cmd.nextToken("default", "CIDR")
-	route, d, err := cmd.parseRouteAddAppendReplaceDel(ns)
+	route, d, expires, err := cmd.parseRouteAddAppendReplaceDel(ns, false)
 	if err != nil {
 		return err
 	}
 
-	link, err := netlink.LinkByName(d)
+	route.LinkIndex, err = cmd.resolveLink(d)
 	if err != nil {
-		return fmt.Errorf("error getting link %s: %v", d, err)
+		return err
 	}
 
-	route.LinkIndex = link.Attrs().Index
-
-	if err := cmd.handle.RouteReplace(route); err != nil {
-		return fmt.Errorf("error appending route %s -> %s: %v", route.Dst.IP, d, err)
+	if expires != 0 {
+		err = cmd.routeAddExpires(route, expires, unix.NLM_F_CREATE|unix.NLM_F_REPLACE)
+	} else {
+		err = cmd.handle.RouteReplace(route)
+	}
+	if err != nil {
+		return fmt.Errorf("error appending route %s -> %s: %v", dstText(route), d, cmd.onlinkHint(err, route))
 	}
 	return nil
 }
 
 func (cmd *cmd) routeDel() error {
 	ns := cmd.nextToken("default", "CIDR")
-	route, d, err := cmd.parseRouteAddAppendReplaceDel(ns)
+	// The lifetime does not identify the route.
+	route, d, _, err := cmd.parseRouteAddAppendReplaceDel(ns, true)
 	if err != nil {
 		return err
 	}
 
-	link, err := netlink.LinkByName(d)
+	route.LinkIndex, err = cmd.resolveLink(d)
 	if err != nil {
-		return fmt.Errorf("error getting link %s: %v", d, err)
+		return err
 	}
 
-	route.LinkIndex = link.Attrs().Index
-
 	if err := cmd.handle.RouteDel(route); err != nil {
-		return fmt.Errorf("error deleting route %s -> %s: %v", route.Dst.IP, d, err)
+		return fmt.Errorf("error deleting route %s -> %s: %v", dstText(route), d, err)
 	}
 	return nil
 }
 
-func (cmd *cmd) parseRouteAddAppendReplaceDel(ns string) (*netlink.Route, string, error) {
-	var err error
+// routeOptions are the options of a route after its device.
+var routeOptions = []string{"via", "onlink", "type", "tos", "table", "proto", "scope", "metric", "mtu", "advmss", "rtt", "rttvar", "reordering", "window", "cwnd", "initcwnd", "ssthresh", "realms", "src", "rto_min", "hoplimit", "initrwnd", "congctl", "features", "quickack", "fastopen_no_cookie", "expires"}
+
+// parseRouteAddAppendReplaceDel parses the route to ns, to delete if del is
+// set. It returns the route, its device, and its lifetime in seconds, or 0 if
+// it does not expire.
+func (cmd *cmd) parseRouteAddAppendReplaceDel(ns string, del bool) (*netlink.Route, string, uint32, error) {
+	var (
+		err     error
+		expires uint32
+	)
 
 	route := &netlink.Route{}
 
-	_, route.Dst, err = net.ParseCIDR(ns)
-	if err != nil {
-		return nil, "", err
+	// The node may start with its type, e.g. local 10.0.0.5.
+	if typ, ok := routeTypes[ns]; ok {
+		route.Type = typ
+		ns = cmd.nextToken("default", "PREFIX")
+	}
+	// The default route has no destination, see routeDst.
+	if ns != "default" {
+		route.Dst, err = parseRoutePrefix(ns)
+		if err != nil {
+			return nil, "", 0, err
+		}
+	}
+	var (
+		scopeSet, tableSet bool
+		options            []string
+	)
+
+	if cmd.tokenRemains() && cmd.peekToken("via", "dev", "device-name") == "via" {
+		cmd.nextToken("via")
+		if route.Gw, err = cmd.parseGateway(); err != nil {
+			return nil, "", 0, err
+		}
+		if err := cmd.requireDev(route.Gw); err != nil {
+			return nil, "", 0, err
+		}
 	}
 
 	d := cmd.nextToken("dev", "device-name")
@@ -201,115 +673,126 @@ func (cmd *cmd) parseRouteAddAppendReplaceDel(ns string) (*netlink.Route, string
 	}
 
 	for cmd.tokenRemains() {
-		switch cmd.nextToken("type", "tos", "table", "proto", "scope", "metric", "mtu", "advmss", "rtt", "rttvar", "reordering", "window", "cwnd", "initcwnd", "ssthresh", "realms", "src", "rto_min", "hoplimit", "initrwnd", "congctl", "features", "quickack", "fastopen_no_cookie") {
+		option := cmd.nextToken(routeOptions...)
+		options = append(options, option)
+		switch option {
+		case "via":
+			route.Gw, err = cmd.parseGateway()
+			if err != nil {
+				return nil, "", 0, err
+			}
+		case "onlink":
+			route.Flags |= int(netlink.FLAG_ONLINK)
 		case "tos":
 			route.Tos, err = cmd.parseInt("TOS")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 
 		case "table":
-			route.Table, err = cmd.parseInt("TABLE_ID")
+			table, err := parseTableID(cmd.nextToken("TABLE_ID"))
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
+			route.Table = int(table)
+			tableSet = true
 
 		case "proto":
 			proto, err := cmd.parseInt("RTPROTO")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 
 			route.Protocol = netlink.RouteProtocol(proto)
 
 		case "scope":
-			scope, err := cmd.parseUint8("SCOPE")
+			route.Scope, err = cmd.parseScope()
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
-			route.Scope = netlink.Scope(scope)
+			scopeSet = true
 		case "metric":
 			route.Priority, err = cmd.parseInt("METRIC")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "mtu":
 			route.MTU, err = cmd.parseInt("NUMBER")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "advmss":
 			route.AdvMSS, err = cmd.parseInt("NUMBER")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "rtt":
 			route.Rtt, err = cmd.parseInt("TIME")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "rttvar":
 			route.RttVar, err = cmd.parseInt("TIME")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "reordering":
 			route.Reordering, err = cmd.parseInt("NUMBER")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "window":
 			route.Window, err = cmd.parseInt("NUMBER")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "cwnd":
 			route.Cwnd, err = cmd.parseInt("NUMBER")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "initcwnd":
 			route.InitCwnd, err = cmd.parseInt("NUMBER")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "ssthresh":
 			route.Ssthresh, err = cmd.parseInt("NUMBER")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "realms":
 			route.Realm, err = cmd.parseInt("REALM")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "src":
 			token := cmd.nextToken("ADDRESS")
 			route.Src = net.ParseIP(token)
 			if route.Src == nil {
-				return nil, "", fmt.Errorf("invalid source address: %v", token)
+				return nil, "", 0, fmt.Errorf("invalid source address: %v", token)
 			}
 		case "rto_min":
 			route.RtoMin, err = cmd.parseInt("TIME")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "hoplimit":
 			route.Hoplimit, err = cmd.parseInt("NUMBER")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "initrwnd":
 			route.InitRwnd, err = cmd.parseInt("NUMBER")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "congctl":
 			route.Congctl = cmd.nextToken("NAME")
 		case "features":
 			route.Features, err = cmd.parseInt("FEATURES")
 			if err != nil {
-				return nil, "", err
+				return nil, "", 0, err
 			}
 		case "quickack":
 			switch cmd.nextToken("0", "1") {
@@ -318,7 +801,7 @@ func (cmd *cmd) parseRouteAddAppendReplaceDel(ns string) (*netlink.Route, string
 			case "0":
 				route.QuickACK = 0
 			default:
-				return nil, "", cmd.usage()
+				return nil, "", 0, cmd.usage()
 			}
 		case "fastopen_no_cookie":
 			switch cmd.nextToken("0", "1") {
@@ -327,46 +810,174 @@ func (cmd *cmd) parseRouteAddAppendReplaceDel(ns string) (*netlink.Route, string
 			case "0":
 				route.FastOpenNoCookie = 0
 			default:
-				return nil, "", cmd.usage()
+				return nil, "", 0, cmd.usage()
+			}
+		case "expires":
+			expires, err = cmd.parseUint32("TIME")
+			if err != nil {
+				return nil, "", 0, err
 			}
 		default:
-			return nil, "", cmd.usage()
+			return nil, "", 0, cmd.usage()
+		}
+	}
+
+	if route.Dst == nil && route.Gw == nil {
+		// Only the gateway tells the family of a default route apart.
+		route.Dst = routeDst(netlink.Route{Family: cmd.Family})
+	}
+	if expires != 0 && !del {
+		if err := checkExpires(route, options); err != nil {
+			return nil, "", 0, err
 		}
 	}
 
-	return route, d, nil
+	setRouteTypeDefaults(route, scopeSet, tableSet)
+	if !scopeSet {
+		setUnicastScope(route, del)
+	}
+	return route, d, expires, nil
+}
+
+// dstText returns the destination of route for messages: its address, or
+// default.
+func dstText(route *netlink.Route) string {
+	if route.Dst == nil {
+		return "default"
+	}
+	return route.Dst.IP.String()
+}
+
+// setRouteTypeDefaults sets the scope and table of route that were not given
+// as iproute2 does for its type: local and nat IPv4 routes are of host scope,
+// broadcast, multicast, and anycast ones of link scope, and all but multicast
+// routes go to the local table. Like setUnicastScope, it leaves IPv6 routes
+// of global scope.
+func setRouteTypeDefaults(route *netlink.Route, scopeSet, tableSet bool) {
+	if !scopeSet && routeDst(*route).IP.To4() != nil {
+		switch route.Type {
+		case unix.RTN_LOCAL, unix.RTN_NAT:
+			route.Scope = netlink.SCOPE_HOST
+		case unix.RTN_BROADCAST, unix.RTN_MULTICAST, unix.RTN_ANYCAST:
+			route.Scope = netlink.SCOPE_LINK
+		}
+	}
+	if !tableSet {
+		switch route.Type {
+		case unix.RTN_LOCAL, unix.RTN_BROADCAST, unix.RTN_NAT, unix.RTN_ANYCAST:
+			route.Table = unix.RT_TABLE_LOCAL
+		}
+	}
+}
+
+// setUnicastScope sets the scope of the unicast IPv4 route, whose scope was
+// not given, as iproute2 does: a route without a gateway reaches its
+// destination directly on the device, so it is of link scope, e.g.
+// `ip route add 10.0.0.0/24 dev eth0`, and a route to delete is of scope
+// nowhere, which the kernel matches with routes of any scope. iproute2 leaves
+// the scope of IPv6 routes, which the kernel ignores, global.
+func setUnicastScope(route *netlink.Route, del bool) {
+	if route.Type != unix.RTN_UNSPEC && route.Type != unix.RTN_UNICAST {
+		return
+	}
+	if routeDst(*route).IP.To4() == nil {
+		return
+	}
+	switch {
+	case del:
+		route.Scope = netlink.SCOPE_NOWHERE
+	case route.Gw == nil:
+		route.Scope = netlink.SCOPE_LINK
+	}
+}
+
+// parseGateway parses the ADDRESS of via.
+func (cmd *cmd) parseGateway() (net.IP, error) {
+	token := cmd.nextToken("ADDRESS")
+	gw := net.ParseIP(token)
+	if gw == nil {
+		return nil, fmt.Errorf("invalid gateway address: %v", token)
+	}
+	return gw, nil
+}
+
+// requireDev returns an error unless a device follows a route via gw, as
+// `dev NAME` or a name that is no option. An IPv6 link-local gateway is only
+// meaningful on a given link.
+func (cmd *cmd) requireDev(gw net.IP) error {
+	if cmd.tokenRemains() {
+		next := cmd.peekToken("dev", "device-name")
+		if next == "dev" || !slices.Contains(routeOptions, next) {
+			return nil
+		}
+	}
+	if gw.To4() == nil && gw.IsLinkLocalUnicast() {
+		return fmt.Errorf("dev is required for link-local gateway %s", gw)
+	}
+	return cmd.usage()
+}
+
+// onlinkHint explains the error of adding route if the kernel rejected its
+// gateway for being on none of the subnets of its device, which it only
+// accepts with onlink. Other errors are returned as is.
+func (cmd *cmd) onlinkHint(err error, route *netlink.Route) error {
+	return onlinkHint(err, route, func() ([]netlink.Addr, error) {
+		dev := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: route.LinkIndex}}
+		return cmd.handle.AddrList(dev, netlink.FAMILY_ALL)
+	})
+}
+
+// onlinkHint is cmd.onlinkHint with addrs returning the addresses of the
+// route's device. They are only looked up for ENETUNREACH and EINVAL.
+func onlinkHint(err error, route *netlink.Route, addrs func() ([]netlink.Addr, error)) error {
+	if route.Gw == nil || route.Flags&int(netlink.FLAG_ONLINK) != 0 {
+		return err
+	}
+	if !errors.Is(err, unix.ENETUNREACH) && !errors.Is(err, unix.EINVAL) {
+		return err
+	}
+	as, aerr := addrs()
+	if aerr != nil {
+		return err
+	}
+	for _, a := range as {
+		if a.IPNet != nil && a.IPNet.Contains(route.Gw) {
+			return err
+		}
+	}
+	return fmt.Errorf("%w: gateway %s is on no subnet of the device; add onlink if it is reachable on the link anyway", err, route.Gw)
 }
 
 func (cmd *cmd) routeShow() error {
-	filter, filterMask, root, match, exact, err := cmd.parseRouteShowListFlush()
+	filter, filterMask, root, match, exact, from, err := cmd.parseRouteShowListFlush()
 	if err != nil {
 		return err
 	}
 
-	routeList, ifaceNames, err := cmd.filteredRouteList(filter, filterMask, root, match, exact)
+	routeList, attrs, ifaceNames, err := cmd.filteredRouteList(filter, filterMask, root, match, exact, from)
 	if err != nil {
 		return err
 	}
 
-	return cmd.showRoutes(routeList, ifaceNames)
+	return cmd.showRoutes(routeList, attrs, ifaceNames)
 }
 
 func (cmd *cmd) showAllRoutes() error {
-	routeList, ifaceNames, err := cmd.filteredRouteList(nil, 0, nil, nil, nil)
+	routeList, attrs, ifaceNames, err := cmd.filteredRouteList(nil, 0, nil, nil, nil, nil)
 	if err != nil {
 		return err
 	}
 
-	return cmd.showRoutes(routeList, ifaceNames)
+	return cmd.showRoutes(routeList, attrs, ifaceNames)
 }
 
 func (cmd *cmd) routeFlush() error {
-	filter, filterMask, root, match, exact, err := cmd.parseRouteShowListFlush()
+	filter, filterMask, root, match, exact, from, err := cmd.parseRouteShowListFlush()
 	if err != nil {
 		return err
 	}
 
-	routes, _, err := cmd.filteredRouteList(filter, filterMask, root, match, exact)
+	routes, _, _, err := cmd.filteredRouteList(filter, filterMask, root, match, exact, from)
 	if err != nil {
 		return err
 	}
@@ -380,99 +991,188 @@ func (cmd *cmd) routeFlush() error {
 	return nil
 }
 
-func (cmd *cmd) parseRouteShowListFlush() (*netlink.Route, uint64, *net.IPNet, *net.IPNet, *net.IPNet, error) {
+// parseRouteShowListFlush parses a route SELECTOR. Beside the netlink filter,
+// it returns the prefixes selected by root, match, exact, and from, see
+// matchRoutes.
+func (cmd *cmd) parseRouteShowListFlush() (*netlink.Route, uint64, *net.IPNet, *net.IPNet, *net.IPNet, *net.IPNet, error) {
 	var (
 		filterMask uint64
 		filter     netlink.Route
 		root       *net.IPNet
 		match      *net.IPNet
 		exact      *net.IPNet
+		from       *net.IPNet
 	)
 
 	for cmd.tokenRemains() {
-		switch cmd.nextToken("scope", "table", "proto", "root", "match", "exact", "type") {
+		switch cmd.nextToken("scope", "table", "proto", "root", "match", "exact", "from", "iif", "oif", "dev", "type") {
 		case "scope":
 			filterMask |= netlink.RT_FILTER_SCOPE
-			scope, err := cmd.parseUint8("SCOPE")
+			scope, err := cmd.parseScope()
 			if err != nil {
-				return nil, 0, nil, nil, nil, err
+				return nil, 0, nil, nil, nil, nil, err
 			}
-			filter.Scope = netlink.Scope(scope)
+			filter.Scope = scope
 
 		case "table":
+			// Table 0, all, selects the routes of all tables.
 			filterMask |= netlink.RT_FILTER_TABLE
-			table, err := cmd.parseInt("TABLE_ID")
+			token := cmd.nextToken("TABLE_ID")
+			if token == "all" {
+				filter.Table = unix.RT_TABLE_UNSPEC
+				break
+			}
+			table, err := parseTableID(token)
 			if err != nil {
-				return nil, 0, nil, nil, nil, err
+				return nil, 0, nil, nil, nil, nil, err
 			}
-			filter.Table = table
+			filter.Table = int(table)
 
 		case "proto":
 			filterMask |= netlink.RT_FILTER_PROTOCOL
 			proto, err := cmd.parseInt("RTPROTO")
 			if err != nil {
-				return nil, 0, nil, nil, nil, err
+				return nil, 0, nil, nil, nil, nil, err
 			}
 			filter.Protocol = netlink.RouteProtocol(proto)
 
 		case "root":
-			token := cmd.nextToken("PREFIX")
-			_, prefix, err := net.ParseCIDR(token)
+			prefix, err := parseRoutePrefix(cmd.nextToken("PREFIX"))
 			if err != nil {
-				return nil, 0, nil, nil, nil, err
+				return nil, 0, nil, nil, nil, nil, err
 			}
 			root = prefix
 
 		case "match":
-			token := cmd.nextToken("PREFIX")
-			_, prefix, err := net.ParseCIDR(token)
+			prefix, err := parseRoutePrefix(cmd.nextToken("PREFIX"))
 			if err != nil {
-				return nil, 0, nil, nil, nil, err
+				return nil, 0, nil, nil, nil, nil, err
 			}
 			match = prefix
 
 		case "exact":
-			token := cmd.nextToken("PREFIX")
-			_, prefix, err := net.ParseCIDR(token)
+			prefix, err := parseRoutePrefix(cmd.nextToken("PREFIX"))
 			if err != nil {
-				return nil, 0, nil, nil, nil, err
+				return nil, 0, nil, nil, nil, nil, err
 			}
 			exact = prefix
+
+		case "from":
+			prefix, err := parseRoutePrefix(cmd.nextToken("PREFIX"))
+			if err != nil {
+				return nil, 0, nil, nil, nil, nil, err
+			}
+			from = prefix
+
+		case "iif":
+			link, err := cmd.lookupLink(cmd.nextToken("STRING"))
+			if err != nil {
+				return nil, 0, nil, nil, nil, nil, err
+			}
+			filterMask |= netlink.RT_FILTER_IIF
+			filter.ILinkIndex = link.Attrs().Index
+
+		case "oif", "dev":
+			link, err := cmd.lookupLink(cmd.nextToken("STRING"))
+			if err != nil {
+				return nil, 0, nil, nil, nil, nil, err
+			}
+			filterMask |= netlink.RT_FILTER_OIF
+			filter.LinkIndex = link.Attrs().Index
+
 		case "type":
 			if routeType, ok := routeTypes[cmd.nextToken()]; ok {
 				filter.Type = routeType
 				filterMask |= netlink.RT_FILTER_TYPE
 			} else {
-				return nil, 0, nil, nil, nil, cmd.usage()
+				return nil, 0, nil, nil, nil, nil, cmd.usage()
 			}
 		default:
-			return nil, 0, nil, nil, nil, cmd.usage()
+			// A bare prefix selects that exact prefix, as in iproute2.
+			prefix, err := parseRoutePrefix(cmd.currentToken())
+			if err != nil {
+				return nil, 0, nil, nil, nil, nil, cmd.usage()
+			}
+			exact = prefix
+		}
+	}
+
+	// Routes of types other than unicast are mostly outside the main
+	// table, such as local and broadcast routes, so a type selects the
+	// routes of all tables unless a table is given.
+	if filterMask&netlink.RT_FILTER_TYPE != 0 && filterMask&netlink.RT_FILTER_TABLE == 0 {
+		filterMask |= netlink.RT_FILTER_TABLE
+		filter.Table = unix.RT_TABLE_UNSPEC
+	}
+
+	// Like iproute2, infer the family from the selector prefix.
+	for _, prefix := range []*net.IPNet{root, match, exact, from} {
+		if prefix != nil && cmd.Family == netlink.FAMILY_ALL {
+			if prefix.IP.To4() == nil {
+				cmd.Family = netlink.FAMILY_V6
+			} else {
+				cmd.Family = netlink.FAMILY_V4
+			}
 		}
 	}
 
-	return &filter, filterMask, root, match, exact, nil
+	return &filter, filterMask, root, match, exact, from, nil
 }
 
 type Route struct {
-	Dst      string   `json:"dst"`
-	Dev      string   `json:"dev"`
+	// Type is only shown for routes other than unicast, or with details.
+	Type string `json:"type,omitempty"`
+	Dst  string `json:"dst"`
+	// Src is the source prefix of a source-specific route.
+	Src  string `json:"src,omitempty"`
+	Nhid uint32 `json:"nhid,omitempty"`
+	// Expires is the remaining lifetime in seconds, 0 if the route does not
+	// expire.
+	Expires int    `json:"expires,omitempty"`
+	Dev     string `json:"dev,omitempty"`
+	// Table is only shown for routes outside the main table.
+	Table    string   `json:"table,omitempty"`
+	Iif      string   `json:"iif,omitempty"`
 	Protocol string   `json:"protocol"`
 	Scope    string   `json:"scope"`
 	PrefSrc  string   `json:"prefsrc"`
 	Flags    []string `json:"flags,omitempty"`
+
+	// table is the ID of the table, which orders routes to the same
+	// destination for --sorted.
+	table int
 }
 
-// showRoutes prints the routes in the system.
-func (cmd *cmd) showRoutes(routes []netlink.Route, ifaceNames []string) error {
+// showRoutes prints the routes in the system, with their attributes attrs.
+func (cmd *cmd) showRoutes(routes []netlink.Route, attrs []routeAttrs, ifaceNames []string) error {
 	if cmd.Opts.JSON {
 		obj := make([]Route, 0, len(routes))
 
 		for idx, route := range routes {
 
 			pRoute := Route{
-				Dst:   route.Dst.String(),
-				Dev:   ifaceNames[idx],
-				Scope: route.Scope.String(),
+				Dst:     route.Dst.String(),
+				Nhid:    attrs[idx].nhid,
+				Expires: attrs[idx].expires,
+				Dev:     ifaceNames[idx],
+				Scope:   route.Scope.String(),
+				table:   route.Table,
+			}
+			if cmd.Opts.Details || route.Type > unix.RTN_UNICAST {
+				pRoute.Type = routeTypeToString(route.Type)
+			}
+			if src := attrs[idx].src; src != nil {
+				pRoute.Src = src.String()
+			}
+			if tableText(route) != "" {
+				pRoute.Table = tableName(uint32(route.Table))
+			}
+			if route.ILinkIndex != 0 {
+				iif, err := cmd.devName(route.ILinkIndex)
+				if err != nil {
+					return err
+				}
+				pRoute.Iif = iif
 			}
 
 			if !cmd.Opts.Numeric {
@@ -499,65 +1199,177 @@ func (cmd *cmd) showRoutes(routes []netlink.Route, ifaceNames []string) error {
 
 	for idx, route := range routes {
 		if route.Dst == nil {
-			cmd.defaultRoute(route, ifaceNames[idx])
+			cmd.defaultRoute(route, attrs[idx], ifaceNames[idx])
 		} else {
-			cmd.showRoute(route, ifaceNames[idx])
+			cmd.showRoute(route, attrs[idx], ifaceNames[idx])
 		}
 	}
 	return nil
 }
 
-func (cmd *cmd) filteredRouteList(route *netlink.Route, filterMask uint64, root, match, exact *net.IPNet) ([]netlink.Route, []string, error) {
+// filteredRouteList returns the selected routes, their attributes, see
+// routeList, and the names of their devices.
+func (cmd *cmd) filteredRouteList(route *netlink.Route, filterMask uint64, root, match, exact, from *net.IPNet) ([]netlink.Route, []routeAttrs, []string, error) {
 	var matchedRoutes []netlink.Route
 	var ifaceNames []string
 
-	routes, err := netlink.RouteListFiltered(cmd.Family, route, filterMask)
+	routes, attrs, err := cmd.routeList(cmd.Family)
 	if err != nil {
-		return matchedRoutes, nil, err
+		return matchedRoutes, nil, nil, err
 	}
+	routes, attrs = filterRoutes(routes, attrs, route, filterMask)
 
 	if root == nil && match == nil && exact == nil {
 		matchedRoutes = routes
 	} else {
-		matchedRoutes, err = matchRoutes(routes, root, match, exact)
+		matchedRoutes, attrs, err = matchRoutes(routes, attrs, root, match, exact)
 		if err != nil {
-			return matchedRoutes, nil, err
+			return matchedRoutes, nil, nil, err
 		}
 	}
+	if from != nil {
+		matchedRoutes, attrs = matchRoutesFrom(matchedRoutes, attrs, from)
+	}
 
 	for _, route := range matchedRoutes {
-		link, err := cmd.handle.LinkByIndex(route.LinkIndex)
+		name, err := cmd.devName(route.LinkIndex)
 		if err != nil {
-			return matchedRoutes, nil, err
+			return matchedRoutes, nil, nil, err
+		}
+
+		ifaceNames = append(ifaceNames, name)
+	}
+
+	return matchedRoutes, attrs, ifaceNames, nil
+}
+
+// filterRoutes selects the routes, with their attributes attrs, that pass
+// filter in the fields of filterMask, as netlink.RouteListFiltered does. Only
+// routes of the main table pass, unless filterMask selects a table; table 0
+// selects all.
+func filterRoutes(routes []netlink.Route, attrs []routeAttrs, filter *netlink.Route, filterMask uint64) ([]netlink.Route, []routeAttrs) {
+	var filtered []netlink.Route
+	var filteredAttrs []routeAttrs
+	for i, r := range routes {
+		if filter == nil || filterMask&netlink.RT_FILTER_TABLE == 0 {
+			if r.Table != unix.RT_TABLE_MAIN {
+				continue
+			}
+		} else if filter.Table != unix.RT_TABLE_UNSPEC && r.Table != filter.Table {
+			continue
+		}
+		if filter != nil {
+			switch {
+			case filterMask&netlink.RT_FILTER_PROTOCOL != 0 && r.Protocol != filter.Protocol:
+				continue
+			case filterMask&netlink.RT_FILTER_SCOPE != 0 && r.Scope != filter.Scope:
+				continue
+			case filterMask&netlink.RT_FILTER_TYPE != 0 && r.Type != filter.Type:
+				continue
+			case filterMask&netlink.RT_FILTER_OIF != 0 && r.LinkIndex != filter.LinkIndex:
+				continue
+			case filterMask&netlink.RT_FILTER_IIF != 0 && r.ILinkIndex != filter.ILinkIndex:
+				continue
+			}
 		}
+		filtered = append(filtered, r)
+		filteredAttrs = append(filteredAttrs, attrs[i])
+	}
+	return filtered, filteredAttrs
+}
 
-		ifaceNames = append(ifaceNames, link.Attrs().Name)
+// parseRoutePrefix parses a route selector prefix. A plain address selects
+// the host prefix.
+func parseRoutePrefix(token string) (*net.IPNet, error) {
+	if ip := net.ParseIP(token); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
 	}
+	_, prefix, err := net.ParseCIDR(token)
+	return prefix, err
+}
 
-	return matchedRoutes, ifaceNames, nil
+// routeDst returns the destination prefix of a route. A nil destination is
+// the default route of the route's family.
+func routeDst(route netlink.Route) *net.IPNet {
+	if route.Dst != nil {
+		return route.Dst
+	}
+	if route.Family == netlink.FAMILY_V6 || (route.Gw != nil && route.Gw.To4() == nil) {
+		return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+	}
+	return &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
 }
 
-// matchRoutes matches routes against a given prefix.
-func matchRoutes(routes []netlink.Route, root, match, exact *net.IPNet) ([]netlink.Route, error) {
-	matchedRoutes := []netlink.Route{}
+// containsPrefix reports whether the prefix outer covers all of inner. Both
+// must be of the same family.
+func containsPrefix(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	if outerBits != innerBits || outerOnes > innerOnes {
+		return false
+	}
+	return outer.Contains(inner.IP)
+}
 
-	for _, route := range routes {
-		if root != nil && !root.Contains(route.Dst.IP) {
+// matchRoutes matches routes against the given prefixes, following iproute2:
+// root selects routes inside the prefix, match selects routes covering the
+// prefix, and exact selects the prefix itself. Routes selected by match are
+// ordered longest prefix first, so the route the kernel would pick comes
+// first. The attributes attrs of the routes are selected alike.
+func matchRoutes(routes []netlink.Route, attrs []routeAttrs, root, match, exact *net.IPNet) ([]netlink.Route, []routeAttrs, error) {
+	matched := []int{}
+
+	for i, route := range routes {
+		dst := routeDst(route)
+
+		if root != nil && !containsPrefix(root, dst) {
 			continue
 		}
 
-		if match != nil && !match.Contains(route.Dst.IP) {
+		if match != nil && !containsPrefix(dst, match) {
 			continue
 		}
 
-		if exact != nil && !exact.IP.Equal(route.Dst.IP) {
+		if exact != nil && !(containsPrefix(exact, dst) && containsPrefix(dst, exact)) {
 			continue
 		}
 
-		matchedRoutes = append(matchedRoutes, route)
+		matched = append(matched, i)
 	}
 
-	return matchedRoutes, nil
+	if match != nil {
+		sort.SliceStable(matched, func(i, j int) bool {
+			iOnes, _ := routeDst(routes[matched[i]]).Mask.Size()
+			jOnes, _ := routeDst(routes[matched[j]]).Mask.Size()
+			return iOnes > jOnes
+		})
+	}
+
+	matchedRoutes := make([]netlink.Route, 0, len(matched))
+	matchedAttrs := make([]routeAttrs, 0, len(matched))
+	for _, i := range matched {
+		matchedRoutes = append(matchedRoutes, routes[i])
+		matchedAttrs = append(matchedAttrs, attrs[i])
+	}
+	return matchedRoutes, matchedAttrs, nil
+}
+
+// matchRoutesFrom selects the routes whose source prefix is inside from, as
+// iproute2 does. Routes that are not source-specific only match a
+// zero-length from.
+func matchRoutesFrom(routes []netlink.Route, attrs []routeAttrs, from *net.IPNet) ([]netlink.Route, []routeAttrs) {
+	var matchedRoutes []netlink.Route
+	var matchedAttrs []routeAttrs
+	for i, r := range routes {
+		if containsPrefix(from, routeSrc(r, attrs[i])) {
+			matchedRoutes = append(matchedRoutes, r)
+			matchedAttrs = append(matchedAttrs, attrs[i])
+		}
+	}
+	return matchedRoutes, matchedAttrs
 }
 
 func (cmd *cmd) showRoutesForAddress(addr net.IP, options *netlink.RouteGetOptions) error {
@@ -567,14 +1379,14 @@ func (cmd *cmd) showRoutesForAddress(addr net.IP, options *netlink.RouteGetOptio
 	}
 
 	for _, route := range routes {
-		link, err := cmd.handle.LinkByIndex(route.LinkIndex)
+		name, err := cmd.devName(route.LinkIndex)
 		if err != nil {
 			return err
 		}
 		if route.Dst == nil {
-			cmd.defaultRoute(route, link.Attrs().Name)
+			cmd.defaultRoute(route, routeAttrs{}, name)
 		} else {
-			cmd.showRoute(route, link.Attrs().Name)
+			cmd.showRoute(route, routeAttrs{}, name)
 		}
 	}
 	return nil
@@ -608,13 +1420,13 @@ var rtProto = map[int]string{
 }
 
 const (
-	defaultFmt   = "%vdefault via %v dev %s proto %s metric %d\n"
-	routeFmt     = "%v%v dev %s proto %s scope %s src %s metric %d\n"
-	route6Fmt    = "%v%s dev %s proto %s metric %d\n"
-	routeVia6Fmt = "%v%s via %s dev %s proto %s metric %d\n"
+	defaultFmt   = "%vdefault%s via %v dev %s proto %s metric %d%s\n"
+	routeFmt     = "%v%v dev %s proto %s scope %s src %s metric %d%s\n"
+	route6Fmt    = "%v%s dev %s proto %s metric %d%s\n"
+	routeVia6Fmt = "%v%s via %s dev %s proto %s metric %d%s\n"
 )
 
-func (cmd *cmd) defaultRoute(r netlink.Route, name string) {
+func (cmd *cmd) defaultRoute(r netlink.Route, a routeAttrs, name string) {
 	gw := r.Gw
 
 	var proto string
@@ -627,16 +1439,21 @@ func (cmd *cmd) defaultRoute(r netlink.Route, name string) {
 
 	metric := r.Priority
 
-	var detail string
+	detail := cmd.routeTypePrefix(r)
 
-	if cmd.Opts.Details {
-		detail = routeTypeToString(r.Type) + " "
-	}
+	fmt.Fprintf(cmd.Out, defaultFmt, detail, a.nhidText(), gw, name+tableText(r), proto, metric, a.expiresText())
+}
 
-	fmt.Fprintf(cmd.Out, defaultFmt, detail, gw, name, proto, metric)
+// tableText returns " table NAME" for a route outside the main table, or "",
+// to follow the device as in iproute2.
+func tableText(r netlink.Route) string {
+	if r.Table == unix.RT_TABLE_UNSPEC || r.Table == unix.RT_TABLE_MAIN {
+		return ""
+	}
+	return " table " + tableName(uint32(r.Table))
 }
 
-func (cmd *cmd) showRoute(r netlink.Route, name string) {
+func (cmd *cmd) showRoute(r netlink.Route, a routeAttrs, name string) {
 	switch cmd.Family {
 	// print only ipv4 per default
 	case netlink.FAMILY_ALL, netlink.FAMILY_V4:
@@ -644,19 +1461,19 @@ func (cmd *cmd) showRoute(r netlink.Route, name string) {
 			return
 		}
 
-		cmd.printIPv4Route(r, name)
+		cmd.printIPv4Route(r, a, name)
 
 	case netlink.FAMILY_V6:
 		if r.Dst.IP.To4() != nil {
 			return
 		}
 
-		cmd.printIPv6Route(r, name)
+		cmd.printIPv6Route(r, a, name)
 	}
 }
 
-func (cmd *cmd) printIPv4Route(r netlink.Route, name string) {
-	dest := r.Dst.String()
+func (cmd *cmd) printIPv4Route(r netlink.Route, a routeAttrs, name string) {
+	dest := r.Dst.String() + a.fromText() + a.nhidText()
 
 	var proto, scope string
 
@@ -671,17 +1488,13 @@ func (cmd *cmd) printIPv4Route(r netlink.Route, name string) {
 	src := r.Src
 	metric := r.Priority
 
-	var detail string
+	detail := cmd.routeTypePrefix(r)
 
-	if cmd.Opts.Details {
-		detail = routeTypeToString(r.Type) + " "
-	}
-
-	fmt.Fprintf(cmd.Out, routeFmt, detail, dest, name, proto, scope, src, metric)
+	fmt.Fprintf(cmd.Out, routeFmt, detail, dest, name+tableText(r), proto, scope, src, metric, a.expiresText())
 }
 
-func (cmd *cmd) printIPv6Route(r netlink.Route, name string) {
-	dest := r.Dst
+func (cmd *cmd) printIPv6Route(r netlink.Route, a routeAttrs, name string) {
+	dest := r.Dst.String() + a.fromText() + a.nhidText()
 
 	var proto string
 
@@ -693,17 +1506,13 @@ func (cmd *cmd) printIPv6Route(r netlink.Route, name string) {
 
 	metric := r.Priority
 
-	var detail string
-
-	if cmd.Opts.Details {
-		detail = routeTypeToString(r.Type) + " "
-	}
+	detail := cmd.routeTypePrefix(r)
 
 	if r.Gw != nil {
 		gw := r.Gw
-		fmt.Fprintf(cmd.Out, routeVia6Fmt, detail, dest, gw, name, proto, metric)
+		fmt.Fprintf(cmd.Out, routeVia6Fmt, detail, dest, gw, name+tableText(r), proto, metric, a.expiresText())
 	} else {
-		fmt.Fprintf(cmd.Out, route6Fmt, detail, dest, name, proto, metric)
+		fmt.Fprintf(cmd.Out, route6Fmt, detail, dest, name+tableText(r), proto, metric, a.expiresText())
 	}
 }
 
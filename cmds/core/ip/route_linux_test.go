@@ -7,12 +7,16 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
 	"golang.org/x/sys/unix"
 )
 
@@ -24,6 +28,7 @@ func TestRouteTypeToString(t *testing.T) {
 		{1, "unicast"},
 		{2, "local"},
 		{3, "broadcast"},
+		{4, "anycast"},
 		{5, "multicast"},
 		{6, "blackhole"},
 		{7, "unreachable"},
@@ -68,7 +73,8 @@ func TestParseRouteAddAppendReplaceDel(t *testing.T) {
 			args:         []string{"dev", "lo"},
 			expectedLink: "lo",
 			expected: netlink.Route{
-				Dst: dst,
+				Dst:   dst,
+				Scope: netlink.SCOPE_LINK,
 			},
 			wantErr: false,
 		},
@@ -111,10 +117,38 @@ func TestParseRouteAddAppendReplaceDel(t *testing.T) {
 			expectedLink: "lo",
 			expected: netlink.Route{
 				Dst:      dst,
+				Scope:    netlink.SCOPE_LINK,
 				QuickACK: 0,
 			},
 			wantErr: false,
 		},
+		{
+			name:         "via before dev",
+			addr:         "192.0.0.2/24",
+			args:         []string{"via", "10.1.0.1", "dev", "lo", "onlink"},
+			expectedLink: "lo",
+			expected: netlink.Route{
+				Dst:   dst,
+				Gw:    net.ParseIP("10.1.0.1"),
+				Flags: int(netlink.FLAG_ONLINK),
+			},
+		},
+		{
+			name:         "via after dev",
+			addr:         "192.0.0.2/24",
+			args:         []string{"dev", "lo", "via", "10.1.0.1"},
+			expectedLink: "lo",
+			expected: netlink.Route{
+				Dst: dst,
+				Gw:  net.ParseIP("10.1.0.1"),
+			},
+		},
+		{
+			name:    "via invalid",
+			addr:    "192.0.0.2/24",
+			args:    []string{"via", "ac", "dev", "lo"},
+			wantErr: true,
+		},
 		{
 			name:         "fastopen_no_cookie 0",
 			addr:         "192.0.0.2/24",
@@ -122,6 +156,7 @@ func TestParseRouteAddAppendReplaceDel(t *testing.T) {
 			expectedLink: "lo",
 			expected: netlink.Route{
 				Dst:              dst,
+				Scope:            netlink.SCOPE_LINK,
 				FastOpenNoCookie: 0,
 			},
 			wantErr: false,
@@ -264,6 +299,68 @@ func TestParseRouteAddAppendReplaceDel(t *testing.T) {
 			args:    []string{"dev", "lo", "tos", "ac"},
 			wantErr: true,
 		},
+		{
+			name:         "local",
+			addr:         "local",
+			args:         []string{"10.0.0.5", "dev", "lo"},
+			expectedLink: "lo",
+			expected: netlink.Route{
+				Dst:   &net.IPNet{IP: net.IPv4(10, 0, 0, 5).To4(), Mask: net.CIDRMask(32, 32)},
+				Type:  unix.RTN_LOCAL,
+				Scope: netlink.SCOPE_HOST,
+				Table: unix.RT_TABLE_LOCAL,
+			},
+		},
+		{
+			name:         "broadcast with scope and table",
+			addr:         "broadcast",
+			args:         []string{"10.0.0.255", "dev", "lo", "scope", "0", "table", "1"},
+			expectedLink: "lo",
+			expected: netlink.Route{
+				Dst:   &net.IPNet{IP: net.IPv4(10, 0, 0, 255).To4(), Mask: net.CIDRMask(32, 32)},
+				Type:  unix.RTN_BROADCAST,
+				Table: 1,
+			},
+		},
+		{
+			name:         "table name",
+			addr:         "10.0.0.0/24",
+			args:         []string{"dev", "lo", "table", "local"},
+			expectedLink: "lo",
+			expected: netlink.Route{
+				Dst:   &net.IPNet{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(24, 32)},
+				Scope: netlink.SCOPE_LINK,
+				Table: unix.RT_TABLE_LOCAL,
+			},
+		},
+		{
+			name:         "anycast",
+			addr:         "anycast",
+			args:         []string{"2001:db8::/64", "dev", "lo"},
+			expectedLink: "lo",
+			expected: netlink.Route{
+				Dst:   &net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(64, 128)},
+				Type:  unix.RTN_ANYCAST,
+				Table: unix.RT_TABLE_LOCAL,
+			},
+		},
+		{
+			name:         "multicast stays in main",
+			addr:         "multicast",
+			args:         []string{"224.0.0.0/4", "dev", "lo"},
+			expectedLink: "lo",
+			expected: netlink.Route{
+				Dst:   &net.IPNet{IP: net.IPv4(224, 0, 0, 0).To4(), Mask: net.CIDRMask(4, 32)},
+				Type:  unix.RTN_MULTICAST,
+				Scope: netlink.SCOPE_LINK,
+			},
+		},
+		{
+			name:    "type without prefix",
+			addr:    "local",
+			args:    []string{"dev", "lo"},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -274,7 +371,7 @@ func TestParseRouteAddAppendReplaceDel(t *testing.T) {
 				Args:   tt.args,
 				Out:    &out,
 			}
-			route, link, err := cmd.parseRouteAddAppendReplaceDel(tt.addr)
+			route, link, _, err := cmd.parseRouteAddAppendReplaceDel(tt.addr, false)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseRouteAddAppendReplaceDel() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -292,6 +389,59 @@ func TestParseRouteAddAppendReplaceDel(t *testing.T) {
 	}
 }
 
+func TestRouteScopeInference(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ns   string
+		args []string
+		del  bool
+		want netlink.Scope
+	}{
+		{name: "dev", ns: "10.0.0.0/24", args: []string{"dev", "eth0"}, want: netlink.SCOPE_LINK},
+		{name: "via", ns: "10.0.0.0/24", args: []string{"via", "192.168.1.1", "dev", "eth0"}, want: netlink.SCOPE_UNIVERSE},
+		{name: "explicit scope", ns: "10.0.0.0/24", args: []string{"dev", "eth0", "scope", "0"}, want: netlink.SCOPE_UNIVERSE},
+		{name: "unicast", ns: "unicast", args: []string{"10.0.0.0/24", "dev", "eth0"}, want: netlink.SCOPE_LINK},
+		{name: "local", ns: "local", args: []string{"127.0.0.2", "dev", "lo"}, want: netlink.SCOPE_HOST},
+		{name: "blackhole", ns: "blackhole", args: []string{"10.0.0.0/24", "dev", "lo"}, want: netlink.SCOPE_UNIVERSE},
+		{name: "IPv6", ns: "2001:db8::/64", args: []string{"dev", "eth0"}, want: netlink.SCOPE_UNIVERSE},
+		{name: "IPv6 local", ns: "local", args: []string{"2001:db8::1", "dev", "lo"}, want: netlink.SCOPE_UNIVERSE},
+		{name: "IPv6 anycast", ns: "anycast", args: []string{"2001:db8::/64", "dev", "eth0"}, want: netlink.SCOPE_UNIVERSE},
+		{name: "IPv6 multicast", ns: "multicast", args: []string{"ff00::/8", "dev", "eth0"}, want: netlink.SCOPE_UNIVERSE},
+		{name: "anycast", ns: "anycast", args: []string{"10.0.0.0/24", "dev", "eth0"}, want: netlink.SCOPE_LINK},
+		{name: "del", ns: "10.0.0.0/24", args: []string{"dev", "eth0"}, del: true, want: netlink.SCOPE_NOWHERE},
+		{name: "del via", ns: "10.0.0.0/24", args: []string{"via", "192.168.1.1", "dev", "eth0"}, del: true, want: netlink.SCOPE_NOWHERE},
+		{name: "del with scope", ns: "10.0.0.0/24", args: []string{"dev", "eth0", "scope", "253"}, del: true, want: netlink.SCOPE_LINK},
+		{name: "scope name", ns: "10.0.0.0/24", args: []string{"dev", "eth0", "scope", "host"}, want: netlink.SCOPE_HOST},
+		{name: "del local", ns: "local", args: []string{"127.0.0.2", "dev", "lo"}, del: true, want: netlink.SCOPE_HOST},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := cmd{Cursor: -1, Args: tt.args, Out: new(bytes.Buffer)}
+			route, _, _, err := cmd.parseRouteAddAppendReplaceDel(tt.ns, tt.del)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if route.Scope != tt.want {
+				t.Errorf("parseRouteAddAppendReplaceDel(%q, %t) scope = %v, want %v", tt.ns, tt.del, route.Scope, tt.want)
+			}
+		})
+	}
+
+	// The inferred scope is what the routes are shown with.
+	var out bytes.Buffer
+	cmd := cmd{Cursor: -1, Args: []string{"dev", "eth0"}, Out: &out}
+	route, _, _, err := cmd.parseRouteAddAppendReplaceDel("10.0.0.0/24", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.Src = net.ParseIP("10.0.0.2")
+	if err := cmd.showRoutes([]netlink.Route{*route}, []routeAttrs{{}}, []string{"eth0"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "10.0.0.0/24 dev eth0 proto unspec scope link src 10.0.0.2 metric 0\n"; out.String() != want {
+		t.Errorf("showRoutes() = %q, want %q", &out, want)
+	}
+}
+
 func TestParseRouteShowListFlush(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -301,6 +451,7 @@ func TestParseRouteShowListFlush(t *testing.T) {
 		wantRoot   *net.IPNet
 		wantMatch  *net.IPNet
 		wantExact  *net.IPNet
+		wantFrom   *net.IPNet
 		wantErr    bool
 	}{
 		{
@@ -375,6 +526,73 @@ func TestParseRouteShowListFlush(t *testing.T) {
 			args:    []string{"exact", "invalid_prefix"},
 			wantErr: true,
 		},
+		{
+			name:       "Interfaces",
+			args:       []string{"iif", "eth1", "oif", "eth0"},
+			wantFilter: &netlink.Route{ILinkIndex: 3, LinkIndex: 2},
+			wantMask:   netlink.RT_FILTER_IIF | netlink.RT_FILTER_OIF,
+		},
+		{
+			name:       "Dev is oif",
+			args:       []string{"dev", "eth0"},
+			wantFilter: &netlink.Route{LinkIndex: 2},
+			wantMask:   netlink.RT_FILTER_OIF,
+		},
+		{
+			name:       "Table name",
+			args:       []string{"table", "local"},
+			wantFilter: &netlink.Route{Table: unix.RT_TABLE_LOCAL},
+			wantMask:   netlink.RT_FILTER_TABLE,
+		},
+		{
+			name:       "All tables",
+			args:       []string{"table", "all"},
+			wantFilter: &netlink.Route{},
+			wantMask:   netlink.RT_FILTER_TABLE,
+		},
+		{
+			name:       "Type selects all tables",
+			args:       []string{"type", "local"},
+			wantFilter: &netlink.Route{Type: unix.RTN_LOCAL},
+			wantMask:   netlink.RT_FILTER_TYPE | netlink.RT_FILTER_TABLE,
+		},
+		{
+			name:    "Missing device",
+			args:    []string{"oif", "eth9"},
+			wantErr: true,
+		},
+		{
+			name:       "From prefix",
+			args:       []string{"from", "2001:db8::/32"},
+			wantFilter: &netlink.Route{},
+			wantFrom: &net.IPNet{
+				IP:   net.ParseIP("2001:db8::"),
+				Mask: net.CIDRMask(32, 128),
+			},
+		},
+		{
+			name:    "Invalid from prefix",
+			args:    []string{"from", "invalid_prefix"},
+			wantErr: true,
+		},
+		{
+			name:       "Bare prefix is exact",
+			args:       []string{"10.1.2.0/24"},
+			wantFilter: &netlink.Route{},
+			wantExact: &net.IPNet{
+				IP:   net.IPv4(10, 1, 2, 0),
+				Mask: net.CIDRMask(24, 32),
+			},
+		},
+		{
+			name:       "Match address",
+			args:       []string{"match", "10.1.2.3"},
+			wantFilter: &netlink.Route{},
+			wantMatch: &net.IPNet{
+				IP:   net.IPv4(10, 1, 2, 3),
+				Mask: net.CIDRMask(32, 32),
+			},
+		},
 		{
 			name:       "Valid exact prefix",
 			args:       []string{"exact", "172.16.0.0/12"},
@@ -392,8 +610,12 @@ func TestParseRouteShowListFlush(t *testing.T) {
 			cmd := cmd{
 				Cursor: -1,
 				Args:   tt.args,
+				linkCache: newLinkCache([]netlink.Link{
+					&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}},
+					&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", Index: 3}},
+				}),
 			}
-			gotFilter, gotMask, gotRoot, gotMatch, gotExact, err := cmd.parseRouteShowListFlush()
+			gotFilter, gotMask, gotRoot, gotMatch, gotExact, gotFrom, err := cmd.parseRouteShowListFlush()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseRouteShowListFlush() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -414,6 +636,9 @@ func TestParseRouteShowListFlush(t *testing.T) {
 				if gotExact != nil && tt.wantExact != nil && !gotExact.IP.Equal(tt.wantExact.IP) {
 					t.Errorf("parseRouteShowListFlush() exact = %v, want %v", gotExact, tt.wantExact)
 				}
+				if gotFrom.String() != tt.wantFrom.String() {
+					t.Errorf("parseRouteShowListFlush() from = %v, want %v", gotFrom, tt.wantFrom)
+				}
 			}
 		})
 	}
@@ -487,11 +712,79 @@ func TestMatchRoutes(t *testing.T) {
 			want:    []netlink.Route{},
 			wantErr: false,
 		},
+		{
+			name: "Match covering prefixes longest first",
+			routes: []netlink.Route{
+				{Family: netlink.FAMILY_V4},
+				{Dst: &net.IPNet{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+				{Dst: &net.IPNet{IP: net.IPv4(10, 1, 2, 0), Mask: net.CIDRMask(24, 32)}},
+				{Dst: &net.IPNet{IP: net.IPv4(10, 1, 2, 128), Mask: net.CIDRMask(25, 32)}},
+				{Dst: &net.IPNet{IP: net.IPv4(10, 1, 0, 0), Mask: net.CIDRMask(16, 32)}},
+				{Dst: &net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)}},
+			},
+			match: &net.IPNet{
+				IP:   net.IPv4(10, 1, 2, 0),
+				Mask: net.CIDRMask(24, 32),
+			},
+			want: []netlink.Route{
+				{Dst: &net.IPNet{IP: net.IPv4(10, 1, 2, 0), Mask: net.CIDRMask(24, 32)}},
+				{Dst: &net.IPNet{IP: net.IPv4(10, 1, 0, 0), Mask: net.CIDRMask(16, 32)}},
+				{Dst: &net.IPNet{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+				{Dst: &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}},
+			},
+		},
+		{
+			name: "Exact does not match covering prefix",
+			routes: []netlink.Route{
+				{Dst: &net.IPNet{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+				{Dst: &net.IPNet{IP: net.IPv4(10, 1, 2, 0), Mask: net.CIDRMask(24, 32)}},
+			},
+			exact: &net.IPNet{
+				IP:   net.IPv4(10, 1, 2, 0),
+				Mask: net.CIDRMask(24, 32),
+			},
+			want: []netlink.Route{
+				{Dst: &net.IPNet{IP: net.IPv4(10, 1, 2, 0), Mask: net.CIDRMask(24, 32)}},
+			},
+		},
+		{
+			name: "Match IPv6",
+			routes: []netlink.Route{
+				{Family: netlink.FAMILY_V6},
+				{Family: netlink.FAMILY_V4},
+				{Dst: &net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)}},
+				{Dst: &net.IPNet{IP: net.ParseIP("2001:db8:1::"), Mask: net.CIDRMask(48, 128)}},
+			},
+			match: &net.IPNet{
+				IP:   net.ParseIP("2001:db8::1"),
+				Mask: net.CIDRMask(128, 128),
+			},
+			want: []netlink.Route{
+				{Dst: &net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)}},
+				{Dst: &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}},
+			},
+		},
+		{
+			name: "Root IPv6",
+			routes: []netlink.Route{
+				{Family: netlink.FAMILY_V6},
+				{Dst: &net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)}},
+				{Dst: &net.IPNet{IP: net.ParseIP("2001:db8:1::"), Mask: net.CIDRMask(48, 128)}},
+			},
+			root: &net.IPNet{
+				IP:   net.ParseIP("2001:db8::"),
+				Mask: net.CIDRMask(32, 128),
+			},
+			want: []netlink.Route{
+				{Dst: &net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)}},
+				{Dst: &net.IPNet{IP: net.ParseIP("2001:db8:1::"), Mask: net.CIDRMask(48, 128)}},
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := matchRoutes(tt.routes, tt.root, tt.match, tt.exact)
+			got, _, err := matchRoutes(tt.routes, make([]routeAttrs, len(tt.routes)), tt.root, tt.match, tt.exact)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("matchRoutes() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -501,7 +794,8 @@ func TestMatchRoutes(t *testing.T) {
 				return
 			}
 			for i := range got {
-				if !got[i].Dst.IP.Equal(tt.want[i].Dst.IP) || got[i].Dst.Mask.String() != tt.want[i].Dst.Mask.String() {
+				dst := routeDst(got[i])
+				if !dst.IP.Equal(tt.want[i].Dst.IP) || dst.Mask.String() != tt.want[i].Dst.Mask.String() {
 					t.Errorf("matchRoutes() = %v, want %v", got, tt.want)
 				}
 			}
@@ -509,6 +803,57 @@ func TestMatchRoutes(t *testing.T) {
 	}
 }
 
+func TestFilterRoutes(t *testing.T) {
+	routes := []netlink.Route{
+		{Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST, Priority: 1},
+		{Table: unix.RT_TABLE_LOCAL, Type: unix.RTN_LOCAL, Priority: 2},
+		{Table: 100, Type: unix.RTN_UNICAST, Priority: 3},
+		{Table: unix.RT_TABLE_LOCAL, Type: unix.RTN_BROADCAST, Priority: 4},
+	}
+	attrs := []routeAttrs{{nhid: 1}, {nhid: 2}, {nhid: 3}, {nhid: 4}}
+	for _, tt := range []struct {
+		name string
+		args []string
+		want []int
+	}{
+		{name: "Main table", want: []int{1}},
+		{name: "All tables", args: []string{"table", "all"}, want: []int{1, 2, 3, 4}},
+		{name: "Table name", args: []string{"table", "local"}, want: []int{2, 4}},
+		{name: "Table number", args: []string{"table", "100"}, want: []int{3}},
+		{name: "Type in all tables", args: []string{"type", "local"}, want: []int{2}},
+		{name: "Type in table", args: []string{"type", "unicast", "table", "main"}, want: []int{1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := cmd{Cursor: -1, Args: tt.args}
+			filter, mask, _, _, _, _, err := cmd.parseRouteShowListFlush()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, gotAttrs := filterRoutes(routes, attrs, filter, mask)
+			var metrics []int
+			for i, r := range got {
+				if gotAttrs[i].nhid != uint32(r.Priority) {
+					t.Errorf("route with metric %d has nhid %d", r.Priority, gotAttrs[i].nhid)
+				}
+				metrics = append(metrics, r.Priority)
+			}
+			if !cmp.Equal(metrics, tt.want) {
+				t.Errorf("filterRoutes() metrics = %v, want %v", metrics, tt.want)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	cmd := cmd{Out: &out}
+	local := netlink.Route{Table: unix.RT_TABLE_LOCAL, Type: unix.RTN_LOCAL, Dst: &net.IPNet{IP: net.IPv4(10, 0, 0, 1).To4(), Mask: net.CIDRMask(32, 32)}, Protocol: unix.RTPROT_KERNEL, Scope: netlink.SCOPE_HOST, Src: net.IPv4(10, 0, 0, 1)}
+	if err := cmd.showRoutes([]netlink.Route{local}, []routeAttrs{{}}, []string{"eth0"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "local 10.0.0.1/32 dev eth0 table local proto kernel scope host src 10.0.0.1 metric 0\n"; out.String() != want {
+		t.Errorf("showRoutes() = %q, want %q", &out, want)
+	}
+}
+
 func TestDefaultRoute(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -568,7 +913,7 @@ func TestDefaultRoute(t *testing.T) {
 			var out bytes.Buffer
 			tt.cmd.Out = &out
 
-			tt.cmd.defaultRoute(tt.route, tt.linkName)
+			tt.cmd.defaultRoute(tt.route, routeAttrs{}, tt.linkName)
 			if got := out.String(); got != tt.expected {
 				t.Errorf("defaultRoute() = %v, want %v", got, tt.expected)
 			}
@@ -740,7 +1085,7 @@ func TestShowRoute(t *testing.T) {
 		var out bytes.Buffer
 		t.Run(tt.name, func(t *testing.T) {
 			tt.cmd.Out = &out
-			tt.cmd.showRoute(tt.route, tt.linkName)
+			tt.cmd.showRoute(tt.route, routeAttrs{}, tt.linkName)
 			if got := out.String(); got != tt.expected {
 				t.Errorf("showRoute() = %v, want %v", got, tt.expected)
 			}
@@ -849,6 +1194,47 @@ func TestShowRoutes(t *testing.T) {
 `,
 			wantErr: false,
 		},
+		{
+			name: "local route",
+			routes: []netlink.Route{
+				{
+					Dst:      &net.IPNet{IP: net.IPv4(10, 0, 0, 5).To4(), Mask: net.CIDRMask(32, 32)},
+					Type:     unix.RTN_LOCAL,
+					Scope:    netlink.SCOPE_HOST,
+					Protocol: 2,
+					Src:      net.ParseIP("10.0.0.5"),
+				},
+			},
+			ifaceNames: []string{"eth0"},
+			wantOutput: "local 10.0.0.5/32 dev eth0 proto kernel scope host src 10.0.0.5 metric 0\n",
+		},
+		{
+			name: "JSON local route",
+			opts: flags{JSON: true},
+			routes: []netlink.Route{
+				{
+					Dst:      &net.IPNet{IP: net.IPv4(10, 0, 0, 5).To4(), Mask: net.CIDRMask(32, 32)},
+					Type:     unix.RTN_LOCAL,
+					Scope:    netlink.SCOPE_HOST,
+					Protocol: 2,
+				},
+			},
+			ifaceNames: []string{"eth0"},
+			wantOutput: `[{"type":"local","dst":"10.0.0.5/32","dev":"eth0","protocol":"kernel","scope":"host","prefsrc":""}]`,
+		},
+		{
+			name: "JSON unicast route with details",
+			opts: flags{JSON: true, Details: true},
+			routes: []netlink.Route{
+				{
+					Dst:      &net.IPNet{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)},
+					Type:     unix.RTN_UNICAST,
+					Protocol: 2,
+				},
+			},
+			ifaceNames: []string{"eth0"},
+			wantOutput: `[{"type":"unicast","dst":"10.0.0.0/8","dev":"eth0","protocol":"kernel","scope":"universe","prefsrc":""}]`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -859,7 +1245,7 @@ func TestShowRoutes(t *testing.T) {
 				Out:  &out,
 			}
 
-			err := cmd.showRoutes(tt.routes, tt.ifaceNames)
+			err := cmd.showRoutes(tt.routes, make([]routeAttrs, len(tt.routes)), tt.ifaceNames)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("showRoutes() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -870,3 +1256,325 @@ func TestShowRoutes(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRouteNhid(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("10.1.0.0/16")
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		want    nhidRoute
+		wantErr bool
+	}{
+		{
+			name: "Prefix",
+			args: []string{"ip", "route", "add", "10.1.0.0/16", "nhid", "5", "table", "100", "metric", "20"},
+			want: nhidRoute{family: netlink.FAMILY_V4, dst: dst, nhid: 5, table: 100, metric: 20},
+		},
+		{
+			name: "Default",
+			args: []string{"ip", "route", "add", "default", "nhid", "5"},
+			want: nhidRoute{family: netlink.FAMILY_V4, nhid: 5},
+		},
+		{
+			name:    "Zero nhid",
+			args:    []string{"ip", "route", "add", "default", "nhid", "0"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := cmd{Cursor: 3, Args: tt.args, Out: new(bytes.Buffer)}
+			got, err := cmd.parseRouteNhid(tt.args[3])
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRouteNhid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if c := cmp.Diff(tt.want, got, cmp.AllowUnexported(nhidRoute{})); c != "" {
+				t.Errorf("parseRouteNhid() diff:\n%v", c)
+			}
+		})
+	}
+}
+
+func TestShowRoutesNhid(t *testing.T) {
+	msg := nl.NewRtMsg()
+	msg.Family, msg.Dst_len = netlink.FAMILY_V4, 16
+	b := msg.Serialize()
+	for _, attr := range []*nl.RtAttr{
+		nl.NewRtAttr(unix.RTA_DST, net.IPv4(10, 1, 0, 0).To4()),
+		nl.NewRtAttr(rtaNhID, []byte{5, 0, 0, 0}),
+	} {
+		b = append(b, attr.Serialize()...)
+	}
+	k, nhid, ok := parseRouteNhid(b)
+	if !ok || nhid != 5 {
+		t.Fatalf("parseRouteNhid() = %v, %d, %v, want nhid 5", k, nhid, ok)
+	}
+
+	route := netlink.Route{
+		Family:   netlink.FAMILY_V4,
+		Table:    unix.RT_TABLE_MAIN,
+		Dst:      &net.IPNet{IP: net.IPv4(10, 1, 0, 0).To4(), Mask: net.CIDRMask(16, 32)},
+		Protocol: unix.RTPROT_BOOT,
+		Src:      net.ParseIP("10.0.0.2"),
+	}
+	var out bytes.Buffer
+	cmd := cmd{Out: &out}
+	if err := cmd.showRoutes([]netlink.Route{route}, []routeAttrs{{nhid: nhid}}, []string{"eth0"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "10.1.0.0/16 nhid 5 dev eth0 proto boot scope global src 10.0.0.2 metric 0\n"
+	if got := out.String(); got != want {
+		t.Errorf("showRoutes() = %q, want %q", got, want)
+	}
+}
+
+func TestShowRoutesFrom(t *testing.T) {
+	dst := &net.IPNet{IP: net.ParseIP("2001:db8:2::"), Mask: net.CIDRMask(64, 128)}
+	srcs := []*net.IPNet{
+		{IP: net.ParseIP("2001:db8:1::"), Mask: net.CIDRMask(48, 128)},
+		{IP: net.ParseIP("2001:db8:3::"), Mask: net.CIDRMask(48, 128)},
+	}
+	cmd := cmd{
+		Family:    netlink.FAMILY_V6,
+		linkCache: newLinkCache([]netlink.Link{&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", Index: 3}}}),
+	}
+	// Two routes that differ only in their source prefix, as dumped.
+	var msgs [][]byte
+	for _, src := range srcs {
+		msg := nl.NewRtMsg()
+		msg.Family, msg.Dst_len, msg.Src_len = netlink.FAMILY_V6, 64, 48
+		b := msg.Serialize()
+		for _, attr := range []*nl.RtAttr{
+			nl.NewRtAttr(unix.RTA_DST, dst.IP),
+			nl.NewRtAttr(unix.RTA_SRC, src.IP),
+			nl.NewRtAttr(unix.RTA_PRIORITY, nl.Uint32Attr(1024)),
+			nl.NewRtAttr(unix.RTA_IIF, nl.Uint32Attr(3)),
+		} {
+			b = append(b, attr.Serialize()...)
+		}
+		if _, got, ok := parseRouteSrc(b); !ok || got.String() != src.String() {
+			t.Fatalf("parseRouteSrc() = %v, %v, want %v", got, ok, src)
+		}
+		msgs = append(msgs, b)
+	}
+
+	// netlink.Route lacks the source prefix, so both look alike.
+	specific := netlink.Route{Family: netlink.FAMILY_V6, Table: unix.RT_TABLE_MAIN, Dst: dst, Protocol: unix.RTPROT_BOOT, Type: unix.RTN_UNICAST, Priority: 1024, ILinkIndex: 3}
+	plain := netlink.Route{Family: netlink.FAMILY_V6, Table: unix.RT_TABLE_MAIN, Dst: &net.IPNet{IP: net.ParseIP("fe80::"), Mask: net.CIDRMask(64, 128)}, Protocol: unix.RTPROT_KERNEL, Type: unix.RTN_UNICAST, Priority: 256}
+	attrs, ok := pairRouteAttrs([]netlink.Route{specific, specific}, msgs)
+	if !ok {
+		t.Fatalf("pairRouteAttrs() = %v, %v, want ok", attrs, ok)
+	}
+	// The routes changed between the dumps.
+	for _, changed := range [][]netlink.Route{{specific}, {specific, specific, plain}, {plain, specific}} {
+		if got, ok := pairRouteAttrs(changed, msgs); ok {
+			t.Errorf("pairRouteAttrs(%d routes) = %v, %v, want not ok", len(changed), got, ok)
+		}
+	}
+	routes := []netlink.Route{specific, specific, plain}
+	attrs = append(attrs, routeAttrs{})
+	for _, tt := range []struct {
+		from string
+		want []string
+	}{
+		{"2001:db8::/32", []string{"2001:db8:1::/48", "2001:db8:3::/48"}},
+		{"2001:db8:1::/48", []string{"2001:db8:1::/48"}},
+		{"2001:db8:3::/48", []string{"2001:db8:3::/48"}},
+		{"2001:db8:1:1::/64", nil},
+		{"::/0", []string{"2001:db8:1::/48", "2001:db8:3::/48", "::/0"}},
+	} {
+		_, from, _ := net.ParseCIDR(tt.from)
+		got, gotAttrs := matchRoutesFrom(routes, attrs, from)
+		var gotSrcs []string
+		for i, r := range got {
+			gotSrcs = append(gotSrcs, routeSrc(r, gotAttrs[i]).String())
+		}
+		if !cmp.Equal(gotSrcs, tt.want) {
+			t.Errorf("matchRoutesFrom(%s) sources = %q, want %q", tt.from, gotSrcs, tt.want)
+		}
+	}
+
+	var out bytes.Buffer
+	cmd.Out = &out
+	if err := cmd.showRoutes(routes[:2], attrs[:2], []string{"eth0", "eth0"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "2001:db8:2::/64 from 2001:db8:1::/48 dev eth0 proto boot metric 1024\n" +
+		"2001:db8:2::/64 from 2001:db8:3::/48 dev eth0 proto boot metric 1024\n"
+	if out.String() != want {
+		t.Errorf("showRoutes() = %q, want %q", &out, want)
+	}
+
+	out.Reset()
+	cmd.Opts.JSON = true
+	if err := cmd.showRoutes(routes[:2], attrs[:2], []string{"eth0", "eth0"}); err != nil {
+		t.Fatal(err)
+	}
+	var shown []Route
+	if err := json.Unmarshal(out.Bytes(), &shown); err != nil {
+		t.Fatal(err)
+	}
+	if len(shown) != 2 || shown[0].Src != srcs[0].String() || shown[1].Src != srcs[1].String() || shown[0].Iif != "eth1" {
+		t.Errorf("showRoutes() JSON = %s, want src %s and %s, and iif eth1", &out, srcs[0], srcs[1])
+	}
+}
+
+func TestParseRouteExpires(t *testing.T) {
+	cmd := cmd{Cursor: -1, Args: []string{"dev", "eth0", "metric", "5", "expires", "300"}, Out: new(bytes.Buffer)}
+	route, link, expires, err := cmd.parseRouteAddAppendReplaceDel("2001:db8::/64", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != "eth0" || route.Priority != 5 || expires != 300 {
+		t.Errorf("parseRouteAddAppendReplaceDel() = %v, %q, %d, want metric 5, eth0, 300", route, link, expires)
+	}
+
+	// The default route of router advertisements.
+	cmd.Cursor, cmd.Args = -1, []string{"via", "fe80::1", "dev", "eth0", "expires", "300"}
+	route, link, expires, err = cmd.parseRouteAddAppendReplaceDel("default", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route.Dst != nil || !route.Gw.Equal(net.ParseIP("fe80::1")) || link != "eth0" || expires != 300 {
+		t.Errorf("parseRouteAddAppendReplaceDel(default) = %v, %q, %d, want via fe80::1, eth0, 300", route, link, expires)
+	}
+
+	for _, tt := range []struct {
+		ns   string
+		args []string
+		want string
+	}{
+		{"2001:db8::/64", []string{"dev", "eth0", "expires", "soon"}, `parsing "soon"`},
+		{"10.0.0.0/24", []string{"dev", "eth0", "expires", "300"}, "expires is only supported for IPv6 routes"},
+		{"default", []string{"via", "10.0.0.1", "dev", "eth0", "expires", "300"}, "expires is only supported for IPv6 routes"},
+		{"local", []string{"2001:db8::1", "dev", "eth0", "expires", "300"}, "expires is only supported for unicast routes, not local routes"},
+		{"2001:db8::/64", []string{"dev", "eth0", "mtu", "1280", "expires", "300"}, "expires can't be combined with mtu"},
+	} {
+		cmd.Cursor, cmd.Args = -1, tt.args
+		if _, _, _, err := cmd.parseRouteAddAppendReplaceDel(tt.ns, false); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseRouteAddAppendReplaceDel(%s %q) = %v, want %q", tt.ns, tt.args, err, tt.want)
+		}
+	}
+	// The lifetime does not identify a route to delete.
+	cmd.Cursor, cmd.Args = -1, []string{"dev", "eth0", "expires", "300"}
+	if _, _, _, err := cmd.parseRouteAddAppendReplaceDel("10.0.0.0/24", true); err != nil {
+		t.Errorf("parseRouteAddAppendReplaceDel(del) = %v, want nil", err)
+	}
+}
+
+func TestShowRoutesExpires(t *testing.T) {
+	dst := &net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(64, 128)}
+	msg := nl.NewRtMsg()
+	msg.Family, msg.Dst_len = netlink.FAMILY_V6, 64
+	// struct rta_cacheinfo with rta_expires of 299.5s in USER_HZ.
+	cacheinfo := make([]byte, 32)
+	nl.NativeEndian().PutUint32(cacheinfo[8:], 29950)
+	b := msg.Serialize()
+	for _, attr := range []*nl.RtAttr{
+		nl.NewRtAttr(unix.RTA_DST, dst.IP),
+		nl.NewRtAttr(unix.RTA_PRIORITY, nl.Uint32Attr(100)),
+		nl.NewRtAttr(unix.RTA_CACHEINFO, cacheinfo),
+	} {
+		b = append(b, attr.Serialize()...)
+	}
+	k, expires, ok := parseRouteExpires(b)
+	if !ok || expires != 299 {
+		t.Fatalf("parseRouteExpires() = %v, %d, %v, want 299", k, expires, ok)
+	}
+	// Routes without a lifetime report 0.
+	nl.NativeEndian().PutUint32(cacheinfo[8:], 0)
+	if _, _, ok := parseRouteExpires(append(msg.Serialize(), nl.NewRtAttr(unix.RTA_CACHEINFO, cacheinfo).Serialize()...)); ok {
+		t.Errorf("parseRouteExpires() without a lifetime = true, want false")
+	}
+
+	routes := []netlink.Route{
+		{Family: netlink.FAMILY_V6, Table: unix.RT_TABLE_MAIN, Dst: dst, Protocol: unix.RTPROT_RA, Priority: 100},
+		{Family: netlink.FAMILY_V6, Table: unix.RT_TABLE_MAIN, Dst: &net.IPNet{IP: net.ParseIP("fe80::"), Mask: net.CIDRMask(64, 128)}, Protocol: unix.RTPROT_KERNEL, Priority: 256},
+	}
+	var out bytes.Buffer
+	cmd := cmd{Out: &out, Family: netlink.FAMILY_V6}
+	attrs := []routeAttrs{{expires: expires}, {}}
+	if err := cmd.showRoutes(routes, attrs, []string{"eth0", "eth0"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "2001:db8::/64 dev eth0 proto ra metric 100 expires 299sec\nfe80::/64 dev eth0 proto kernel metric 256\n"
+	if got := out.String(); got != want {
+		t.Errorf("showRoutes() = %q, want %q", got, want)
+	}
+
+	out.Reset()
+	cmd.Opts.JSON = true
+	if err := cmd.showRoutes(routes, attrs, []string{"eth0", "eth0"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); !strings.Contains(got, `"dst":"2001:db8::/64","expires":299,`) || strings.Count(got, "expires") != 1 {
+		t.Errorf("showRoutes() JSON = %s, want expires on 2001:db8::/64 only", got)
+	}
+}
+
+func TestOnlinkHint(t *testing.T) {
+	gw := net.ParseIP("10.1.0.1")
+	onSubnet := []netlink.Addr{{IPNet: &net.IPNet{IP: net.IPv4(10, 1, 0, 2), Mask: net.CIDRMask(24, 32)}}}
+	offSubnet := []netlink.Addr{{IPNet: &net.IPNet{IP: net.IPv4(192, 168, 0, 2), Mask: net.CIDRMask(24, 32)}}}
+	for _, tt := range []struct {
+		name     string
+		err      error
+		route    netlink.Route
+		addrs    []netlink.Addr
+		wantHint bool
+	}{
+		{"off subnet unreachable", unix.ENETUNREACH, netlink.Route{Gw: gw}, offSubnet, true},
+		{"off subnet invalid", unix.EINVAL, netlink.Route{Gw: gw}, offSubnet, true},
+		{"no addresses", unix.ENETUNREACH, netlink.Route{Gw: gw}, nil, true},
+		{"on subnet", unix.ENETUNREACH, netlink.Route{Gw: gw}, onSubnet, false},
+		{"onlink", unix.EINVAL, netlink.Route{Gw: gw, Flags: int(netlink.FLAG_ONLINK)}, offSubnet, false},
+		{"no gateway", unix.EINVAL, netlink.Route{}, offSubnet, false},
+		{"other error", unix.EEXIST, netlink.Route{Gw: gw}, offSubnet, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := onlinkHint(tt.err, &tt.route, func() ([]netlink.Addr, error) { return tt.addrs, nil })
+			if !errors.Is(err, tt.err) {
+				t.Errorf("onlinkHint() = %v, want it to wrap %v", err, tt.err)
+			}
+			if hint := strings.Contains(err.Error(), "add onlink"); hint != tt.wantHint {
+				t.Errorf("onlinkHint() = %q, hint %t, want %t", err, hint, tt.wantHint)
+			}
+		})
+	}
+}
+
+func TestRouteLinkLocalGatewayDev(t *testing.T) {
+	const want = "dev is required for link-local gateway fe80::1"
+	c := cmd{Cursor: 2, Args: []string{"ip", "route", "add", "default", "via", "fe80::1"}, Out: new(bytes.Buffer)}
+	if err := c.routeAdd(); err == nil || err.Error() != want {
+		t.Errorf("routeAdd() = %v, want %q", err, want)
+	}
+	c = cmd{Cursor: -1, Args: []string{"via", "fe80::1"}, Out: new(bytes.Buffer)}
+	if _, _, _, err := c.parseRouteAddAppendReplaceDel("2001:db8::/64", false); err == nil || err.Error() != want {
+		t.Errorf("parseRouteAddAppendReplaceDel() = %v, want %q", err, want)
+	}
+	// An option is no device.
+	c = cmd{Cursor: -1, Args: []string{"via", "fe80::1", "metric", "5"}, Out: new(bytes.Buffer)}
+	if _, _, _, err := c.parseRouteAddAppendReplaceDel("2001:db8::/64", false); err == nil || err.Error() != want {
+		t.Errorf("parseRouteAddAppendReplaceDel() = %v, want %q", err, want)
+	}
+	// Other gateways lacking a device, including IPv4 link-local ones,
+	// are usage errors.
+	for _, gw := range []string{"2001:db8::1", "169.254.1.1"} {
+		c = cmd{Cursor: -1, Args: []string{"via", gw, "metric", "5"}, Out: new(bytes.Buffer)}
+		if _, _, _, err := c.parseRouteAddAppendReplaceDel("default", false); err == nil || strings.Contains(err.Error(), "link-local") {
+			t.Errorf("parseRouteAddAppendReplaceDel(via %s) = %v, want a usage error", gw, err)
+		}
+	}
+	c = cmd{Cursor: -1, Args: []string{"via", "fe80::1", "lo", "metric", "5"}, Out: new(bytes.Buffer)}
+	if _, dev, _, err := c.parseRouteAddAppendReplaceDel("2001:db8::/64", false); err != nil || dev != "lo" {
+		t.Errorf("parseRouteAddAppendReplaceDel() = %q, %v, want device lo", dev, err)
+	}
+	c = cmd{Cursor: -1, Args: []string{"via", "fe80::1", "dev", "lo"}, Out: new(bytes.Buffer)}
+	route, dev, _, err := c.parseRouteAddAppendReplaceDel("2001:db8::/64", false)
+	if err != nil || dev != "lo" || !route.Gw.Equal(net.ParseIP("fe80::1")) {
+		t.Errorf("parseRouteAddAppendReplaceDel() = %v, %q, %v, want via fe80::1 dev lo", route, dev, err)
+	}
+}
@@ -0,0 +1,427 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//go:build !tinygo || tinygo.enable
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+const ruleHelp = `Usage: ip rule [ show ]
+       ip rule { add | del } SELECTOR ACTION
+
+SELECTOR := [ not ] [ from PREFIX ] [ to PREFIX ] [ iif STRING ]
+            [ oif STRING ] [ pref NUMBER ]
+ACTION := [ table TABLE_ID ] | goto NUMBER | nop |
+          blackhole | unreachable | prohibit
+TABLE_ID := [ local | main | default | NUMBER ]
+`
+
+// ruleActions maps the rule actions without arguments to their FR_ACT_*
+// value.
+var ruleActions = map[string]uint8{
+	"nop":         unix.FR_ACT_NOP,
+	"blackhole":   unix.FR_ACT_BLACKHOLE,
+	"unreachable": unix.FR_ACT_UNREACHABLE,
+	"prohibit":    unix.FR_ACT_PROHIBIT,
+}
+
+func (cmd *cmd) rule() error {
+	if !cmd.tokenRemains() {
+		return cmd.ruleShow()
+	}
+
+	switch c := cmd.findPrefix("show", "list", "add", "del", "help"); c {
+	case "show", "list":
+		return cmd.ruleShow()
+	case "add", "del":
+		r, err := cmd.parseRule(c == "add")
+		if err != nil {
+			return err
+		}
+		if c == "add" {
+			return cmd.ruleAdd(r)
+		}
+		return cmd.ruleModify(unix.RTM_DELRULE, 0, r)
+	case "help":
+		fmt.Fprint(cmd.Out, ruleHelp)
+		return nil
+	}
+	return cmd.usage()
+}
+
+// ruleConfig is a rule to add or delete.
+type ruleConfig struct {
+	family   int
+	not      bool
+	src, dst *net.IPNet
+	iif, oif string
+	pref     *uint32
+	table    uint32
+	action   uint8
+	// target is the priority of the rule to jump to with FR_ACT_GOTO.
+	target uint32
+}
+
+// parseRule parses the selector and action of a rule. If add is set and no
+// action is given, the rule looks up the main table.
+func (cmd *cmd) parseRule(add bool) (ruleConfig, error) {
+	r := ruleConfig{family: cmd.Family}
+
+	setAction := func(action uint8) error {
+		if r.action != unix.FR_ACT_UNSPEC {
+			return fmt.Errorf("only one of table, goto, nop, blackhole, unreachable and prohibit may be given")
+		}
+		r.action = action
+		return nil
+	}
+
+	for cmd.tokenRemains() {
+		var err error
+		switch token := cmd.nextToken("not", "from", "to", "iif", "oif", "pref", "table", "goto", "nop", "blackhole", "unreachable", "prohibit"); token {
+		case "not":
+			r.not = true
+		case "from":
+			r.src, err = cmd.parseRulePrefix()
+		case "to":
+			r.dst, err = cmd.parseRulePrefix()
+		case "iif", "dev":
+			r.iif = cmd.nextToken("<DEVICE>")
+		case "oif":
+			r.oif = cmd.nextToken("<DEVICE>")
+		case "pref", "priority", "preference":
+			var pref uint32
+			pref, err = cmd.parseUint32("NUMBER")
+			r.pref = &pref
+		case "table", "lookup":
+			if err = setAction(unix.FR_ACT_TO_TBL); err == nil {
+				r.table, err = parseTableID(cmd.nextToken("TABLE_ID"))
+			}
+		case "goto":
+			if err = setAction(unix.FR_ACT_GOTO); err == nil {
+				r.target, err = cmd.parseUint32("NUMBER")
+			}
+		case "nop", "blackhole", "unreachable", "prohibit":
+			err = setAction(ruleActions[token])
+		default:
+			return r, cmd.usage()
+		}
+		if err != nil {
+			return r, err
+		}
+	}
+
+	for _, prefix := range []*net.IPNet{r.src, r.dst} {
+		if prefix == nil {
+			continue
+		}
+		family := netlink.FAMILY_V4
+		if prefix.IP.To4() == nil {
+			family = netlink.FAMILY_V6
+		}
+		if r.family != netlink.FAMILY_ALL && r.family != family {
+			return r, fmt.Errorf("prefixes of the rule are not of the same family")
+		}
+		r.family = family
+	}
+	if r.family == netlink.FAMILY_ALL {
+		r.family = netlink.FAMILY_V4
+	}
+
+	if add && r.action == unix.FR_ACT_UNSPEC {
+		r.action, r.table = unix.FR_ACT_TO_TBL, unix.RT_TABLE_MAIN
+	}
+	return r, nil
+}
+
+// parseRulePrefix parses the PREFIX of from and to: "all", an address, or a
+// CIDR.
+func (cmd *cmd) parseRulePrefix() (*net.IPNet, error) {
+	token := cmd.nextToken("PREFIX")
+	if token == "all" {
+		return nil, nil
+	}
+	if _, prefix, err := net.ParseCIDR(token); err == nil {
+		return prefix, nil
+	}
+	ip := net.ParseIP(token)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid prefix %q", token)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// ruleAdd adds r. A goto rule can only jump forward, to a rule of a larger
+// priority number, so its target is checked against the priority the rule
+// will get.
+func (cmd *cmd) ruleAdd(r ruleConfig) error {
+	if r.action == unix.FR_ACT_GOTO {
+		var pref uint32
+		if r.pref != nil {
+			pref = *r.pref
+		} else {
+			rules, err := cmd.ruleList(r.family)
+			if err != nil {
+				return err
+			}
+			pref = defaultRulePref(rules)
+		}
+		if err := checkGoto(pref, r.target); err != nil {
+			return err
+		}
+	}
+	return cmd.ruleModify(unix.RTM_NEWRULE, unix.NLM_F_CREATE|unix.NLM_F_EXCL, r)
+}
+
+// defaultRulePref returns the priority the kernel assigns to a new rule
+// given without one: just before the second rule, if that is not 0.
+func defaultRulePref(rules []Rule) uint32 {
+	if len(rules) > 1 && rules[1].Priority > 0 {
+		return rules[1].Priority - 1
+	}
+	return 0
+}
+
+// checkGoto returns an error if a rule of priority pref may not jump to
+// target.
+func checkGoto(pref, target uint32) error {
+	if target <= pref {
+		return fmt.Errorf("goto target %d must be greater than the rule's priority %d, rules cannot jump backwards", target, pref)
+	}
+	return nil
+}
+
+// ruleModify sends an RTM_NEWRULE or RTM_DELRULE request for r.
+func (cmd *cmd) ruleModify(proto, flags int, r ruleConfig) error {
+	req := cmd.newRequest(proto, flags|unix.NLM_F_ACK)
+	req.AddData(ruleMsg(r))
+	for _, attr := range ruleAttrs(r) {
+		req.AddData(attr)
+	}
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
+
+// ruleMsg returns the struct fib_rule_hdr of r.
+func ruleMsg(r ruleConfig) *nl.RtMsg {
+	msg := &nl.RtMsg{RtMsg: unix.RtMsg{Family: uint8(r.family), Type: r.action}}
+	if r.table < 256 {
+		msg.Table = uint8(r.table)
+	}
+	if r.not {
+		msg.Flags |= unix.FIB_RULE_INVERT
+	}
+	if r.src != nil {
+		ones, _ := r.src.Mask.Size()
+		msg.Src_len = uint8(ones)
+	}
+	if r.dst != nil {
+		ones, _ := r.dst.Mask.Size()
+		msg.Dst_len = uint8(ones)
+	}
+	return msg
+}
+
+// ruleAttrs returns the FRA_* attributes of r.
+func ruleAttrs(r ruleConfig) []*nl.RtAttr {
+	u32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		nl.NativeEndian().PutUint32(b, v)
+		return b
+	}
+	ip := func(ip net.IP) []byte {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4
+		}
+		return ip.To16()
+	}
+
+	var attrs []*nl.RtAttr
+	if r.src != nil {
+		attrs = append(attrs, nl.NewRtAttr(unix.FRA_SRC, ip(r.src.IP)))
+	}
+	if r.dst != nil {
+		attrs = append(attrs, nl.NewRtAttr(unix.FRA_DST, ip(r.dst.IP)))
+	}
+	if r.iif != "" {
+		attrs = append(attrs, nl.NewRtAttr(unix.FRA_IIFNAME, []byte(r.iif+"\x00")))
+	}
+	if r.oif != "" {
+		attrs = append(attrs, nl.NewRtAttr(unix.FRA_OIFNAME, []byte(r.oif+"\x00")))
+	}
+	if r.pref != nil {
+		attrs = append(attrs, nl.NewRtAttr(unix.FRA_PRIORITY, u32(*r.pref)))
+	}
+	if r.table >= 256 {
+		attrs = append(attrs, nl.NewRtAttr(unix.FRA_TABLE, u32(r.table)))
+	}
+	if r.action == unix.FR_ACT_GOTO {
+		attrs = append(attrs, nl.NewRtAttr(unix.FRA_GOTO, u32(r.target)))
+	}
+	return attrs
+}
+
+// Rule is a routing policy rule as shown by ip rule.
+type Rule struct {
+	Priority uint32  `json:"priority"`
+	Not      bool    `json:"not,omitempty"`
+	Src      string  `json:"src"`
+	SrcLen   int     `json:"srclen,omitempty"`
+	Dst      string  `json:"dst,omitempty"`
+	DstLen   int     `json:"dstlen,omitempty"`
+	Iif      string  `json:"iif,omitempty"`
+	Oif      string  `json:"oif,omitempty"`
+	Table    string  `json:"table,omitempty"`
+	Action   string  `json:"action,omitempty"`
+	Target   *uint32 `json:"target,omitempty"`
+	// Unresolved is set for a goto rule whose target does not exist.
+	Unresolved bool `json:"unresolved,omitempty"`
+}
+
+// ruleList dumps the rules of family.
+func (cmd *cmd) ruleList(family int) ([]Rule, error) {
+	req := cmd.newRequest(unix.RTM_GETRULE, unix.NLM_F_DUMP)
+	req.AddData(&nl.RtMsg{RtMsg: unix.RtMsg{Family: uint8(family)}})
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWRULE)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]Rule, 0, len(msgs))
+	for _, m := range msgs {
+		r, err := parseRuleMsg(m)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// parseRuleMsg decodes the data of an RTM_NEWRULE message.
+func parseRuleMsg(b []byte) (Rule, error) {
+	if len(b) < unix.SizeofRtMsg {
+		return Rule{}, fmt.Errorf("short rule message: %d bytes", len(b))
+	}
+	// struct fib_rule_hdr has the layout of struct rtmsg.
+	msg := nl.DeserializeRtMsg(b)
+	attrs, err := nl.ParseRouteAttr(b[unix.SizeofRtMsg:])
+	if err != nil {
+		return Rule{}, fmt.Errorf("can't parse rule message: %v", err)
+	}
+
+	r := Rule{
+		Not:        msg.Flags&unix.FIB_RULE_INVERT != 0,
+		Src:        "all",
+		Unresolved: msg.Flags&unix.FIB_RULE_UNRESOLVED != 0,
+	}
+	table := uint32(msg.Table)
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case unix.FRA_PRIORITY:
+			r.Priority = nl.NativeEndian().Uint32(a.Value)
+		case unix.FRA_SRC:
+			r.Src = net.IP(a.Value).String()
+			if int(msg.Src_len) != 8*len(a.Value) {
+				r.SrcLen = int(msg.Src_len)
+			}
+		case unix.FRA_DST:
+			r.Dst = net.IP(a.Value).String()
+			if int(msg.Dst_len) != 8*len(a.Value) {
+				r.DstLen = int(msg.Dst_len)
+			}
+		case unix.FRA_IIFNAME:
+			r.Iif = nl.BytesToString(a.Value)
+		case unix.FRA_OIFNAME:
+			r.Oif = nl.BytesToString(a.Value)
+		case unix.FRA_TABLE:
+			table = nl.NativeEndian().Uint32(a.Value)
+		case unix.FRA_GOTO:
+			target := nl.NativeEndian().Uint32(a.Value)
+			r.Target = &target
+		}
+	}
+
+	switch msg.Type {
+	case unix.FR_ACT_TO_TBL:
+		r.Table = tableName(table)
+	case unix.FR_ACT_GOTO:
+		r.Action = "goto"
+	default:
+		for name, action := range ruleActions {
+			if action == msg.Type {
+				r.Action = name
+			}
+		}
+		if r.Action == "" {
+			r.Action = strconv.Itoa(int(msg.Type))
+		}
+	}
+	return r, nil
+}
+
+func (cmd *cmd) ruleShow() error {
+	family := cmd.Family
+	if family == netlink.FAMILY_ALL {
+		family = netlink.FAMILY_V4
+	}
+	rules, err := cmd.ruleList(family)
+	if err != nil {
+		return err
+	}
+	return cmd.printRules(rules)
+}
+
+func (cmd *cmd) printRules(rules []Rule) error {
+	if cmd.Opts.JSON {
+		return printJSON(*cmd, rules)
+	}
+
+	for _, r := range rules {
+		fmt.Fprintf(cmd.Out, "%d:\t", r.Priority)
+		if r.Not {
+			fmt.Fprint(cmd.Out, "not ")
+		}
+		fmt.Fprintf(cmd.Out, "from %s", r.Src)
+		if r.SrcLen != 0 {
+			fmt.Fprintf(cmd.Out, "/%d", r.SrcLen)
+		}
+		if r.Dst != "" {
+			fmt.Fprintf(cmd.Out, " to %s", r.Dst)
+			if r.DstLen != 0 {
+				fmt.Fprintf(cmd.Out, "/%d", r.DstLen)
+			}
+		}
+		if r.Iif != "" {
+			fmt.Fprintf(cmd.Out, " iif %s", r.Iif)
+		}
+		if r.Oif != "" {
+			fmt.Fprintf(cmd.Out, " oif %s", r.Oif)
+		}
+
+		switch {
+		case r.Table != "":
+			fmt.Fprintf(cmd.Out, " lookup %s", r.Table)
+		case r.Action == "goto" && r.Target != nil:
+			fmt.Fprintf(cmd.Out, " goto %d", *r.Target)
+		default:
+			fmt.Fprintf(cmd.Out, " %s", r.Action)
+		}
+		if r.Unresolved {
+			fmt.Fprint(cmd.Out, " [unresolved]")
+		}
+		fmt.Fprintln(cmd.Out)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//go:build !tinygo || tinygo.enable
+
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseRule(t *testing.T) {
+	pref := uint32(100)
+	_, src, _ := net.ParseCIDR("10.0.0.0/8")
+	_, dst6, _ := net.ParseCIDR("2001:db8::/32")
+
+	tests := []struct {
+		name    string
+		args    []string
+		add     bool
+		want    ruleConfig
+		wantErr bool
+	}{
+		{
+			name: "Default action",
+			args: []string{"ip", "rule", "add", "from", "10.0.0.0/8"},
+			add:  true,
+			want: ruleConfig{family: netlink.FAMILY_V4, src: src, table: unix.RT_TABLE_MAIN, action: unix.FR_ACT_TO_TBL},
+		},
+		{
+			name: "Goto",
+			args: []string{"ip", "rule", "add", "iif", "eth0", "pref", "100", "goto", "1000"},
+			add:  true,
+			want: ruleConfig{family: netlink.FAMILY_V4, iif: "eth0", pref: &pref, action: unix.FR_ACT_GOTO, target: 1000},
+		},
+		{
+			name: "Nop",
+			args: []string{"ip", "rule", "add", "not", "oif", "eth1", "nop"},
+			add:  true,
+			want: ruleConfig{family: netlink.FAMILY_V4, not: true, oif: "eth1", action: unix.FR_ACT_NOP},
+		},
+		{
+			name: "Prohibit IPv6",
+			args: []string{"ip", "rule", "add", "to", "2001:db8::/32", "prohibit"},
+			add:  true,
+			want: ruleConfig{family: netlink.FAMILY_V6, dst: dst6, action: unix.FR_ACT_PROHIBIT},
+		},
+		{
+			name: "Named table",
+			args: []string{"ip", "rule", "del", "lookup", "local"},
+			want: ruleConfig{family: netlink.FAMILY_V4, table: unix.RT_TABLE_LOCAL, action: unix.FR_ACT_TO_TBL},
+		},
+		{
+			name: "Delete without action",
+			args: []string{"ip", "rule", "del", "pref", "100"},
+			want: ruleConfig{family: netlink.FAMILY_V4, pref: &pref},
+		},
+		{
+			name:    "Two actions",
+			args:    []string{"ip", "rule", "add", "table", "100", "blackhole"},
+			add:     true,
+			wantErr: true,
+		},
+		{
+			name:    "Mixed families",
+			args:    []string{"ip", "rule", "add", "from", "10.0.0.0/8", "to", "2001:db8::/32"},
+			add:     true,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid table",
+			args:    []string{"ip", "rule", "add", "table", "x"},
+			add:     true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := cmd{Cursor: 2, Args: tt.args, Out: new(bytes.Buffer)}
+			got, err := cmd.parseRule(tt.add)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if c := cmp.Diff(tt.want, got, cmp.AllowUnexported(ruleConfig{})); c != "" {
+				t.Errorf("parseRule() diff:\n%v", c)
+			}
+		})
+	}
+}
+
+func TestCheckGoto(t *testing.T) {
+	if err := checkGoto(100, 1000); err != nil {
+		t.Errorf("checkGoto(100, 1000) = %v, want nil", err)
+	}
+	for _, target := range []uint32{100, 50} {
+		if err := checkGoto(100, target); err == nil {
+			t.Errorf("checkGoto(100, %d) = nil, want error", target)
+		}
+	}
+
+	rules := []Rule{{Priority: 0}, {Priority: 32766}, {Priority: 32767}}
+	if got := defaultRulePref(rules); got != 32765 {
+		t.Errorf("defaultRulePref() = %d, want 32765", got)
+	}
+	if got := defaultRulePref(rules[:1]); got != 0 {
+		t.Errorf("defaultRulePref() of one rule = %d, want 0", got)
+	}
+}
+
+func TestParseRuleMsg(t *testing.T) {
+	pref := uint32(100)
+	_, src, _ := net.ParseCIDR("10.0.0.0/8")
+	target := uint32(1000)
+
+	tests := []struct {
+		name string
+		r    ruleConfig
+		want Rule
+	}{
+		{
+			name: "Goto",
+			r:    ruleConfig{family: netlink.FAMILY_V4, iif: "eth0", pref: &pref, action: unix.FR_ACT_GOTO, target: 1000},
+			want: Rule{Priority: 100, Src: "all", Iif: "eth0", Action: "goto", Target: &target},
+		},
+		{
+			name: "Table",
+			r:    ruleConfig{family: netlink.FAMILY_V4, src: src, pref: &pref, table: 1000, action: unix.FR_ACT_TO_TBL},
+			want: Rule{Priority: 100, Src: "10.0.0.0", SrcLen: 8, Table: "1000"},
+		},
+		{
+			name: "Unreachable",
+			r:    ruleConfig{family: netlink.FAMILY_V4, not: true, oif: "eth1", action: unix.FR_ACT_UNREACHABLE},
+			want: Rule{Not: true, Src: "all", Oif: "eth1", Action: "unreachable"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := ruleMsg(tt.r).Serialize()
+			for _, attr := range ruleAttrs(tt.r) {
+				b = append(b, attr.Serialize()...)
+			}
+			got, err := parseRuleMsg(b)
+			if err != nil {
+				t.Fatalf("parseRuleMsg() = %v", err)
+			}
+			if c := cmp.Diff(tt.want, got); c != "" {
+				t.Errorf("parseRuleMsg() diff:\n%v", c)
+			}
+		})
+	}
+}
+
+func TestPrintRules(t *testing.T) {
+	target := uint32(1000)
+	rules := []Rule{
+		{Priority: 0, Src: "all", Table: "local"},
+		{Priority: 100, Src: "10.0.0.0", SrcLen: 8, Iif: "eth0", Action: "goto", Target: &target},
+		{Priority: 200, Not: true, Src: "all", Oif: "eth1", Action: "nop"},
+		{Priority: 300, Src: "all", Dst: "192.168.0.1", Action: "blackhole"},
+		{Priority: 400, Src: "all", Action: "goto", Target: &target, Unresolved: true},
+	}
+
+	var out bytes.Buffer
+	cmd := cmd{Out: &out}
+	if err := cmd.printRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	want := "0:\tfrom all lookup local\n" +
+		"100:\tfrom 10.0.0.0/8 iif eth0 goto 1000\n" +
+		"200:\tnot from all oif eth1 nop\n" +
+		"300:\tfrom all to 192.168.0.1 blackhole\n" +
+		"400:\tfrom all goto 1000 [unresolved]\n"
+	if got := out.String(); got != want {
+		t.Errorf("printRules() = %q, want %q", got, want)
+	}
+
+	out.Reset()
+	cmd.Opts.JSON = true
+	if err := cmd.printRules(rules[1:2]); err != nil {
+		t.Fatal(err)
+	}
+	wantJSON := `[{"priority":100,"src":"10.0.0.0","srclen":8,"iif":"eth0","action":"goto","target":1000}]`
+	if got := out.String(); got != wantJSON {
+		t.Errorf("printRules() JSON = %s, want %s", got, wantJSON)
+	}
+}
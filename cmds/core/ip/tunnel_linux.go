@@ -159,7 +159,7 @@ func (cmd *cmd) showAllTunnels() error {
 }
 
 func (cmd *cmd) showTunnels(op *options) error {
-	links, err := netlink.LinkList()
+	links, err := cmd.handle.LinkList()
 	if err != nil {
 		return fmt.Errorf("failed to list interfaces: %v", err)
 	}
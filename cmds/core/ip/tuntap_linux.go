@@ -8,8 +8,14 @@ package main
 import (
 	"fmt"
 	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -186,9 +192,26 @@ func filterTunTaps(links []netlink.Link, options tuntapOptions) (*netlink.Tuntap
 	return filteredTunTaps[0], nil
 }
 
+// sysClassNet and procDir are where the tun/tap details missing from netlink
+// dumps are read.
+var (
+	sysClassNet = "/sys/class/net"
+	procDir     = "/proc"
+)
+
+// Tuntap is a tun/tap device as ip -json tuntap show prints it, with the keys
+// of iproute2.
 type Tuntap struct {
 	IfName string   `json:"ifname"`
 	Flags  []string `json:"flags"`
+	// Owner and Group are the uid and gid allowed to attach to the device,
+	// if it is restricted.
+	Owner *uint32 `json:"user,omitempty"`
+	Group *uint32 `json:"group,omitempty"`
+	// Queues is the number of queues attached, counted from the file
+	// descriptors of the processes that may be inspected. It is only set
+	// with -details.
+	Queues *int `json:"queues,omitempty"`
 }
 
 func (cmd *cmd) tuntapShow() error {
@@ -202,63 +225,150 @@ func (cmd *cmd) tuntapShow() error {
 
 func (cmd *cmd) printTunTaps(links []netlink.Link) error {
 	prints := make([]Tuntap, 0)
+	var queues map[string]int
 
 	for _, link := range links {
 		tunTap, ok := link.(*netlink.Tuntap)
 		if !ok {
 			continue
 		}
+		if queues == nil {
+			queues = tuntapQueues()
+		}
 
 		var obj Tuntap
+		obj.IfName = tunTap.Name
+		obj.Owner, obj.Group = tuntapSysfs(tunTap)
+		if cmd.Opts.Details {
+			n := queues[tunTap.Name]
+			obj.Queues = &n
+		}
+		obj.Flags = tuntapFlags(tunTap)
+
+		prints = append(prints, obj)
+	}
 
-		obj.Flags = append(obj.Flags, tunTap.Mode.String())
+	if cmd.Opts.JSON {
+		return printJSON(*cmd, prints)
+	}
 
-		if tunTap.Flags&netlink.TUNTAP_NO_PI == 1 {
-			obj.Flags = append(obj.Flags, "pi")
-		}
+	for _, print := range prints {
+		output := fmt.Sprintf("%s:", print.IfName)
 
-		if tunTap.Flags&netlink.TUNTAP_ONE_QUEUE != 0 {
-			obj.Flags = append(obj.Flags, "one_queue")
-		} else if tunTap.Flags&netlink.TUNTAP_MULTI_QUEUE != 0 {
-			obj.Flags = append(obj.Flags, "multi_queue")
+		for _, flag := range print.Flags {
+			output += fmt.Sprintf(" %s", flag)
 		}
 
-		if tunTap.Flags&netlink.TUNTAP_VNET_HDR != 0 {
-			obj.Flags = append(obj.Flags, "vnet_hdr")
+		if !slices.Contains(print.Flags, "persist") {
+			output += " non-persist"
 		}
 
-		if tunTap.NonPersist {
-			obj.Flags = append(obj.Flags, "non-persist")
-		} else {
-			obj.Flags = append(obj.Flags, "persist")
+		if print.Owner != nil {
+			output += fmt.Sprintf(" user %d", *print.Owner)
 		}
 
-		if tunTap.Owner != 0 {
-			obj.Flags = append(obj.Flags, fmt.Sprintf("user %d", tunTap.Owner))
+		if print.Group != nil {
+			output += fmt.Sprintf(" group %d", *print.Group)
 		}
 
-		if tunTap.Group != 0 {
-			obj.Flags = append(obj.Flags, fmt.Sprintf("group %d", tunTap.Group))
+		if print.Queues != nil {
+			output += fmt.Sprintf(" queues %d", *print.Queues)
 		}
 
-		obj.IfName = tunTap.Name
+		fmt.Fprintln(cmd.Out, output)
+	}
 
-		prints = append(prints, obj)
+	return nil
+}
+
+// tuntapFlags returns the flags of tunTap in the words and order of iproute2.
+func tuntapFlags(tunTap *netlink.Tuntap) []string {
+	flags := []string{tunTap.Mode.String()}
+	// IFF_NO_PI and IFF_NOFILTER share a bit, which iproute2 shows as both
+	// pi and filter when clear.
+	filter := tunTap.Flags&netlink.TUNTAP_NO_PI == 0
+	if filter {
+		flags = append(flags, "pi")
+	}
+	if tunTap.Flags&netlink.TUNTAP_ONE_QUEUE != 0 {
+		flags = append(flags, "one_queue")
+	}
+	if tunTap.Flags&netlink.TUNTAP_MULTI_QUEUE != 0 {
+		flags = append(flags, "multi_queue")
+	}
+	if tunTap.Flags&netlink.TUNTAP_VNET_HDR != 0 {
+		flags = append(flags, "vnet_hdr")
+	}
+	if !tunTap.NonPersist {
+		flags = append(flags, "persist")
 	}
+	if filter {
+		flags = append(flags, "filter")
+	}
+	return flags
+}
 
-	if cmd.Opts.JSON {
-		return printJSON(*cmd, prints)
+// tuntapSysfs completes tunTap from sysfs, since netlink dumps lack its flags
+// and do not tell an owner or group of root from none. It returns the owner
+// and group, nil if the device is not restricted to one. Without sysfs, the
+// netlink values are kept and 0 is taken for none.
+func tuntapSysfs(tunTap *netlink.Tuntap) (owner, group *uint32) {
+	dir := filepath.Join(sysClassNet, tunTap.Name)
+	if b, err := os.ReadFile(filepath.Join(dir, "tun_flags")); err == nil {
+		if flags, err := strconv.ParseUint(strings.TrimSpace(string(b)), 0, 16); err == nil {
+			tunTap.Flags = netlink.TuntapFlag(flags)
+			tunTap.NonPersist = flags&unix.IFF_PERSIST == 0
+		}
 	}
 
-	for _, print := range prints {
-		output := fmt.Sprintf("%s:", print.IfName)
+	owner, group = nonZero(tunTap.Owner), nonZero(tunTap.Group)
+	if id, ok := readSysfsID(filepath.Join(dir, "owner")); ok {
+		owner = id
+	}
+	if id, ok := readSysfsID(filepath.Join(dir, "group")); ok {
+		group = id
+	}
+	return owner, group
+}
 
-		for _, flag := range print.Flags {
-			output += fmt.Sprintf(" %s", flag)
-		}
+// readSysfsID reads the uid or gid in path, which the kernel writes as -1 for
+// none. ok is false if path cannot be read.
+func readSysfsID(path string) (id *uint32, ok bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil || n < 0 || n > math.MaxUint32 {
+		return nil, err == nil
+	}
+	v := uint32(n)
+	return &v, true
+}
 
-		fmt.Fprintln(cmd.Out, output)
+// nonZero returns a pointer to v, or nil if it is 0.
+func nonZero(v uint32) *uint32 {
+	if v == 0 {
+		return nil
 	}
+	return &v
+}
 
-	return nil
+// tuntapQueues counts the queues attached to each tun/tap device by the file
+// descriptors of processes, which /proc/PID/fdinfo shows as "iff:\tNAME".
+func tuntapQueues() map[string]int {
+	queues := make(map[string]int)
+	infos, _ := filepath.Glob(filepath.Join(procDir, "[0-9]*", "fdinfo", "*"))
+	for _, info := range infos {
+		b, err := os.ReadFile(info)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if name, ok := strings.CutPrefix(line, "iff:\t"); ok {
+				queues[name]++
+			}
+		}
+	}
+	return queues
 }
@@ -7,7 +7,10 @@ package main
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -270,6 +273,9 @@ func TestFilterTunTaps(t *testing.T) {
 }
 
 func TestPrintTunTaps(t *testing.T) {
+	sysClassNet, procDir = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { sysClassNet, procDir = "/sys/class/net", "/proc" })
+
 	// Mock netlink.Tuntap instances
 	mockTun := &netlink.Tuntap{LinkAttrs: netlink.LinkAttrs{Name: "tun0"}, Mode: netlink.TUNTAP_MODE_TUN}
 	mockTap := &netlink.Tuntap{LinkAttrs: netlink.LinkAttrs{Name: "tap0"}, Mode: netlink.TUNTAP_MODE_TAP}
@@ -289,7 +295,7 @@ func TestPrintTunTaps(t *testing.T) {
 			cmd: cmd{
 				Opts: flags{JSON: false},
 			},
-			expected: "tun0: tun persist\n",
+			expected: "tun0: tun pi persist filter\n",
 		},
 		{
 			name:  "Print single tap device",
@@ -297,7 +303,7 @@ func TestPrintTunTaps(t *testing.T) {
 			cmd: cmd{
 				Opts: flags{JSON: false},
 			},
-			expected: "tap0: tap persist\n",
+			expected: "tap0: tap pi persist filter\n",
 		},
 		{
 			name:  "Print single tap device with multiple flags",
@@ -305,7 +311,7 @@ func TestPrintTunTaps(t *testing.T) {
 			cmd: cmd{
 				Opts: flags{JSON: false},
 			},
-			expected: "tap1: tap one_queue vnet_hdr non-persist user 1 group 1\n",
+			expected: "tap1: tap pi one_queue vnet_hdr filter non-persist user 1 group 1\n",
 		},
 		{
 			name:  "Print single tap device with various flags",
@@ -321,7 +327,7 @@ func TestPrintTunTaps(t *testing.T) {
 			cmd: cmd{
 				Opts: flags{JSON: true},
 			},
-			expected: `[{"ifname":"tap1","flags":["tap","one_queue","vnet_hdr","non-persist","user 1","group 1"]}]`,
+			expected: `[{"ifname":"tap1","flags":["tap","pi","one_queue","vnet_hdr","filter"],"user":1,"group":1}]`,
 		},
 	}
 
@@ -344,6 +350,113 @@ func TestPrintTunTaps(t *testing.T) {
 	}
 }
 
+func TestPrintTunTapsSysfs(t *testing.T) {
+	sysClassNet, procDir = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { sysClassNet, procDir = "/sys/class/net", "/proc" })
+	for name, content := range map[string]string{
+		// A persistent multi-queue tap owned by root, attached twice.
+		filepath.Join(sysClassNet, "tap0", "tun_flags"): "0x5902\n",
+		filepath.Join(sysClassNet, "tap0", "owner"):     "0\n",
+		filepath.Join(sysClassNet, "tap0", "group"):     "-1\n",
+		filepath.Join(procDir, "10", "fdinfo", "3"):     "pos:\t0\nflags:\t02\niff:\ttap0\n",
+		filepath.Join(procDir, "10", "fdinfo", "4"):     "pos:\t0\nflags:\t02\niff:\ttap0\n",
+		filepath.Join(procDir, "11", "fdinfo", "3"):     "pos:\t0\nflags:\t02\niff:\ttap1\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The netlink dump lacks the flags and takes root for no owner.
+	links := []netlink.Link{&netlink.Tuntap{LinkAttrs: netlink.LinkAttrs{Name: "tap0"}, Mode: netlink.TUNTAP_MODE_TAP, NonPersist: true}}
+
+	var out bytes.Buffer
+	c := cmd{Out: &out, Opts: flags{Details: true}}
+	if err := c.printTunTaps(links); err != nil {
+		t.Fatal(err)
+	}
+	if want := "tap0: tap multi_queue vnet_hdr persist user 0 queues 2\n"; out.String() != want {
+		t.Errorf("printTunTaps() = %q, want %q", &out, want)
+	}
+
+	out.Reset()
+	c.Opts.JSON = true
+	if err := c.printTunTaps(links); err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"ifname":"tap0","flags":["tap","multi_queue","vnet_hdr","persist"],"user":0,"queues":2}]`; out.String() != want {
+		t.Errorf("printTunTaps() JSON = %s, want %s", &out, want)
+	}
+}
+
+// TestPrintTunTapsGolden compares ip -json tuntap show with the output of
+// iproute2 6.1.0 for devices made by:
+//
+//	ip tuntap add dev gold0 mode tap user 1000 multi_queue
+//	ip tuntap add dev gold1 mode tun
+//	ip tuntap add dev gold2 mode tun pi vnet_hdr one_queue group 5
+//	ip tuntap add dev gold3 mode tap pi
+//	ip tuntap add dev gold4 mode tap multi_queue vnet_hdr pi user 0 group 0
+func TestPrintTunTapsGolden(t *testing.T) {
+	sysClassNet, procDir = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { sysClassNet, procDir = "/sys/class/net", "/proc" })
+	var links []netlink.Link
+	for _, dev := range []struct {
+		name, flags, owner, group string
+		mode                      netlink.TuntapMode
+	}{
+		{"gold0", "0x1902", "1000", "-1", netlink.TUNTAP_MODE_TAP},
+		{"gold1", "0x1801", "-1", "-1", netlink.TUNTAP_MODE_TUN},
+		{"gold2", "0x6801", "-1", "5", netlink.TUNTAP_MODE_TUN},
+		{"gold3", "0x802", "-1", "-1", netlink.TUNTAP_MODE_TAP},
+		{"gold4", "0x4902", "0", "0", netlink.TUNTAP_MODE_TAP},
+	} {
+		dir := filepath.Join(sysClassNet, dev.name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range map[string]string{"tun_flags": dev.flags, "owner": dev.owner, "group": dev.group} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		links = append(links, &netlink.Tuntap{LinkAttrs: netlink.LinkAttrs{Name: dev.name}, Mode: dev.mode})
+	}
+
+	for _, tt := range []struct {
+		name   string
+		links  []netlink.Link
+		golden string
+	}{
+		{"devices", links, readGolden(t, "tuntap.json")},
+		{"none", nil, "[]"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c := cmd{Out: &out, Opts: flags{JSON: true}}
+			if err := c.printTunTaps(tt.links); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.golden {
+				t.Errorf("printTunTaps() JSON = %s, want %s", &out, tt.golden)
+			}
+		})
+	}
+}
+
+// readGolden returns the iproute2 output in testdata/name, without the
+// newline it ends with.
+func readGolden(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(string(b), "\n")
+}
+
 func TestTunTapDevice(t *testing.T) {
 	tests := []struct {
 		name     string
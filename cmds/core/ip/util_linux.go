@@ -6,22 +6,43 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/vishvananda/netlink"
 )
 
 type Printable interface {
-	Link | []Link | Vrf | []Vrf | Neigh | []Neigh | Route | []Route | Tunnel | []Tunnel | Tuntap | []Tuntap
+	Link | []Link | Vrf | []Vrf | Neigh | []Neigh | Route | []Route | Tunnel | []Tunnel | Tuntap | []Tuntap | []Rule | []Nexthop | []Netns | MonitorEvent
 }
 
+// defaultIndent is the indentation of pretty JSON output without --indent.
+const defaultIndent = "    "
+
 func printJSON[T Printable](cmd cmd, data T) error {
 	var jsonData []byte
 	var err error
 
+	if cmd.Opts.Sorted {
+		sortJSON(data)
+	}
+
+	var v any = data
+	if cmd.Opts.Envelope {
+		v = envelope(data)
+	}
+
 	if cmd.Opts.Prettify {
-		jsonData, err = json.MarshalIndent(data, "", "    ") // Use 4 spaces for indentation
+		indent := cmd.Opts.Indent
+		if indent == "" {
+			indent = defaultIndent
+		}
+		jsonData, err = json.MarshalIndent(v, "", indent)
 	} else {
-		jsonData, err = json.Marshal(data)
+		jsonData, err = json.Marshal(v)
 	}
 	if err != nil {
 		return fmt.Errorf("error marshalling JSON data: %v", err)
@@ -34,3 +55,182 @@ func printJSON[T Printable](cmd cmd, data T) error {
 
 	return nil
 }
+
+// jsonEnvelope wraps JSON output with --envelope, so that the outputs of
+// several objects collected together describe themselves.
+type jsonEnvelope struct {
+	Kind  string `json:"kind"`
+	Items any    `json:"items"`
+}
+
+// envelope returns data wrapped in a jsonEnvelope, a single object as the only
+// item. An empty result has an empty list of items, never null.
+func envelope(data any) jsonEnvelope {
+	env := jsonEnvelope{Kind: jsonKind(data), Items: data}
+	switch v := reflect.ValueOf(data); {
+	case v.Kind() != reflect.Slice:
+		env.Items = []any{data}
+	case v.IsNil():
+		env.Items = []any{}
+	}
+	return env
+}
+
+// jsonKind returns the kind of Printable data, the object it describes.
+func jsonKind(data any) string {
+	switch data.(type) {
+	case Link, []Link:
+		return "link"
+	case Vrf, []Vrf:
+		return "vrf"
+	case Neigh, []Neigh:
+		return "neigh"
+	case Route, []Route:
+		return "route"
+	case Tunnel, []Tunnel:
+		return "tunnel"
+	case Tuntap, []Tuntap:
+		return "tuntap"
+	case []Rule:
+		return "rule"
+	case []Nexthop:
+		return "nexthop"
+	case []Netns:
+		return "netns"
+	case MonitorEvent:
+		return "monitor"
+	}
+	return ""
+}
+
+// sortJSON sorts the slices of data in place by a stable key, so that dumps of
+// an unchanged system print identical JSON, see --sorted: links by ifindex,
+// routes by destination then table, and neighbors by address then device.
+// Other data is left in the order it was dumped.
+func sortJSON(data any) {
+	switch d := data.(type) {
+	case []Link:
+		sort.SliceStable(d, func(i, j int) bool { return d[i].IfIndex < d[j].IfIndex })
+	case []Route:
+		sort.SliceStable(d, func(i, j int) bool {
+			if d[i].Dst != d[j].Dst {
+				return d[i].Dst < d[j].Dst
+			}
+			return d[i].table < d[j].table
+		})
+	case []Neigh:
+		sort.SliceStable(d, func(i, j int) bool {
+			if c := bytes.Compare(d[i].Dst.To16(), d[j].Dst.To16()); c != 0 {
+				return c < 0
+			}
+			return d[i].Dev < d[j].Dev
+		})
+	}
+}
+
+// deviceNotFoundError is returned for a device name that does not exist. Its
+// text matches iproute2.
+type deviceNotFoundError string
+
+func (e deviceNotFoundError) Error() string {
+	return fmt.Sprintf("Cannot find device %q", string(e))
+}
+
+// linkCache indexes the links of a single link dump by name and index.
+type linkCache struct {
+	byName  map[string][]netlink.Link
+	byIndex map[int]netlink.Link
+}
+
+func newLinkCache(links []netlink.Link) *linkCache {
+	c := &linkCache{
+		byName:  make(map[string][]netlink.Link),
+		byIndex: make(map[int]netlink.Link),
+	}
+	for _, l := range links {
+		c.byName[l.Attrs().Name] = append(c.byName[l.Attrs().Name], l)
+		c.byIndex[l.Attrs().Index] = l
+	}
+	return c
+}
+
+// links returns the link cache, dumping the links on first use.
+func (cmd *cmd) links() (*linkCache, error) {
+	if cmd.linkCache != nil {
+		return cmd.linkCache, nil
+	}
+
+	var (
+		links []netlink.Link
+		err   error
+	)
+	if cmd.handle != nil {
+		links, err = cmd.handle.LinkList()
+	} else {
+		links, err = netlink.LinkList()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't enumerate interfaces: %v", err)
+	}
+
+	cmd.linkCache = newLinkCache(links)
+	return cmd.linkCache, nil
+}
+
+// lookupLink returns the link named name.
+func (cmd *cmd) lookupLink(name string) (netlink.Link, error) {
+	c, err := cmd.links()
+	if err != nil {
+		return nil, err
+	}
+
+	switch links := c.byName[name]; len(links) {
+	case 0:
+		return nil, deviceNotFoundError(name)
+	case 1:
+		return links[0], nil
+	default:
+		return nil, fmt.Errorf("device name %q is ambiguous: %d links", name, len(links))
+	}
+}
+
+// resolveLink returns the index of the link named name.
+func (cmd *cmd) resolveLink(name string) (int, error) {
+	link, err := cmd.lookupLink(name)
+	if err != nil {
+		return 0, err
+	}
+	return link.Attrs().Index, nil
+}
+
+// linkName returns the name of the link with the given index.
+func (cmd *cmd) linkName(index int) (string, error) {
+	c, err := cmd.links()
+	if err != nil {
+		return "", err
+	}
+
+	link, ok := c.byIndex[index]
+	if !ok {
+		return "", fmt.Errorf("cannot find device with index %d", index)
+	}
+	return link.Attrs().Name, nil
+}
+
+// devName returns the name of the device with the given index for printing
+// routes, neighbours, and nexthops, resolved from the link cache. As in
+// iproute2, it is "" for no device, index 0, and "if" and the index for an
+// unknown device, e.g. one removed since the link dump.
+func (cmd *cmd) devName(index int) (string, error) {
+	if index == 0 {
+		return "", nil
+	}
+	c, err := cmd.links()
+	if err != nil {
+		return "", err
+	}
+	if link, ok := c.byIndex[index]; ok {
+		return link.Attrs().Name, nil
+	}
+	return fmt.Sprintf("if%d", index), nil
+}
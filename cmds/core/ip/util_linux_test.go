@@ -7,7 +7,14 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
 	"testing"
+
+	"github.com/vishvananda/netlink"
 )
 
 // TestPrintJSON tests the printJSON function with different scenarios.
@@ -31,6 +38,19 @@ func TestPrintJSON(t *testing.T) {
 			want:    "{\n    \"name\": \"Test\",\n    \"table\": 2\n}",
 			wantErr: false,
 		},
+		{
+			name: "With Indent",
+			cmd: cmd{
+				Opts: flags{
+					Prettify: true,
+					Indent:   "  ",
+				},
+				Out: &bytes.Buffer{},
+			},
+			data:    Vrf{Name: "Test", Table: 2},
+			want:    "{\n  \"name\": \"Test\",\n  \"table\": 2\n}",
+			wantErr: false,
+		},
 		{
 			name: "Without Prettify",
 			cmd: cmd{
@@ -58,3 +78,190 @@ func TestPrintJSON(t *testing.T) {
 		})
 	}
 }
+
+// TestPrintJSONPrettify checks that each kind of Printable is compact by
+// default and indented with -p.
+func TestPrintJSONPrettify(t *testing.T) {
+	nsid := 1
+	for _, tt := range []struct {
+		name  string
+		print func(cmd) error
+	}{
+		{"link", func(c cmd) error { return printJSON(c, Link{IfName: "eth0"}) }},
+		{"links", func(c cmd) error { return printJSON(c, []Link{{IfName: "eth0"}}) }},
+		{"vrf", func(c cmd) error { return printJSON(c, Vrf{Name: "blue", Table: 10}) }},
+		{"vrfs", func(c cmd) error { return printJSON(c, []Vrf{{Name: "blue", Table: 10}}) }},
+		{"neigh", func(c cmd) error { return printJSON(c, Neigh{Dst: net.IPv4(10, 0, 0, 1)}) }},
+		{"neighs", func(c cmd) error { return printJSON(c, []Neigh{{Dst: net.IPv4(10, 0, 0, 1)}}) }},
+		{"route", func(c cmd) error { return printJSON(c, Route{Dst: "default"}) }},
+		{"routes", func(c cmd) error { return printJSON(c, []Route{{Dst: "default"}}) }},
+		{"tunnel", func(c cmd) error { return printJSON(c, Tunnel{IfName: "gre0"}) }},
+		{"tunnels", func(c cmd) error { return printJSON(c, []Tunnel{{IfName: "gre0"}}) }},
+		{"tuntap", func(c cmd) error { return printJSON(c, Tuntap{IfName: "tap0"}) }},
+		{"tuntaps", func(c cmd) error { return printJSON(c, []Tuntap{{IfName: "tap0"}}) }},
+		{"rules", func(c cmd) error { return printJSON(c, []Rule{{Priority: 32766}}) }},
+		{"nexthops", func(c cmd) error { return printJSON(c, []Nexthop{{ID: 1}}) }},
+		{"netns", func(c cmd) error { return printJSON(c, []Netns{{Name: "red", NSID: &nsid}}) }},
+		{"monitor", func(c cmd) error { return printJSON(c, MonitorEvent{Type: "link"}) }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, pretty := range []bool{false, true} {
+				var out bytes.Buffer
+				if err := tt.print(cmd{Out: &out, Opts: flags{JSON: true, Prettify: pretty}}); err != nil {
+					t.Fatal(err)
+				}
+				if indented := strings.Contains(out.String(), "\n    "); indented != pretty {
+					t.Errorf("printJSON() with Prettify %t = %s, indented %t", pretty, &out, indented)
+				}
+				if !json.Valid(out.Bytes()) {
+					t.Errorf("printJSON() = %s, not valid JSON", &out)
+				}
+			}
+		})
+	}
+}
+
+func TestPrintJSONSorted(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		print func(cmd) error
+		want  string
+	}{
+		{
+			name: "links",
+			print: func(c cmd) error {
+				return printJSON(c, []Link{{IfIndex: 3, IfName: "eth1"}, {IfIndex: 1, IfName: "lo"}, {IfIndex: 2, IfName: "eth0"}})
+			},
+			want: `"lo".*"eth0".*"eth1"`,
+		},
+		{
+			name: "routes",
+			print: func(c cmd) error {
+				return printJSON(c, []Route{{Dst: "10.0.0.0/8", Dev: "eth1", table: 254}, {Dst: "10.0.0.0/8", Dev: "eth0", table: 100}, {Dst: "10.0.0.0/24", Dev: "eth2"}})
+			},
+			want: `"10.0.0.0/24".*"eth0".*"eth1"`,
+		},
+		{
+			name: "neighs",
+			print: func(c cmd) error {
+				return printJSON(c, []Neigh{{Dst: net.IPv4(10, 0, 0, 10), Dev: "eth0"}, {Dst: net.IPv4(10, 0, 0, 2), Dev: "eth1"}, {Dst: net.IPv4(10, 0, 0, 2), Dev: "eth0"}})
+			},
+			want: `"10.0.0.2","dev":"eth0".*"10.0.0.2","dev":"eth1".*"10.0.0.10"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := tt.print(cmd{Out: &out, Opts: flags{JSON: true, Sorted: true}}); err != nil {
+				t.Fatal(err)
+			}
+			if !regexp.MustCompile(tt.want).Match(out.Bytes()) {
+				t.Errorf("printJSON() = %s, want it sorted as %s", &out, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintJSONEnvelope(t *testing.T) {
+	nsid := 1
+	for _, tt := range []struct {
+		kind  string
+		print func(cmd) error
+		items int
+	}{
+		{"link", func(c cmd) error { return printJSON(c, Link{IfName: "eth0"}) }, 1},
+		{"link", func(c cmd) error { return printJSON(c, []Link{{IfName: "eth0"}, {IfName: "eth1"}}) }, 2},
+		{"vrf", func(c cmd) error { return printJSON(c, []Vrf{{Name: "blue", Table: 10}}) }, 1},
+		{"neigh", func(c cmd) error { return printJSON(c, []Neigh{{Dst: net.IPv4(10, 0, 0, 1)}}) }, 1},
+		{"route", func(c cmd) error { return printJSON(c, []Route{}) }, 0},
+		{"rule", func(c cmd) error { return printJSON(c, []Rule(nil)) }, 0},
+		{"tunnel", func(c cmd) error { return printJSON(c, Tunnel{IfName: "gre0"}) }, 1},
+		{"tuntap", func(c cmd) error { return printJSON(c, []Tuntap{{IfName: "tap0"}}) }, 1},
+		{"rule", func(c cmd) error { return printJSON(c, []Rule{{Priority: 32766}}) }, 1},
+		{"nexthop", func(c cmd) error { return printJSON(c, []Nexthop{{ID: 1}}) }, 1},
+		{"netns", func(c cmd) error { return printJSON(c, []Netns{{Name: "red", NSID: &nsid}}) }, 1},
+		{"monitor", func(c cmd) error { return printJSON(c, MonitorEvent{Type: "link"}) }, 1},
+	} {
+		t.Run(tt.kind, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := tt.print(cmd{Out: &out, Opts: flags{JSON: true, Envelope: true}}); err != nil {
+				t.Fatal(err)
+			}
+			var got struct {
+				Kind  string            `json:"kind"`
+				Items []json.RawMessage `json:"items"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+				t.Fatalf("printJSON() = %s: %v", &out, err)
+			}
+			if got.Kind != tt.kind || len(got.Items) != tt.items {
+				t.Errorf("printJSON() = %s, want kind %q and %d items", &out, tt.kind, tt.items)
+			}
+			if tt.items == 0 && !strings.Contains(out.String(), `"items":[]`) {
+				t.Errorf("printJSON() = %s, want an empty list of items", &out)
+			}
+		})
+	}
+}
+
+func TestResolveLink(t *testing.T) {
+	lo := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "lo", Index: 1}}
+	eth0 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}}
+	dup1 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "dup", Index: 3}}
+	dup2 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "dup", Index: 4}}
+	cmd := cmd{linkCache: newLinkCache([]netlink.Link{lo, eth0, dup1, dup2})}
+
+	tests := []struct {
+		name      string
+		wantIndex int
+		wantErr   string
+	}{
+		{name: "eth0", wantIndex: 2},
+		{name: "lo", wantIndex: 1},
+		{name: "eth9", wantErr: `Cannot find device "eth9"`},
+		{name: "dup", wantErr: `device name "dup" is ambiguous: 2 links`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cmd.resolveLink(tt.name)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("resolveLink(%q) error = %v, want %q", tt.name, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.wantIndex {
+				t.Fatalf("resolveLink(%q) = %d, %v, want %d", tt.name, got, err, tt.wantIndex)
+			}
+			if name, err := cmd.linkName(got); err != nil || name != tt.name {
+				t.Errorf("linkName(%d) = %q, %v, want %q", got, name, err, tt.name)
+			}
+		})
+	}
+
+	if _, err := cmd.linkName(9); err == nil {
+		t.Errorf("linkName(9) = nil error, want error")
+	}
+
+	var notFound deviceNotFoundError
+	if _, err := cmd.lookupLink("eth9"); !errors.As(err, &notFound) {
+		t.Errorf("lookupLink(eth9) error = %v, want deviceNotFoundError", err)
+	}
+}
+
+func TestDevName(t *testing.T) {
+	eth0 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}}
+	cmd := cmd{linkCache: newLinkCache([]netlink.Link{eth0})}
+
+	for _, tt := range []struct {
+		index int
+		want  string
+	}{
+		{index: 2, want: "eth0"},
+		{index: 0, want: ""},
+		{index: 9, want: "if9"},
+	} {
+		if got, err := cmd.devName(tt.index); err != nil || got != tt.want {
+			t.Errorf("devName(%d) = %q, %v, want %q", tt.index, got, err, tt.want)
+		}
+	}
+}
@@ -99,3 +99,35 @@ func TestPrintVrf(t *testing.T) {
 		})
 	}
 }
+
+// TestPrintVrfGolden compares ip -json vrf show with the keys and layout
+// iproute2 prints for VRFs made by:
+//
+//	ip link add vrf-blue type vrf table 10
+//	ip link add vrf-red type vrf table 20
+func TestPrintVrfGolden(t *testing.T) {
+	links := []netlink.Link{
+		&netlink.Vrf{LinkAttrs: netlink.LinkAttrs{Name: "vrf-blue"}, Table: 10},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}},
+		&netlink.Vrf{LinkAttrs: netlink.LinkAttrs{Name: "vrf-red"}, Table: 20},
+	}
+	for _, tt := range []struct {
+		name   string
+		links  []netlink.Link
+		golden string
+	}{
+		{"vrfs", links, readGolden(t, "vrf.json")},
+		{"none", links[1:2], "[]"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c := cmd{Out: &out, Opts: flags{JSON: true}}
+			if err := c.printVrf(tt.links); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.golden {
+				t.Errorf("printVrf() JSON = %s, want %s", &out, tt.golden)
+			}
+		})
+	}
+}
@@ -0,0 +1,1093 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Additional tags required for specific commands. Assume command names unique
+// despite being in different directories.
+var addBuildTags = map[string][]string{
+	"bzimage":  {"noasm"},
+	"console":  {"noasm"},
+	"gzip":     {"noasm"},
+	"init":     {"noasm"},
+	"insmod":   {"noasm"},
+	"kconf":    {"noasm"},
+	"modprobe": {"noasm"},
+	"rmmod":    {"noasm"},
+}
+
+// allowedBuildTags are the tags addBuildTags may give a command. Adding to
+// them is a policy decision, so unknown tags are rejected unless
+// -allow-any-tag is set.
+var allowedBuildTags = []string{
+	"math_big_pure_go",
+	"netgo",
+	"noasm",
+	"osusergo",
+	"purego",
+}
+
+// checkBuildTags returns an error naming the commands in tags given a tag not
+// in allowedBuildTags.
+func checkBuildTags(tags map[string][]string) error {
+	var bad []string
+	for cmd, cmdTags := range tags {
+		for _, tag := range cmdTags {
+			if !slices.Contains(allowedBuildTags, tag) {
+				bad = append(bad, fmt.Sprintf("%s: %q", cmd, tag))
+			}
+		}
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+	sort.Strings(bad)
+	return fmt.Errorf("build tags not in the allowlist %v (use -allow-any-tag to allow them): %s", allowedBuildTags, strings.Join(bad, ", "))
+}
+
+// Tags tinygo sets for GOOS=linux GOARCH=amd64, plus tinygo.enable so that
+// packages which already carry the constraint are still considered.
+var tinygoTags = []string{
+	"tinygo",
+	"tinygo.enable",
+	"purego",
+	"math_big_pure_go",
+	"gc.precise",
+	"scheduler.tasks",
+	"serial.none",
+}
+
+// defaultTarget is the GOOS/GOARCH pair built unless -targets is given.
+const defaultTarget = "linux/amd64"
+
+// targetEnv returns the environment for building for target, a GOOS/GOARCH
+// pair.
+func targetEnv(target string) []string {
+	if target == "" {
+		target = defaultTarget
+	}
+	goos, goarch, _ := strings.Cut(target, "/")
+	return []string{"GOOS=" + goos, "CGO_ENABLED=0", "GOARCH=" + goarch}
+}
+
+// buildEnv returns the environment the commands building for cfg.target
+// add to that of tinygoize: that of targetEnv and, with -cache-dir, the Go
+// cache in its go subdirectory and, as tinygo keeps its cache in the user
+// cache directory, XDG_CACHE_HOME pointing at it.
+func buildEnv(cfg config) []string {
+	env := targetEnv(cfg.target)
+	if cfg.cacheDir != "" {
+		env = append(env, "GOCACHE="+filepath.Join(cfg.cacheDir, "go"), "XDG_CACHE_HOME="+cfg.cacheDir)
+	}
+	return env
+}
+
+// commandName returns the name of the command in dir, the key of
+// addBuildTags and addRuntimeOptions.
+func commandName(dir string) string {
+	parts := strings.Split(dir, "/")
+	return parts[len(parts)-1]
+}
+
+// buildTags returns the additional tags needed to build the command in dir.
+func buildTags(dir string) []string {
+	return addBuildTags[commandName(dir)]
+}
+
+// runtimeOptions select the tinygo scheduler and garbage collector of a
+// command. Empty fields keep the defaults of the target.
+type runtimeOptions struct {
+	scheduler string // -scheduler, e.g. "none"
+	gc        string // -gc, e.g. "leaking"
+}
+
+// Non-default runtime options required for specific commands, keyed like
+// addBuildTags.
+var addRuntimeOptions = map[string]runtimeOptions{}
+
+// runtimeOpts returns the runtime options of the command in dir.
+func runtimeOpts(dir string) runtimeOptions {
+	return addRuntimeOptions[commandName(dir)]
+}
+
+// args returns the `tinygo build` flags selecting o.
+func (o runtimeOptions) args() []string {
+	var args []string
+	if o.scheduler != "" {
+		args = append(args, "-scheduler", o.scheduler)
+	}
+	if o.gc != "" {
+		args = append(args, "-gc", o.gc)
+	}
+	return args
+}
+
+// String returns the flags selecting o, e.g. "-scheduler none", or "".
+func (o runtimeOptions) String() string {
+	return strings.Join(o.args(), " ")
+}
+
+// goTags returns tinygoTags plus tags, with the scheduler and gc tags tinygo
+// sets by default replaced by those selecting o, so that `go` evaluates build
+// constraints as tinygo does.
+func (o runtimeOptions) goTags(tags ...string) []string {
+	goTags := make([]string, 0, len(tinygoTags)+len(tags))
+	for _, tag := range tinygoTags {
+		switch {
+		case o.scheduler != "" && strings.HasPrefix(tag, "scheduler."):
+			tag = "scheduler." + o.scheduler
+		case o.gc != "" && strings.HasPrefix(tag, "gc."):
+			tag = "gc." + o.gc
+		}
+		goTags = append(goTags, tag)
+	}
+	return append(goTags, tags...)
+}
+
+// BuildResult is the outcome of building a single package.
+type BuildResult struct {
+	dir      string
+	tags     []string
+	excluded bool
+	// err is non-nil if tinygo failed to build the package.
+	err    error
+	output []byte
+	// constrained is the number of .go files carrying the tinygo
+	// constraint before this run, out of files.
+	constrained, files int
+	// warnings are the lines of output matching a warning pattern, see
+	// -warnings.
+	warnings []string
+	// cacheRetry is set if the package was built again after clearing
+	// the tinygo cache, see -retry-clear-cache.
+	cacheRetry bool
+	// parallelOnly is set if the package failed to build alongside
+	// others, but built on its own, see -confirm-failures.
+	parallelOnly bool
+	// implicated are the source files named in the errors of a failing
+	// build, see -implicated-files.
+	implicated []string
+	// duration is how long tinygo took, or 0 if the package was not built,
+	// e.g. because the result was cached.
+	duration time.Duration
+	// durations are those of all builds of the package with -repeat, or
+	// nil.
+	durations []time.Duration
+	// size is the size of the binary kept with -o-dir, or 0.
+	size int64
+	// runtime are the non-default runtime options the package was built
+	// with, see addRuntimeOptions.
+	runtime runtimeOptions
+}
+
+// needsConstraint reports whether a failing package lacks the constraint in
+// some of its files.
+func (br BuildResult) needsConstraint() bool {
+	return br.constrained < br.files
+}
+
+// WorkerResult is what a worker reports for a single directory.
+type WorkerResult struct {
+	br BuildResult
+	// modified lists the files whose constraints were (or, in check-only
+	// mode, would be) changed.
+	modified []string
+	// staleConstraint is set for EXCLUDED packages that carry the tinygo
+	// constraint.
+	staleConstraint bool
+	// err is a tool error, e.g. a file that could not be parsed. It is a
+	// *BuildError.
+	err error
+}
+
+// The phases of processing a package, see BuildError.
+const (
+	// PhaseParse reads the files of a package and their constraints, and
+	// checks whether it is excluded.
+	PhaseParse = "parse"
+	// PhaseBuild builds a package with tinygo.
+	PhaseBuild = "build"
+	// PhaseFixup rewrites the constraints of a package.
+	PhaseFixup = "fixup"
+)
+
+// BuildError is a tool error processing the package in Dir, listed as a TOOL
+// ERROR. Phase is empty if it is not known, e.g. for a panic.
+type BuildError struct {
+	Dir   string
+	Phase string
+	Err   error
+}
+
+func (e *BuildError) Error() string {
+	if e.Phase == "" {
+		return fmt.Sprintf("%s: %v", e.Dir, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Dir, e.Phase, e.Err)
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// buildError returns err as a *BuildError of dir in phase, or nil if err is
+// nil.
+func buildError(dir, phase string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &BuildError{Dir: dir, Phase: phase, Err: err}
+}
+
+// BuildStatus tracks the set of passing, failing, and excluded commands.
+type BuildStatus struct {
+	passing []BuildResult
+	// passingWarnings are the passing commands whose build emitted
+	// warnings, see -warnings.
+	passingWarnings []BuildResult
+	failing         []BuildResult
+	excluded        []BuildResult
+	// empty are the directories without non-test .go files, which are
+	// neither built nor fixed up.
+	empty         []BuildResult
+	staleExcluded []string
+	modified      []string
+	errors        []error
+	// noSpace is set if the builds were stopped for lack of disk space.
+	noSpace bool
+	// targets holds the status of each target when building a matrix.
+	targets []TargetStatus
+	// tagImpacts are the effects of the additional build tags, see
+	// -tag-impact.
+	tagImpacts []tagImpact
+	// known are the -known-failures entries of the FAILING packages, by
+	// dir, see matchKnown.
+	known map[string]knownFailure
+}
+
+// TargetStatus is the build status of one target of a matrix.
+type TargetStatus struct {
+	target string
+	status BuildStatus
+}
+
+// sortOutputs sorts every list of s, the results by dir and the errors by
+// message, so that the reports and notes rendered from it do not depend on
+// the order in which workers complete. The targets of a matrix stay in the
+// order given.
+func (s *BuildStatus) sortOutputs() {
+	for _, set := range [][]BuildResult{s.passing, s.passingWarnings, s.failing, s.excluded, s.empty} {
+		sort.SliceStable(set, func(i, j int) bool { return set[i].dir < set[j].dir })
+	}
+	sort.Strings(s.staleExcluded)
+	sort.Strings(s.modified)
+	sort.SliceStable(s.errors, func(i, j int) bool { return s.errors[i].Error() < s.errors[j].Error() })
+}
+
+// tinygoVersion returns the version reported by `tinygo version`. A
+// development build reports the commit it was built from, e.g.
+// 0.34.0-dev-1e13f4e3, but one built outside git only 0.34.0-dev, so a hash
+// of the binary is appended to keep its results apart from those of other
+// builds in reports and the cache, e.g. 0.34.0-dev+bin.5e2bf1c0a6d4.
+func tinygoVersion(tinygo string) (string, error) {
+	out, err := exec.Command(tinygo, "version").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s version: %w", tinygo, err)
+	}
+	v := strings.TrimPrefix(strings.TrimSpace(string(out)), "tinygo version ")
+	v, _, _ = strings.Cut(v, " ")
+	if strings.HasSuffix(v, "-dev") {
+		sum, err := binaryHash(tinygo)
+		if err != nil {
+			return "", err
+		}
+		v += "+bin." + sum[:12]
+	}
+	return v, nil
+}
+
+// binaryHash returns the hash of the executable tinygo, looked up in $PATH if
+// it is a bare name.
+func binaryHash(tinygo string) (string, error) {
+	path, err := exec.LookPath(tinygo)
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// tinygoBuildTags returns the build tags tinygo resolves for cfg.target, as
+// reported by `tinygo info`.
+func tinygoBuildTags(cfg config) ([]string, error) {
+	c := exec.Command(cfg.tinygo, "info")
+	c.Env = append(os.Environ(), buildEnv(cfg)...)
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s info: %w", cfg.tinygo, err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if tags, ok := strings.CutPrefix(line, "build tags:"); ok {
+			return strings.Fields(tags), nil
+		}
+	}
+	return nil, fmt.Errorf("%s info: no build tags reported", cfg.tinygo)
+}
+
+// checkToolchain returns an error if tinygo builds against another GOROOT
+// than the go running the exclusion checks of isExcluded, whose results may
+// then disagree with the builds. The checks cannot run through tinygo
+// instead, as it has no equivalent of `go build -n`. A tinygo not reporting
+// its GOROOT is not checked.
+func checkToolchain(tinygo string) error {
+	out, err := exec.Command(tinygo, "env", "GOROOT").Output()
+	if err != nil {
+		return fmt.Errorf("%s env GOROOT: %w", tinygo, err)
+	}
+	tinygoRoot := strings.TrimSpace(string(out))
+	if tinygoRoot == "" {
+		return nil
+	}
+	out, err = exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		return fmt.Errorf("go env GOROOT: %w", err)
+	}
+	goRoot := strings.TrimSpace(string(out))
+	if sameDir(tinygoRoot, goRoot) {
+		return nil
+	}
+	return fmt.Errorf("%s uses GOROOT %s, but go uses %s", tinygo, tinygoRoot, goRoot)
+}
+
+// sameDir reports whether a and b name the same directory, following
+// symlinks.
+func sameDir(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ra, errA := filepath.EvalSymlinks(a)
+	rb, errB := filepath.EvalSymlinks(b)
+	return errA == nil && errB == nil && ra == rb
+}
+
+// isExcluded checks (via `go build -n`) if the package in dir is excluded by
+// build constraints, given the scheduler and gc it is built with.
+func isExcluded(cfg config, dir string) (bool, error) {
+	tags := runtimeOpts(dir).goTags(buildTags(dir)...)
+	c := exec.Command("go", "build", "-n", "-tags", strings.Join(tags, ","))
+	c.Dir = dir
+	c.Env = append(os.Environ(), buildEnv(cfg)...)
+	out, err := c.CombinedOutput()
+	if err == nil {
+		return false, nil
+	}
+	if bytes.Contains(out, []byte("build constraints exclude all Go files")) {
+		return true, nil
+	}
+	return false, fmt.Errorf("go build -n: %w\n%s", err, out)
+}
+
+// defaultWarningPatterns match the warnings of tinygo and its linker, used
+// unless -warning-pattern is given.
+var defaultWarningPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\bwarning:`),
+}
+
+// errWarnings marks a build that succeeded with warnings, with
+// -warnings-as-failures.
+var errWarnings = errors.New("build emitted warnings")
+
+// scanWarnings returns the lines of output matching any of patterns.
+func scanWarnings(patterns []*regexp.Regexp, output []byte) []string {
+	var warnings []string
+	for _, line := range strings.Split(string(output), "\n") {
+		for _, re := range patterns {
+			if re.MatchString(line) {
+				warnings = append(warnings, strings.TrimSpace(line))
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+// implicatedPattern matches a source position in the output of a build,
+// e.g. "../../pkg/foo/foo.go:12:3:".
+var implicatedPattern = regexp.MustCompile(`(?m)(?:^|\s)([^\s:]+\.go):\d+(?::\d+)?:`)
+
+// implicatedFiles returns the source files named in output of a build in dir,
+// in the order they are first named. tinygo runs in dir, so relative paths
+// are joined to it.
+func implicatedFiles(dir string, output []byte) []string {
+	var files []string
+	for _, m := range implicatedPattern.FindAllSubmatch(output, -1) {
+		file := string(m[1])
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		file = filepath.ToSlash(file)
+		if !slices.Contains(files, file) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// errNoArtifact marks a build that succeeded without producing a binary.
+var errNoArtifact = errors.New("no artifact produced")
+
+// artifactPath returns where the binary for dir is written, or "" if
+// binaries are not kept.
+func artifactPath(cfg config, dir string) (string, error) {
+	if cfg.outDir == "" {
+		return "", nil
+	}
+	outDir := cfg.outDir
+	if len(cfg.targets) > 1 {
+		// Keep the binaries of each target apart, e.g. bin/linux_arm64.
+		outDir = filepath.Join(outDir, strings.ReplaceAll(cfg.target, "/", "_"))
+	}
+	// tinygo runs in dir, so the path must not be relative.
+	return filepath.Abs(filepath.Join(outDir, dir))
+}
+
+// build runs `tinygo build` in dir.
+func build(cfg config, dir string) BuildResult {
+	return buildWithTags(cfg, dir, buildTags(dir))
+}
+
+// buildCommand returns the `tinygo build` command for the package of br,
+// with its tags and runtime options, and the path of the binary it writes,
+// or "" if binaries are not kept.
+func buildCommand(cfg config, br BuildResult) (*exec.Cmd, string, error) {
+	tags := append([]string{"tinygo.enable"}, br.tags...)
+	args := append([]string{"build", "-tags", strings.Join(tags, ",")}, br.runtime.args()...)
+	artifact, err := artifactPath(cfg, br.dir)
+	if err == nil && artifact != "" {
+		err = os.MkdirAll(filepath.Dir(artifact), 0o755)
+		args = append(args, "-o", artifact)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	c := exec.Command(cfg.tinygo, args...)
+	c.Dir = br.dir
+	c.Env = append(os.Environ(), buildEnv(cfg)...)
+	return c, artifact, nil
+}
+
+// buildWithTags runs `tinygo build` in dir with the additional tags given
+// rather than those of addBuildTags, and the runtime options of
+// addRuntimeOptions.
+func buildWithTags(cfg config, dir string, addTags []string) BuildResult {
+	br := BuildResult{dir: dir, tags: addTags, runtime: runtimeOpts(dir)}
+	c, artifact, err := buildCommand(cfg, br)
+	if err != nil {
+		br.err = err
+		return br
+	}
+
+	start := time.Now()
+	br.output, br.err = c.CombinedOutput()
+	br.duration = time.Since(start)
+	if br.err != nil && isNoSpace(br.output) {
+		br.err = fmt.Errorf("%w: %v", errNoSpace, br.err)
+		return br
+	}
+
+	br.output = truncateOutput(br.output, cfg.maxOutput)
+
+	// A misconfigured build may exit 0 without writing anything.
+	if br.err == nil && artifact != "" {
+		if fi, err := os.Stat(artifact); err != nil || fi.Size() == 0 {
+			br.err = errNoArtifact
+		} else {
+			br.size = fi.Size()
+		}
+	}
+	return br
+}
+
+// truncateOutput returns the last limit bytes of output, starting at a line,
+// after a note of how much was cut. The tail is kept as it usually holds the
+// error. A limit of 0 keeps all of output.
+func truncateOutput(output []byte, limit int) []byte {
+	if limit <= 0 || len(output) <= limit {
+		return output
+	}
+	tail := output[len(output)-limit:]
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 && i < len(tail)-1 {
+		tail = tail[i+1:]
+	}
+	note := fmt.Sprintf("[%d bytes of output truncated, see -max-output]\n", len(output)-len(tail))
+	return append([]byte(note), tail...)
+}
+
+// cacheCorruptPatterns match the errors of builds failing because of a
+// corrupt tinygo cache rather than because of the package.
+var cacheCorruptPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)cache.*(corrupt|unexpected EOF|checksum|malformed)`),
+	regexp.MustCompile(`(?i)(invalid|malformed) bitcode`),
+	regexp.MustCompile(`(?i)file too short`),
+}
+
+// isCacheCorrupt reports whether output of a failed build points at a corrupt
+// tinygo cache.
+func isCacheCorrupt(output []byte) bool {
+	for _, re := range cacheCorruptPatterns {
+		if re.Match(output) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheCleaner clears the tinygo cache on behalf of all workers, once per
+// corruption: builds that fail because of a corrupt cache after it was
+// cleared only retry.
+type cacheCleaner struct {
+	mu         sync.Mutex
+	generation int // number of times the cache was cleared
+}
+
+// cleared returns the number of times the cache was cleared.
+func (c *cacheCleaner) cleared() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// clear runs `tinygo clean`, which removes the cache tinygo uses, e.g. as
+// set by -cache-dir, unless it was cleared after generation.
+func (c *cacheCleaner) clear(cfg config, generation int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return nil
+	}
+	cmd := exec.Command(cfg.tinygo, "clean")
+	cmd.Env = append(os.Environ(), buildEnv(cfg)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s clean: %w\n%s", cfg.tinygo, err, out)
+	}
+	c.generation++
+	return nil
+}
+
+// retryBuild builds dir, and if the build fails because of a corrupt tinygo
+// cache, clears the cache and builds it once more.
+func retryBuild(cfg config, dir string) BuildResult {
+	if cfg.cleaner == nil {
+		return build(cfg, dir)
+	}
+	generation := cfg.cleaner.cleared()
+	br := build(cfg, dir)
+	if br.err == nil || errors.Is(br.err, errNoSpace) || !isCacheCorrupt(br.output) {
+		return br
+	}
+	if err := cfg.cleaner.clear(cfg, generation); err != nil {
+		log.Printf("not retrying %s: %v", dir, err)
+		return br
+	}
+	if cfg.verbose {
+		log.Printf("%s: retrying with a cleared tinygo cache", dir)
+	}
+	first := br.duration
+	br = build(cfg, dir)
+	br.cacheRetry = true
+	br.duration += first
+	return br
+}
+
+// cachedBuild returns the cached result of building dir, building it if
+// there is none.
+func cachedBuild(cfg config, dir string) BuildResult {
+	if cfg.cache == nil {
+		return retryBuild(cfg, dir)
+	}
+	key, err := cfg.cache.key(cfg, dir)
+	if err != nil {
+		// Without a key, the result can be neither looked up nor stored.
+		if cfg.verbose {
+			log.Printf("%v", err)
+		}
+		return retryBuild(cfg, dir)
+	}
+	if br, ok := cfg.cache.get(key, dir); ok {
+		return br
+	}
+	br := retryBuild(cfg, dir)
+	// The next run may have the disk space this one lacked.
+	if !errors.Is(br.err, errNoSpace) {
+		cfg.cache.put(key, br)
+	}
+	return br
+}
+
+// processDir builds a single directory and fixes up its constraints.
+func processDir(cfg config, dir string) WorkerResult {
+	excluded, err := isExcluded(cfg, dir)
+	if err != nil {
+		return WorkerResult{br: BuildResult{dir: dir}, err: buildError(dir, PhaseParse, err)}
+	}
+	if cfg.verbose {
+		ignored, err := ignoredFiles(dir)
+		if err != nil {
+			return WorkerResult{br: BuildResult{dir: dir}, err: buildError(dir, PhaseParse, err)}
+		}
+		for _, file := range ignored {
+			log.Printf("%s: %v", file, errIgnored)
+		}
+	}
+	if excluded {
+		res := WorkerResult{br: BuildResult{dir: dir, tags: buildTags(dir), runtime: runtimeOpts(dir), excluded: true}}
+		// Excluded packages need no constraint work, but a leftover
+		// tinygo constraint implies they are tinygo-relevant.
+		stale, err := pkgHasConstraint(dir)
+		if err != nil {
+			res.err = buildError(dir, PhaseParse, err)
+			return res
+		}
+		res.staleConstraint = stale
+		if stale && cfg.stripExcluded {
+			res.modified, err = fixupPkgConstraints(dir, cfg.gateFile, cfg.fileMode, cfg.changed, true, cfg.checkOnly)
+			res.err = buildError(dir, PhaseFixup, err)
+		}
+		return res
+	}
+
+	constrained, files, err := countConstraints(dir)
+	if err != nil {
+		return WorkerResult{br: BuildResult{dir: dir}, err: buildError(dir, PhaseParse, err)}
+	}
+	res := WorkerResult{br: cachedBuild(cfg, dir)}
+	if cfg.repeat > 1 && !errors.Is(res.br.err, errNoSpace) {
+		res.br = repeatBuild(cfg, dir, res.br)
+	}
+	if errors.Is(res.br.err, errNoSpace) {
+		// Not a tinygo failure, so leave the constraints alone.
+		return WorkerResult{br: BuildResult{dir: dir}, err: buildError(dir, PhaseBuild, fmt.Errorf("%w\n%s", res.br.err, res.br.output))}
+	}
+	res.br.constrained, res.br.files = constrained, files
+	if res.br.err == nil && (cfg.warnings || cfg.warningsAsFailures) {
+		patterns := cfg.warningPatterns
+		if len(patterns) == 0 {
+			patterns = defaultWarningPatterns
+		}
+		res.br.warnings = scanWarnings(patterns, res.br.output)
+		if len(res.br.warnings) > 0 && cfg.warningsAsFailures {
+			res.br.err = errWarnings
+		}
+	}
+	if res.br.err != nil && cfg.implicatedFiles {
+		res.br.implicated = implicatedFiles(dir, res.br.output)
+	}
+	if res.br.err != nil && cfg.confirmFailures {
+		// The constraints are fixed up once the failure is confirmed.
+		return res
+	}
+	res.modified, err = fixupPkgConstraints(dir, cfg.gateFile, cfg.fileMode, cfg.changed, res.br.err == nil, cfg.checkOnly)
+	res.err = buildError(dir, PhaseFixup, err)
+	return res
+}
+
+// worker processes tasks until they run out. It starts taking tasks once warm
+// is closed.
+func worker(cfg config, id int, warm <-chan struct{}, tasks <-chan string, results chan<- WorkerResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+	<-warm
+	for dir := range tasks {
+		if cfg.verbose {
+			log.Printf("[%d] %s", id, dir)
+		}
+		if err := checkDiskSpace(cfg); err != nil {
+			results <- WorkerResult{br: BuildResult{dir: dir}, err: buildError(dir, PhaseBuild, err)}
+			continue
+		}
+		done := make(chan struct{})
+		if cfg.verbose && cfg.heartbeatInterval > 0 {
+			go heartbeat(id, dir, cfg.heartbeatAfter, cfg.heartbeatInterval, done)
+		}
+		res := recoverDir(dir, func() WorkerResult { return processDir(cfg, dir) })
+		close(done)
+		results <- res
+	}
+}
+
+// heartbeat logs that worker id is still building dir every interval, once
+// after has passed, until done is closed. It tells a slow build from a hung
+// run.
+func heartbeat(id int, dir string, after, interval time.Duration, done <-chan struct{}) {
+	start := time.Now()
+	timer := time.NewTimer(after)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		log.Printf("[%d] %s still building (%ds elapsed)", id, dir, int(time.Since(start).Seconds()))
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recoverDir returns the result of process for dir, or a tool error with the
+// stack if process panics, e.g. on a malformed file, so that a single package
+// cannot end the run.
+func recoverDir(dir string, process func() WorkerResult) (res WorkerResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			res = WorkerResult{br: BuildResult{dir: dir}, err: &BuildError{Dir: dir, Err: fmt.Errorf("panic: %v\n%s", r, debug.Stack())}}
+		}
+	}()
+	return process()
+}
+
+// buildDirs builds dirs with cfg.jobs workers and collects the results. The
+// first cfg.ramp dirs are built by a single worker to warm the build cache
+// before the others join in. Once the disk runs short of space, no more
+// builds are started.
+func buildDirs(cfg config, dirs []string) BuildStatus {
+	tasks := make(chan string)
+	results := make(chan WorkerResult)
+	stop := make(chan struct{})
+
+	// The first worker starts right away, the others once warm is closed.
+	ramp := min(cfg.ramp, len(dirs))
+	started, warm := make(chan struct{}), make(chan struct{})
+	close(started)
+	if ramp <= 0 {
+		close(warm)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.jobs; i++ {
+		wg.Add(1)
+		if i == 0 {
+			go worker(cfg, i, started, tasks, results, &wg)
+		} else {
+			go worker(cfg, i, warm, tasks, results, &wg)
+		}
+	}
+	go func() {
+		defer close(tasks)
+		for _, dir := range dirs {
+			select {
+			case tasks <- dir:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var status BuildStatus
+	done := 0
+	summary := progressSummary{total: len(dirs), last: time.Now(), interval: cfg.progressInterval}
+	// Only a terminal gets a line per package, redrawn in place.
+	terminal := isTerminal(os.Stderr)
+	var redraw redrawThrottle
+	// Every result is read, even after an error: the loop ends once the
+	// workers have finished and closed results, so none is left blocked
+	// sending. Out of disk space, stop ends the dispatch instead.
+	for res := range results {
+		done++
+		if done == ramp && !status.noSpace {
+			close(warm)
+		}
+		status.add(res)
+		if errors.Is(res.err, errNoSpace) && !status.noSpace {
+			status.noSpace = true
+			close(stop)
+			// Release the workers still waiting for the ramp.
+			if done < ramp {
+				close(warm)
+			}
+		}
+		// Failures to confirm are streamed once confirmed.
+		if cfg.stream != nil && !(cfg.confirmFailures && res.err == nil && res.br.err != nil) {
+			cfg.stream.addResult(cfg, res)
+		}
+		if cfg.verbose && terminal && redraw.due(done, len(dirs), time.Now()) {
+			progress(done, len(dirs), res)
+		}
+		if line, ok := summary.update(done, res, time.Now()); ok && cfg.verbose && !terminal {
+			log.Print(line)
+		}
+	}
+	if cfg.confirmFailures && !status.noSpace {
+		confirmFailures(cfg, &status)
+	}
+	status.sortOutputs()
+	return status
+}
+
+// add records res in s.
+func (s *BuildStatus) add(res WorkerResult) {
+	s.modified = append(s.modified, res.modified...)
+	switch {
+	case res.err != nil:
+		s.errors = append(s.errors, res.err)
+	case res.br.excluded:
+		s.excluded = append(s.excluded, res.br)
+		if res.staleConstraint {
+			s.staleExcluded = append(s.staleExcluded, res.br.dir)
+		}
+	case res.br.err != nil:
+		s.failing = append(s.failing, res.br)
+	case len(res.br.warnings) > 0:
+		s.passingWarnings = append(s.passingWarnings, res.br)
+	default:
+		s.passing = append(s.passing, res.br)
+	}
+}
+
+// confirmFailures builds the FAILING packages of status again, one at a time
+// with no other build running, and fixes up their constraints, see
+// -confirm-failures. Packages that build on their own only failed for the
+// contention of the parallel builds, e.g. for memory, and are reported as
+// building, noted as failing only under parallelism.
+func confirmFailures(cfg config, status *BuildStatus) {
+	failing := status.failing
+	status.failing = nil
+	if cfg.verbose && len(failing) > 0 {
+		log.Printf("confirming %d failures by building them one at a time", len(failing))
+	}
+	c := cfg
+	c.confirmFailures = false
+	// The cache holds the failure to confirm.
+	c.cache = nil
+	for _, br := range failing {
+		dir := br.dir
+		if err := checkDiskSpace(c); err != nil {
+			status.add(WorkerResult{br: BuildResult{dir: dir}, err: buildError(dir, PhaseBuild, err)})
+			status.noSpace = true
+			return
+		}
+		res := recoverDir(dir, func() WorkerResult { return processDir(c, dir) })
+		if res.err == nil && !res.br.excluded && res.br.err == nil {
+			res.br.parallelOnly = true
+			if cfg.verbose {
+				log.Printf("%s: failed only under parallelism", dir)
+			}
+		}
+		status.add(res)
+		if cfg.stream != nil {
+			cfg.stream.addResult(cfg, res)
+		}
+		if errors.Is(res.err, errNoSpace) {
+			status.noSpace = true
+			return
+		}
+	}
+}
+
+// buildMatrix builds dirs for each of cfg.targets and then fixes up the
+// constraints of the combined result: a package builds if it builds on every
+// target that does not exclude it, and is excluded if all targets exclude it.
+func buildMatrix(cfg config, dirs []string) BuildStatus {
+	var status BuildStatus
+	for _, target := range cfg.targets {
+		// Constraints are fixed up once all targets are built.
+		c := cfg
+		c.target, c.checkOnly = target, true
+		ts := buildDirs(c, dirs)
+		for _, err := range ts.errors {
+			status.errors = append(status.errors, fmt.Errorf("%s: %w", target, err))
+		}
+		status.targets = append(status.targets, TargetStatus{target: target, status: ts})
+		if ts.noSpace {
+			status.noSpace = true
+			break
+		}
+	}
+
+	// Tally the results of each package across the targets.
+	type tally struct {
+		br                     BuildResult
+		seen, failed, excluded int
+		stale                  bool
+	}
+	tallies := make(map[string]*tally)
+	get := func(dir string) *tally {
+		if tallies[dir] == nil {
+			tallies[dir] = &tally{}
+		}
+		tallies[dir].seen++
+		return tallies[dir]
+	}
+	for _, ts := range status.targets {
+		for _, br := range append(slices.Clone(ts.status.passing), ts.status.passingWarnings...) {
+			// Keep the warnings of any target.
+			if t := get(br.dir); t.br.dir == "" || t.br.excluded || (t.failed == 0 && len(br.warnings) > 0) {
+				t.br = br
+			}
+		}
+		for _, br := range ts.status.failing {
+			t := get(br.dir)
+			if t.failed == 0 {
+				t.br = br
+			}
+			t.failed++
+		}
+		for _, br := range ts.status.excluded {
+			t := get(br.dir)
+			if t.br.dir == "" {
+				t.br = br
+			}
+			t.excluded++
+		}
+		for _, dir := range ts.status.staleExcluded {
+			tallies[dir].stale = true
+		}
+	}
+
+	for _, dir := range dirs {
+		// A package with a tool error on some target is only reported
+		// in the errors.
+		t, ok := tallies[dir]
+		if !ok || t.seen < len(cfg.targets) {
+			continue
+		}
+		var (
+			modified []string
+			err      error
+		)
+		switch {
+		case t.excluded == len(cfg.targets):
+			status.excluded = append(status.excluded, t.br)
+			if !t.stale {
+				continue
+			}
+			status.staleExcluded = append(status.staleExcluded, dir)
+			if cfg.stripExcluded {
+				modified, err = fixupPkgConstraints(dir, cfg.gateFile, cfg.fileMode, cfg.changed, true, cfg.checkOnly)
+			}
+		case t.failed > 0:
+			status.failing = append(status.failing, t.br)
+			modified, err = fixupPkgConstraints(dir, cfg.gateFile, cfg.fileMode, cfg.changed, false, cfg.checkOnly)
+		default:
+			if len(t.br.warnings) > 0 {
+				status.passingWarnings = append(status.passingWarnings, t.br)
+			} else {
+				status.passing = append(status.passing, t.br)
+			}
+			modified, err = fixupPkgConstraints(dir, cfg.gateFile, cfg.fileMode, cfg.changed, true, cfg.checkOnly)
+		}
+		status.modified = append(status.modified, modified...)
+		if err != nil {
+			status.errors = append(status.errors, buildError(dir, PhaseFixup, err))
+		}
+	}
+	status.sortOutputs()
+	return status
+}
+
+// progress reports completion of res on a terminal, redrawing a single line.
+func progress(done, total int, res WorkerResult) {
+	state := "PASS"
+	switch {
+	case res.err != nil:
+		state = "ERROR"
+	case res.br.excluded:
+		state = "EXCLUDED"
+	case res.br.err != nil:
+		state = "FAIL"
+	case len(res.br.warnings) > 0:
+		state = "WARN"
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[K[%d/%d] %s %s", done, total, state, res.br.dir)
+	if done == total {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// redrawInterval is the shortest time between redraws of the progress line,
+// so that a flood of cached results does not make the terminal flicker.
+const redrawInterval = 100 * time.Millisecond
+
+// redrawThrottle limits redraws of the progress line to one per
+// redrawInterval. The line of the last completion is always drawn.
+type redrawThrottle struct {
+	last time.Time
+}
+
+// due reports whether the progress line of the done-th of total results is
+// to be drawn at now.
+func (r *redrawThrottle) due(done, total int, now time.Time) bool {
+	if done < total && now.Sub(r.last) < redrawInterval {
+		return false
+	}
+	r.last = now
+	return true
+}
+
+// Progress summaries are logged at most every summaryEvery completions or
+// -progress-interval, whichever comes first.
+const summaryEvery = 10
+
+// progressSummary tracks the overall progress of a run for logs that are not
+// redrawn on a terminal, e.g. CI logs.
+type progressSummary struct {
+	total, failing int
+	// lastDone and last are the completions and time of the last
+	// summary.
+	lastDone int
+	last     time.Time
+	// interval is the time between summaries; with 0, every completion
+	// is summarized.
+	interval time.Duration
+}
+
+// update records the completion of res, the done-th result, and returns a
+// summary line if one is due at now.
+func (p *progressSummary) update(done int, res WorkerResult, now time.Time) (string, bool) {
+	if res.err == nil && !res.br.excluded && res.br.err != nil {
+		p.failing++
+	}
+	if done < p.total && done-p.lastDone < summaryEvery && now.Sub(p.last) < p.interval {
+		return "", false
+	}
+	p.lastDone, p.last = done, now
+	return fmt.Sprintf("%d%% complete (%d/%d), %d failing so far", done*100/p.total, done, p.total, p.failing), true
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
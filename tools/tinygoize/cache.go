@@ -0,0 +1,262 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// errCachedFailure is the error of a failing build taken from the cache.
+var errCachedFailure = errors.New("build failed (cached)")
+
+// errCacheLocked is returned by openCache if another run holds the cache.
+var errCacheLocked = errors.New("cache is locked by another run")
+
+// cacheEntry is a build result stored in the cache.
+type cacheEntry struct {
+	Pass   bool   `json:"pass"`
+	Output string `json:"output,omitempty"`
+}
+
+// buildCache records build results across runs, see -cache. The cache file
+// is locked while in use and replaced atomically when saved, so concurrent
+// runs never see a partial file.
+type buildCache struct {
+	path    string
+	version string // tinygo version, part of every key
+	lock    *os.File
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	dirs    map[string]string // hash of the files of each directory
+	dirty   bool
+}
+
+// openCache locks and reads the cache at path. A missing or unreadable
+// cache file starts an empty cache. If another run holds the lock,
+// errCacheLocked is returned.
+func openCache(path, version string) (*buildCache, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		lock.Close()
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return nil, errCacheLocked
+		}
+		return nil, fmt.Errorf("locking %s: %w", path, err)
+	}
+
+	c := &buildCache{
+		path:    path,
+		version: version,
+		lock:    lock,
+		entries: make(map[string]cacheEntry),
+		dirs:    make(map[string]string),
+	}
+	// A damaged cache only costs a full build.
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &c.entries); err != nil {
+			c.entries = make(map[string]cacheEntry)
+		}
+	}
+	return c, nil
+}
+
+// close saves the cache if it changed and releases the lock.
+func (c *buildCache) close() error {
+	defer c.lock.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}
+
+// get returns the cached result of building dir with key.
+func (c *buildCache) get(key, dir string) (BuildResult, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok {
+		return BuildResult{}, false
+	}
+	br := BuildResult{dir: dir, tags: buildTags(dir), runtime: runtimeOpts(dir), output: []byte(e.Output)}
+	if !e.Pass {
+		br.err = errCachedFailure
+	}
+	return br, true
+}
+
+// put records br under key.
+func (c *buildCache) put(key string, br BuildResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = cacheEntry{Pass: br.err == nil, Output: string(br.output)}
+	c.dirty = true
+}
+
+// listedPackage is a package as go list -json describes it, with the files
+// building it reads.
+type listedPackage struct {
+	Dir      string
+	Standard bool
+	Module   *struct{ GoMod string }
+
+	GoFiles, CgoFiles, SFiles, CFiles, CXXFiles, HFiles, SysoFiles, EmbedFiles []string
+}
+
+// files returns the files building p reads, relative to its directory.
+func (p listedPackage) files() []string {
+	var files []string
+	for _, f := range [][]string{p.GoFiles, p.CgoFiles, p.SFiles, p.CFiles, p.CXXFiles, p.HFiles, p.SysoFiles, p.EmbedFiles} {
+		files = append(files, f...)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// key returns the cache key of building dir: a hash of the tinygo version,
+// the target, the tags, the runtime options, the files of dir and of all its
+// non-standard dependencies, and the go.mod and go.sum files of their
+// modules. The tinygo constraint is ignored, as builds enable it.
+func (c *buildCache) key(cfg config, dir string) (string, error) {
+	tags := append([]string{"tinygo.enable"}, buildTags(dir)...)
+	runtime := runtimeOpts(dir)
+	cmd := exec.Command("go", "list", "-deps", "-json", "-tags", strings.Join(runtime.goTags(tags...), ","), ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), buildEnv(cfg)...)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: go list: %w", dir, err)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00", c.version, cfg.target, strings.Join(tags, ","), runtime)
+	modules := make(map[string]bool)
+	for dec := json.NewDecoder(bytes.NewReader(out)); dec.More(); {
+		var p listedPackage
+		if err := dec.Decode(&p); err != nil {
+			return "", fmt.Errorf("%s: go list: %w", dir, err)
+		}
+		if p.Standard {
+			continue
+		}
+		sum, err := c.dirHash(p.Dir, p.files())
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", p.Dir, sum)
+		if p.Module != nil && p.Module.GoMod != "" {
+			modules[p.Module.GoMod] = true
+		}
+	}
+
+	// The module files select the versions of the dependencies.
+	goMods := make([]string, 0, len(modules))
+	for goMod := range modules {
+		goMods = append(goMods, goMod)
+	}
+	sort.Strings(goMods)
+	for _, goMod := range goMods {
+		files := []string{filepath.Base(goMod)}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(goMod), "go.sum")); err == nil {
+			files = append(files, "go.sum")
+		}
+		sum, err := c.dirHash(filepath.Dir(goMod), files)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", goMod, sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dirHash returns the hash of files in dir, with .go files in the canonical
+// form of canonicalSource.
+func (c *buildCache) dirHash(dir string, files []string) (string, error) {
+	id := dir + "\x00" + strings.Join(files, "\x00")
+	c.mu.Lock()
+	sum, ok := c.dirs[id]
+	c.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	h := sha256.New()
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasSuffix(file, ".go") {
+			src = canonicalSource(path, src)
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", file, len(src))
+		h.Write(src)
+	}
+	sum = hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	c.dirs[id] = sum
+	c.mu.Unlock()
+	return sum, nil
+}
+
+// canonicalSource returns src with the tinygo constraint removed and the
+// remaining constraint in canonical form, so that sources differing only in
+// how their constraint is written hash alike. A source whose constraint does
+// not parse is returned as is.
+func canonicalSource(name string, src []byte) []byte {
+	if stripped, _, err := rewriteConstraints(name, src, true); err == nil {
+		src = stripped
+	}
+	if !hasBuildComment(src) {
+		return src
+	}
+	bl, _, err := findBuildLine(name, src)
+	if err != nil || bl == nil {
+		return src
+	}
+	src = bl.dropPlus(src)
+	var out bytes.Buffer
+	out.Write(src[:bl.start])
+	out.WriteString(goBuild + canonical(bl.expr).String())
+	out.Write(src[bl.end:])
+	return out.Bytes()
+}
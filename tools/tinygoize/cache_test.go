@@ -0,0 +1,170 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// countBuilds returns the number of builds logged to the RAMP_LOG file.
+func countBuilds(t *testing.T, logFile string) int {
+	t.Helper()
+	b, err := os.ReadFile(logFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(b), "start ")
+}
+
+func TestRunCache(t *testing.T) {
+	root, cfg := testTree(t)
+	logFile := filepath.Join(t.TempDir(), "log")
+	t.Setenv("RAMP_LOG", logFile)
+	cfg.cachePath = "cache.json"
+	dirs := []string{"cmds/pass", "cmds/fail", "cmds/excluded"}
+
+	var first, second bytes.Buffer
+	if code := run(cfg, dirs, &first, io.Discard); code != exitOK {
+		t.Fatalf("first run() = %d, want %d", code, exitOK)
+	}
+	if got := countBuilds(t, logFile); got != 2 {
+		t.Fatalf("first run built %d packages, want 2", got)
+	}
+	if code := run(cfg, dirs, &second, io.Discard); code != exitOK {
+		t.Fatalf("second run() = %d, want %d", code, exitOK)
+	}
+	if got := countBuilds(t, logFile); got != 2 {
+		t.Errorf("second run built %d packages, want none", got-2)
+	}
+	if first.String() != second.String() {
+		t.Errorf("cached report differs:\n%s\nwant:\n%s", &second, &first)
+	}
+
+	// A source change invalidates the package's entry.
+	if err := os.WriteFile("cmds/pass/other.go", []byte(copyright+"\npackage main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitOK {
+		t.Fatalf("third run() = %d, want %d", code, exitOK)
+	}
+	if got := countBuilds(t, logFile); got != 3 {
+		t.Errorf("third run built %d packages, want 1", got-2)
+	}
+
+	// So do changes to the other files of a build, and to the module.
+	for _, tt := range []struct {
+		file, src string
+		builds    int
+	}{
+		{"cmds/pass/defs.h", "#define X 1\n", 1},
+		{"cmds/pass/defs.h", "#define X 2\n", 1},
+		{"go.mod", "module example.com/m\n\ngo 1.22\n", 2},
+	} {
+		before := countBuilds(t, logFile)
+		if err := os.WriteFile(tt.file, []byte(tt.src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if code := run(cfg, dirs, io.Discard, io.Discard); code != exitOK {
+			t.Fatalf("run() after writing %s = %d, want %d", tt.file, code, exitOK)
+		}
+		if got := countBuilds(t, logFile) - before; got != tt.builds {
+			t.Errorf("run() after writing %s built %d packages, want %d", tt.file, got, tt.builds)
+		}
+	}
+
+	// Only the cache and its lock file remain; no temp files.
+	matches, err := filepath.Glob(filepath.Join(root, "cache.json*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(root, "cache.json"), filepath.Join(root, "cache.json.lock")}
+	if strings.Join(matches, " ") != strings.Join(want, " ") {
+		t.Errorf("cache files = %v, want %v", matches, want)
+	}
+}
+
+func TestRunCacheLocked(t *testing.T) {
+	_, cfg := testTree(t)
+	logFile := filepath.Join(t.TempDir(), "log")
+	t.Setenv("RAMP_LOG", logFile)
+	cfg.cachePath = "cache.json"
+
+	held, err := openCache(cfg.cachePath, "0.33.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.close()
+	if _, err := openCache(cfg.cachePath, "0.33.0"); !errors.Is(err, errCacheLocked) {
+		t.Fatalf("openCache() of a held cache = %v, want %v", err, errCacheLocked)
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := run(cfg, []string{"cmds/pass"}, io.Discard, io.Discard); code != exitOK {
+			t.Fatalf("run() = %d, want %d", code, exitOK)
+		}
+	}
+	if got := countBuilds(t, logFile); got != 2 {
+		t.Errorf("runs built %d packages, want 2", got)
+	}
+	if _, err := os.Stat(cfg.cachePath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("locked cache was written: %v", err)
+	}
+}
+
+func TestOpenCacheCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	if err := os.WriteFile(path, []byte(`{"abc": {"pass": tr`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := openCache(path, "0.33.0")
+	if err != nil {
+		t.Fatalf("openCache() = %v, want nil", err)
+	}
+	defer c.close()
+	if len(c.entries) != 0 {
+		t.Errorf("corrupt cache has %d entries, want 0", len(c.entries))
+	}
+}
+
+func TestDirHashCanonical(t *testing.T) {
+	c := &buildCache{dirs: make(map[string]string)}
+	hash := func(constraint string) string {
+		t.Helper()
+		dir := t.TempDir()
+		src := copyright + "\n" + constraint + "\n\npackage main\n\nfunc main() {}\n"
+		if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		sum, err := c.dirHash(dir, []string{"main.go"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+
+	want := hash("//go:build amd64 && linux")
+	for _, constraint := range []string{
+		"//go:build linux && amd64",
+		"//go:build (!tinygo || tinygo.enable) && linux && amd64",
+		"//go:build linux && (!tinygo || tinygo.enable) && amd64 && linux",
+		"// +build linux,amd64",
+	} {
+		if got := hash(constraint); got != want {
+			t.Errorf("dirHash() of %q = %s, want %s as for the canonical form", constraint, got, want)
+		}
+	}
+	if got := hash("//go:build linux"); got == want {
+		t.Errorf("dirHash() of another constraint = %s, want it to differ", got)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// changedSet holds the absolute paths of the files changed since a git ref,
+// whose constraints alone are fixed up, see -changed-only. A nil set holds
+// every file.
+type changedSet map[string]bool
+
+// has reports whether file is in c.
+func (c changedSet) has(file string) bool {
+	if c == nil {
+		return true
+	}
+	return c[resolvePath(file)]
+}
+
+// resolvePath returns the absolute path of file with symlinks resolved, as
+// git reports the top of the work tree, or file if that fails.
+func resolvePath(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved
+	}
+	return abs
+}
+
+// changedFiles returns the files of the git work tree changed since ref,
+// whether committed or not, and the untracked files that are not ignored,
+// which are new to ref too.
+func changedFiles(ref string) (changedSet, error) {
+	top, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	top = strings.TrimSpace(top)
+	changed := make(changedSet)
+	for _, args := range [][]string{
+		{"-C", top, "diff", "--name-only", ref, "--"},
+		{"-C", top, "ls-files", "--others", "--exclude-standard"},
+	} {
+		out, err := git(args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range strings.Split(out, "\n") {
+			if name != "" {
+				changed[resolvePath(filepath.Join(top, filepath.FromSlash(name)))] = true
+			}
+		}
+	}
+	return changed, nil
+}
+
+// git runs git with args and returns its output, or its error message.
+func git(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
+			err = errors.New(strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestRunChangedOnly(t *testing.T) {
+	_, cfg := testTree(t)
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not found")
+	}
+	other := copyright + "\npackage main\n\nfunc other() {}\n"
+	if err := os.WriteFile("cmds/fail/other.go", []byte(other), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "tree"},
+	} {
+		if out, err := exec.Command(git, args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", args[0], err, out)
+		}
+	}
+	// Since HEAD, main.go of cmds/fail changed and new.go is new, while
+	// cmds/pass, which carries a stale constraint, is untouched.
+	main := readFile(t, "cmds/fail/main.go") + "\n// Changed.\n"
+	if err := os.WriteFile("cmds/fail/main.go", []byte(main), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("cmds/fail/new.go", []byte(other), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pass := readFile(t, "cmds/pass/main.go")
+	dirs := []string{"cmds/pass", "cmds/fail", "cmds/excluded"}
+
+	cfg.changedOnly = "HEAD"
+	cfg.checkOnly = true
+	var notes strings.Builder
+	if code := run(cfg, dirs, io.Discard, &notes); code != exitUpdates {
+		t.Fatalf("run() = %d, want %d:\n%s", code, exitUpdates, &notes)
+	}
+	if want := "Updates required:\n  cmds/fail/main.go\n  cmds/fail/new.go\n"; !strings.Contains(notes.String(), want) {
+		t.Errorf("run() notes:\n%s\nwant:\n%s", &notes, want)
+	}
+
+	cfg.checkOnly = false
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitOK {
+		t.Fatalf("run() = %d, want %d", code, exitOK)
+	}
+	if !strings.Contains(readFile(t, "cmds/fail/main.go"), "//go:build !tinygo || tinygo.enable") {
+		t.Errorf("changed cmds/fail/main.go was not constrained")
+	}
+	if readFile(t, "cmds/fail/other.go") != other || readFile(t, "cmds/pass/main.go") != pass {
+		t.Errorf("run() modified files unchanged since HEAD")
+	}
+
+	cfg.changedOnly = "no-such-ref"
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitSetup {
+		t.Errorf("run() with a bad ref = %d, want %d", code, exitSetup)
+	}
+}
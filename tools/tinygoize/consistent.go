@@ -0,0 +1,55 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// inconsistency is a package whose committed constraints disagree with its
+// build result, see -assert-consistent.
+type inconsistency struct {
+	dir, reason string
+}
+
+// inconsistencies returns the FAILING packages of status lacking the tinygo
+// constraint in some files, and the PASSING ones carrying it in some, sorted
+// by dir. EXCLUDED packages have no build result to disagree with.
+func inconsistencies(status BuildStatus) []inconsistency {
+	var incs []inconsistency
+	for _, br := range status.failing {
+		if br.needsConstraint() {
+			incs = append(incs, inconsistency{
+				dir:    filepath.ToSlash(br.dir),
+				reason: fmt.Sprintf("FAILING, but %d of %d files lack the tinygo constraint", br.files-br.constrained, br.files),
+			})
+		}
+	}
+	for _, br := range append(slices.Clone(status.passing), status.passingWarnings...) {
+		if br.constrained > 0 {
+			incs = append(incs, inconsistency{
+				dir:    filepath.ToSlash(br.dir),
+				reason: fmt.Sprintf("PASSING, but %d of %d files carry the tinygo constraint", br.constrained, br.files),
+			})
+		}
+	}
+	sort.Slice(incs, func(i, j int) bool { return incs[i].dir < incs[j].dir })
+	return incs
+}
+
+// writeInconsistencies explains each of incs.
+func writeInconsistencies(w io.Writer, incs []inconsistency) {
+	if len(incs) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Constraints inconsistent with the build results:\n")
+	for _, inc := range incs {
+		fmt.Fprintf(w, "  %s: %s\n", inc.dir, inc.reason)
+	}
+}
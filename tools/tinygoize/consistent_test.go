@@ -0,0 +1,62 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInconsistencies(t *testing.T) {
+	status := BuildStatus{
+		passing:         []BuildResult{{dir: "cmds/core/ls", files: 2}, {dir: "cmds/core/cat", constrained: 1, files: 2}},
+		passingWarnings: []BuildResult{{dir: "cmds/core/init", constrained: 1, files: 1}},
+		failing:         []BuildResult{{dir: "cmds/core/ip", constrained: 3, files: 3}, {dir: "cmds/core/dd", constrained: 1, files: 4}},
+		excluded:        []BuildResult{{dir: "cmds/core/dmesg", constrained: 1, files: 1}},
+	}
+	want := []inconsistency{
+		{"cmds/core/cat", "PASSING, but 1 of 2 files carry the tinygo constraint"},
+		{"cmds/core/dd", "FAILING, but 3 of 4 files lack the tinygo constraint"},
+		{"cmds/core/init", "PASSING, but 1 of 1 files carry the tinygo constraint"},
+	}
+	if got := inconsistencies(status); !reflect.DeepEqual(got, want) {
+		t.Errorf("inconsistencies() = %q, want %q", got, want)
+	}
+}
+
+func TestRunAssertConsistent(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.assertConsistent = true
+	dirs := []string{"cmds/pass", "cmds/fail", "cmds/excluded"}
+	pass, fail := readFile(t, "cmds/pass/main.go"), readFile(t, "cmds/fail/main.go")
+
+	var notes strings.Builder
+	if code := run(cfg, dirs, io.Discard, &notes); code != exitUpdates {
+		t.Errorf("run() = %d, want %d", code, exitUpdates)
+	}
+	want := "Constraints inconsistent with the build results:\n" +
+		"  cmds/fail: FAILING, but 1 of 1 files lack the tinygo constraint\n" +
+		"  cmds/pass: PASSING, but 1 of 1 files carry the tinygo constraint\n"
+	if got := notes.String(); !strings.Contains(got, want) || strings.Contains(got, "Updates required") {
+		t.Errorf("run() notes:\n%s\nwant only the explanations:\n%s", got, want)
+	}
+	if readFile(t, "cmds/pass/main.go") != pass || readFile(t, "cmds/fail/main.go") != fail {
+		t.Errorf("run() modified files")
+	}
+
+	// Swapped, the constraints agree with the build results.
+	for file, src := range map[string]string{"cmds/pass/main.go": fail, "cmds/fail/main.go": pass} {
+		if err := os.WriteFile(file, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notes.Reset()
+	if code := run(cfg, dirs, io.Discard, &notes); code != exitOK {
+		t.Errorf("run() of consistent constraints = %d, want %d:\n%s", code, exitOK, &notes)
+	}
+}
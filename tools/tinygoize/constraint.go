@@ -0,0 +1,540 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	goBuild = "//go:build "
+	// tinygoConstraint excludes a package from tinygo builds unless the
+	// tinygo.enable tag is set.
+	tinygoConstraint = "!tinygo || tinygo.enable"
+)
+
+var tinygoExpr = func() constraint.Expr {
+	x, err := constraint.Parse(goBuild + tinygoConstraint)
+	if err != nil {
+		panic(err)
+	}
+	return x
+}()
+
+// gateConstraint selects the gate file of a package, see -gate-file: it is
+// only built by tinygo, unless tinygo.enable is set.
+const gateConstraint = "tinygo && !tinygo.enable"
+
+// gateTemplate is the source of a gate file. It fails tinygo builds of its
+// package with a message naming the problem, in place of constraining every
+// file of the package.
+const gateTemplate = `// Code generated by tinygoize. DO NOT EDIT.
+
+` + goBuild + gateConstraint + `
+
+package %s
+
+// This package does not build with tinygo. Set the tinygo.enable tag to try
+// anyway.
+var _ = packageDoesNotBuildWithTinygo
+`
+
+// stripTinygo returns x with the tinygo constraint removed from its top-level
+// AND terms, or nil if nothing is left.
+func stripTinygo(x constraint.Expr) constraint.Expr {
+	if x.String() == tinygoExpr.String() {
+		return nil
+	}
+	and, ok := x.(*constraint.AndExpr)
+	if !ok {
+		return x
+	}
+	l, r := stripTinygo(and.X), stripTinygo(and.Y)
+	switch {
+	case l == nil:
+		return r
+	case r == nil:
+		return l
+	}
+	return &constraint.AndExpr{X: l, Y: r}
+}
+
+// canonical returns x with the operands of its && and || chains flattened,
+// deduplicated, and sorted, so that equivalent constraints print alike. At
+// the top level, the tinygo constraint comes first. Operands sort by their
+// first tag, e.g. !linux with linux, then by their text.
+func canonical(x constraint.Expr) constraint.Expr {
+	switch x := x.(type) {
+	case *constraint.NotExpr:
+		return &constraint.NotExpr{X: canonical(x.X)}
+	case *constraint.AndExpr:
+		terms := sortTerms(flatten(x, isAnd))
+		if i := slices.IndexFunc(terms, func(t constraint.Expr) bool { return t.String() == tinygoExpr.String() }); i > 0 {
+			terms = append(append([]constraint.Expr{terms[i]}, terms[:i]...), terms[i+1:]...)
+		}
+		return join(terms, func(x, y constraint.Expr) constraint.Expr { return &constraint.AndExpr{X: x, Y: y} })
+	case *constraint.OrExpr:
+		terms := sortTerms(flatten(x, isOr))
+		return join(terms, func(x, y constraint.Expr) constraint.Expr { return &constraint.OrExpr{X: x, Y: y} })
+	}
+	return x
+}
+
+func isAnd(x constraint.Expr) bool {
+	_, ok := x.(*constraint.AndExpr)
+	return ok
+}
+
+func isOr(x constraint.Expr) bool {
+	_, ok := x.(*constraint.OrExpr)
+	return ok
+}
+
+// flatten returns the canonical operands of the chain of x whose links
+// satisfy link.
+func flatten(x constraint.Expr, link func(constraint.Expr) bool) []constraint.Expr {
+	if !link(x) {
+		return []constraint.Expr{canonical(x)}
+	}
+	var l, r constraint.Expr
+	switch x := x.(type) {
+	case *constraint.AndExpr:
+		l, r = x.X, x.Y
+	case *constraint.OrExpr:
+		l, r = x.X, x.Y
+	}
+	return append(flatten(l, link), flatten(r, link)...)
+}
+
+// sortTerms sorts terms by their first tag, then their text, and drops
+// duplicates.
+func sortTerms(terms []constraint.Expr) []constraint.Expr {
+	key := func(t constraint.Expr) string { return strings.TrimLeft(t.String(), "!(") }
+	slices.SortStableFunc(terms, func(a, b constraint.Expr) int {
+		if c := strings.Compare(key(a), key(b)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.String(), b.String())
+	})
+	return slices.CompactFunc(terms, func(a, b constraint.Expr) bool { return a.String() == b.String() })
+}
+
+// join chains terms left to right with op.
+func join(terms []constraint.Expr, op func(x, y constraint.Expr) constraint.Expr) constraint.Expr {
+	x := terms[0]
+	for _, t := range terms[1:] {
+		x = op(x, t)
+	}
+	return x
+}
+
+// hasTinygo reports whether x carries the tinygo constraint.
+func hasTinygo(x constraint.Expr) bool {
+	s := stripTinygo(x)
+	return s == nil || s.String() != x.String()
+}
+
+// hasIgnore reports whether x uses the ignore tag, as the //go:build ignore
+// of standalone programs such as generators does.
+func hasIgnore(x constraint.Expr) bool {
+	switch x := x.(type) {
+	case *constraint.TagExpr:
+		return x.Tag == "ignore"
+	case *constraint.NotExpr:
+		return hasIgnore(x.X)
+	case *constraint.AndExpr:
+		return hasIgnore(x.X) || hasIgnore(x.Y)
+	case *constraint.OrExpr:
+		return hasIgnore(x.X) || hasIgnore(x.Y)
+	}
+	return false
+}
+
+// isIgnored reports whether src is constrained with the ignore tag, see
+// hasIgnore.
+func isIgnored(name string, src []byte) (bool, error) {
+	if !hasBuildComment(src) {
+		return false, nil
+	}
+	bl, _, err := findBuildLine(name, src)
+	return bl != nil && hasIgnore(bl.expr), err
+}
+
+// errDuplicateBuild is returned for a file with several //go:build lines,
+// which tinygoize refuses to edit.
+var errDuplicateBuild = errors.New("duplicate //go:build line")
+
+// buildLine is a //go:build line found in a source file.
+type buildLine struct {
+	start, end int // byte offsets of the comment text
+	expr       constraint.Expr
+	// plus holds the offsets of the further lines of a file constrained
+	// only by legacy // +build lines, the first of which is at start.
+	// expr is then the conjunction of them all.
+	plus [][2]int
+}
+
+// dropPlus returns src without the further // +build lines of bl, which a
+// rewritten constraint replaces along with the first.
+func (bl *buildLine) dropPlus(src []byte) []byte {
+	for i := len(bl.plus) - 1; i >= 0; i-- {
+		start, end := bl.plus[i][0], bl.plus[i][1]
+		if end < len(src) && src[end] == '\n' {
+			end++
+		}
+		src = append(src[:start:start], src[end:]...)
+	}
+	return src
+}
+
+// findBuildLine returns the //go:build line in the header of src, or nil.
+// Without one, the legacy // +build lines stand in for it, as they do for
+// go/build. insert is the offset where a new constraint line should go. A
+// header with several //go:build lines is an error, as go vet has it: which
+// of them to edit is ambiguous.
+func findBuildLine(name string, src []byte) (bl *buildLine, insert int, err error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, name, src, parser.ParseComments|parser.PackageClauseOnly)
+	if err != nil {
+		return nil, 0, err
+	}
+	var first token.Pos
+	var plus *buildLine
+	for i, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+		// New constraints go after a leading comment group such as
+		// the copyright header, but never into the package doc.
+		if i == 0 && cg != f.Doc {
+			insert = fset.Position(cg.End()).Offset
+		}
+		for _, c := range cg.List {
+			// Like go/build, ignore // +build lines in the package
+			// doc.
+			if constraint.IsPlusBuild(c.Text) && cg != f.Doc {
+				x, err := constraint.Parse(c.Text)
+				if err != nil {
+					return nil, 0, fmt.Errorf("%s: %w", fset.Position(c.Pos()), err)
+				}
+				start, end := fset.Position(c.Pos()).Offset, fset.Position(c.End()).Offset
+				if plus == nil {
+					plus = &buildLine{start: start, end: end, expr: x}
+				} else {
+					plus.expr = &constraint.AndExpr{X: plus.expr, Y: x}
+					plus.plus = append(plus.plus, [2]int{start, end})
+				}
+				continue
+			}
+			if !constraint.IsGoBuild(c.Text) {
+				continue
+			}
+			if bl != nil {
+				return nil, 0, fmt.Errorf("%s: %w, the first at line %d", fset.Position(c.Pos()), errDuplicateBuild, fset.Position(first).Line)
+			}
+			x, err := constraint.Parse(c.Text)
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s: %w", fset.Position(c.Pos()), err)
+			}
+			first = c.Pos()
+			bl = &buildLine{
+				start: fset.Position(c.Pos()).Offset,
+				end:   fset.Position(c.End()).Offset,
+				expr:  x,
+			}
+		}
+	}
+	if bl == nil {
+		bl = plus
+	}
+	return bl, insert, nil
+}
+
+// hasBuildComment reports whether src may contain a build constraint, by a
+// raw scan for its markers.
+func hasBuildComment(src []byte) bool {
+	return bytes.Contains(src, []byte("//go:build")) || bytes.Contains(src, []byte("// +build"))
+}
+
+// rewriteConstraints adds (builds == false) or removes (builds == true) the
+// tinygo constraint in src, leaving the rewritten constraint canonical. Legacy
+// // +build lines are merged into the //go:build line that replaces them. It
+// reports whether src changed.
+func rewriteConstraints(name string, src []byte, builds bool) ([]byte, bool, error) {
+	// There is nothing to remove from a file without constraints, so
+	// spare parsing it. Adding one needs the parse to find its place.
+	if builds && !hasBuildComment(src) {
+		return src, false, nil
+	}
+	bl, insert, err := findBuildLine(name, src)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var out bytes.Buffer
+	switch {
+	case bl == nil && builds:
+		return src, false, nil
+	case bl == nil:
+		out.Write(src[:insert])
+		if insert == 0 {
+			out.WriteString(goBuild + tinygoConstraint + "\n\n")
+		} else {
+			out.WriteString("\n" + goBuild + tinygoConstraint)
+		}
+		out.Write(src[insert:])
+	case builds == !hasTinygo(bl.expr):
+		return src, false, nil
+	case builds:
+		src = bl.dropPlus(src)
+		x := stripTinygo(bl.expr)
+		if x != nil {
+			x = canonical(x)
+			out.Write(src[:bl.start])
+			out.WriteString(goBuild + x.String())
+			out.Write(src[bl.end:])
+			break
+		}
+		// Drop the whole line, and the blank line following it if it
+		// stood on its own.
+		start, end := bl.start, bl.end
+		if end < len(src) && src[end] == '\n' {
+			end++
+		}
+		if (start == 0 || bytes.HasSuffix(src[:start], []byte("\n\n"))) && end < len(src) && src[end] == '\n' {
+			end++
+		}
+		out.Write(src[:start])
+		out.Write(src[end:])
+	default:
+		src = bl.dropPlus(src)
+		x := canonical(&constraint.AndExpr{X: tinygoExpr, Y: bl.expr})
+		out.Write(src[:bl.start])
+		out.WriteString(goBuild + x.String())
+		out.Write(src[bl.end:])
+	}
+	return out.Bytes(), true, nil
+}
+
+// writeSource writes src to file with the permission bits perm, or those of
+// like if perm is 0. Unlike os.WriteFile, it sets them on existing files too,
+// and regardless of the umask.
+func writeSource(file string, src []byte, perm os.FileMode, like string) error {
+	if perm == 0 {
+		fi, err := os.Stat(like)
+		if err != nil {
+			return err
+		}
+		perm = fi.Mode().Perm()
+	}
+	if err := os.WriteFile(file, src, perm); err != nil {
+		return err
+	}
+	fi, err := os.Stat(file)
+	if err != nil || fi.Mode().Perm() == perm {
+		return err
+	}
+	return os.Chmod(file, perm)
+}
+
+// fixupFileConstraints updates the tinygo constraint of a single file. If
+// dryRun is set, the file is not written. The file keeps its permissions
+// unless perm is set. It reports whether the file needs changes. Files
+// constrained with the ignore tag are not part of the package and are
+// skipped, with errIgnored.
+func fixupFileConstraints(file string, perm os.FileMode, builds, dryRun bool) (bool, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	if ignored, err := isIgnored(file, src); err != nil || ignored {
+		if ignored {
+			err = fmt.Errorf("%s: %w", file, errIgnored)
+		}
+		return false, err
+	}
+	out, changed, err := rewriteConstraints(file, src, builds)
+	if err != nil || !changed || dryRun {
+		return changed, err
+	}
+	return true, writeSource(file, out, perm, file)
+}
+
+// errIgnored is returned for a file constrained with the ignore tag, which
+// tinygoize leaves alone.
+var errIgnored = errors.New("skipped, constrained by //go:build ignore")
+
+// isGate reports whether src is a gate file, whatever its name.
+func isGate(name string, src []byte) bool {
+	if !hasBuildComment(src) {
+		return false
+	}
+	bl, _, err := findBuildLine(name, src)
+	return err == nil && bl != nil && bl.expr.String() == gateConstraint
+}
+
+// splitGates splits the .go files in dir into gate files, files constrained
+// with the ignore tag, and the others.
+func splitGates(dir string) (gates, ignored, files []string, err error) {
+	all, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, file := range all {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		ignore, err := isIgnored(file, src)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		switch {
+		case ignore:
+			ignored = append(ignored, file)
+		case isGate(file, src):
+			gates = append(gates, file)
+		default:
+			files = append(files, file)
+		}
+	}
+	return gates, ignored, files, nil
+}
+
+// ignoredFiles returns the .go files in dir constrained with the ignore tag,
+// which tinygoize skips.
+func ignoredFiles(dir string) ([]string, error) {
+	_, ignored, _, err := splitGates(dir)
+	return ignored, err
+}
+
+// gateSource returns the source of a gate file for the package in dir, and
+// the package file it was derived from.
+func gateSource(dir string) ([]byte, string, error) {
+	_, _, files, err := splitGates(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.PackageClauseOnly)
+		if err != nil {
+			return nil, "", err
+		}
+		return []byte(fmt.Sprintf(gateTemplate, f.Name.Name)), file, nil
+	}
+	return nil, "", fmt.Errorf("%s: no Go files to gate", dir)
+}
+
+// fixupPkgConstraints updates the tinygo constraint of every .go file in dir
+// and returns the files needing changes. If gate is set, a failing package
+// gets a gate file of that name rather than a constraint in each file. Gate
+// files of packages that build are removed. Rewritten files keep their
+// permissions, and gate files take those of the package's files, unless perm
+// is set. Only the files in changed are rewritten, and a gate file, which
+// covers the whole package, is only added or removed if changed holds one of
+// its files.
+func fixupPkgConstraints(dir, gate string, perm os.FileMode, changed changedSet, builds, dryRun bool) ([]string, error) {
+	gates, _, files, err := splitGates(dir)
+	if err != nil {
+		return nil, err
+	}
+	if changed != nil {
+		if !slices.ContainsFunc(gates, changed.has) && !slices.ContainsFunc(files, changed.has) {
+			return nil, nil
+		}
+		files = slices.DeleteFunc(files, func(file string) bool { return !changed.has(file) })
+	}
+	var modified []string
+	if builds {
+		for _, file := range gates {
+			modified = append(modified, file)
+			if !dryRun {
+				if err := os.Remove(file); err != nil {
+					return modified, err
+				}
+			}
+		}
+	}
+	// A gate file covers the whole package.
+	if !builds && len(gates) > 0 {
+		return nil, nil
+	}
+
+	if !builds && gate != "" {
+		constrained, total, err := countConstraints(dir)
+		if err != nil || constrained == total {
+			return nil, err
+		}
+		file := filepath.Join(dir, gate)
+		if _, err := os.Stat(file); err == nil {
+			return nil, fmt.Errorf("%s: exists and is not a gate file", file)
+		}
+		if dryRun {
+			return []string{file}, nil
+		}
+		src, like, err := gateSource(dir)
+		if err != nil {
+			return nil, err
+		}
+		return []string{file}, writeSource(file, src, perm, like)
+	}
+
+	for _, file := range files {
+		changed, err := fixupFileConstraints(file, perm, builds, dryRun)
+		if err != nil {
+			return modified, err
+		}
+		if changed {
+			modified = append(modified, file)
+		}
+	}
+	return modified, nil
+}
+
+// countConstraints returns how many .go files in dir carry the tinygo
+// constraint, and the total number of .go files. Gate files are not counted,
+// and constrain all others, nor are files constrained with the ignore tag.
+func countConstraints(dir string) (constrained, total int, err error) {
+	gates, _, files, err := splitGates(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(gates) > 0 {
+		return len(files), len(files), nil
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return 0, 0, err
+		}
+		bl, _, err := findBuildLine(file, src)
+		if err != nil {
+			return 0, 0, err
+		}
+		if bl != nil && hasTinygo(bl.expr) {
+			constrained++
+		}
+	}
+	return constrained, len(files), nil
+}
+
+// pkgHasConstraint reports whether any .go file in dir carries the tinygo
+// constraint.
+func pkgHasConstraint(dir string) (bool, error) {
+	n, _, err := countConstraints(dir)
+	return n > 0, err
+}
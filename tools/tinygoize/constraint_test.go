@@ -0,0 +1,421 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"go/build/constraint"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const copyright = `// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+`
+
+func TestRewriteConstraints(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		in     string
+		builds bool
+		want   string
+	}{
+		{
+			name: "add after copyright",
+			in:   copyright + "\n// Package doc.\npackage main\n",
+			want: copyright + "//go:build !tinygo || tinygo.enable\n\n// Package doc.\npackage main\n",
+		},
+		{
+			name: "add without header",
+			in:   "// Package doc.\npackage main\n",
+			want: "//go:build !tinygo || tinygo.enable\n\n// Package doc.\npackage main\n",
+		},
+		{
+			name: "add to existing",
+			in:   copyright + "\n//go:build linux && (amd64 || arm64)\n\npackage main\n",
+			want: copyright + "\n//go:build (!tinygo || tinygo.enable) && (amd64 || arm64) && linux\n\npackage main\n",
+		},
+		{
+			name: "add to or",
+			in:   copyright + "//go:build linux || windows\n\npackage main\n",
+			want: copyright + "//go:build (!tinygo || tinygo.enable) && (linux || windows)\n\npackage main\n",
+		},
+		{
+			name: "add to legacy",
+			in:   copyright + "\n// +build linux,amd64 arm64\n\npackage main\n",
+			want: copyright + "\n//go:build (!tinygo || tinygo.enable) && ((amd64 && linux) || arm64)\n\npackage main\n",
+		},
+		{
+			name: "add to several legacy",
+			in:   copyright + "\n// +build linux\n// +build !386\n\npackage main\n",
+			want: copyright + "\n//go:build (!tinygo || tinygo.enable) && !386 && linux\n\npackage main\n",
+		},
+		{
+			name: "legacy in package doc",
+			in:   copyright + "\n// +build linux\npackage main\n",
+			want: copyright + "//go:build !tinygo || tinygo.enable\n\n// +build linux\npackage main\n",
+		},
+		{
+			name:   "remove from legacy",
+			in:     copyright + "\n// +build !tinygo tinygo.enable\n// +build linux\n\npackage main\n",
+			builds: true,
+			want:   copyright + "\n//go:build linux\n\npackage main\n",
+		},
+		{
+			name: "already present",
+			in:   copyright + "//go:build (!tinygo || tinygo.enable) && linux\n\npackage main\n",
+			want: copyright + "//go:build (!tinygo || tinygo.enable) && linux\n\npackage main\n",
+		},
+		{
+			name:   "remove only term after copyright",
+			in:     copyright + "//go:build !tinygo || tinygo.enable\n\n// Package doc.\npackage main\n",
+			builds: true,
+			want:   copyright + "\n// Package doc.\npackage main\n",
+		},
+		{
+			name:   "remove only term standalone",
+			in:     copyright + "\n//go:build !tinygo || tinygo.enable\n\npackage main\n",
+			builds: true,
+			want:   copyright + "\npackage main\n",
+		},
+		{
+			name:   "remove from and",
+			in:     copyright + "//go:build (!tinygo || tinygo.enable) && linux && (amd64 || arm64)\n\npackage main\n",
+			builds: true,
+			want:   copyright + "//go:build (amd64 || arm64) && linux\n\npackage main\n",
+		},
+		{
+			name:   "nothing to remove",
+			in:     copyright + "//go:build linux\n\npackage main\n",
+			builds: true,
+			want:   copyright + "//go:build linux\n\npackage main\n",
+		},
+		{
+			name:   "no constraint builds",
+			in:     copyright + "\npackage main\n",
+			builds: true,
+			want:   copyright + "\npackage main\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed, err := rewriteConstraints("x.go", []byte(tt.in), tt.builds)
+			if err != nil {
+				t.Fatalf("rewriteConstraints() = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("rewriteConstraints() =\n%s\nwant:\n%s", got, tt.want)
+			}
+			if changed != (tt.in != tt.want) {
+				t.Errorf("rewriteConstraints() changed = %t, want %t", changed, tt.in != tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	for _, tt := range []struct {
+		in   []string
+		want string
+	}{
+		{
+			in:   []string{"linux && amd64", "amd64 && linux", "amd64 && linux && amd64"},
+			want: "amd64 && linux",
+		},
+		{
+			in: []string{
+				"linux && (!tinygo || tinygo.enable) && !cgo",
+				"(!tinygo || tinygo.enable) && (linux && !cgo)",
+				"!cgo && linux && (tinygo.enable || !tinygo)",
+			},
+			want: "(!tinygo || tinygo.enable) && !cgo && linux",
+		},
+		{
+			in:   []string{"(windows || linux) && (arm64 || amd64)", "(amd64 || arm64) && (linux || windows)"},
+			want: "(amd64 || arm64) && (linux || windows)",
+		},
+	} {
+		for _, in := range tt.in {
+			x, err := constraint.Parse(goBuild + in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := canonical(x).String(); got != tt.want {
+				t.Errorf("canonical(%s) = %s, want %s", in, got, tt.want)
+			}
+		}
+	}
+
+	// Equivalent constraints converge once the tinygo constraint is added.
+	var outs []string
+	for _, line := range []string{"linux && amd64", "amd64 && linux"} {
+		out, _, err := rewriteConstraints("x.go", []byte(copyright+goBuild+line+"\n\npackage main\n"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outs = append(outs, string(out))
+	}
+	if outs[0] != outs[1] {
+		t.Errorf("rewriteConstraints() of equivalent constraints =\n%s\nand\n%s", outs[0], outs[1])
+	}
+}
+
+func TestRewriteConstraintsRoundTrip(t *testing.T) {
+	for _, in := range []string{
+		copyright + "\npackage main\n",
+		copyright + "\n// Package doc.\npackage main\n",
+		copyright + "//go:build linux\n\npackage main\n",
+		"package main\n",
+	} {
+		added, _, err := rewriteConstraints("x.go", []byte(in), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		removed, _, err := rewriteConstraints("x.go", added, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(removed) != in {
+			t.Errorf("round trip of\n%s\ngot\n%s", in, removed)
+		}
+	}
+}
+
+func TestRewriteConstraintsParseError(t *testing.T) {
+	if _, _, err := rewriteConstraints("x.go", []byte("not go"), false); err == nil {
+		t.Errorf("rewriteConstraints() = nil, want error")
+	}
+	// Without a constraint to remove, the file is not parsed.
+	if out, changed, err := rewriteConstraints("x.go", []byte("not go"), true); err != nil || changed || string(out) != "not go" {
+		t.Errorf("rewriteConstraints() = %q, %t, %v, want unchanged", out, changed, err)
+	}
+	if _, _, err := rewriteConstraints("x.go", []byte("not go\n// +build linux\n"), true); err == nil {
+		t.Errorf("rewriteConstraints() with a +build line = nil, want error")
+	}
+}
+
+func TestRewriteConstraintsDuplicate(t *testing.T) {
+	src := copyright + "//go:build linux\n//go:build !tinygo || tinygo.enable\n\npackage main\n"
+	for _, builds := range []bool{false, true} {
+		_, _, err := rewriteConstraints("x.go", []byte(src), builds)
+		if !errors.Is(err, errDuplicateBuild) || !strings.Contains(err.Error(), "x.go:5:1: ") {
+			t.Errorf("rewriteConstraints(builds %t) = %v, want %v at x.go:5:1", builds, err, errDuplicateBuild)
+		}
+	}
+	// Lines after the package clause are no constraints.
+	if _, _, err := rewriteConstraints("x.go", []byte(copyright+"//go:build linux\n\npackage main\n\n//go:build ignore\n"), true); err != nil {
+		t.Errorf("rewriteConstraints() with a line after the package clause = %v", err)
+	}
+}
+
+func TestFixupPkgConstraints(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"a.go":      copyright + "\npackage main\n",
+		"a_test.go": copyright + "\npackage main\n",
+		"b.go":      copyright + "//go:build !tinygo || tinygo.enable\n\npackage main\n",
+		"README":    "not go",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modified, err := fixupPkgConstraints(dir, "", 0, nil, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modified) != 2 {
+		t.Errorf("fixupPkgConstraints(dryRun) modified %q, want 2 files", modified)
+	}
+	if has, err := pkgHasConstraint(dir); err != nil || !has {
+		t.Errorf("pkgHasConstraint() = %t, %v, want true, nil", has, err)
+	}
+
+	// The dry run must not have written anything.
+	b, err := os.ReadFile(filepath.Join(dir, "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != copyright+"\npackage main\n" {
+		t.Errorf("dry run modified a.go:\n%s", b)
+	}
+
+	if _, err := fixupPkgConstraints(dir, "", 0, nil, false, false); err != nil {
+		t.Fatal(err)
+	}
+	modified, err = fixupPkgConstraints(dir, "", 0, nil, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modified) != 3 {
+		t.Errorf("fixupPkgConstraints(builds) modified %q, want 3 files", modified)
+	}
+	if has, err := pkgHasConstraint(dir); err != nil || has {
+		t.Errorf("pkgHasConstraint() = %t, %v, want false, nil", has, err)
+	}
+}
+
+func TestFixupPkgConstraintsIgnore(t *testing.T) {
+	dir := t.TempDir()
+	gen := copyright + "//go:build ignore\n\npackage main\n"
+	for name, src := range map[string]string{
+		"a.go":   copyright + "\npackage main\n",
+		"gen.go": gen,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, builds := range []bool{false, true} {
+		modified, err := fixupPkgConstraints(dir, "", 0, nil, builds, false)
+		if err != nil || len(modified) != 1 || filepath.Base(modified[0]) != "a.go" {
+			t.Errorf("fixupPkgConstraints(builds %t) = %q, %v, want a.go", builds, modified, err)
+		}
+		if got := readFile(t, filepath.Join(dir, "gen.go")); got != gen {
+			t.Errorf("fixupPkgConstraints(builds %t) modified gen.go:\n%s", builds, got)
+		}
+	}
+	if _, err := fixupFileConstraints(filepath.Join(dir, "gen.go"), 0, false, false); !errors.Is(err, errIgnored) {
+		t.Errorf("fixupFileConstraints(gen.go) = %v, want %v", err, errIgnored)
+	}
+	if ignored, err := ignoredFiles(dir); err != nil || len(ignored) != 1 || filepath.Base(ignored[0]) != "gen.go" {
+		t.Errorf("ignoredFiles() = %q, %v, want gen.go", ignored, err)
+	}
+	if constrained, total, err := countConstraints(dir); err != nil || constrained != 0 || total != 1 {
+		t.Errorf("countConstraints() = %d, %d, %v, want 0, 1, nil", constrained, total, err)
+	}
+}
+
+func TestHasIgnore(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want bool
+	}{
+		{"//go:build ignore", true},
+		{"//go:build ignore && linux", true},
+		{"//go:build linux || !ignore", true},
+		{"//go:build linux", false},
+		{"//go:build " + tinygoConstraint, false},
+	} {
+		x, err := constraint.Parse(tt.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hasIgnore(x); got != tt.want {
+			t.Errorf("hasIgnore(%q) = %t, want %t", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestFixupPkgConstraintsGate(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"a.go":      copyright + "\npackage foo\n",
+		"a_test.go": copyright + "\npackage foo_test\n",
+		"b.go":      copyright + "//go:build linux\n\npackage foo\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gate := filepath.Join(dir, "tinygo.go")
+
+	modified, err := fixupPkgConstraints(dir, "tinygo.go", 0, nil, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modified) != 1 || modified[0] != gate {
+		t.Fatalf("fixupPkgConstraints(gate) modified %q, want %q", modified, gate)
+	}
+	src, err := os.ReadFile(gate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isGate(gate, src) || !strings.Contains(string(src), "\npackage foo\n") {
+		t.Errorf("gate file:\n%s", src)
+	}
+	if got := readFile(t, filepath.Join(dir, "a.go")); got != copyright+"\npackage foo\n" {
+		t.Errorf("gate mode modified a.go:\n%s", got)
+	}
+	if constrained, total, err := countConstraints(dir); err != nil || constrained != 3 || total != 3 {
+		t.Errorf("countConstraints() = %d, %d, %v, want 3, 3, nil", constrained, total, err)
+	}
+
+	// A gated package needs no per-file constraints, even without -gate-file.
+	for _, name := range []string{"tinygo.go", ""} {
+		if modified, err := fixupPkgConstraints(dir, name, 0, nil, false, false); err != nil || len(modified) != 0 {
+			t.Errorf("fixupPkgConstraints(%q) of a gated package = %q, %v, want none", name, modified, err)
+		}
+	}
+
+	// Once the package builds, the gate goes.
+	if modified, err := fixupPkgConstraints(dir, "", 0, nil, true, false); err != nil || len(modified) != 1 || modified[0] != gate {
+		t.Errorf("fixupPkgConstraints(builds) = %q, %v, want %q", modified, err, gate)
+	}
+	if _, err := os.Stat(gate); !os.IsNotExist(err) {
+		t.Errorf("gate file not removed: %v", err)
+	}
+
+	// An ordinary file of the gate's name is never overwritten.
+	if err := os.WriteFile(gate, []byte(copyright+"\npackage foo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fixupPkgConstraints(dir, "tinygo.go", 0, nil, false, false); err == nil {
+		t.Errorf("fixupPkgConstraints() over an existing file = nil, want error")
+	}
+}
+
+func TestFixupPkgConstraintsMode(t *testing.T) {
+	perm := func(name string) os.FileMode {
+		t.Helper()
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Mode().Perm()
+	}
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")
+	for _, file := range []string{a, b} {
+		if err := os.WriteFile(file, []byte(copyright+"\npackage main\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// Chmod, as the umask applies to os.WriteFile.
+		if err := os.Chmod(file, 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Rewritten files keep their permissions.
+	if _, err := fixupPkgConstraints(dir, "", 0, nil, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := perm(a); got != 0o640 {
+		t.Errorf("a.go mode = %o, want 640", got)
+	}
+
+	// -file-mode overrides them.
+	if _, err := fixupPkgConstraints(dir, "", 0o600, nil, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := perm(a); got != 0o600 {
+		t.Errorf("a.go mode with -file-mode 600 = %o, want 600", got)
+	}
+
+	// Gate files take the permissions of the package.
+	if err := os.Chmod(a, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fixupPkgConstraints(dir, "tinygo.go", 0, nil, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := perm(filepath.Join(dir, "tinygo.go")); got != 0o640 {
+		t.Errorf("tinygo.go mode = %o, want 640", got)
+	}
+}
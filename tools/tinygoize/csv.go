@@ -0,0 +1,45 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// csvHeader names the columns of the CSV report, see -csv.
+var csvHeader = []string{"dir", "command", "status", "tags", "duration_seconds"}
+
+// writeCSV writes status as CSV, one row per command sorted by dir, for
+// spreadsheets. The duration is empty for commands that were not built, e.g.
+// because their result was cached.
+func writeCSV(w io.Writer, status BuildStatus) error {
+	var rows [][]string
+	for _, set := range resultSets(status) {
+		for _, br := range set.results {
+			duration := ""
+			if br.duration > 0 {
+				duration = fmt.Sprintf("%.1f", br.duration.Seconds())
+			}
+			rows = append(rows, []string{
+				filepath.ToSlash(br.dir),
+				filepath.Base(br.dir),
+				set.status,
+				strings.Join(br.tags, ","),
+				duration,
+			})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	cw.WriteAll(rows)
+	return cw.Error()
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteCSV(t *testing.T) {
+	status := BuildStatus{
+		passing:  []BuildResult{{dir: "cmds/core/init", tags: []string{"noasm", "purego"}, duration: 1250 * time.Millisecond}},
+		failing:  []BuildResult{{dir: "cmds/core/ip", duration: 3 * time.Second}},
+		excluded: []BuildResult{{dir: "cmds/core/dmesg"}},
+	}
+	var b strings.Builder
+	if err := writeCSV(&b, status); err != nil {
+		t.Fatal(err)
+	}
+	want := `dir,command,status,tags,duration_seconds
+cmds/core/dmesg,dmesg,excluded,,
+cmds/core/init,init,passing,"noasm,purego",1.2
+cmds/core/ip,ip,failing,,3.0
+`
+	if b.String() != want {
+		t.Errorf("writeCSV() = \n%s\nwant\n%s", &b, want)
+	}
+}
+
+func TestRunCSV(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly, cfg.pathCSV = true, "report.csv"
+
+	if code := run(cfg, []string{"cmds/pass", "cmds/fail", "cmds/excluded"}, io.Discard, io.Discard); code != exitUpdates {
+		t.Fatalf("run() = %d, want %d", code, exitUpdates)
+	}
+	f, err := os.Open(cfg.pathCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("CSV has %d rows, want a header and 3 commands: %q", len(rows), rows)
+	}
+	for i, want := range [][]string{
+		{"cmds/excluded", "excluded", ""},
+		{"cmds/fail", "failing", "built"},
+		{"cmds/pass", "passing", "built"},
+	} {
+		row := rows[i+1]
+		if row[0] != want[0] || row[2] != want[1] || (row[4] != "") != (want[2] == "built") {
+			t.Errorf("CSV row %d = %q, want %s %s, duration %t", i+1, row, want[0], want[1], want[2] == "built")
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"golang.org/x/sys/unix"
+)
+
+// errNoSpace marks a build that ran out of disk space, which says nothing
+// about whether the package builds with tinygo.
+var errNoSpace = errors.New("out of disk space")
+
+// noSpacePattern matches the errors of a build failing for lack of disk
+// space rather than because of the package.
+var noSpacePattern = regexp.MustCompile(`(?i)no space left on device|disk quota exceeded|\bENOSPC\b|\bEDQUOT\b`)
+
+// isNoSpace reports whether output of a failed build shows that the disk ran
+// full.
+func isNoSpace(output []byte) bool {
+	return noSpacePattern.Match(output)
+}
+
+// diskDirs returns the directories builds write to: the temporary directory,
+// the tinygo cache, or -cache-dir, and those of -cache and -o-dir.
+func diskDirs(cfg config) []string {
+	dirs := []string{os.TempDir()}
+	if cfg.cacheDir != "" {
+		dirs = append(dirs, cfg.cacheDir)
+	} else if dir, err := os.UserCacheDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "tinygo"))
+	}
+	if cfg.cachePath != "" {
+		dirs = append(dirs, filepath.Dir(cfg.cachePath))
+	}
+	if cfg.outDir != "" {
+		dirs = append(dirs, cfg.outDir)
+	}
+	return dirs
+}
+
+// freeSpace returns the bytes available to unprivileged users on the file
+// system holding path. A path that does not exist yet is created on the file
+// system of its closest existing parent.
+func freeSpace(path string) (uint64, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		var st unix.Statfs_t
+		err := unix.Statfs(path, &st)
+		if err == nil {
+			return st.Bavail * uint64(st.Bsize), nil
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, unix.ENOENT) || parent == path {
+			return 0, fmt.Errorf("statfs %s: %w", path, err)
+		}
+		path = parent
+	}
+}
+
+// checkDiskSpace returns an error wrapping errNoSpace if any of the
+// directories builds write to has less than -min-free MiB available.
+// Directories whose file system cannot be queried are not checked.
+func checkDiskSpace(cfg config) error {
+	if cfg.minFree <= 0 {
+		return nil
+	}
+	want := uint64(cfg.minFree) << 20
+	for _, dir := range diskDirs(cfg) {
+		free, err := freeSpace(dir)
+		if err != nil {
+			continue
+		}
+		if free < want {
+			return fmt.Errorf("%w: %s has %d MiB free, -min-free is %d MiB", errNoSpace, dir, free>>20, cfg.minFree)
+		}
+	}
+	return nil
+}
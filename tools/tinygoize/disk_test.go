@@ -0,0 +1,94 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsNoSpace(t *testing.T) {
+	for _, tt := range []struct {
+		output string
+		want   bool
+	}{
+		{"write /tmp/x.o: no space left on device", true},
+		{"ld.lld: error: write failed: Disk quota exceeded", true},
+		{"error: ENOSPC", true},
+		{"main.go:3:2: undefined: foo", false},
+		{"", false},
+	} {
+		if got := isNoSpace([]byte(tt.output)); got != tt.want {
+			t.Errorf("isNoSpace(%q) = %t, want %t", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	// A directory yet to be created is checked on its parent's file system.
+	missing := filepath.Join(t.TempDir(), "a", "b")
+	if _, err := freeSpace(missing); err != nil {
+		t.Errorf("freeSpace(%q) = %v", missing, err)
+	}
+
+	cfg := config{outDir: missing}
+	if err := checkDiskSpace(cfg); err != nil {
+		t.Errorf("checkDiskSpace() with -min-free 0 = %v, want nil", err)
+	}
+	cfg.minFree = 1
+	if err := checkDiskSpace(cfg); err != nil {
+		t.Errorf("checkDiskSpace() with -min-free 1 = %v, want nil", err)
+	}
+	cfg.minFree = 1 << 40
+	if err := checkDiskSpace(cfg); !errors.Is(err, errNoSpace) {
+		t.Errorf("checkDiskSpace() with -min-free 1 EiB = %v, want %v", err, errNoSpace)
+	}
+}
+
+func TestRunMinFree(t *testing.T) {
+	_, cfg := testTree(t)
+	logFile := filepath.Join(t.TempDir(), "log")
+	t.Setenv("RAMP_LOG", logFile)
+	cfg.minFree = 1 << 40
+
+	var stderr bytes.Buffer
+	if code := run(cfg, []string{"cmds/pass", "cmds/fail"}, io.Discard, &stderr); code != exitSetup {
+		t.Fatalf("run() = %d, want %d", code, exitSetup)
+	}
+	if !strings.Contains(stderr.String(), "-min-free") {
+		t.Errorf("stderr = %q, want the -min-free threshold", &stderr)
+	}
+	if got := countBuilds(t, logFile); got != 0 {
+		t.Errorf("run() built %d packages, want none", got)
+	}
+}
+
+func TestRunNoSpace(t *testing.T) {
+	_, cfg := testTree(t)
+	if err := os.Rename("cmds/fail/FAIL", "cmds/fail/NOSPACE"); err != nil {
+		t.Fatal(err)
+	}
+	fail := readFile(t, "cmds/fail/main.go")
+
+	var stdout, stderr bytes.Buffer
+	if code := run(cfg, []string{"cmds/fail"}, &stdout, &stderr); code != exitSetup {
+		t.Fatalf("run() = %d, want %d", code, exitSetup)
+	}
+	if !strings.Contains(stderr.String(), "out of disk space") {
+		t.Errorf("stderr = %q, want an out of disk space error", &stderr)
+	}
+	// The package is neither reported as failing nor constrained.
+	if stdout.Len() != 0 {
+		t.Errorf("stdout = %q, want no report", &stdout)
+	}
+	if got := readFile(t, "cmds/fail/main.go"); got != fail {
+		t.Errorf("cmds/fail/main.go was modified:\n%s", got)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// readExpectedExcluded reads an -expected-excluded file, listing one
+// package directory per line. Blank lines and lines starting with # are
+// ignored.
+func readExpectedExcluded(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	expected := make(map[string]bool)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Clean the dirs as run does those given.
+		expected[filepath.ToSlash(filepath.Clean(line))] = true
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return expected, nil
+}
+
+// diffExcluded compares the EXCLUDED packages of status with those
+// expected. It returns those excluded unexpectedly, and those expected but
+// built or failing. Expected packages that were not processed, e.g. because
+// they were not given, are not missing.
+func diffExcluded(status BuildStatus, expected map[string]bool) (unexpected, missing []string) {
+	for _, br := range status.excluded {
+		if dir := filepath.ToSlash(br.dir); !expected[dir] {
+			unexpected = append(unexpected, dir)
+		}
+	}
+	for _, set := range [][]BuildResult{status.passing, status.passingWarnings, status.failing} {
+		for _, br := range set {
+			if dir := filepath.ToSlash(br.dir); expected[dir] {
+				missing = append(missing, dir)
+			}
+		}
+	}
+	sort.Strings(unexpected)
+	sort.Strings(missing)
+	return unexpected, missing
+}
+
+// writeExcludedDiff reports the differences found by diffExcluded against
+// the file path.
+func writeExcludedDiff(w io.Writer, path string, unexpected, missing []string) {
+	for _, set := range []struct {
+		header string
+		dirs   []string
+	}{
+		{"EXCLUDED packages not in " + path + ":", unexpected},
+		{"Packages in " + path + " that are no longer EXCLUDED:", missing},
+	} {
+		if len(set.dirs) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s\n", set.header)
+		for _, dir := range set.dirs {
+			fmt.Fprintf(w, "  %s\n", dir)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffExcluded(t *testing.T) {
+	status := BuildStatus{
+		passing:  []BuildResult{{dir: "cmds/core/ls"}},
+		failing:  []BuildResult{{dir: "cmds/core/ip"}},
+		excluded: []BuildResult{{dir: "cmds/exp/plan9"}, {dir: "cmds/core/typo"}},
+	}
+	expected := map[string]bool{"cmds/exp/plan9": true, "cmds/core/ip": true, "cmds/not/built": true}
+	unexpected, missing := diffExcluded(status, expected)
+	if want := []string{"cmds/core/typo"}; !cmp.Equal(unexpected, want) {
+		t.Errorf("diffExcluded() unexpected = %v, want %v", unexpected, want)
+	}
+	if want := []string{"cmds/core/ip"}; !cmp.Equal(missing, want) {
+		t.Errorf("diffExcluded() missing = %v, want %v", missing, want)
+	}
+}
+
+func TestRunExpectedExcluded(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly, cfg.staleSeverity = true, severityWarn
+	dirs := []string{"cmds/pass", "cmds/excluded"}
+
+	if err := os.WriteFile("excluded.txt", []byte("# Plan 9 only\n./cmds/excluded/\n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.expectedExcluded = "excluded.txt"
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitOK {
+		t.Errorf("run(-expected-excluded) = %d, want %d", code, exitOK)
+	}
+
+	if err := os.WriteFile("excluded.txt", []byte("cmds/pass\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	if code := run(cfg, dirs, io.Discard, &stderr); code != exitExclude {
+		t.Errorf("run(-expected-excluded) = %d, want %d", code, exitExclude)
+	}
+	want := "EXCLUDED packages not in excluded.txt:\n  cmds/excluded\nPackages in excluded.txt that are no longer EXCLUDED:\n  cmds/pass\n"
+	if !bytes.Contains(stderr.Bytes(), []byte(want)) {
+		t.Errorf("run(-expected-excluded) stderr lacks %q:\n%s", want, &stderr)
+	}
+
+	cfg.expectedExcluded = "missing.txt"
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitSetup {
+		t.Errorf("run(-expected-excluded missing.txt) = %d, want %d", code, exitSetup)
+	}
+}
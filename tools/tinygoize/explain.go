@@ -0,0 +1,67 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// explain builds the package in dir once, streaming the output of tinygo to
+// stdout and stderr as it comes, and prints what tinygoize makes of the
+// package: its tags, constraints, exclusion, and build result, see -explain.
+// No files are modified. Only tool errors make it exit non-zero.
+func explain(cfg config, version, dir string, stdout, stderr io.Writer) int {
+	tags := buildTags(dir)
+	runtime := runtimeOpts(dir)
+	fmt.Fprintf(stdout, "package:         %s\n", filepath.ToSlash(dir))
+	fmt.Fprintf(stdout, "tinygo:          %s\n", version)
+	fmt.Fprintf(stdout, "target:          %s\n", cfg.target)
+	fmt.Fprintf(stdout, "additional tags: %s\n", orNone(strings.Join(tags, ",")))
+	fmt.Fprintf(stdout, "runtime:         %s\n", orNone(runtime.String()))
+
+	constrained, files, err := countConstraints(dir)
+	if err != nil {
+		return fatalError(cfg, stderr, exitError, buildError(dir, PhaseParse, err))
+	}
+	fmt.Fprintf(stdout, "constraint:      %d of %d files carry the tinygo constraint\n", constrained, files)
+
+	excluded, err := isExcluded(cfg, dir)
+	if err != nil {
+		return fatalError(cfg, stderr, exitError, buildError(dir, PhaseParse, err))
+	}
+	fmt.Fprintf(stdout, "exclusion check: go build -n -tags %s\n", strings.Join(runtime.goTags(tags...), ","))
+	if excluded {
+		fmt.Fprintf(stdout, "result:          EXCLUDED, build constraints exclude all Go files\n")
+		return exitOK
+	}
+
+	c, _, err := buildCommand(cfg, BuildResult{dir: dir, tags: tags, runtime: runtime})
+	if err != nil {
+		return fatalError(cfg, stderr, exitError, err)
+	}
+	fmt.Fprintf(stdout, "command:         cd %s && %s %s\n", filepath.ToSlash(dir), strings.Join(buildEnv(cfg), " "), strings.Join(c.Args, " "))
+	c.Stdout, c.Stderr = stdout, stderr
+	start := time.Now()
+	err = c.Run()
+	duration := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		fmt.Fprintf(stdout, "result:          FAILING after %v: %v\n", duration, err)
+	} else {
+		fmt.Fprintf(stdout, "result:          PASSING after %v\n", duration)
+	}
+	return exitOK
+}
+
+// orNone returns s, or "none" if it is empty.
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRunExplain(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.explain = true
+	fail := readFile(t, "cmds/fail/main.go")
+
+	for _, tt := range []struct {
+		dir        string
+		want       []string
+		wantStderr string
+	}{
+		{
+			dir: "cmds/fail",
+			want: []string{
+				"package:         cmds/fail\n",
+				"tinygo:          0.33.0\n",
+				"target:          linux/amd64\n",
+				"additional tags: none\n",
+				"constraint:      0 of 1 files carry the tinygo constraint\n",
+				"command:         cd cmds/fail && GOOS=linux CGO_ENABLED=0 GOARCH=amd64 ",
+				"tinygo build -tags tinygo.enable\n",
+				"result:          FAILING after ",
+			},
+			wantStderr: "fake tinygo error\n",
+		},
+		{
+			dir:  "cmds/pass",
+			want: []string{"constraint:      1 of 1 files carry the tinygo constraint\n", "result:          PASSING after "},
+		},
+		{
+			dir:  "cmds/excluded",
+			want: []string{"result:          EXCLUDED, build constraints exclude all Go files\n"},
+		},
+	} {
+		t.Run(tt.dir, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			if code := run(cfg, []string{tt.dir}, &stdout, &stderr); code != exitOK {
+				t.Fatalf("run() = %d, want %d:\n%s", code, exitOK, &stderr)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("output lacks %q:\n%s", want, &stdout)
+				}
+			}
+			// The output of tinygo is streamed, not reported.
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("stderr = %q, want %q", &stderr, tt.wantStderr)
+			}
+			if strings.Contains(stdout.String(), "###") {
+				t.Errorf("output has a report:\n%s", &stdout)
+			}
+		})
+	}
+	if readFile(t, "cmds/fail/main.go") != fail {
+		t.Errorf("-explain modified cmds/fail")
+	}
+
+	if code := run(cfg, []string{"cmds/pass", "cmds/fail"}, io.Discard, io.Discard); code != exitUsage {
+		t.Errorf("run() of two directories = %d, want %d", code, exitUsage)
+	}
+}
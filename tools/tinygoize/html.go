@@ -0,0 +1,191 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"html/template"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Formats of the -o report.
+const (
+	formatMarkdown = "markdown"
+	formatHTML     = "html"
+)
+
+// htmlPackage is a row of the HTML report.
+type htmlPackage struct {
+	Dir    string   `json:"dir"`
+	Status string   `json:"status"`
+	Tags   []string `json:"tags"`
+	// Seconds is the build duration, or 0 if the package was not built,
+	// e.g. because the result was cached.
+	Seconds float64 `json:"seconds"`
+	// Size is the size of the binary kept with -o-dir, or 0.
+	Size       int64  `json:"size"`
+	KnownIssue string `json:"known_issue,omitempty"`
+}
+
+// htmlData is what htmlTemplate renders.
+type htmlData struct {
+	Tinygo   string
+	Tags     string
+	Targets  string
+	Stamp    string
+	Packages []htmlPackage
+}
+
+// htmlPackages returns the rows of the HTML report of status, sorted by dir.
+func htmlPackages(status BuildStatus) []htmlPackage {
+	pkgs := []htmlPackage{}
+	for _, set := range resultSets(status) {
+		for _, br := range set.results {
+			dir := filepath.ToSlash(br.dir)
+			tags := br.tags
+			if tags == nil {
+				tags = []string{}
+			}
+			pkgs = append(pkgs, htmlPackage{
+				Dir:        dir,
+				Status:     set.status,
+				Tags:       tags,
+				Seconds:    br.duration.Seconds(),
+				Size:       br.size,
+				KnownIssue: status.known[dir].issue,
+			})
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Dir < pkgs[j].Dir })
+	return pkgs
+}
+
+// writeHTML writes the build status as a self-contained HTML page, see
+// -format. The packages are embedded as JSON and rendered by the page itself
+// into a table that can be sorted by each column and filtered, so that the
+// page can be published as a static file.
+func writeHTML(w io.Writer, cfg config, info reportInfo, status BuildStatus) error {
+	return htmlTemplate.Execute(w, htmlData{
+		Tinygo:   info.version,
+		Tags:     strings.Join(info.tags, " "),
+		Targets:  strings.Join(cfg.targets, ", "),
+		Stamp:    strings.TrimSpace(info.stamp),
+		Packages: htmlPackages(status),
+	})
+}
+
+// htmlTemplate is the page of writeHTML. In the script element, html/template
+// encodes the packages as JSON, escaping what could end the element.
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>tinygo build status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #ddd; }
+th { cursor: pointer; user-select: none; background: #f4f4f4; }
+th.asc::after { content: " \25B2"; }
+th.desc::after { content: " \25BC"; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+#filter { padding: 0.3em; width: 24em; margin-bottom: 1em; }
+.badge { display: inline-block; padding: 0.1em 0.5em; border-radius: 0.8em; font-size: 0.85em; color: #fff; }
+.passing { background: #2da44e; }
+.passing-with-warnings { background: #bf8700; }
+.failing { background: #cf222e; }
+.excluded { background: #6e7781; }
+.empty { background: #afb8c1; }
+</style>
+</head>
+<body>
+<h1>Status of tinygo builds using tinygo {{.Tinygo}}</h1>
+{{- if .Targets}}
+<p>Targets: {{.Targets}}</p>
+{{- end}}
+{{- if .Tags}}
+<p>Resolved build tags: <code>{{.Tags}}</code></p>
+{{- end}}
+{{- if .Stamp}}
+<p>{{.Stamp}}</p>
+{{- end}}
+<input id="filter" type="search" placeholder="Filter packages, statuses, tags" autofocus>
+<span id="count"></span>
+<table>
+<thead>
+<tr><th data-key="dir">Package</th><th data-key="status">Status</th><th data-key="tags">Tags</th><th data-key="seconds">Build time</th><th data-key="size">Binary size</th></tr>
+</thead>
+<tbody id="rows"></tbody>
+</table>
+<script id="data" type="application/json">{{.Packages}}</script>
+<script>
+(function() {
+	var packages = JSON.parse(document.getElementById("data").textContent);
+	var rows = document.getElementById("rows");
+	var filter = document.getElementById("filter");
+	var count = document.getElementById("count");
+	var headers = document.querySelectorAll("th");
+	var sortKey = "dir", sortDir = 1;
+
+	function value(p, key) {
+		return key === "tags" ? p.tags.join(",") : p[key];
+	}
+	function size(n) {
+		if (!n) return "";
+		var units = ["B", "KiB", "MiB", "GiB"], i = 0;
+		while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+		return (i ? n.toFixed(1) : n) + " " + units[i];
+	}
+	function cell(tr, text, cls) {
+		var td = tr.insertCell();
+		td.textContent = text;
+		if (cls) td.className = cls;
+		return td;
+	}
+	function render() {
+		var words = filter.value.toLowerCase().split(/\s+/).filter(Boolean);
+		var shown = packages.filter(function(p) {
+			var text = [p.dir, p.status, p.tags.join(" "), p.known_issue || ""].join(" ").toLowerCase();
+			return words.every(function(w) { return text.indexOf(w) >= 0; });
+		});
+		shown.sort(function(a, b) {
+			var x = value(a, sortKey), y = value(b, sortKey);
+			return (x < y ? -1 : x > y ? 1 : a.dir < b.dir ? -1 : 1) * sortDir;
+		});
+		rows.textContent = "";
+		shown.forEach(function(p) {
+			var tr = rows.insertRow();
+			cell(tr, p.dir);
+			var badge = document.createElement("span");
+			badge.className = "badge " + p.status;
+			badge.textContent = p.status;
+			var td = cell(tr, "");
+			td.appendChild(badge);
+			if (p.known_issue) td.appendChild(document.createTextNode(" tracked in " + p.known_issue));
+			cell(tr, p.tags.join(","));
+			cell(tr, p.seconds ? p.seconds.toFixed(1) + " s" : "", "num");
+			cell(tr, size(p.size), "num");
+		});
+		count.textContent = shown.length + " of " + packages.length + " packages";
+		headers.forEach(function(th) {
+			th.className = th.dataset.key !== sortKey ? "" : sortDir > 0 ? "asc" : "desc";
+		});
+	}
+	headers.forEach(function(th) {
+		th.addEventListener("click", function() {
+			sortDir = th.dataset.key === sortKey ? -sortDir : 1;
+			sortKey = th.dataset.key;
+			render();
+		});
+	});
+	filter.addEventListener("input", render);
+	render();
+})();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,107 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// htmlDataPattern matches the packages embedded in an HTML report.
+var htmlDataPattern = regexp.MustCompile(`(?s)<script id="data" type="application/json">(.*?)</script>`)
+
+// readHTMLPackages returns the packages embedded in the HTML report page.
+func readHTMLPackages(t *testing.T, page string) []htmlPackage {
+	t.Helper()
+	m := htmlDataPattern.FindStringSubmatch(page)
+	if m == nil {
+		t.Fatalf("HTML report lacks the package data:\n%s", page)
+	}
+	var pkgs []htmlPackage
+	if err := json.Unmarshal([]byte(m[1]), &pkgs); err != nil {
+		t.Fatalf("package data %q: %v", m[1], err)
+	}
+	return pkgs
+}
+
+func TestWriteHTML(t *testing.T) {
+	status := BuildStatus{
+		passing:  []BuildResult{{dir: "cmds/core/init", tags: []string{"noasm", "purego"}, duration: 1250 * time.Millisecond, size: 1 << 20}},
+		failing:  []BuildResult{{dir: "cmds/core/ip", duration: 3 * time.Second}},
+		excluded: []BuildResult{{dir: "cmds/core/</script>"}},
+		known:    map[string]knownFailure{"cmds/core/ip": {issue: "https://github.com/tinygo-org/tinygo/issues/1234"}},
+	}
+	info := reportInfo{version: "0.33.0", tags: []string{"linux", "tinygo"}}
+	var b strings.Builder
+	if err := writeHTML(&b, config{targets: []string{"linux/amd64"}}, info, status); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	for _, want := range []string{
+		"<!DOCTYPE html>",
+		"using tinygo 0.33.0",
+		"Targets: linux/amd64",
+		"<code>linux tinygo</code>",
+		`<input id="filter"`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("HTML report lacks %q:\n%s", want, page)
+		}
+	}
+	// The page is self-contained.
+	for _, external := range []string{"<link", "src="} {
+		if strings.Contains(page, external) {
+			t.Errorf("HTML report refers to external resources with %q", external)
+		}
+	}
+
+	want := []htmlPackage{
+		{Dir: "cmds/core/</script>", Status: statusExcluded, Tags: []string{}},
+		{Dir: "cmds/core/init", Status: statusPassing, Tags: []string{"noasm", "purego"}, Seconds: 1.25, Size: 1 << 20},
+		{Dir: "cmds/core/ip", Status: statusFailing, Tags: []string{}, Seconds: 3, KnownIssue: "https://github.com/tinygo-org/tinygo/issues/1234"},
+	}
+	if got := readHTMLPackages(t, page); !reflect.DeepEqual(got, want) {
+		t.Errorf("HTML report packages = %+v, want %+v", got, want)
+	}
+}
+
+func TestRunHTML(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly, cfg.format, cfg.pathMD = true, formatHTML, "status.html"
+
+	if code := run(cfg, []string{"cmds/pass", "cmds/fail", "cmds/excluded"}, io.Discard, io.Discard); code != exitUpdates {
+		t.Fatalf("run() = %d, want %d", code, exitUpdates)
+	}
+	page := readFile(t, cfg.pathMD)
+	if strings.Contains(page, "###") {
+		t.Errorf("HTML report has markdown:\n%s", page)
+	}
+	var got []string
+	for _, p := range readHTMLPackages(t, page) {
+		got = append(got, p.Dir+" "+p.Status)
+	}
+	want := []string{"cmds/excluded excluded", "cmds/fail failing", "cmds/pass passing"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("HTML report packages = %q, want %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		format     string
+		streamJSON bool
+	}{
+		{format: "pdf"},
+		{format: formatHTML, streamJSON: true},
+	} {
+		cfg.format, cfg.streamJSON = tt.format, tt.streamJSON
+		if code := run(cfg, []string{"cmds/pass"}, io.Discard, io.Discard); code != exitUsage {
+			t.Errorf("run() with -format %s, -stream-json %t = %d, want %d", tt.format, tt.streamJSON, code, exitUsage)
+		}
+	}
+}
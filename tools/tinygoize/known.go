@@ -0,0 +1,157 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// knownFailure is an entry of a -known-failures file: a package whose
+// failure is tracked upstream.
+type knownFailure struct {
+	issue string
+	// expires is the last day the failure is accepted, or zero if it
+	// does not expire.
+	expires time.Time
+}
+
+// expired reports whether the day k expires is past at now.
+func (k knownFailure) expired(now time.Time) bool {
+	return !k.expires.IsZero() && !now.Before(k.expires.AddDate(0, 0, 1))
+}
+
+// readKnownFailures reads a -known-failures file, listing one package
+// directory per line with the upstream issue tracking its failure and,
+// optionally, the date the entry expires, e.g.
+//
+//	cmds/core/ip https://github.com/tinygo-org/tinygo/issues/1234 2025-06-30
+//
+// Blank lines and lines starting with # are ignored.
+func readKnownFailures(path string) (map[string]knownFailure, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	known := make(map[string]knownFailure)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: want DIR ISSUE [EXPIRY], got %q", path, n, line)
+		}
+		k := knownFailure{issue: fields[1]}
+		if len(fields) == 3 {
+			if k.expires, err = time.Parse(time.DateOnly, fields[2]); err != nil {
+				return nil, fmt.Errorf("%s:%d: expiry date: %w", path, n, err)
+			}
+		}
+		// Clean the dirs as run does those given.
+		known[filepath.ToSlash(filepath.Clean(fields[0]))] = k
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return known, nil
+}
+
+// matchKnown returns the entries of known matching FAILING packages of
+// status, which are listed as KNOWN FAILING.
+func matchKnown(status BuildStatus, known map[string]knownFailure) map[string]knownFailure {
+	matched := make(map[string]knownFailure)
+	for _, br := range status.failing {
+		dir := filepath.ToSlash(br.dir)
+		if k, ok := known[dir]; ok {
+			matched[dir] = k
+		}
+	}
+	return matched
+}
+
+// splitFailing splits the FAILING packages of status into those not listed
+// in status.known and the KNOWN FAILING ones.
+func splitFailing(status BuildStatus) (unknown, known []BuildResult) {
+	for _, br := range status.failing {
+		if _, ok := status.known[filepath.ToSlash(br.dir)]; ok {
+			known = append(known, br)
+		} else {
+			unknown = append(unknown, br)
+		}
+	}
+	return unknown, known
+}
+
+// staleKnown returns the entries of known that should be removed: those of
+// FAILING packages past their expiry date at now, and those of packages
+// that build or are excluded. Entries of packages that were not processed
+// are neither.
+func staleKnown(status BuildStatus, known map[string]knownFailure, now time.Time) (expired, fixed []string) {
+	for dir, k := range matchKnown(status, known) {
+		if k.expired(now) {
+			expired = append(expired, dir)
+		}
+	}
+	for _, set := range [][]BuildResult{status.passing, status.passingWarnings, status.excluded} {
+		for _, br := range set {
+			dir := filepath.ToSlash(br.dir)
+			if _, ok := known[dir]; ok {
+				fixed = append(fixed, dir)
+			}
+		}
+	}
+	sort.Strings(expired)
+	sort.Strings(fixed)
+	return expired, fixed
+}
+
+// writeStaleKnown reports the entries of the -known-failures file path
+// found by staleKnown.
+func writeStaleKnown(w io.Writer, path string, known map[string]knownFailure, expired, fixed []string) {
+	for _, set := range []struct {
+		header string
+		dirs   []string
+	}{
+		{"Known failures in " + path + " past their expiry date:", expired},
+		{"Known failures in " + path + " that no longer fail:", fixed},
+	} {
+		if len(set.dirs) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s\n", set.header)
+		for _, dir := range set.dirs {
+			k := known[dir]
+			fmt.Fprintf(w, "  %s (%s", dir, k.issue)
+			if !k.expires.IsZero() {
+				fmt.Fprintf(w, ", expires %s", k.expires.Format(time.DateOnly))
+			}
+			fmt.Fprintf(w, ")\n")
+		}
+	}
+}
+
+// splitKnownFiles splits files into those of packages not listed in
+// status.known and those of KNOWN FAILING packages.
+func splitKnownFiles(status BuildStatus, files []string) (unknown, known []string) {
+	for _, file := range files {
+		if _, ok := status.known[filepath.ToSlash(filepath.Dir(file))]; ok {
+			known = append(known, file)
+		} else {
+			unknown = append(unknown, file)
+		}
+	}
+	return unknown, known
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadKnownFailures(t *testing.T) {
+	path := t.TempDir() + "/known.txt"
+	for _, tt := range []struct {
+		name, src string
+		want      map[string]knownFailure
+		wantErr   bool
+	}{
+		{
+			name: "entries",
+			src:  "# Tracked upstream\n./cmds/core/ip/ #1234 2025-06-30\n\ncmds/core/dd #42\n",
+			want: map[string]knownFailure{
+				"cmds/core/ip": {issue: "#1234", expires: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)},
+				"cmds/core/dd": {issue: "#42"},
+			},
+		},
+		{name: "no issue", src: "cmds/core/ip\n", wantErr: true},
+		{name: "bad date", src: "cmds/core/ip #1234 30/06/2025\n", wantErr: true},
+		{name: "extra field", src: "cmds/core/ip #1234 2025-06-30 x\n", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tt.src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readKnownFailures(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readKnownFailures() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !cmp.Equal(got, tt.want, cmp.AllowUnexported(knownFailure{})) {
+				t.Errorf("readKnownFailures() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaleKnown(t *testing.T) {
+	status := BuildStatus{
+		passing:  []BuildResult{{dir: "cmds/core/ls"}},
+		failing:  []BuildResult{{dir: "cmds/core/ip"}, {dir: "cmds/core/dd"}, {dir: "cmds/core/init"}},
+		excluded: []BuildResult{{dir: "cmds/exp/plan9"}},
+	}
+	day := time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)
+	known := map[string]knownFailure{
+		"cmds/core/ip":   {issue: "#1", expires: day},
+		"cmds/core/dd":   {issue: "#2", expires: day.AddDate(0, 0, -1)},
+		"cmds/core/init": {issue: "#3"},
+		"cmds/core/ls":   {issue: "#4"},
+		"cmds/exp/plan9": {issue: "#5", expires: day.AddDate(0, 0, 1)},
+		"cmds/not/built": {issue: "#6", expires: day.AddDate(-1, 0, 0)},
+	}
+	// The last day of an entry is still accepted.
+	expired, fixed := staleKnown(status, known, day.Add(23*time.Hour))
+	if want := []string{"cmds/core/dd"}; !cmp.Equal(expired, want) {
+		t.Errorf("staleKnown() expired = %v, want %v", expired, want)
+	}
+	if want := []string{"cmds/core/ls", "cmds/exp/plan9"}; !cmp.Equal(fixed, want) {
+		t.Errorf("staleKnown() fixed = %v, want %v", fixed, want)
+	}
+}
+
+func TestRunKnownFailures(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly, cfg.staleSeverity = true, severityWarn
+	cfg.pathMD, cfg.pathJSON = "status.md", "status.json"
+	cfg.knownFailures = "known.txt"
+	dirs := []string{"cmds/pass", "cmds/fail"}
+
+	// Without the entry, cmds/fail lacks the constraint.
+	if err := os.WriteFile("known.txt", []byte("# None yet\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitUpdates {
+		t.Errorf("run() without entries = %d, want %d", code, exitUpdates)
+	}
+
+	if err := os.WriteFile("known.txt", []byte("cmds/fail https://example.com/issues/1 2999-12-31\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var notes strings.Builder
+	if code := run(cfg, dirs, &notes, io.Discard); code != exitOK {
+		t.Errorf("run() = %d, want %d:\n%s", code, exitOK, &notes)
+	}
+	md := readFile(t, cfg.pathMD)
+	for _, want := range []string{
+		"### FAILING (0 commands)\n",
+		"### KNOWN FAILING (1 commands)\n - [cmds/fail](cmds/fail) (tracked in https://example.com/issues/1)\n",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown lacks %q:\n%s", want, md)
+		}
+	}
+	var r Report
+	if err := json.Unmarshal([]byte(readFile(t, cfg.pathJSON)), &r); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range r.Packages {
+		if want := map[string]string{"cmds/fail": "https://example.com/issues/1"}[p.Dir]; p.KnownIssue != want {
+			t.Errorf("%s: known issue %q, want %q", p.Dir, p.KnownIssue, want)
+		}
+	}
+
+	for _, tt := range []struct {
+		entry, want string
+	}{
+		{"cmds/fail #1 2000-01-01\n", "Known failures in known.txt past their expiry date:\n  cmds/fail (#1, expires 2000-01-01)\n"},
+		{"cmds/fail #1\ncmds/pass #2\n", "Known failures in known.txt that no longer fail:\n  cmds/pass (#2)\n"},
+	} {
+		if err := os.WriteFile("known.txt", []byte(tt.entry), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		notes.Reset()
+		if code := run(cfg, dirs, &notes, io.Discard); code != exitKnown {
+			t.Errorf("run() with %q = %d, want %d", tt.entry, code, exitKnown)
+		}
+		if !strings.Contains(notes.String(), tt.want) {
+			t.Errorf("run() with %q notes:\n%s\nwant %q", tt.entry, &notes, tt.want)
+		}
+	}
+}
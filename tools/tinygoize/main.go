@@ -1,82 +1,1167 @@
-// Copyright 2017-2018 the u-root Authors. All rights reserved
+// Copyright 2017-2024 the u-root Authors. All rights reserved
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// usage: invoke this with a list of directories.
-// For each directory, it will try a tinygobuild with
-// CGO_ENABLED=0, GOARCH=amd64, and GOOS=linux.
-// If the tinygo build fails, it will use ast package
-// to rewrite //go:build lines as follows:
-// the line starts as //go:build expr
-// it is rewritten to //go:build !tinygo && (expr)
-// When the file is written, the expression seems
-// to be simplified.
-
+// tinygoize builds packages with tinygo and updates their build constraints.
+//
+// Synopsis:
+//
+//	tinygoize [OPTIONS] DIR...
+//
+// Description:
+//
+//	For each directory, tinygoize runs `tinygo build` with CGO_ENABLED=0,
+//	GOARCH=amd64, and GOOS=linux, or for each of the -targets. If the build
+//	fails, the constraint
+//
+//	    //go:build !tinygo || tinygo.enable
+//
+//	is ANDed into the //go:build line of every .go file in the package. If
+//	the build succeeds, the constraint is removed again. Packages whose
+//	files are all excluded by their existing build constraints are reported
+//	as EXCLUDED and are not modified. Directories without non-test .go
+//	files are reported as EMPTY and are neither built nor modified.
+//
+//	A DIR of "-" reads the directories from stdin, one per line, e.g.
+//
+//	    find cmds -name '*.go' -exec dirname {} \; | sort -u | tinygoize -
+//
+//	Directories given more than once are built once.
+//
+//	A summary of the passing, failing, and excluded packages is written to
+//	the -o file, or stdout, as markdown or, with -format html, as a web page.
+//
+// Options:
+//
+//	-t:                    path to tinygo (default "tinygo")
+//	-j:                    number of parallel builds (default NumCPU)
+//	-o:                    report output file, "-" or "" for stdout, in the
+//	                       -format
+//	-format:               format of the -o report: "markdown" (default), or
+//	                       "html" for a self-contained page with a table of the
+//	                       packages, their status, tags, build time, and binary
+//	                       size, which can be sorted and filtered in the
+//	                       browser, e.g. to publish as a static status page
+//	-open:                 open the -o report with xdg-open, or open on macOS,
+//	                       e.g. in the browser; outside an interactive session
+//	                       or without the opener, only warn
+//	-n:                    check only, do not modify any files
+//	-v:                    verbose; show the progress of each package, or, if
+//	                       stderr is not a terminal, log the percentage complete
+//	                       every 10 packages or -progress-interval
+//	-progress-interval:    with -v and stderr not a terminal, the longest time
+//	                       between progress logs, 0 to log every package
+//	                       (default 5s)
+//	-heartbeat-after:      with -v, log builds running longer than this as
+//	                       still building, e.g. "[3] cmds/core/ls still
+//	                       building (90s elapsed)" (default 1m)
+//	-heartbeat:            the time between these logs, 0 for none
+//	                       (default 30s)
+//	-strip-excluded:       remove the tinygo constraint from EXCLUDED packages
+//	-machine:              report fatal errors as a JSON object on stderr
+//	-gap:                  report packages whose constraints disagree with their
+//	                       build result, i.e. the work left to do
+//	-has-constraint:       only build packages carrying the tinygo constraint
+//	-no-constraint:        only build packages not carrying the tinygo constraint
+//	-o-dir:                keep the built binaries in this directory; a build
+//	                       that produces no binary counts as failing
+//	-emoji:                prefix the headers of the passing, failing, and
+//	                       excluded sections with emoji, e.g. ✅ PASSING
+//	-timestamp-header:     record the generation time (UTC) and, inside a git
+//	                       repository, the short commit hash in the report header
+//	-ramp:                 build this many packages alone first to warm a cold
+//	                       build cache, then use all -j workers (default 0)
+//	-group-by-category:    group each report section by command category, e.g.
+//	                       cmds/core, with per-category counts
+//	-max-per-section:      list at most this many commands in each markdown
+//	                       section, noting how many more there are; the counts
+//	                       and the JSON report stay complete (default 0, all)
+//	-targets:              comma-separated GOOS/GOARCH pairs to build for
+//	                       (default linux/amd64); a package keeps the tinygo
+//	                       constraint unless it builds on all of them
+//	-json:                 JSON report output file, recording the tinygo version,
+//	                       the resolved build tags, and each package's status
+//	-stream-json:          instead of the -o report, write a JSON array
+//	                       to the -o file with an object for each package as
+//	                       its build completes, for each target; the array is
+//	                       closed even if the run is interrupted
+//	-csv:                  CSV report output file for spreadsheets, with the
+//	                       directory, name, status, build tags, and build
+//	                       duration of each command
+//	-metrics:              Prometheus textfile output, e.g. for node_exporter,
+//	                       with the numbers of passing, failing, and excluded
+//	                       commands and of modified files, and the build
+//	                       duration of each command
+//	-compare:              compare the results with an earlier JSON report and
+//	                       flag changes of the tinygo version and build tags
+//	-compare-head:         compare the results with the -json report as committed
+//	                       at HEAD, e.g. to see what a branch changed
+//	-cache:                cache build results in this file and skip packages
+//	                       whose sources, tinygo version, and tags are unchanged;
+//	                       a cache locked by a concurrent run is not used
+//	-warnings:             list passing commands whose build output contains
+//	                       warnings in a PASSING WITH WARNINGS section
+//	-warnings-as-failures: treat commands whose build output contains warnings
+//	                       as failing
+//	-warning-pattern:      regular expression matching a warning line; may be
+//	                       repeated, replacing the default "(?i)\bwarning:"
+//	-patch:                write the constraint changes as a unified diff to this
+//	                       file, for `git apply`, instead of modifying the
+//	                       sources; implies -n
+//	-repro-file:           write a shell script to this file with a line
+//	                       reproducing the build of each failing package, e.g.
+//	                       for upstream tinygo bug reports
+//	-changed-only:         only fix up the constraints of the files changed
+//	                       since this git ref, committed or not, and of new
+//	                       untracked files, leaving the others alone even if
+//	                       they need an update, e.g. "-changed-only main" to
+//	                       keep the diff of a branch to the files it touches
+//	-gate-file:            exclude a failing package from tinygo builds with a
+//	                       single generated file of this name, e.g. tinygo.go,
+//	                       instead of constraining each of its files; the file
+//	                       fails tinygo builds unless tinygo.enable is set
+//	-cache-dir:            keep the Go and tinygo build caches in this directory,
+//	                       created if needed, instead of the user's, by setting
+//	                       GOCACHE and XDG_CACHE_HOME for the builds; a fresh
+//	                       directory for each run measures cold builds, and one
+//	                       shared across runs warm builds, isolated from other
+//	                       builds on the machine
+//	-retry-clear-cache:    if a build fails with an error pointing at a corrupt
+//	                       tinygo cache, clear the cache with `tinygo clean` and
+//	                       build the package again, once
+//	-confirm-failures:     build the failing packages again after the parallel
+//	                       builds, one at a time; those that build on their
+//	                       own are reported as passing, noted as having failed
+//	                       only under parallelism, e.g. for lack of memory,
+//	                       rather than as tinygo failures
+//	-min-free:             free disk space in MiB required in the temporary,
+//	                       tinygo cache or -cache-dir, -cache, and -o-dir
+//	                       directories before and during the builds, 0 to
+//	                       disable (default 1024); builds failing for lack of
+//	                       disk space are tool errors
+//	-missing-severity:     with -n, "error" (default) to exit 1 if failing
+//	                       packages lack the constraint, or "warn" to only
+//	                       note it
+//	-stale-severity:       with -n, "error" (default) to exit 1 if packages that
+//	                       build or are excluded carry the constraint, or "warn"
+//	                       to only note it
+//	-file-mode:            octal permissions of rewritten and generated source
+//	                       files, e.g. 0640; by default, rewritten files keep
+//	                       theirs and gate files take those of the package
+//	-on-modified:          command to run, unless -n is given, with the files
+//	                       whose constraints were updated appended as arguments,
+//	                       e.g. "gofmt -w" or "git add"; words are split on
+//	                       spaces, without shell quoting
+//	-max-output:           keep only the last this many bytes of the output of
+//	                       each build, 0 to keep all of it (default 8192)
+//	-dep-order:            build the packages with the fewest dependencies, as
+//	                       reported by `go list`, first, so that the libraries
+//	                       they share with larger packages are compiled and
+//	                       cached early; best combined with -ramp. If `go list`
+//	                       fails, the packages are built in the order given
+//	-plan:                 print the order -dep-order builds the packages in and
+//	                       exit
+//	-implicated-files:     list the source files named in the errors of each
+//	                       failing package
+//	-size-baseline:        JSON file mapping command directories to binary sizes
+//	                       in bytes, e.g. {"cmds/core/ls": 1261568}; with -o-dir,
+//	                       list the commands whose binaries grew by more than
+//	                       -size-threshold and exit 5 if there are any
+//	-size-threshold:       percentage by which a binary may grow over its
+//	                       -size-baseline size (default 5)
+//	-expected-excluded:    file listing the packages expected to be EXCLUDED, one
+//	                       directory per line; exit 6 if other packages are
+//	                       EXCLUDED, or listed ones are built instead
+//	-known-failures:       file listing failures tracked upstream, one package
+//	                       directory per line with the issue and an optional
+//	                       expiry date, e.g. "cmds/core/ip URL 2025-06-30";
+//	                       listed FAILING packages are reported as KNOWN
+//	                       FAILING and need no update with -n. Exit 7 if an
+//	                       entry has expired or its package no longer fails
+//	-tag-impact:           build each command given additional tags by the
+//	                       built-in table once without each of them, and list
+//	                       in a "Tag impact" section the commands each tag lets
+//	                       build or breaks; a tag changing nothing may be
+//	                       obsolete. With -targets, only the first is built
+//	-diff-exit:            like `git diff --exit-code`: print the constraint
+//	                       changes as a patch and exit 1 if there are any, 0 if
+//	                       not, without a report or modifying any files; for
+//	                       pre-commit hooks
+//	-quiet:                with -diff-exit, do not print the patch
+//	-explain:              build the single directory given once, streaming the
+//	                       tinygo output, and print its tags, constraints,
+//	                       build command, and whether it is PASSING, FAILING,
+//	                       or EXCLUDED, without a report or modifying any files
+//	-assert-consistent:    check that every FAILING package carries the tinygo
+//	                       constraint and no PASSING package does, explain each
+//	                       that disagrees, and exit 1 if any do; implies -n
+//	-repeat:               build each package this many times and list the
+//	                       minimum, median, and maximum build time of each
+//	                       command in a "Build times" section and the -json
+//	                       report; implies building without -cache (default 1)
+//	-allow-any-tag:        allow commands to be built with additional build tags
+//	                       outside the allowlist, e.g. noasm and purego
+//	-version:              print the tinygoize version and exit
+//
+// Exit status:
+//
+//	0: success
+//	1: -n was given and constraint updates are required, -assert-consistent
+//	   was given and constraints disagree with build results, or -diff-exit
+//	   was given and files would change
+//	2: some packages could not be processed
+//	3: bad flags or arguments
+//	4: unusable environment, e.g. tinygo is missing or the disk is full
+//	5: binaries grew by more than -size-threshold
+//	6: the EXCLUDED packages differ from -expected-excluded
+//	7: -known-failures has expired entries or entries that no longer fail
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"encoding/json"
+	"errors"
 	"flag"
-	"go/parser"
-	"go/printer"
-	"go/token"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+)
+
+// Exit codes.
+const (
+	exitOK      = 0 // Nothing to do, or all updates were written.
+	exitUpdates = 1 // Check only: constraint updates are required.
+	exitError   = 2 // Some packages could not be processed.
+	exitUsage   = 3 // Bad flags or arguments.
+	exitSetup   = 4 // The environment is unusable, e.g. tinygo is missing.
+	exitSize    = 5 // Binaries grew by more than -size-threshold.
+	exitExclude = 6 // The EXCLUDED packages are not those expected.
+	exitKnown   = 7 // Entries of -known-failures are to be removed.
 )
 
-const goBuild = "//go:build "
+type config struct {
+	tinygo          string
+	jobs            int
+	pathMD          string
+	checkOnly       bool
+	verbose         bool
+	stripExcluded   bool
+	machine         bool
+	gap             bool
+	hasConstraint   bool
+	noConstraint    bool
+	outDir          string
+	timestampHeader bool
+	ramp            int
+	version         bool
+	groupByCategory bool
+	emoji           bool
+	// format is the format of the -o report, formatMarkdown or
+	// formatHTML.
+	format string
+	// open opens the -o report, see openReport.
+	open bool
+	// maxPerSection caps the commands listed in each markdown section, 0
+	// for no limit.
+	maxPerSection int
+	// progressInterval is the longest time between progress logs, see
+	// progressSummary.
+	progressInterval time.Duration
+	// heartbeatAfter is how long a build runs before heartbeat logs that
+	// it is still going, every heartbeatInterval.
+	heartbeatAfter, heartbeatInterval time.Duration
+	// targets are the GOOS/GOARCH pairs to build for, and target the one
+	// being built.
+	targets []string
+	target  string
+	// pathJSON is the JSON report file, and compare an older one to
+	// compare it with.
+	pathJSON string
+	compare  string
+	// compareHead compares with the pathJSON committed at HEAD instead.
+	compareHead bool
+	// streamJSON streams the results to the -o file as they complete,
+	// through stream.
+	streamJSON bool
+	stream     *jsonStream
+	// pathCSV is the CSV report file, and pathMetrics the Prometheus
+	// textfile.
+	pathCSV     string
+	pathMetrics string
+	// cachePath is the -cache file, and cache the results read from it.
+	cachePath string
+	cache     *buildCache
+	// warnings, warningsAsFailures, and warningPatterns control how
+	// warnings in the output of passing builds are treated.
+	warnings           bool
+	warningsAsFailures bool
+	warningPatterns    []*regexp.Regexp
+	// patch is the file the constraint changes are written to instead of
+	// the sources.
+	patch string
+	// reproFile is the script reproducing the failing builds.
+	reproFile string
+	// gateFile is the name of the file gating failing packages, see
+	// fixupPkgConstraints.
+	gateFile string
+	// changedOnly is the git ref since which the files whose constraints
+	// are fixed up, changed, were changed.
+	changedOnly string
+	changed     changedSet
+	// cacheDir is the directory holding the Go and tinygo build caches,
+	// or empty for those of the user.
+	cacheDir string
+	// retryClearCache retries builds failing because of a corrupt tinygo
+	// cache, which cleaner clears.
+	retryClearCache bool
+	cleaner         *cacheCleaner
+	// confirmFailures builds the failing packages again one at a time, see
+	// confirmFailures.
+	confirmFailures bool
+	// minFree is the disk space in MiB that must be left for builds to be
+	// started, see checkDiskSpace.
+	minFree int64
+	// missingSeverity and staleSeverity are the severities of missing
+	// and stale constraints with -n, severityError if "".
+	missingSeverity string
+	staleSeverity   string
+	// fileMode, if set, are the permissions of the sources written.
+	fileMode os.FileMode
+	// onModified is the command run with the modified files, see
+	// runOnModified.
+	onModified string
+	// maxOutput is the number of bytes of build output kept, see
+	// truncateOutput.
+	maxOutput int
+	// depOrder builds the packages in dependency order, see depOrder, and
+	// plan prints that order instead.
+	depOrder bool
+	plan     bool
+	// implicatedFiles lists the source files named in the errors of failing
+	// packages.
+	implicatedFiles bool
+	// sizeBaseline is the file of binary sizes compared with those kept
+	// in outDir, see sizeRegressions, and sizeThreshold the growth in
+	// percent allowed.
+	sizeBaseline  string
+	sizeThreshold float64
+	// expectedExcluded is the file listing the packages expected to be
+	// EXCLUDED, see diffExcluded.
+	expectedExcluded string
+	// knownFailures is the file listing the failures tracked upstream,
+	// see readKnownFailures.
+	knownFailures string
+	// tagImpact builds commands without their additional tags, see
+	// tagImpacts.
+	tagImpact bool
+	// diffExit only reports whether files would change, see diffExit, and
+	// quiet keeps it from printing the changes.
+	diffExit bool
+	quiet    bool
+	// explain builds a single package verbosely, see explain.
+	explain bool
+	// assertConsistent checks the constraints against the build results
+	// without modifying any files, see inconsistencies.
+	assertConsistent bool
+	// repeat is the number of times each package is built to measure the
+	// variance of build times, see repeatBuild.
+	repeat int
+	// allowAnyTag skips checking the additional build tags of commands
+	// against allowedBuildTags.
+	allowAnyTag bool
+}
+
+// buildVersion may be set at link time with
+// -ldflags "-X main.buildVersion=...".
+var buildVersion string
+
+// toolVersion returns the version of tinygoize itself: the linker-injected
+// version if set, else the module version and VCS revision from the build
+// info.
+func toolVersion() string {
+	if buildVersion != "" {
+		return buildVersion
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	v := bi.Main.Version
+	var rev, dirty string
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				dirty = "-dirty"
+			}
+		}
+	}
+	if rev != "" {
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		v += " " + rev + dirty
+	}
+	return v
+}
+
+// parseFlags parses the command line into a config and the directories to
+// build.
+func parseFlags(args []string, stderr io.Writer) (config, []string, error) {
+	var cfg config
+	fs := flag.NewFlagSet("tinygoize", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&cfg.tinygo, "t", "tinygo", "Path to tinygo")
+	fs.IntVar(&cfg.jobs, "j", runtime.NumCPU(), "Number of parallel builds")
+	fs.StringVar(&cfg.pathMD, "o", "", "Report output file, '-' or '' for stdout, see -format")
+	fs.StringVar(&cfg.format, "format", formatMarkdown, "Format of the -o report: markdown or html")
+	fs.BoolVar(&cfg.open, "open", false, "Open the -o report, e.g. in the browser")
+	fs.BoolVar(&cfg.checkOnly, "n", false, "Check only, do not modify any files")
+	fs.BoolVar(&cfg.verbose, "v", false, "Verbose")
+	fs.DurationVar(&cfg.progressInterval, "progress-interval", 5*time.Second, "With -v and no terminal, the longest time between progress logs, 0 for every package")
+	fs.DurationVar(&cfg.heartbeatAfter, "heartbeat-after", time.Minute, "With -v, how long a build runs before it is logged to be still building")
+	fs.DurationVar(&cfg.heartbeatInterval, "heartbeat", 30*time.Second, "With -v, the time between logs of a build still building, 0 for none")
+	fs.BoolVar(&cfg.stripExcluded, "strip-excluded", false, "Remove the tinygo constraint from EXCLUDED packages")
+	fs.BoolVar(&cfg.machine, "machine", false, "Report fatal errors as a JSON object on stderr")
+	fs.BoolVar(&cfg.gap, "gap", false, "Report packages whose constraints disagree with their build result")
+	fs.BoolVar(&cfg.hasConstraint, "has-constraint", false, "Only build packages carrying the tinygo constraint")
+	fs.BoolVar(&cfg.noConstraint, "no-constraint", false, "Only build packages not carrying the tinygo constraint")
+	fs.StringVar(&cfg.outDir, "o-dir", "", "Keep the built binaries in this directory")
+	fs.BoolVar(&cfg.emoji, "emoji", false, "Prefix the headers of the main report sections with emoji")
+	fs.BoolVar(&cfg.timestampHeader, "timestamp-header", false, "Record the generation time and git revision in the report header")
+	fs.IntVar(&cfg.ramp, "ramp", 0, "Number of packages to build alone to warm the build cache before going parallel")
+	fs.BoolVar(&cfg.groupByCategory, "group-by-category", false, "Group each report section by command category, e.g. cmds/core")
+	fs.IntVar(&cfg.maxPerSection, "max-per-section", 0, "List at most this many commands in each markdown section (0 for all)")
+	fs.Func("targets", "Comma-separated GOOS/GOARCH pairs to build for (default "+defaultTarget+")", func(s string) error {
+		cfg.targets = append(cfg.targets, strings.Split(s, ",")...)
+		return nil
+	})
+	fs.StringVar(&cfg.pathJSON, "json", "", "JSON report output file")
+	fs.BoolVar(&cfg.streamJSON, "stream-json", false, "Stream the results to the -o file as a JSON array instead of the report")
+	fs.StringVar(&cfg.pathCSV, "csv", "", "CSV report output file")
+	fs.StringVar(&cfg.pathMetrics, "metrics", "", "Prometheus textfile metrics output file")
+	fs.StringVar(&cfg.compare, "compare", "", "Compare the results with an earlier JSON report")
+	fs.BoolVar(&cfg.compareHead, "compare-head", false, "Compare the results with the -json report committed at HEAD")
+	fs.StringVar(&cfg.cachePath, "cache", "", "File caching build results across runs")
+	fs.BoolVar(&cfg.warnings, "warnings", false, "Report passing commands whose build emitted warnings separately")
+	fs.BoolVar(&cfg.warningsAsFailures, "warnings-as-failures", false, "Treat commands whose build emitted warnings as failing")
+	fs.Func("warning-pattern", "Regular expression matching a warning line, may be repeated", func(s string) error {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		cfg.warningPatterns = append(cfg.warningPatterns, re)
+		return nil
+	})
+	fs.StringVar(&cfg.patch, "patch", "", "Write the constraint changes to this patch file instead of the sources")
+	fs.StringVar(&cfg.reproFile, "repro-file", "", "Write a shell script reproducing the build of each failing package to this file")
+	fs.StringVar(&cfg.changedOnly, "changed-only", "", "Only fix up the constraints of the files changed since this git ref")
+	fs.StringVar(&cfg.gateFile, "gate-file", "", "Gate failing packages with a generated file of this name instead of constraining each file")
+	fs.StringVar(&cfg.cacheDir, "cache-dir", "", "Directory for the Go and tinygo build caches, instead of the user's")
+	fs.BoolVar(&cfg.confirmFailures, "confirm-failures", false, "Build failing packages again one at a time, reporting those that then build as passing")
+	fs.BoolVar(&cfg.retryClearCache, "retry-clear-cache", false, "Retry builds failing because of a corrupt tinygo cache after clearing it")
+	fs.Int64Var(&cfg.minFree, "min-free", 1024, "Free disk space in MiB required to start a build, 0 to disable")
+	fs.StringVar(&cfg.missingSeverity, "missing-severity", severityError, "With -n, severity of failing packages lacking the constraint: error or warn")
+	fs.StringVar(&cfg.staleSeverity, "stale-severity", severityError, "With -n, severity of building or excluded packages carrying the constraint: error or warn")
+	fs.Func("file-mode", "Octal permissions of rewritten source files, e.g. 0640 (default: keep them)", func(s string) error {
+		mode, err := strconv.ParseUint(s, 8, 32)
+		if err != nil || mode == 0 || mode > 0o777 {
+			return fmt.Errorf("%q is not an octal permission mode", s)
+		}
+		cfg.fileMode = os.FileMode(mode)
+		return nil
+	})
+	fs.StringVar(&cfg.onModified, "on-modified", "", "Command to run with the modified files appended as arguments")
+	fs.IntVar(&cfg.maxOutput, "max-output", 8192, "Bytes of the output of each build to keep, 0 for all")
+	fs.BoolVar(&cfg.depOrder, "dep-order", false, "Build the packages with the fewest dependencies first to reuse the build cache")
+	fs.BoolVar(&cfg.plan, "plan", false, "Print the order -dep-order builds the packages in and exit")
+	fs.BoolVar(&cfg.implicatedFiles, "implicated-files", false, "List the source files named in the errors of failing packages")
+	fs.StringVar(&cfg.sizeBaseline, "size-baseline", "", "JSON file of command binary sizes to compare those in -o-dir with")
+	fs.Float64Var(&cfg.sizeThreshold, "size-threshold", 5, "Percentage by which a binary may grow over its -size-baseline size")
+	fs.StringVar(&cfg.expectedExcluded, "expected-excluded", "", "File listing the packages expected to be EXCLUDED, one per line")
+	fs.StringVar(&cfg.knownFailures, "known-failures", "", "File listing failing packages tracked upstream, with the issue and an optional expiry date")
+	fs.BoolVar(&cfg.tagImpact, "tag-impact", false, "Report which additional build tags change the outcome of builds")
+	fs.BoolVar(&cfg.diffExit, "diff-exit", false, "Print the constraint changes as a patch and exit 1 if there are any, without writing")
+	fs.BoolVar(&cfg.quiet, "quiet", false, "With -diff-exit, do not print the patch")
+	fs.BoolVar(&cfg.explain, "explain", false, "Build the single directory given verbosely, without a report")
+	fs.BoolVar(&cfg.assertConsistent, "assert-consistent", false, "Check that the constraints agree with the build results, without modifying any files")
+	fs.IntVar(&cfg.repeat, "repeat", 1, "Build each package this many times and report the min/median/max build time")
+	fs.BoolVar(&cfg.allowAnyTag, "allow-any-tag", false, "Allow additional build tags outside the allowlist")
+	fs.BoolVar(&cfg.version, "version", false, "Print the tinygoize version and exit")
+
+	// A bad flag may come before -machine, so look for it up front to
+	// keep human-readable usage text out of machine output.
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if name := strings.TrimLeft(arg, "-"); arg != name && (name == "machine" || name == "machine=true") {
+			cfg.machine = true
+			fs.SetOutput(io.Discard)
+		}
+	}
+
+	err := fs.Parse(args)
+	return cfg, fs.Args(), err
+}
 
 func main() {
-	flag.Parse()
-
-	p := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
-	for _, d := range flag.Args() {
-		c := exec.Command("tinygo", "build")
-		c.Dir = d
-		c.Stdout, c.Stderr = os.Stdout, os.Stderr
-		c.Env = append(os.Environ(), "GOOS=linux", "CGO_ENABLED=0", "GOARCH=amd64")
-		if err := c.Run(); err == nil {
+	cfg, dirs, err := parseFlags(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(exitOK)
+	}
+	if err != nil {
+		os.Exit(fatalError(cfg, os.Stderr, exitUsage, err))
+	}
+	if cfg.version {
+		fmt.Printf("tinygoize %s\n", toolVersion())
+		os.Exit(exitOK)
+	}
+	if dirs, err = stdinDirs(dirs, os.Stdin); err != nil {
+		os.Exit(fatalError(cfg, os.Stderr, exitUsage, err))
+	}
+	os.Exit(run(cfg, dirs, os.Stdout, os.Stderr))
+}
+
+// stdinDirs replaces a "-" in dirs with the directories read from stdin, one
+// per line. Blank lines are skipped.
+func stdinDirs(dirs []string, stdin io.Reader) ([]string, error) {
+	i := slices.Index(dirs, "-")
+	if i < 0 {
+		return dirs, nil
+	}
+	if slices.Contains(dirs[i+1:], "-") {
+		return nil, errors.New(`"-" given more than once`)
+	}
+	var read []string
+	s := bufio.NewScanner(stdin)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			read = append(read, line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("reading directories from stdin: %w", err)
+	}
+	return append(append(slices.Clone(dirs[:i]), read...), dirs[i+1:]...), nil
+}
+
+// fatalError reports an error ending the run and returns code. In machine
+// mode, the error is written as a single JSON object.
+func fatalError(cfg config, stderr io.Writer, code int, err error) int {
+	if !cfg.machine {
+		fmt.Fprintf(stderr, "tinygoize: %v\n", err)
+		return code
+	}
+	json.NewEncoder(stderr).Encode(struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}{err.Error(), code})
+	return code
+}
+
+// run builds dirs and returns the process exit code. It runs in stages:
+// setup checks the options and reads their inputs, build builds the
+// packages, and report writes the reports and notes and picks the exit code.
+func run(cfg config, dirs []string, stdout, stderr io.Writer) int {
+	r, code := setup(cfg, dirs, stdout, stderr)
+	if r == nil {
+		return code
+	}
+	defer r.closeOut()
+	if code, done := r.build(); done {
+		return code
+	}
+	return r.report()
+}
+
+// runState is the state of a run that its stages share.
+type runState struct {
+	cfg            config
+	stdout, stderr io.Writer
+	// out is the -o file once opened, or stdout.
+	out io.Writer
+	// dirs are the packages to build, empty the directories without Go
+	// files.
+	dirs, empty      []string
+	version          string
+	sizeBaseline     map[string]int64
+	expectedExcluded map[string]bool
+	knownFailures    map[string]knownFailure
+	status           BuildStatus
+}
+
+// openOut creates the -o file, unless the report goes to stdout or the file
+// is already open.
+func (r *runState) openOut() error {
+	if r.cfg.pathMD == "" || r.cfg.pathMD == "-" || r.out != r.stdout {
+		return nil
+	}
+	f, err := os.Create(r.cfg.pathMD)
+	if err != nil {
+		return err
+	}
+	r.out = f
+	return nil
+}
+
+// closeOut closes the -o file, if it was opened.
+func (r *runState) closeOut() {
+	if f, ok := r.out.(*os.File); ok && r.out != r.stdout {
+		f.Close()
+	}
+}
+
+// setup checks cfg and dirs, and reads the inputs the options name. It
+// returns nil and the exit code if the run ends there, as it does with
+// -explain and -plan.
+func setup(cfg config, dirs []string, stdout, stderr io.Writer) (*runState, int) {
+	if len(dirs) == 0 {
+		return nil, fatalError(cfg, stderr, exitUsage, errors.New("no directories given"))
+	}
+	if cfg.jobs < 1 {
+		cfg.jobs = 1
+	}
+	// Clean the dirs so that "cmds/core/ls/", "./cmds/core/ls", and
+	// "cmds/core/ls" all name the same command, and build each once.
+	seen := make(map[string]bool)
+	var cleaned []string
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if seen[dir] {
 			continue
 		}
-		files, err := filepath.Glob(filepath.Join(d, "*"))
+		seen[dir] = true
+		cleaned = append(cleaned, dir)
+	}
+	dirs = cleaned
+	for _, dir := range dirs {
+		fi, err := os.Stat(dir)
 		if err != nil {
-			log.Fatal(err)
+			return nil, fatalError(cfg, stderr, exitUsage, err)
 		}
-		for _, file := range files {
-			if !strings.HasSuffix(file, ".go") {
-				continue
-			}
-			log.Printf("Process %s", file)
-			b, err := os.ReadFile(file)
-			if err != nil {
-				log.Fatal(err)
-			}
-			fset := token.NewFileSet() // positions are relative to fset
-			f, err := parser.ParseFile(fset, file, string(b), parser.ParseComments|parser.SkipObjectResolution)
-			if err != nil {
-				log.Fatalf("parsing\n%v\n:%v", string(b), err)
+		if !fi.IsDir() {
+			return nil, fatalError(cfg, stderr, exitUsage, fmt.Errorf("%q is not a directory", dir))
+		}
+	}
+
+	if len(cfg.targets) == 0 {
+		cfg.targets = []string{defaultTarget}
+	}
+	for _, target := range cfg.targets {
+		if goos, goarch, ok := strings.Cut(target, "/"); !ok || goos == "" || goarch == "" {
+			return nil, fatalError(cfg, stderr, exitUsage, fmt.Errorf("target %q is not a GOOS/GOARCH pair", target))
+		}
+	}
+	cfg.target = cfg.targets[0]
+	if cfg.cacheDir != "" {
+		// The builds run in the package directories.
+		var err error
+		if cfg.cacheDir, err = filepath.Abs(cfg.cacheDir); err == nil {
+			err = os.MkdirAll(cfg.cacheDir, 0o755)
+		}
+		if err != nil {
+			return nil, fatalError(cfg, stderr, exitSetup, fmt.Errorf("creating cache directory: %w", err))
+		}
+	}
+	if cfg.explain {
+		if len(dirs) != 1 {
+			return nil, fatalError(cfg, stderr, exitUsage, fmt.Errorf("-explain takes a single directory, not %d", len(dirs)))
+		}
+		version, err := tinygoVersion(cfg.tinygo)
+		if err != nil {
+			return nil, fatalError(cfg, stderr, exitSetup, err)
+		}
+		return nil, explain(cfg, version, dirs[0], stdout, stderr)
+	}
+
+	if cfg.format == "" {
+		cfg.format = formatMarkdown
+	}
+	if err := checkOptions(cfg); err != nil {
+		return nil, fatalError(cfg, stderr, exitUsage, err)
+	}
+	var sizeBaseline map[string]int64
+	if cfg.sizeBaseline != "" {
+		var err error
+		if sizeBaseline, err = readSizeBaseline(cfg.sizeBaseline); err != nil {
+			return nil, fatalError(cfg, stderr, exitSetup, fmt.Errorf("reading size baseline: %w", err))
+		}
+	}
+	var expectedExcluded map[string]bool
+	if cfg.expectedExcluded != "" {
+		var err error
+		if expectedExcluded, err = readExpectedExcluded(cfg.expectedExcluded); err != nil {
+			return nil, fatalError(cfg, stderr, exitSetup, fmt.Errorf("reading expected exclusions: %w", err))
+		}
+	}
+	var knownFailures map[string]knownFailure
+	if cfg.knownFailures != "" {
+		var err error
+		if knownFailures, err = readKnownFailures(cfg.knownFailures); err != nil {
+			return nil, fatalError(cfg, stderr, exitSetup, fmt.Errorf("reading known failures: %w", err))
+		}
+	}
+	if cfg.changedOnly != "" {
+		var err error
+		if cfg.changed, err = changedFiles(cfg.changedOnly); err != nil {
+			return nil, fatalError(cfg, stderr, exitSetup, fmt.Errorf("listing changed files: %w", err))
+		}
+	}
+	if cfg.hasConstraint || cfg.noConstraint {
+		dirs = filterByConstraint(dirs, cfg.hasConstraint)
+	}
+	dirs, empty := splitEmpty(dirs)
+	if cfg.depOrder || cfg.plan {
+		if ordered, err := depOrder(cfg, dirs); err != nil {
+			log.Printf("building in the order given: %v", err)
+		} else {
+			dirs = ordered
+		}
+	}
+	if cfg.plan {
+		for _, dir := range dirs {
+			fmt.Fprintln(stdout, filepath.ToSlash(dir))
+		}
+		return nil, exitOK
+	}
+
+	if !cfg.allowAnyTag {
+		if err := checkBuildTags(addBuildTags); err != nil {
+			return nil, fatalError(cfg, stderr, exitSetup, err)
+		}
+	}
+
+	version, err := tinygoVersion(cfg.tinygo)
+	if err != nil {
+		return nil, fatalError(cfg, stderr, exitSetup, err)
+	}
+	if err := checkToolchain(cfg.tinygo); err != nil {
+		log.Printf("%v; exclusion checks may disagree with the builds", err)
+	}
+
+	if cfg.patch != "" || cfg.assertConsistent || cfg.diffExit {
+		cfg.checkOnly = true
+	}
+	if err := checkDiskSpace(cfg); err != nil {
+		return nil, fatalError(cfg, stderr, exitSetup, err)
+	}
+	if cfg.retryClearCache {
+		cfg.cleaner = &cacheCleaner{}
+	}
+
+	// The cache holds no binaries, so -o-dir must build everything, and
+	// -repeat is about timing builds.
+	if cfg.cachePath != "" && cfg.outDir == "" && cfg.repeat <= 1 {
+		cfg.cache, err = openCache(cfg.cachePath, version)
+		if err != nil {
+			// Building without the cache is slower, but just as correct.
+			log.Printf("not using the cache: %v", err)
+		}
+	}
+
+	return &runState{
+		cfg:              cfg,
+		stdout:           stdout,
+		stderr:           stderr,
+		out:              stdout,
+		dirs:             dirs,
+		empty:            empty,
+		version:          version,
+		sizeBaseline:     sizeBaseline,
+		expectedExcluded: expectedExcluded,
+		knownFailures:    knownFailures,
+	}, exitOK
+}
+
+// checkOptions checks the options of cfg for usage errors.
+func checkOptions(cfg config) error {
+	if cfg.gateFile != "" && (filepath.Base(cfg.gateFile) != cfg.gateFile || filepath.Ext(cfg.gateFile) != ".go" || strings.HasSuffix(cfg.gateFile, "_test.go")) {
+		return fmt.Errorf("-gate-file %q is not the name of a non-test .go file", cfg.gateFile)
+	}
+	for _, sev := range []struct{ flag, severity string }{
+		{"-missing-severity", cfg.missingSeverity},
+		{"-stale-severity", cfg.staleSeverity},
+	} {
+		if sev.severity != "" && sev.severity != severityError && sev.severity != severityWarn {
+			return fmt.Errorf("%s %q is neither %q nor %q", sev.flag, sev.severity, severityError, severityWarn)
+		}
+	}
+	if cfg.onModified != "" && len(strings.Fields(cfg.onModified)) == 0 {
+		return errors.New("-on-modified is blank")
+	}
+	if reportWriters[cfg.format] == nil {
+		return fmt.Errorf("-format %q is neither %s nor %s", cfg.format, formatMarkdown, formatHTML)
+	}
+	if cfg.streamJSON && cfg.format == formatHTML {
+		return errors.New("-stream-json and -format html are mutually exclusive")
+	}
+	if cfg.streamJSON && cfg.diffExit {
+		return errors.New("-stream-json and -diff-exit are mutually exclusive")
+	}
+	if cfg.compareHead && cfg.pathJSON == "" {
+		return errors.New("-compare-head requires -json")
+	}
+	if cfg.maxPerSection < 0 {
+		return fmt.Errorf("-max-per-section %d is negative", cfg.maxPerSection)
+	}
+	if cfg.open && (cfg.pathMD == "" || cfg.pathMD == "-") {
+		return errors.New("-open requires an -o file")
+	}
+	if cfg.repeat < 0 {
+		return fmt.Errorf("-repeat %d is negative", cfg.repeat)
+	}
+	if cfg.sizeBaseline != "" {
+		switch {
+		case cfg.outDir == "":
+			return errors.New("-size-baseline requires -o-dir")
+		case len(cfg.targets) > 1:
+			return errors.New("-size-baseline requires a single target")
+		case cfg.sizeThreshold < 0:
+			return fmt.Errorf("-size-threshold %g is negative", cfg.sizeThreshold)
+		}
+	}
+	if cfg.hasConstraint && cfg.noConstraint {
+		return errors.New("-has-constraint and -no-constraint are mutually exclusive")
+	}
+	return nil
+}
+
+// build builds the packages and records the status. It reports whether the
+// run ends there, with the exit code, as it does with -diff-exit.
+func (r *runState) build() (int, bool) {
+	// The -o file is created before the builds to stream their results,
+	// and otherwise once they are done.
+	if r.cfg.streamJSON {
+		if err := r.openOut(); err != nil {
+			return fatalError(r.cfg, r.stderr, exitSetup, err), true
+		}
+		r.cfg.stream = newJSONStream(r.out)
+		defer r.cfg.stream.close()
+		defer closeOnSignal(r.cfg.stream)()
+	}
+
+	var status BuildStatus
+	if len(r.cfg.targets) > 1 {
+		status = buildMatrix(r.cfg, r.dirs)
+	} else {
+		status = buildDirs(r.cfg, r.dirs)
+	}
+	for _, dir := range r.empty {
+		status.empty = append(status.empty, BuildResult{dir: dir})
+		if r.cfg.stream != nil {
+			r.cfg.stream.add(StreamedPackage{PackageReport: newPackageReport(statusEmpty, BuildResult{dir: dir})})
+		}
+	}
+	if r.cfg.stream != nil {
+		if err := r.cfg.stream.close(); err != nil {
+			return fatalError(r.cfg, r.stderr, exitSetup, fmt.Errorf("streaming JSON: %w", err)), true
+		}
+	}
+	if r.cfg.cache != nil {
+		if err := r.cfg.cache.close(); err != nil {
+			log.Printf("saving the cache: %v", err)
+		}
+	}
+	// Results are incomplete without the packages that were not built.
+	if status.noSpace {
+		for _, err := range status.errors {
+			if errors.Is(err, errNoSpace) {
+				return fatalError(r.cfg, r.stderr, exitSetup, fmt.Errorf("stopped building: %w", err)), true
 			}
-		done:
-			for _, cg := range f.Comments {
-				for _, c := range cg.List {
-					if !strings.HasPrefix(c.Text, goBuild) {
-						continue
-					}
-					c.Text = goBuild + "!tinygo && (" + c.Text[len(goBuild):] + ")"
-					break done
-				}
+		}
+	}
+
+	if r.cfg.tagImpact {
+		status.tagImpacts = tagImpacts(r.cfg, status)
+	}
+	status.known = matchKnown(status, r.knownFailures)
+	r.status = status
+
+	if r.cfg.patch != "" {
+		if err := writePatch(r.cfg.patch, status); err != nil {
+			return fatalError(r.cfg, r.stderr, exitSetup, fmt.Errorf("writing patch: %w", err)), true
+		}
+	}
+	if r.cfg.reproFile != "" {
+		if err := writeRepro(r.cfg.reproFile, r.cfg, status); err != nil {
+			return fatalError(r.cfg, r.stderr, exitSetup, fmt.Errorf("writing reproductions: %w", err)), true
+		}
+	}
+	if r.cfg.diffExit {
+		return diffExit(r.cfg, status, r.stdout, r.stderr), true
+	}
+	return exitOK, false
+}
+
+// reportWriters write the -o report in each -format.
+var reportWriters = map[string]func(w io.Writer, cfg config, info reportInfo, status BuildStatus) error{
+	formatMarkdown: writeMarkdown,
+	formatHTML:     writeHTML,
+}
+
+// report writes the reports, notes what is left to do, and returns the exit
+// code.
+func (r *runState) report() int {
+	cfg, status := r.cfg, r.status
+	if err := r.openOut(); err != nil {
+		return fatalError(cfg, r.stderr, exitSetup, err)
+	}
+	info := reportInfo{version: r.version}
+	var err error
+	// The tags only explain a report, so failing to get them is no error.
+	if info.tags, err = tinygoBuildTags(cfg); err != nil && cfg.verbose {
+		log.Printf("%v", err)
+	}
+	if cfg.timestampHeader {
+		info.stamp = timestamp(time.Now(), gitRevision(), toolVersion())
+	}
+	if !cfg.streamJSON {
+		if err := reportWriters[cfg.format](r.out, cfg, info, status); err != nil {
+			return fatalError(cfg, r.stderr, exitSetup, fmt.Errorf("writing %s report: %w", cfg.format, err))
+		}
+	}
+	if cfg.open {
+		openReport(cfg.pathMD, r.stderr)
+	}
+
+	report := newReport(cfg, info, status)
+	if cfg.pathJSON != "" {
+		if err := writeReport(cfg.pathJSON, report); err != nil {
+			return fatalError(cfg, r.stderr, exitSetup, fmt.Errorf("writing JSON report: %w", err))
+		}
+	}
+
+	if cfg.pathCSV != "" {
+		f, err := os.Create(cfg.pathCSV)
+		if err != nil {
+			return fatalError(cfg, r.stderr, exitSetup, err)
+		}
+		err = writeCSV(f, status)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fatalError(cfg, r.stderr, exitSetup, fmt.Errorf("writing CSV report: %w", err))
+		}
+	}
+
+	if cfg.pathMetrics != "" {
+		if err := writeMetricsFile(cfg.pathMetrics, status); err != nil {
+			return fatalError(cfg, r.stderr, exitSetup, fmt.Errorf("writing metrics: %w", err))
+		}
+	}
+
+	return r.notes(report)
+}
+
+// notes writes what the run found beyond the reports, e.g. the files left to
+// update, and returns the exit code.
+func (r *runState) notes(report Report) int {
+	cfg, status, stderr := r.cfg, r.status, r.stderr
+	// With the report on stdout, keep the remaining notes on stderr.
+	notes := r.stdout
+	if r.out == r.stdout {
+		notes = stderr
+	}
+	if cfg.compare != "" {
+		old, err := readReport(cfg.compare)
+		if err != nil {
+			return fatalError(cfg, stderr, exitSetup, err)
+		}
+		fmt.Fprintf(notes, "Compared to %s:\n", cfg.compare)
+		compareReports(notes, old, report)
+	}
+	if cfg.compareHead {
+		old, err := headReport(cfg.pathJSON)
+		if err != nil {
+			return fatalError(cfg, stderr, exitSetup, err)
+		}
+		fmt.Fprintf(notes, "Changed since HEAD:\n")
+		compareReports(notes, old, report)
+	}
+	if len(status.staleExcluded) > 0 {
+		verb := "carry"
+		if cfg.stripExcluded {
+			verb = "carried"
+		}
+		fmt.Fprintf(notes, "EXCLUDED packages that %s a tinygo constraint:\n", verb)
+		for _, dir := range status.staleExcluded {
+			fmt.Fprintf(notes, "  %s\n", dir)
+		}
+	}
+
+	mustDoWork := len(status.modified) > 0
+	var inconsistent []inconsistency
+	if cfg.assertConsistent {
+		inconsistent = inconsistencies(status)
+		writeInconsistencies(notes, inconsistent)
+		// The explanations take the place of the updates.
+		mustDoWork = false
+	}
+	if mustDoWork && !cfg.checkOnly {
+		fmt.Fprintf(notes, "Updated:\n")
+		for _, file := range status.modified {
+			fmt.Fprintf(notes, "  %s\n", file)
+		}
+	}
+	if mustDoWork && cfg.checkOnly {
+		// Only updates of error severity require work.
+		var required, suggested []string
+		missing, stale := splitModified(status)
+		// Known failures need no update, whatever the severity.
+		missing, knownMissing := splitKnownFiles(status, missing)
+		for _, set := range []struct {
+			files    []string
+			severity string
+		}{
+			{missing, cfg.missingSeverity},
+			{stale, cfg.staleSeverity},
+			{knownMissing, severityWarn},
+		} {
+			if set.severity == severityWarn {
+				suggested = append(suggested, set.files...)
+			} else {
+				required = append(required, set.files...)
 			}
-			// Complete source file.
-			var buf bytes.Buffer
-			if err = p.Fprint(&buf, fset, f); err != nil {
-				log.Fatalf("Printing:%v", err)
+		}
+		sort.Strings(required)
+		sort.Strings(suggested)
+		mustDoWork = len(required) > 0
+		for _, set := range []struct {
+			header string
+			files  []string
+		}{
+			{"Updates required:", required},
+			{"Warning: updates suggested:", suggested},
+		} {
+			if len(set.files) == 0 {
+				continue
 			}
-			if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
-				log.Fatal(err)
+			fmt.Fprintf(notes, "%s\n", set.header)
+			for _, file := range set.files {
+				fmt.Fprintf(notes, "  %s\n", file)
 			}
 		}
 	}
+	if mustDoWork && !cfg.checkOnly && cfg.onModified != "" {
+		if err := runOnModified(cfg.onModified, status.modified, notes, stderr); err != nil {
+			return fatalError(cfg, stderr, exitError, err)
+		}
+	}
+
+	var regressions []sizeRegression
+	if r.sizeBaseline != nil {
+		regressions = sizeRegressions(status, r.sizeBaseline, cfg.sizeThreshold)
+	}
+	if len(regressions) > 0 {
+		writeSizeRegressions(notes, cfg.sizeBaseline, cfg.sizeThreshold, regressions)
+	}
+	var unexpected, missing []string
+	if r.expectedExcluded != nil {
+		unexpected, missing = diffExcluded(status, r.expectedExcluded)
+		writeExcludedDiff(notes, cfg.expectedExcluded, unexpected, missing)
+	}
+	var expired, fixed []string
+	if r.knownFailures != nil {
+		expired, fixed = staleKnown(status, r.knownFailures, time.Now())
+		writeStaleKnown(notes, cfg.knownFailures, r.knownFailures, expired, fixed)
+	}
+
+	if len(status.errors) > 0 {
+		return exitError
+	}
+	if len(regressions) > 0 {
+		return exitSize
+	}
+	if len(unexpected) > 0 || len(missing) > 0 {
+		return exitExclude
+	}
+	if len(expired) > 0 || len(fixed) > 0 {
+		return exitKnown
+	}
+	if cfg.checkOnly && mustDoWork || len(inconsistent) > 0 {
+		return exitUpdates
+	}
+	return exitOK
+}
+
+// Severities of the updates found with -n, see -missing-severity and
+// -stale-severity.
+const (
+	severityError = "error" // The update is required, exit 1.
+	severityWarn  = "warn"  // The update is only suggested, exit 0.
+)
+
+// splitModified splits the modified files of status into those constraining
+// failing packages, and those removing stale constraints from packages that
+// build or are excluded.
+func splitModified(status BuildStatus) (missing, stale []string) {
+	failing := make(map[string]bool)
+	for _, br := range status.failing {
+		failing[br.dir] = true
+	}
+	for _, file := range status.modified {
+		if failing[filepath.Dir(file)] {
+			missing = append(missing, file)
+		} else {
+			stale = append(stale, file)
+		}
+	}
+	return missing, stale
+}
+
+// runOnModified runs command, split into words on spaces, with files
+// appended as arguments. Files include those deleted, e.g. gate files no
+// longer needed with -gate-file.
+func runOnModified(command string, files []string, stdout, stderr io.Writer) error {
+	args := append(strings.Fields(command), files...)
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdout, c.Stderr = stdout, stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("-on-modified %q: %w", command, err)
+	}
+	return nil
+}
+
+// splitEmpty splits dirs into those with and those without non-test .go
+// files. tinygo would fail to build the latter with "no Go files", which is
+// no tinygo failure.
+func splitEmpty(dirs []string) (nonEmpty, empty []string) {
+	for _, dir := range dirs {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+		if slices.ContainsFunc(files, func(f string) bool { return !strings.HasSuffix(f, "_test.go") }) {
+			nonEmpty = append(nonEmpty, dir)
+		} else {
+			empty = append(empty, dir)
+		}
+	}
+	return nonEmpty, empty
+}
+
+// filterByConstraint returns the dirs whose packages do (has == true) or do
+// not carry the tinygo constraint. Directories that cannot be scanned are
+// kept, so that the build reports the problem.
+func filterByConstraint(dirs []string, has bool) []string {
+	var filtered []string
+	for _, dir := range dirs {
+		got, err := pkgHasConstraint(dir)
+		if err != nil || got == has {
+			filtered = append(filtered, dir)
+		}
+	}
+	return filtered
 }
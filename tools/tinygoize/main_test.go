@@ -0,0 +1,1555 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTinygo fails to build any package containing a file named FAIL or
+// FAIL_$GOARCH, and does not write the -o binary for packages containing
+// NOARTIFACT. Packages containing WARN build with a warning, and those
+// containing NOSPACE fail as if the disk were full. Packages containing
+// CORRUPT fail as if the cache were corrupt while $FAKE_CACHE/poisoned exists,
+// which `clean` removes. Packages containing NEEDTAG fail unless built with
+// the tag it holds, and those containing NEEDARG unless built with the
+// arguments it holds. Packages containing SLEEP take the seconds it holds to
+// build. With RAMP_LOG set, it logs when each build starts and
+// ends, and with CACHE_LOG set, the GOCACHE and XDG_CACHE_HOME of each build.
+// With CONTENTION_DIR set, builds take a second, and packages containing
+// CONTENTION fail if another build runs meanwhile.
+// `info` reports EXTRA_TAG as an additional build tag, and `env` FAKE_GOROOT
+// as the GOROOT if it is set.
+const fakeTinygo = `#!/bin/sh
+case "$1" in
+version)
+	echo "tinygo version 0.33.0 linux/amd64 (using go version go1.22.5 and LLVM version 18.1.2)"
+	;;
+info)
+	echo "LLVM triple:       x86_64-unknown-linux"
+	echo "build tags:        linux $GOARCH tinygo purego $EXTRA_TAG"
+	;;
+clean)
+	rm -f "$FAKE_CACHE/poisoned"
+	;;
+env)
+	echo "${FAKE_GOROOT-$(go env GOROOT)}"
+	;;
+build)
+	all="$*"
+	if [ -n "$RAMP_LOG" ]; then
+		echo "start ${PWD##*/}" >> "$RAMP_LOG"
+		sleep 0.2
+		echo "end ${PWD##*/}" >> "$RAMP_LOG"
+	fi
+	if [ -n "$CACHE_LOG" ]; then
+		echo "$GOCACHE $XDG_CACHE_HOME" >> "$CACHE_LOG"
+	fi
+	if [ -n "$CONTENTION_DIR" ]; then
+		touch "$CONTENTION_DIR/${PWD##*/}"
+		before=$(ls "$CONTENTION_DIR" | wc -l)
+		sleep 1
+		after=$(ls "$CONTENTION_DIR" | wc -l)
+		rm -f "$CONTENTION_DIR/${PWD##*/}"
+		if [ -e CONTENTION ] && [ "$before" -gt 1 -o "$after" -gt 1 ]; then
+			echo "fatal error: out of memory" >&2
+			exit 1
+		fi
+	fi
+	out=
+	while [ $# -gt 0 ]; do
+		[ "$1" = -o ] && out="$2"
+		[ "$1" = -tags ] && tags="$2"
+		shift
+	done
+	if [ -e CORRUPT ] && [ -e "$FAKE_CACHE/poisoned" ]; then
+		echo "error: could not read cache file $FAKE_CACHE/x.bc: unexpected EOF" >&2
+		exit 1
+	fi
+	if [ -e SLEEP ]; then
+		sleep "$(cat SLEEP)"
+	fi
+	if [ -e NOSPACE ]; then
+		echo "write /tmp/tinygo123/main.o: no space left on device" >&2
+		exit 1
+	fi
+	if [ -e FAIL ] || [ -e "FAIL_$GOARCH" ]; then
+		echo "fake tinygo error" >&2
+		[ -s FAIL ] && cat FAIL >&2
+		exit 1
+	fi
+	if [ -e NEEDARG ] && ! echo " $all " | grep -q -- " $(cat NEEDARG) "; then
+		echo "runtime.Gosched: not supported" >&2
+		exit 1
+	fi
+	if [ -e NEEDTAG ] && ! echo ",$tags," | grep -q ",$(cat NEEDTAG),"; then
+		echo "undefined: asmFunc" >&2
+		exit 1
+	fi
+	if [ -e WARN ]; then
+		echo "main.go:5:2: warning: unsupported feature" >&2
+	fi
+	if [ -n "$out" ] && [ ! -e NOARTIFACT ]; then
+		echo binary > "$out"
+	fi
+	;;
+esac
+`
+
+// testTree creates a module with a passing, a failing, and an excluded
+// package and changes into it. It returns the module root and a config using
+// the fake tinygo.
+func testTree(t *testing.T) (string, config) {
+	t.Helper()
+	root := t.TempDir()
+	files := map[string]string{
+		"go.mod":                  "module example.com/m\n\ngo 1.21\n",
+		"tinygo":                  fakeTinygo,
+		"cmds/pass/main.go":       copyright + "//go:build !tinygo || tinygo.enable\n\npackage main\n\nfunc main() {}\n",
+		"cmds/fail/main.go":       copyright + "\npackage main\n\nfunc main() {}\n",
+		"cmds/fail/FAIL":          "",
+		"cmds/excluded/main.go":   copyright + "//go:build (!tinygo || tinygo.enable) && plan9\n\npackage main\n\nfunc main() {}\n",
+		"cmds/excluded/README.md": "",
+	}
+	for name, src := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(src), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return root, config{tinygo: filepath.Join(root, "tinygo"), jobs: 2}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRun(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.pathMD = "docs/status.md"
+	if err := os.Mkdir("docs", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	if code := run(cfg, []string{"cmds/pass", "cmds/fail", "cmds/excluded"}, &stdout, io.Discard); code != 0 {
+		t.Fatalf("run() = %d, want 0, stdout:\n%s", code, &stdout)
+	}
+
+	if got := readFile(t, "cmds/pass/main.go"); strings.Contains(got, "tinygo") {
+		t.Errorf("passing package still has the constraint:\n%s", got)
+	}
+	if got := readFile(t, "cmds/fail/main.go"); !strings.Contains(got, goBuild+tinygoConstraint) {
+		t.Errorf("failing package lacks the constraint:\n%s", got)
+	}
+	if got := readFile(t, "cmds/excluded/main.go"); !strings.Contains(got, tinygoConstraint) {
+		t.Errorf("excluded package was modified:\n%s", got)
+	}
+
+	md := readFile(t, "docs/status.md")
+	for _, want := range []string{
+		"tinygo version 0.33.0.",
+		"### EXCLUDED (1 commands)\n - [cmds/excluded](../cmds/excluded)\n",
+		"### FAILING (1 commands)\n - [cmds/fail](../cmds/fail)\n",
+		"### PASSING (1 commands)\n - [cmds/pass](../cmds/pass)\n",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown lacks %q:\n%s", want, md)
+		}
+	}
+
+	for _, want := range []string{"Updated:", "cmds/pass/main.go", "cmds/fail/main.go", "EXCLUDED packages that carry a tinygo constraint:\n  cmds/excluded\n"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("stdout lacks %q:\n%s", want, &stdout)
+		}
+	}
+}
+
+func TestRunCheckOnly(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	cfg.pathMD = "status.md"
+
+	var stdout bytes.Buffer
+	if code := run(cfg, []string{"cmds/pass", "cmds/fail"}, &stdout, io.Discard); code != 1 {
+		t.Errorf("run() = %d, want 1", code)
+	}
+	if !strings.Contains(stdout.String(), "Updates required:") {
+		t.Errorf("stdout lacks updates:\n%s", &stdout)
+	}
+	if got := readFile(t, "cmds/fail/main.go"); strings.Contains(got, "tinygo") {
+		t.Errorf("check only run modified a file:\n%s", got)
+	}
+}
+
+func TestRunStripExcluded(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.stripExcluded = true
+	cfg.pathMD = "status.md"
+
+	var stdout bytes.Buffer
+	if code := run(cfg, []string{"cmds/excluded"}, &stdout, io.Discard); code != 0 {
+		t.Fatalf("run() = %d, want 0", code)
+	}
+	if got := readFile(t, "cmds/excluded/main.go"); !strings.Contains(got, "//go:build plan9\n") {
+		t.Errorf("constraint not stripped from excluded package:\n%s", got)
+	}
+	if !strings.Contains(stdout.String(), "EXCLUDED packages that carried a tinygo constraint:") {
+		t.Errorf("stdout lacks stale excluded report:\n%s", &stdout)
+	}
+}
+
+func TestRunBadArgs(t *testing.T) {
+	_, cfg := testTree(t)
+	for _, dirs := range [][]string{nil, {"nonexistent"}, {"go.mod"}} {
+		if code := run(cfg, dirs, &bytes.Buffer{}, io.Discard); code != exitUsage {
+			t.Errorf("run(%q) = %d, want %d", dirs, code, exitUsage)
+		}
+	}
+	for _, gate := range []string{"sub/tinygo.go", "tinygo", "tinygo_test.go"} {
+		cfg.gateFile = gate
+		if code := run(cfg, []string{"cmds/pass"}, &bytes.Buffer{}, io.Discard); code != exitUsage {
+			t.Errorf("run() with -gate-file %q = %d, want %d", gate, code, exitUsage)
+		}
+	}
+}
+
+func TestRunDeterministic(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	cfg.jobs = 1
+	cfg.pathMD = "status.md"
+
+	// With a single worker, results arrive in input order, so reversing
+	// the input reverses the completion order.
+	dirs := []string{"cmds/pass", "cmds/fail", "cmds/excluded"}
+	var outputs []string
+	for _, order := range [][]string{dirs, {dirs[2], dirs[1], dirs[0]}} {
+		var stdout bytes.Buffer
+		run(cfg, order, &stdout, io.Discard)
+		outputs = append(outputs, stdout.String())
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("output depends on completion order:\n%s\nvs\n%s", outputs[0], outputs[1])
+	}
+}
+
+func TestRunShuffledTiming(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	cfg.jobs = 4
+	cfg.pathMD, cfg.pathJSON = "status.md", "status.json"
+
+	// Packages of every kind, including tool errors, whose build times
+	// are shuffled on every run to vary the completion order.
+	dirs := []string{"cmds/pass", "cmds/fail", "cmds/excluded"}
+	for i := 0; i < 6; i++ {
+		dir := fmt.Sprintf("cmds/extra%d", i)
+		files := map[string]string{"main.go": copyright + "\npackage main\n\nfunc main() {}\n"}
+		switch i % 3 {
+		case 0:
+			files["FAIL"] = ""
+		case 1:
+			files["WARN"] = ""
+		case 2:
+			// Two packages in one directory fail the exclusion check.
+			files["other.go"] = "package other\n"
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for name, src := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dirs = append(dirs, dir)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	var first string
+	for round := 0; round < 4; round++ {
+		for _, dir := range dirs {
+			if err := os.WriteFile(filepath.Join(dir, "SLEEP"), []byte(fmt.Sprintf("0.%02d", rng.Intn(20))), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rng.Shuffle(len(dirs), func(i, j int) { dirs[i], dirs[j] = dirs[j], dirs[i] })
+		var notes strings.Builder
+		run(cfg, dirs, &notes, &notes)
+		out := notes.String() + readFile(t, cfg.pathMD) + readFile(t, cfg.pathJSON)
+		if round == 0 {
+			first = out
+		} else if out != first {
+			t.Fatalf("round %d output differs from the first:\n%s\nvs\n%s", round, out, first)
+		}
+	}
+}
+
+func TestMachineErrors(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.machine = true
+	cfg.tinygo = "nonexistent-tinygo"
+
+	var stderr bytes.Buffer
+	if code := run(cfg, []string{"cmds/pass"}, io.Discard, &stderr); code != exitSetup {
+		t.Errorf("run() = %d, want %d", code, exitSetup)
+	}
+	var got struct {
+		Error string
+		Code  int
+	}
+	if err := json.Unmarshal(stderr.Bytes(), &got); err != nil {
+		t.Fatalf("stderr is not JSON: %v\n%s", err, &stderr)
+	}
+	if got.Code != exitSetup || !strings.Contains(got.Error, "nonexistent-tinygo") {
+		t.Errorf("got %+v, want code %d naming tinygo", got, exitSetup)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	for _, tt := range []struct {
+		args        []string
+		wantMachine bool
+		wantErr     bool
+	}{
+		{args: []string{"-n", "cmds/core/ls"}},
+		{args: []string{"-machine", "cmds/core/ls"}, wantMachine: true},
+		{args: []string{"-bogus", "--machine"}, wantMachine: true, wantErr: true},
+		{args: []string{"-bogus"}, wantErr: true},
+		{args: []string{"--", "-machine"}},
+		{args: []string{"-file-mode", "0640", "cmds/core/ls"}},
+		{args: []string{"-file-mode", "rw", "cmds/core/ls"}, wantErr: true},
+		{args: []string{"-file-mode", "01777", "cmds/core/ls"}, wantErr: true},
+	} {
+		var stderr bytes.Buffer
+		cfg, _, err := parseFlags(tt.args, &stderr)
+		if (err != nil) != tt.wantErr || cfg.machine != tt.wantMachine {
+			t.Errorf("parseFlags(%q) = machine %t, %v, want machine %t, error %t", tt.args, cfg.machine, err, tt.wantMachine, tt.wantErr)
+		}
+		// Usage text must not pollute machine output.
+		if tt.wantMachine && stderr.Len() != 0 {
+			t.Errorf("parseFlags(%q) wrote %q in machine mode", tt.args, &stderr)
+		}
+	}
+}
+
+func TestRunGap(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	cfg.gap = true
+
+	var stdout bytes.Buffer
+	run(cfg, []string{"cmds/pass", "cmds/fail", "cmds/excluded"}, &stdout, io.Discard)
+	for _, want := range []string{
+		"## Constraint Gap\n\n0 of 1 failing commands already carry the constraint.\n",
+		"### FAILING WITHOUT CONSTRAINT (1 commands)\n - [cmds/fail](cmds/fail)\n",
+		"### PASSING WITH CONSTRAINT (1 commands)\n - [cmds/pass](cmds/pass)\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("markdown lacks %q:\n%s", want, &stdout)
+		}
+	}
+}
+
+func TestRunEmoji(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	cfg.gap = true
+	dirs := []string{"cmds/pass", "cmds/fail", "cmds/excluded"}
+
+	var stdout bytes.Buffer
+	run(cfg, dirs, &stdout, io.Discard)
+	if strings.Contains(stdout.String(), "✅") {
+		t.Errorf("markdown without -emoji has emoji:\n%s", &stdout)
+	}
+
+	cfg.emoji = true
+	stdout.Reset()
+	run(cfg, dirs, &stdout, io.Discard)
+	for _, want := range []string{
+		"### ⏭️ EXCLUDED (1 commands)\n",
+		"### ❌ FAILING (1 commands)\n",
+		"### ✅ PASSING (1 commands)\n",
+		// Only the main sections carry emoji.
+		"### EMPTY (no Go files) (0 commands)\n",
+		"### PASSING WITH CONSTRAINT (1 commands)\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("markdown lacks %q:\n%s", want, &stdout)
+		}
+	}
+}
+
+func TestFilterByConstraint(t *testing.T) {
+	testTree(t)
+	dirs := []string{"cmds/pass", "cmds/fail", "cmds/excluded"}
+	if got, want := filterByConstraint(dirs, true), []string{"cmds/pass", "cmds/excluded"}; !slices.Equal(got, want) {
+		t.Errorf("filterByConstraint(has) = %q, want %q", got, want)
+	}
+	if got, want := filterByConstraint(dirs, false), []string{"cmds/fail"}; !slices.Equal(got, want) {
+		t.Errorf("filterByConstraint(!has) = %q, want %q", got, want)
+	}
+}
+
+func TestRunConstraintFilter(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	cfg.hasConstraint = true
+
+	var stdout bytes.Buffer
+	run(cfg, []string{"cmds/pass", "cmds/fail"}, &stdout, io.Discard)
+	if !strings.Contains(stdout.String(), "### FAILING (0 commands)") || !strings.Contains(stdout.String(), "### PASSING (1 commands)") {
+		t.Errorf("-has-constraint built the wrong set:\n%s", &stdout)
+	}
+
+	cfg.noConstraint = true
+	if code := run(cfg, []string{"cmds/pass"}, io.Discard, io.Discard); code != exitUsage {
+		t.Errorf("run(-has-constraint -no-constraint) = %d, want %d", code, exitUsage)
+	}
+}
+
+func TestRunOutDir(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	cfg.outDir = "bin"
+	if err := os.MkdirAll("cmds/noartifact", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("cmds/noartifact/main.go", []byte(copyright+"\npackage main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("cmds/noartifact/NOARTIFACT", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	run(cfg, []string{"cmds/pass", "cmds/noartifact"}, &stdout, io.Discard)
+	if _, err := os.Stat("bin/cmds/pass"); err != nil {
+		t.Errorf("binary not kept: %v", err)
+	}
+	for _, want := range []string{
+		"### FAILING (1 commands)\n - [cmds/noartifact](cmds/noartifact) (no artifact produced)\n",
+		"### PASSING (1 commands)\n - [cmds/pass](cmds/pass)\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("markdown lacks %q:\n%s", want, &stdout)
+		}
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	now := time.Date(2024, 7, 1, 14, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	if got, want := timestamp(now, "abc1234", "v1.0.0"), "Generated 2024-07-01T12:30:00Z at revision abc1234 by tinygoize v1.0.0.\n"; got != want {
+		t.Errorf("timestamp() = %q, want %q", got, want)
+	}
+	if got, want := timestamp(now, "", "(devel)"), "Generated 2024-07-01T12:30:00Z by tinygoize (devel).\n"; got != want {
+		t.Errorf("timestamp() = %q, want %q", got, want)
+	}
+}
+
+func TestRunTimestampHeader(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+
+	var stdout bytes.Buffer
+	run(cfg, []string{"cmds/pass"}, &stdout, io.Discard)
+	if strings.Contains(stdout.String(), "Generated ") {
+		t.Errorf("markdown has a timestamp without -timestamp-header:\n%s", &stdout)
+	}
+
+	// The test tree is not a git repository, so only the time is recorded.
+	cfg.timestampHeader = true
+	stdout.Reset()
+	run(cfg, []string{"cmds/pass"}, &stdout, io.Discard)
+	if !strings.Contains(stdout.String(), "\nGenerated ") || strings.Contains(stdout.String(), "revision") {
+		t.Errorf("markdown lacks a timestamp without revision:\n%s", &stdout)
+	}
+}
+
+func TestRunRamp(t *testing.T) {
+	root, cfg := testTree(t)
+	cfg.checkOnly = true
+	cfg.ramp = 1
+	logFile := filepath.Join(root, "ramp.log")
+	t.Setenv("RAMP_LOG", logFile)
+
+	run(cfg, []string{"cmds/pass", "cmds/fail"}, io.Discard, io.Discard)
+	got := strings.Split(strings.TrimSpace(readFile(t, logFile)), "\n")
+	if len(got) != 4 || got[0] != "start pass" || got[1] != "end pass" {
+		t.Errorf("build log = %q, want the first package built alone", got)
+	}
+}
+
+func TestToolVersion(t *testing.T) {
+	if got := toolVersion(); got == "" {
+		t.Errorf("toolVersion() is empty")
+	}
+	defer func(v string) { buildVersion = v }(buildVersion)
+	buildVersion = "v1.2.3"
+	if got := toolVersion(); got != "v1.2.3" {
+		t.Errorf("toolVersion() = %q, want the linker-injected %q", got, buildVersion)
+	}
+}
+
+func TestRunCleansDirs(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	if err := os.MkdirAll("cmds/init", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("cmds/init/main.go", []byte(copyright+"\npackage main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	run(cfg, []string{"cmds/pass/", "./cmds/fail", "cmds/init/"}, &stdout, io.Discard)
+	for _, want := range []string{
+		" - [cmds/fail](cmds/fail)\n",
+		" - [cmds/init](cmds/init) tags: noasm\n",
+		" - [cmds/pass](cmds/pass)\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("markdown lacks %q:\n%s", want, &stdout)
+		}
+	}
+}
+
+func TestCategory(t *testing.T) {
+	for dir, want := range map[string]string{
+		"cmds/core/ls":     "cmds/core",
+		"cmds/exp/foo/bar": "cmds/exp",
+		"cmds/pass":        "cmds",
+		"ls":               ".",
+	} {
+		if got := category(dir); got != want {
+			t.Errorf("category(%q) = %q, want %q", dir, got, want)
+		}
+	}
+}
+
+func TestRunGroupByCategory(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	cfg.groupByCategory = true
+	for _, dir := range []string{"cmds/core/a", "cmds/core/b", "cmds/exp/c"} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(copyright+"\npackage main\n\nfunc main() {}\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile("cmds/core/b/FAIL", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	run(cfg, []string{"cmds/core/a", "cmds/core/b", "cmds/exp/c"}, &stdout, io.Discard)
+	for _, want := range []string{
+		"### FAILING (1 commands)\n\n#### cmds/core (1 of 2 commands)\n - [cmds/core/b](cmds/core/b)\n",
+		"### PASSING (2 commands)\n\n#### cmds/core (1 of 2 commands)\n - [cmds/core/a](cmds/core/a)\n\n#### cmds/exp (1 of 1 commands)\n - [cmds/exp/c](cmds/exp/c)\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("markdown lacks %q:\n%s", want, &stdout)
+		}
+	}
+}
+
+func TestWriteMarkdownMaxPerSection(t *testing.T) {
+	status := BuildStatus{
+		passing: []BuildResult{{dir: "cmds/core/d"}, {dir: "cmds/core/a"}, {dir: "cmds/exp/c"}, {dir: "cmds/core/b"}},
+		failing: []BuildResult{{dir: "cmds/core/e"}},
+	}
+	var b strings.Builder
+	if err := writeMarkdown(&b, config{maxPerSection: 2, groupByCategory: true}, reportInfo{}, status); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		// The counts are those of the whole section.
+		"### PASSING (4 commands)\n\n#### cmds/core (3 of 4 commands)\n - [cmds/core/a](cmds/core/a)\n - [cmds/core/b](cmds/core/b)\n - ... and 2 more\n",
+		"### FAILING (1 commands)\n\n#### cmds/core (1 of 4 commands)\n - [cmds/core/e](cmds/core/e)\n\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("markdown lacks %q:\n%s", want, &b)
+		}
+	}
+}
+
+func TestRunTargets(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.targets = []string{"linux/amd64", "linux/arm64"}
+	cfg.pathMD = "status.md"
+	if err := os.WriteFile("cmds/pass/FAIL_arm64", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("cmds/both", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("cmds/both/main.go", []byte(copyright+"//go:build !tinygo || tinygo.enable\n\npackage main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	if code := run(cfg, []string{"cmds/pass", "cmds/fail", "cmds/both", "cmds/excluded"}, &stdout, io.Discard); code != exitOK {
+		t.Fatalf("run() = %d, want %d", code, exitOK)
+	}
+
+	md := readFile(t, "status.md")
+	for _, want := range []string{
+		"| linux/amd64 | 2 | 1 | 1 |\n| linux/arm64 | 1 | 2 | 1 |\n",
+		"### BUILDS ON SOME TARGETS (1 commands)\n - cmds/pass: linux/amd64\n",
+		"### FAILING (2 commands)\n - [cmds/fail](cmds/fail)\n - [cmds/pass](cmds/pass)\n",
+		"### BUILDS ON ALL TARGETS (1 commands)\n - [cmds/both](cmds/both)\n",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown lacks %q:\n%s", want, md)
+		}
+	}
+
+	// A package failing on one target keeps the constraint.
+	if got := readFile(t, "cmds/pass/main.go"); !strings.Contains(got, goBuild+tinygoConstraint) {
+		t.Errorf("package failing on arm64 lost the constraint:\n%s", got)
+	}
+	if got := readFile(t, "cmds/both/main.go"); strings.Contains(got, "tinygo") {
+		t.Errorf("package building on all targets still has the constraint:\n%s", got)
+	}
+
+	cfg.targets = []string{"linux"}
+	if code := run(cfg, []string{"cmds/pass"}, io.Discard, io.Discard); code != exitUsage {
+		t.Errorf("run(-targets linux) = %d, want %d", code, exitUsage)
+	}
+}
+
+func TestRunJSONCompare(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	cfg.pathJSON = "old.json"
+	dirs := []string{"cmds/pass", "cmds/fail", "cmds/excluded"}
+
+	var stdout bytes.Buffer
+	run(cfg, dirs, &stdout, io.Discard)
+	if !strings.Contains(stdout.String(), "Resolved build tags: `linux amd64 tinygo purego`.") {
+		t.Errorf("markdown lacks the build tags:\n%s", &stdout)
+	}
+	old, err := readReport("old.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PackageReport{
+		{Dir: "cmds/excluded", Status: statusExcluded},
+		{Dir: "cmds/fail", Status: statusFailing},
+		{Dir: "cmds/pass", Status: statusPassing},
+	}
+	if old.Tinygo != "0.33.0" || !slices.Equal(old.Tags, []string{"linux", "amd64", "tinygo", "purego"}) || len(old.Packages) != 3 {
+		t.Fatalf("report = %+v", old)
+	}
+	for i, p := range old.Packages {
+		if p.Dir != want[i].Dir || p.Status != want[i].Status {
+			t.Errorf("report package %d = %+v, want %+v", i, p, want[i])
+		}
+	}
+
+	// A new default tag, and a package that now fails.
+	t.Setenv("EXTRA_TAG", "newtag")
+	if err := os.WriteFile("cmds/pass/FAIL", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.pathJSON = ""
+	cfg.pathMD = "status.md"
+	cfg.compare = "old.json"
+	stdout.Reset()
+	run(cfg, dirs, &stdout, io.Discard)
+	for _, want := range []string{
+		"Compared to old.json:\n",
+		"  build tags changed: +newtag\n",
+		"  cmds/pass: passing -> failing\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("comparison lacks %q:\n%s", want, &stdout)
+		}
+	}
+}
+
+func TestRunCompareHead(t *testing.T) {
+	_, cfg := testTree(t)
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not found")
+	}
+	cfg.checkOnly = true
+	cfg.pathJSON = "report.json"
+	dirs := []string{"cmds/pass", "cmds/fail", "cmds/excluded"}
+	run(cfg, dirs, io.Discard, io.Discard)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "report.json"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "report"},
+	} {
+		if out, err := exec.Command(git, args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", args[0], err, out)
+		}
+	}
+
+	// The package now fails, and the report is rewritten before the
+	// comparison.
+	if err := os.WriteFile("cmds/pass/FAIL", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.compareHead, cfg.pathMD = true, "status.md"
+	var stdout bytes.Buffer
+	run(cfg, dirs, &stdout, io.Discard)
+	want := "Changed since HEAD:\n  cmds/pass: passing -> failing\n"
+	if !strings.Contains(stdout.String(), want) {
+		t.Errorf("output lacks %q:\n%s", want, &stdout)
+	}
+
+	// Not committed, there is nothing to compare with.
+	cfg.pathJSON = "new.json"
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitSetup {
+		t.Errorf("run() of an uncommitted report = %d, want %d", code, exitSetup)
+	}
+	cfg.pathJSON = ""
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitUsage {
+		t.Errorf("run() without -json = %d, want %d", code, exitUsage)
+	}
+}
+
+func TestScanWarnings(t *testing.T) {
+	output := []byte("main.go:5:2: warning: unsupported feature\nld.lld: Warning: something\nall good\n")
+	got := scanWarnings(defaultWarningPatterns, output)
+	want := []string{"main.go:5:2: warning: unsupported feature", "ld.lld: Warning: something"}
+	if !slices.Equal(got, want) {
+		t.Errorf("scanWarnings() = %q, want %q", got, want)
+	}
+	custom := []*regexp.Regexp{regexp.MustCompile(`^all`)}
+	if got := scanWarnings(custom, output); !slices.Equal(got, []string{"all good"}) {
+		t.Errorf("scanWarnings() with a custom pattern = %q", got)
+	}
+}
+
+func TestRunWarnings(t *testing.T) {
+	_, cfg := testTree(t)
+	if err := os.WriteFile("cmds/pass/WARN", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.checkOnly = true
+	dirs := []string{"cmds/pass", "cmds/fail"}
+
+	var stdout bytes.Buffer
+	cfg.warnings = true
+	run(cfg, dirs, &stdout, io.Discard)
+	want := "### PASSING WITH WARNINGS (1 commands)\n - [cmds/pass](cmds/pass)\n   - `main.go:5:2: warning: unsupported feature`\n"
+	if !strings.Contains(stdout.String(), want) {
+		t.Errorf("markdown lacks %q:\n%s", want, &stdout)
+	}
+	if !strings.Contains(stdout.String(), "### PASSING (0 commands)") {
+		t.Errorf("package with warnings is listed as passing:\n%s", &stdout)
+	}
+
+	stdout.Reset()
+	cfg.warnings, cfg.warningsAsFailures = false, true
+	if code := run(cfg, dirs, &stdout, io.Discard); code != exitUpdates {
+		t.Errorf("run() = %d, want %d", code, exitUpdates)
+	}
+	want = "### FAILING (2 commands)\n - [cmds/fail](cmds/fail)\n - [cmds/pass](cmds/pass) (build emitted warnings)\n"
+	if !strings.Contains(stdout.String(), want) {
+		t.Errorf("markdown lacks %q:\n%s", want, &stdout)
+	}
+	if strings.Contains(stdout.String(), "WITH WARNINGS") {
+		t.Errorf("strict markdown has a warnings section:\n%s", &stdout)
+	}
+
+	// Patterns that do not match leave the package passing.
+	stdout.Reset()
+	cfg.warningPatterns = []*regexp.Regexp{regexp.MustCompile(`panic`)}
+	run(cfg, dirs, &stdout, io.Discard)
+	if !strings.Contains(stdout.String(), "### PASSING (1 commands)") {
+		t.Errorf("package without matching warnings is not passing:\n%s", &stdout)
+	}
+}
+
+func TestIsCacheCorrupt(t *testing.T) {
+	for _, tt := range []struct {
+		output string
+		want   bool
+	}{
+		{"error: could not read cache file /root/.cache/tinygo/x.bc: unexpected EOF", true},
+		{"ld.lld: error: /root/.cache/tinygo/thinlto/llvmcache-1: file too short", true},
+		{"error: Invalid bitcode signature", true},
+		{"main.go:3:2: undefined: foo", false},
+	} {
+		if got := isCacheCorrupt([]byte(tt.output)); got != tt.want {
+			t.Errorf("isCacheCorrupt(%q) = %t, want %t", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestRunRetryClearCache(t *testing.T) {
+	_, cfg := testTree(t)
+	fakeCache := t.TempDir()
+	t.Setenv("FAKE_CACHE", fakeCache)
+	for _, name := range []string{"cmds/corrupt/main.go", "cmds/corrupt/CORRUPT", "cmds/other/main.go", "cmds/other/CORRUPT"} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(copyright+"\npackage main\n\nfunc main() {}\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	poison := func() {
+		if err := os.WriteFile(filepath.Join(fakeCache, "poisoned"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dirs := []string{"cmds/corrupt", "cmds/other"}
+	// One at a time, only the first build finds the cache corrupt.
+	cfg.checkOnly, cfg.pathJSON, cfg.jobs = true, "report.json", 1
+
+	poison()
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitUpdates {
+		t.Fatalf("run() without -retry-clear-cache = %d, want %d", code, exitUpdates)
+	}
+
+	poison()
+	cfg.retryClearCache = true
+	var stdout bytes.Buffer
+	if code := run(cfg, dirs, &stdout, io.Discard); code != exitOK {
+		t.Fatalf("run() = %d, want %d", code, exitOK)
+	}
+	if !strings.Contains(stdout.String(), "(retried after clearing the tinygo cache)") {
+		t.Errorf("markdown does not record the retry:\n%s", &stdout)
+	}
+	r, err := readReport(cfg.pathJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range r.Packages {
+		if retry := p.Dir == "cmds/corrupt"; p.Status != statusPassing || p.CacheRetry != retry {
+			t.Errorf("%s: status %s, cache retry %t, want %s, %t", p.Dir, p.Status, p.CacheRetry, statusPassing, retry)
+		}
+	}
+}
+
+func TestProgressSummary(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := progressSummary{total: 25, last: start, interval: 5 * time.Second}
+	pass := WorkerResult{br: BuildResult{dir: "cmds/pass"}}
+	fail := WorkerResult{br: BuildResult{dir: "cmds/fail", err: errors.New("fail")}}
+
+	var got []string
+	for done := 1; done <= 25; done++ {
+		res := pass
+		if done%4 == 0 {
+			res = fail
+		}
+		// Completion 12 comes after a long build.
+		now := start.Add(time.Duration(done) * 100 * time.Millisecond)
+		if done >= 12 {
+			now = now.Add(10 * time.Second)
+		}
+		if line, ok := p.update(done, res, now); ok {
+			got = append(got, line)
+		}
+	}
+	want := []string{
+		"40% complete (10/25), 2 failing so far",
+		"48% complete (12/25), 3 failing so far",
+		"88% complete (22/25), 5 failing so far",
+		"100% complete (25/25), 6 failing so far",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("summaries = %q, want %q", got, want)
+	}
+
+	// Without an interval, every completion is summarized.
+	p = progressSummary{total: 3, last: start}
+	for done := 1; done <= 3; done++ {
+		if _, ok := p.update(done, pass, start); !ok {
+			t.Errorf("update(%d) without interval = false, want true", done)
+		}
+	}
+}
+
+func TestRedrawThrottle(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var r redrawThrottle
+	var drawn []int
+	// A result every 30ms, the last right after the one before.
+	for done := 1; done <= 10; done++ {
+		now := start.Add(time.Duration(done) * 30 * time.Millisecond)
+		if done == 10 {
+			now = start.Add(271 * time.Millisecond)
+		}
+		if r.due(done, 10, now) {
+			drawn = append(drawn, done)
+		}
+	}
+	if want := []int{1, 5, 9, 10}; !slices.Equal(drawn, want) {
+		t.Errorf("drawn %v, want %v", drawn, want)
+	}
+}
+
+func TestRunEmpty(t *testing.T) {
+	_, cfg := testTree(t)
+	for name, src := range map[string]string{
+		"cmds/empty/README.md":       "",
+		"cmds/testonly/main_test.go": copyright + "\npackage main\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg.checkOnly, cfg.pathJSON = true, "report.json"
+
+	var stdout bytes.Buffer
+	// Only cmds/pass needs updates.
+	if code := run(cfg, []string{"cmds/empty", "cmds/testonly", "cmds/pass"}, &stdout, io.Discard); code != exitUpdates {
+		t.Fatalf("run() = %d, want %d", code, exitUpdates)
+	}
+	for _, want := range []string{
+		"### EMPTY (no Go files) (2 commands)\n - [cmds/empty](cmds/empty)\n - [cmds/testonly](cmds/testonly)\n",
+		"### FAILING (0 commands)\n",
+		"### PASSING (1 commands)\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("markdown lacks %q:\n%s", want, &stdout)
+		}
+	}
+	r, err := readReport(cfg.pathJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Packages) != 3 || r.Packages[0].Status != statusEmpty || r.Packages[2].Status != statusEmpty {
+		t.Errorf("report packages = %+v, want cmds/empty and cmds/testonly empty", r.Packages)
+	}
+}
+
+func TestCheckBuildTags(t *testing.T) {
+	if err := checkBuildTags(addBuildTags); err != nil {
+		t.Errorf("checkBuildTags(addBuildTags) = %v, want nil", err)
+	}
+	err := checkBuildTags(map[string][]string{
+		"pass": {"noasm", "nofoo"},
+		"fail": {"purego", "bar"},
+	})
+	if err == nil || !strings.Contains(err.Error(), `fail: "bar", pass: "nofoo"`) {
+		t.Errorf("checkBuildTags() = %v, want the unknown tags of fail and pass", err)
+	}
+}
+
+func TestRunAllowAnyTag(t *testing.T) {
+	_, cfg := testTree(t)
+	defer func(tags map[string][]string) { addBuildTags = tags }(addBuildTags)
+	addBuildTags = map[string][]string{"pass": {"nofoo"}}
+	cfg.checkOnly = true
+
+	var stderr bytes.Buffer
+	if code := run(cfg, []string{"cmds/pass"}, io.Discard, &stderr); code != exitSetup {
+		t.Fatalf("run() = %d, want %d", code, exitSetup)
+	}
+	if !strings.Contains(stderr.String(), "-allow-any-tag") {
+		t.Errorf("stderr = %q, want a hint at -allow-any-tag", &stderr)
+	}
+
+	cfg.allowAnyTag = true
+	if code := run(cfg, []string{"cmds/pass"}, io.Discard, io.Discard); code != exitUpdates {
+		t.Errorf("run() with -allow-any-tag = %d, want %d", code, exitUpdates)
+	}
+}
+
+func TestImplicatedFiles(t *testing.T) {
+	output := `# example.com/m/cmds/fail
+main.go:12:3: undefined: foo
+./util.go:4:2: foo declared and not used
+../../pkg/foo/foo.go:7: cannot use x
+main.go:13:1: undefined: bar
+/usr/lib/go/src/os/file.go:99:10: unsupported
+see doc.go for details
+`
+	want := []string{"cmds/fail/main.go", "cmds/fail/util.go", "pkg/foo/foo.go", "/usr/lib/go/src/os/file.go"}
+	if got := implicatedFiles("cmds/fail", []byte(output)); !slices.Equal(got, want) {
+		t.Errorf("implicatedFiles() = %q, want %q", got, want)
+	}
+	if got := implicatedFiles("cmds/fail", []byte("fake tinygo error\n")); got != nil {
+		t.Errorf("implicatedFiles() without positions = %q, want nil", got)
+	}
+}
+
+func TestRunImplicatedFiles(t *testing.T) {
+	_, cfg := testTree(t)
+	if err := os.WriteFile("cmds/fail/FAIL", []byte("main.go:3:1: undefined: foo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.checkOnly, cfg.implicatedFiles, cfg.pathJSON = true, true, "report.json"
+
+	var stdout bytes.Buffer
+	if code := run(cfg, []string{"cmds/fail", "cmds/pass"}, &stdout, io.Discard); code != exitUpdates {
+		t.Fatalf("run() = %d, want %d", code, exitUpdates)
+	}
+	if want := " - [cmds/fail](cmds/fail)\n   - implicated: `cmds/fail/main.go`\n"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("markdown lacks %q:\n%s", want, &stdout)
+	}
+	r, err := readReport(cfg.pathJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Packages) != 2 || !slices.Equal(r.Packages[0].Implicated, []string{"cmds/fail/main.go"}) || r.Packages[1].Implicated != nil {
+		t.Errorf("report packages = %+v, want cmds/fail/main.go implicated", r.Packages)
+	}
+}
+
+func TestRunOnModified(t *testing.T) {
+	root, cfg := testTree(t)
+	hook := filepath.Join(root, "hook")
+	args := filepath.Join(root, "args")
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\necho \"$@\" > "+args+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg.onModified = hook + " -w"
+
+	// Nothing is run in check-only mode.
+	cfg.checkOnly = true
+	if code := run(cfg, []string{"cmds/pass", "cmds/fail"}, io.Discard, io.Discard); code != exitUpdates {
+		t.Fatalf("run(-n) = %d, want %d", code, exitUpdates)
+	}
+	if _, err := os.Stat(args); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("-on-modified ran with -n: %v", err)
+	}
+
+	cfg.checkOnly = false
+	if code := run(cfg, []string{"cmds/pass", "cmds/fail"}, io.Discard, io.Discard); code != exitOK {
+		t.Fatalf("run() = %d, want %d", code, exitOK)
+	}
+	if got, want := readFile(t, args), "-w cmds/fail/main.go cmds/pass/main.go\n"; got != want {
+		t.Errorf("-on-modified args = %q, want %q", got, want)
+	}
+
+	// Nothing is run if no file was modified.
+	os.Remove(args)
+	if code := run(cfg, []string{"cmds/pass", "cmds/fail"}, io.Discard, io.Discard); code != exitOK {
+		t.Fatalf("run() again = %d, want %d", code, exitOK)
+	}
+	if _, err := os.Stat(args); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("-on-modified ran without modified files: %v", err)
+	}
+
+	// A failing command is an error.
+	var stderr bytes.Buffer
+	cfg.onModified = "false"
+	if err := os.WriteFile("cmds/pass/FAIL", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := run(cfg, []string{"cmds/pass"}, io.Discard, &stderr); code != exitError {
+		t.Errorf("run() with a failing -on-modified = %d, want %d", code, exitError)
+	}
+	if !strings.Contains(stderr.String(), "-on-modified") {
+		t.Errorf("stderr = %q, want the -on-modified error", &stderr)
+	}
+}
+
+func TestRunSeverities(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	dirs := []string{"cmds/pass", "cmds/fail"}
+
+	for _, tt := range []struct {
+		missing, stale string
+		want           int
+		wantNotes      []string
+	}{
+		{
+			want:      exitUpdates,
+			wantNotes: []string{"Updates required:\n  cmds/fail/main.go\n  cmds/pass/main.go\n"},
+		},
+		{
+			stale:     severityWarn,
+			want:      exitUpdates,
+			wantNotes: []string{"Updates required:\n  cmds/fail/main.go\n", "Warning: updates suggested:\n  cmds/pass/main.go\n"},
+		},
+		{
+			missing:   severityWarn,
+			stale:     severityError,
+			want:      exitUpdates,
+			wantNotes: []string{"Updates required:\n  cmds/pass/main.go\n", "Warning: updates suggested:\n  cmds/fail/main.go\n"},
+		},
+		{
+			missing:   severityWarn,
+			stale:     severityWarn,
+			want:      exitOK,
+			wantNotes: []string{"Warning: updates suggested:\n  cmds/fail/main.go\n  cmds/pass/main.go\n"},
+		},
+	} {
+		cfg.missingSeverity, cfg.staleSeverity = tt.missing, tt.stale
+		var stderr bytes.Buffer
+		if code := run(cfg, dirs, io.Discard, &stderr); code != tt.want {
+			t.Errorf("run(-missing-severity %q -stale-severity %q) = %d, want %d", tt.missing, tt.stale, code, tt.want)
+		}
+		for _, want := range tt.wantNotes {
+			if !strings.Contains(stderr.String(), want) {
+				t.Errorf("run(-missing-severity %q -stale-severity %q) notes lack %q:\n%s", tt.missing, tt.stale, want, &stderr)
+			}
+		}
+	}
+
+	cfg.staleSeverity = "fatal"
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitUsage {
+		t.Errorf("run(-stale-severity fatal) = %d, want %d", code, exitUsage)
+	}
+}
+
+func TestRunDuplicateBuildLines(t *testing.T) {
+	root, cfg := testTree(t)
+	dup := copyright + "//go:build linux\n//go:build linux\n\npackage main\n\nfunc main() {}\n"
+	file := filepath.Join(root, "cmds/dup/main.go")
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(dup), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if code := run(cfg, []string{"cmds/dup"}, &b, io.Discard); code != exitError {
+		t.Errorf("run() = %d, want %d", code, exitError)
+	}
+	// go build reports the file before tinygoize would.
+	for _, want := range []string{"### TOOL ERRORS (1)\n - cmds/dup", "main.go: multiple //go:build"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, &b)
+		}
+	}
+	if got := readFile(t, file); got != dup {
+		t.Errorf("run() modified the file:\n%s", got)
+	}
+}
+
+func TestTinygoVersion(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name, version string
+		want          *regexp.Regexp
+	}{
+		{"release", "0.33.0", regexp.MustCompile(`^0\.33\.0$`)},
+		{"dev commit", "0.34.0-dev-1e13f4e3", regexp.MustCompile(`^0\.34\.0-dev-1e13f4e3$`)},
+		{"dev", "0.34.0-dev", regexp.MustCompile(`^0\.34\.0-dev\+bin\.[0-9a-f]{12}$`)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tinygo := filepath.Join(dir, tt.name)
+			script := "#!/bin/sh\necho 'tinygo version " + tt.version + " linux/amd64 (using go version go1.22.5 and LLVM version 18.1.2)'\n"
+			if err := os.WriteFile(tinygo, []byte(script), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			got, err := tinygoVersion(tinygo)
+			if err != nil || !tt.want.MatchString(got) {
+				t.Errorf("tinygoVersion() = %q, %v, want a match of %s", got, err, tt.want)
+			}
+		})
+	}
+
+	// Development builds outside git differ by their binaries.
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	for i, tinygo := range []string{a, b} {
+		script := fmt.Sprintf("#!/bin/sh\n# build %d\necho 'tinygo version 0.34.0-dev linux/amd64'\n", i)
+		if err := os.WriteFile(tinygo, []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	va, errA := tinygoVersion(a)
+	vb, errB := tinygoVersion(b)
+	if errA != nil || errB != nil || va == vb {
+		t.Errorf("tinygoVersion() of two dev builds = %q, %q (%v, %v), want different versions", va, vb, errA, errB)
+	}
+}
+
+func TestBuildDirsErrors(t *testing.T) {
+	root, cfg := testTree(t)
+	cfg.ramp = 2
+	dirs := []string{"cmds/dup", "cmds/nospace"}
+	for i := 0; i < 8; i++ {
+		dirs = append(dirs, fmt.Sprintf("cmds/p%d", i))
+	}
+	files := map[string]string{
+		"cmds/dup/main.go":     copyright + "//go:build linux\n//go:build linux\n\npackage main\n\nfunc main() {}\n",
+		"cmds/nospace/main.go": copyright + "\npackage main\n\nfunc main() {}\n",
+		"cmds/nospace/NOSPACE": "",
+	}
+	for _, dir := range dirs[2:] {
+		files[dir+"/main.go"] = copyright + "\npackage main\n\nfunc main() {}\n"
+	}
+	for name, src := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := runtime.NumGoroutine()
+	statuses := make(chan BuildStatus)
+	go func() { statuses <- buildDirs(cfg, dirs) }()
+	var status BuildStatus
+	select {
+	case status = <-statuses:
+	case <-time.After(time.Minute):
+		t.Fatal("buildDirs() hung after an error")
+	}
+	if len(status.errors) < 2 || !status.noSpace {
+		t.Errorf("buildDirs() errors = %v, noSpace %t; want both tool errors", status.errors, status.noSpace)
+	}
+	// The workers and the dispatcher exit shortly after the results are in.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines left running after buildDirs(), want at most %d", n, before)
+	}
+}
+
+func TestRunToolchainMismatch(t *testing.T) {
+	_, cfg := testTree(t)
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	defer log.SetOutput(os.Stderr)
+
+	if code := run(cfg, []string{"cmds/pass"}, io.Discard, io.Discard); code != exitOK {
+		t.Fatalf("run() = %d, want %d", code, exitOK)
+	}
+	if strings.Contains(b.String(), "GOROOT") {
+		t.Errorf("run() with a matching GOROOT logged:\n%s", &b)
+	}
+
+	// An unreported GOROOT is not checked.
+	t.Setenv("FAKE_GOROOT", "")
+	if err := checkToolchain(cfg.tinygo); err != nil {
+		t.Errorf("checkToolchain() without a GOROOT = %v, want nil", err)
+	}
+
+	t.Setenv("FAKE_GOROOT", "/opt/tinygo/go")
+	b.Reset()
+	if code := run(cfg, []string{"cmds/pass"}, io.Discard, io.Discard); code != exitOK {
+		t.Fatalf("run() = %d, want %d", code, exitOK)
+	}
+	if want := "uses GOROOT /opt/tinygo/go, but go uses "; !strings.Contains(b.String(), want) {
+		t.Errorf("run() with another GOROOT logged:\n%s\nwant %q", &b, want)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	defer log.SetOutput(os.Stderr)
+
+	// A build finishing in time is not logged.
+	done := make(chan struct{})
+	close(done)
+	heartbeat(3, "cmds/core/ls", time.Hour, time.Millisecond, done)
+	if b.Len() != 0 {
+		t.Errorf("heartbeat() of a fast build logged %q", &b)
+	}
+
+	done = make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(done) })
+	heartbeat(3, "cmds/core/ls", 10*time.Millisecond, 20*time.Millisecond, done)
+	if n := strings.Count(b.String(), "[3] cmds/core/ls still building (0s elapsed)\n"); n < 2 {
+		t.Errorf("heartbeat() logged %d heartbeats, want at least 2:\n%s", n, &b)
+	}
+}
+
+func TestRecoverDir(t *testing.T) {
+	res := recoverDir("cmds/fail", func() WorkerResult { panic("boom") })
+	if res.br.dir != "cmds/fail" || res.err == nil {
+		t.Fatalf("recoverDir() = %+v, want a tool error for cmds/fail", res)
+	}
+	for _, want := range []string{"cmds/fail: panic: boom\n", "goroutine "} {
+		if !strings.Contains(res.err.Error(), want) {
+			t.Errorf("recoverDir() error lacks %q:\n%v", want, res.err)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := writeMarkdown(&b, config{}, reportInfo{}, BuildStatus{errors: []error{res.err}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "### TOOL ERRORS (1)\n - cmds/fail: panic: boom\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("markdown lacks %q:\n%s", want, &b)
+	}
+
+	want := WorkerResult{br: BuildResult{dir: "cmds/pass"}}
+	if res := recoverDir("cmds/pass", func() WorkerResult { return want }); res.br.dir != "cmds/pass" || res.err != nil {
+		t.Errorf("recoverDir() without a panic = %+v, want %+v", res, want)
+	}
+}
+
+func TestBuildErrorPhases(t *testing.T) {
+	root, cfg := testTree(t)
+	dup := copyright + "//go:build linux\n//go:build linux\n\npackage main\n\nfunc main() {}\n"
+	file := filepath.Join(root, "cmds/dup/main.go")
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(dup), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// A gate file would overwrite the file of cmds/fail.
+	cfg.gateFile = "main.go"
+
+	status := buildDirs(cfg, []string{"cmds/dup", "cmds/fail", "cmds/pass"})
+	var got []BuildError
+	for _, err := range status.errors {
+		var be *BuildError
+		if !errors.As(err, &be) {
+			t.Fatalf("tool error %v is not a *BuildError", err)
+		}
+		got = append(got, BuildError{Dir: be.Dir, Phase: be.Phase})
+	}
+	want := []BuildError{{Dir: "cmds/dup", Phase: PhaseParse}, {Dir: "cmds/fail", Phase: PhaseFixup}}
+	if !slices.Equal(got, want) {
+		t.Errorf("tool errors = %+v, want %+v", got, want)
+	}
+	if len(status.errors) == 2 && !strings.HasPrefix(status.errors[1].Error(), "cmds/fail: fixup: cmds/fail/main.go: exists") {
+		t.Errorf("tool error = %q, want the dir and phase first", status.errors[1])
+	}
+}
+
+func TestTruncateOutput(t *testing.T) {
+	output := []byte("first line\nsecond line\nerror: the real one\n")
+	for _, tt := range []struct {
+		limit int
+		want  string
+	}{
+		{0, string(output)},
+		{len(output), string(output)},
+		{25, "[23 bytes of output truncated, see -max-output]\nerror: the real one\n"},
+		{5, "[38 bytes of output truncated, see -max-output]\n one\n"},
+	} {
+		if got := string(truncateOutput(output, tt.limit)); got != tt.want {
+			t.Errorf("truncateOutput(%d) = %q, want %q", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestRunMaxOutput(t *testing.T) {
+	_, cfg := testTree(t)
+	spew := strings.Repeat("spew.go:1:1: noise\n", 1000) + "main.go:3:1: undefined: foo\n"
+	if err := os.WriteFile("cmds/fail/FAIL", []byte(spew), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.checkOnly, cfg.implicatedFiles, cfg.pathJSON = true, true, "report.json"
+
+	for _, tt := range []struct {
+		maxOutput int
+		want      []string
+	}{
+		{30, []string{"cmds/fail/main.go"}},
+		{0, []string{"cmds/fail/spew.go", "cmds/fail/main.go"}},
+	} {
+		cfg.maxOutput = tt.maxOutput
+		run(cfg, []string{"cmds/fail"}, io.Discard, io.Discard)
+		r, err := readReport(cfg.pathJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Packages) != 1 || !slices.Equal(r.Packages[0].Implicated, tt.want) {
+			t.Errorf("-max-output %d: report packages = %+v, want %q implicated", tt.maxOutput, r.Packages, tt.want)
+		}
+	}
+}
+
+func TestStdinDirs(t *testing.T) {
+	got, err := stdinDirs([]string{"cmds/a", "-", "cmds/d"}, strings.NewReader("cmds/b\n\n  cmds/c  \ncmds/b\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"cmds/a", "cmds/b", "cmds/c", "cmds/b", "cmds/d"}; !slices.Equal(got, want) {
+		t.Errorf("stdinDirs() = %q, want %q", got, want)
+	}
+
+	if _, err := stdinDirs([]string{"-", "-"}, strings.NewReader("")); err == nil {
+		t.Errorf("stdinDirs(-, -) = nil error, want error")
+	}
+}
+
+func TestRunDuplicateDirs(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	var stdout bytes.Buffer
+	run(cfg, []string{"cmds/fail", "./cmds/fail/", "cmds/fail"}, &stdout, io.Discard)
+	if want := "### FAILING (1 commands)\n"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("markdown lacks %q:\n%s", want, &stdout)
+	}
+}
+
+func TestRuntimeOptions(t *testing.T) {
+	o := runtimeOptions{scheduler: "none", gc: "leaking"}
+	if got, want := o.String(), "-scheduler none -gc leaking"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	tags := o.goTags("noasm")
+	for _, want := range []string{"scheduler.none", "gc.leaking", "noasm", "tinygo"} {
+		if !slices.Contains(tags, want) {
+			t.Errorf("goTags() = %v, lacks %s", tags, want)
+		}
+	}
+	for _, dflt := range []string{"scheduler.tasks", "gc.precise"} {
+		if slices.Contains(tags, dflt) {
+			t.Errorf("goTags() = %v, has default %s", tags, dflt)
+		}
+	}
+	if got := (runtimeOptions{}).goTags(); !slices.Equal(got, tinygoTags) {
+		t.Errorf("goTags() of the defaults = %v, want %v", got, tinygoTags)
+	}
+}
+
+func TestRunRuntimeOptions(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	// The package only builds without the goroutine scheduler.
+	if err := os.MkdirAll("cmds/nosched", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("cmds/nosched/main.go", []byte(copyright+"//go:build scheduler.none\n\npackage main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("cmds/nosched/NEEDARG", []byte("-scheduler none"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	run(cfg, []string{"cmds/nosched"}, &stdout, io.Discard)
+	if want := "### EXCLUDED (1 commands)\n"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("markdown without runtime options lacks %q:\n%s", want, &stdout)
+	}
+
+	addRuntimeOptions["nosched"] = runtimeOptions{scheduler: "none"}
+	t.Cleanup(func() { delete(addRuntimeOptions, "nosched") })
+	stdout.Reset()
+	run(cfg, []string{"cmds/nosched"}, &stdout, io.Discard)
+	if want := "### PASSING (1 commands)\n - [cmds/nosched](cmds/nosched) runtime: `-scheduler none`\n"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("markdown lacks %q:\n%s", want, &stdout)
+	}
+}
+
+func TestRunCacheDir(t *testing.T) {
+	_, cfg := testTree(t)
+	logFile := filepath.Join(t.TempDir(), "log")
+	t.Setenv("CACHE_LOG", logFile)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A relative directory is resolved before the builds change into the
+	// package directories.
+	cfg.cacheDir = "cache"
+	cache := filepath.Join(wd, "cache")
+
+	if code := run(cfg, []string{"cmds/pass", "cmds/fail"}, io.Discard, io.Discard); code != exitOK {
+		t.Fatalf("run() = %d, want %d", code, exitOK)
+	}
+	if fi, err := os.Stat(cache); err != nil || !fi.IsDir() {
+		t.Errorf("-cache-dir %s was not created: %v", cache, err)
+	}
+	want := filepath.Join(cache, "go") + " " + cache
+	lines := strings.Split(strings.TrimSpace(readFile(t, logFile)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %d builds, want 2:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	for _, line := range lines {
+		if line != want {
+			t.Errorf("build caches = %q, want %q", line, want)
+		}
+	}
+
+	cfg.cacheDir = ""
+	if got, want := buildEnv(cfg), targetEnv(cfg.target); !slices.Equal(got, want) {
+		t.Errorf("buildEnv() without -cache-dir = %q, want %q", got, want)
+	}
+}
+
+func TestRunConfirmFailures(t *testing.T) {
+	_, cfg := testTree(t)
+	t.Setenv("CONTENTION_DIR", t.TempDir())
+	src := copyright + "\npackage main\n\nfunc main() {}\n"
+	for name, content := range map[string]string{"cmds/contention/main.go": src, "cmds/contention/CONTENTION": ""} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// cmds/contention is built alongside cmds/pass.
+	dirs := []string{"cmds/contention", "cmds/pass", "cmds/fail"}
+	// section returns the markdown section of header in md.
+	section := func(md, header string) string {
+		_, after, _ := strings.Cut(md, "\n### "+header+" ")
+		before, _, _ := strings.Cut(after, "\n###")
+		return before
+	}
+
+	var stdout strings.Builder
+	c := cfg
+	c.checkOnly = true
+	run(c, dirs, &stdout, io.Discard)
+	if !strings.Contains(section(stdout.String(), "FAILING"), "cmds/contention") {
+		t.Fatalf("cmds/contention does not fail under parallelism:\n%s", &stdout)
+	}
+
+	stdout.Reset()
+	cfg.confirmFailures = true
+	if code := run(cfg, dirs, &stdout, io.Discard); code != exitOK {
+		t.Fatalf("run() = %d, want %d", code, exitOK)
+	}
+	md := stdout.String()
+	if want := " - [cmds/contention](cmds/contention) (failed only under parallelism)\n"; !strings.Contains(section(md, "PASSING"), want) {
+		t.Errorf("PASSING section lacks %q:\n%s", want, md)
+	}
+	if failing := section(md, "FAILING"); strings.Contains(failing, "cmds/contention") || !strings.Contains(failing, "cmds/fail") {
+		t.Errorf("FAILING section is not just cmds/fail:\n%s", md)
+	}
+	if got := readFile(t, "cmds/contention/main.go"); got != src {
+		t.Errorf("cmds/contention was constrained:\n%s", got)
+	}
+	if got := readFile(t, "cmds/fail/main.go"); !strings.Contains(got, "tinygo.enable") {
+		t.Errorf("confirmed failure cmds/fail was not constrained:\n%s", got)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const mdHeader = `# Status of u-root + tinygo
+This document aims to track the progress of building all u-root commands
+with tinygo. It will be updated as more commands can be built.
+
+Commands that cannot be built with tinygo have a "(!tinygo || tinygo.enable)"
+build constraint. Specify the "tinygo.enable" build tag to (attempt to) build
+them.
+
+    tinygo build -tags tinygo.enable cmds/core/ls
+
+Some commands require additional tags to be built. The required tags are listed
+below, e.g. ` + "`cmds/core/init`" + ` and others require ` + "`-tags noasm`" + `.
+
+The list below is the result of building each command for Linux, x86_64 with
+tinygo version %s.
+
+The necessary additions to tinygo will be tracked in
+[#2979](https://github.com/u-root/u-root/issues/2979).
+
+---
+
+## Commands Build Status
+`
+
+// gitRevision returns the short commit hash of the tree in the current
+// directory, or "" outside a git repository.
+func gitRevision() string {
+	out, err := exec.Command("git", "rev-parse", "--short", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// timestamp returns the line recording when, from which revision, and by
+// which tinygoize version the report was generated.
+func timestamp(now time.Time, rev, tool string) string {
+	s := "Generated " + now.UTC().Format(time.RFC3339)
+	if rev != "" {
+		s += " at revision " + rev
+	}
+	return s + " by tinygoize " + tool + ".\n"
+}
+
+// category returns the top-level category of the command in dir, e.g.
+// "cmds/core" for "cmds/core/ls".
+func category(dir string) string {
+	parts := strings.Split(filepath.ToSlash(dir), "/")
+	if len(parts) > 2 {
+		return strings.Join(parts[:2], "/")
+	}
+	return path.Dir(filepath.ToSlash(dir))
+}
+
+// writeTargets writes the per-target counts of a matrix build, and the
+// commands that build on some targets only.
+func writeTargets(b *strings.Builder, status BuildStatus) {
+	fmt.Fprintf(b, "\n## Targets\n\n")
+	fmt.Fprintf(b, "| Target | Passing | Failing | Excluded |\n")
+	fmt.Fprintf(b, "|--------|---------|---------|----------|\n")
+	passing := make(map[string][]string)
+	for _, ts := range status.targets {
+		fmt.Fprintf(b, "| %s | %d | %d | %d |\n", ts.target,
+			len(ts.status.passing)+len(ts.status.passingWarnings), len(ts.status.failing), len(ts.status.excluded))
+		for _, r := range append(slices.Clone(ts.status.passing), ts.status.passingWarnings...) {
+			passing[r.dir] = append(passing[r.dir], ts.target)
+		}
+	}
+
+	fmt.Fprintf(b, "\nThe sections below combine the targets: a command builds on all targets\n")
+	fmt.Fprintf(b, "if it builds on each target that does not exclude it.\n")
+
+	var some []string
+	for _, r := range status.failing {
+		if len(passing[r.dir]) > 0 {
+			some = append(some, r.dir)
+		}
+	}
+	sort.Strings(some)
+	fmt.Fprintf(b, "\n### BUILDS ON SOME TARGETS (%d commands)\n", len(some))
+	for _, dir := range some {
+		fmt.Fprintf(b, " - %s: %s\n", filepath.ToSlash(dir), strings.Join(passing[dir], ", "))
+	}
+}
+
+// sectionEmoji are the emoji prefixed to the headers of the main sections
+// with -emoji.
+var sectionEmoji = map[string]string{
+	"EXCLUDED":                            "⏭️",
+	"FAILING":                             "❌",
+	"PASSING":                             "✅",
+	"PASSING WITH WARNINGS":               "⚠️",
+	"BUILDS ON ALL TARGETS":               "✅",
+	"BUILDS ON ALL TARGETS WITH WARNINGS": "⚠️",
+}
+
+// reportInfo describes how a report was produced.
+type reportInfo struct {
+	// version is the tinygo version.
+	version string
+	// tags are the build tags tinygo resolves, or nil if unknown.
+	tags []string
+	// stamp is the timestamp line, or "".
+	stamp string
+}
+
+// writeMarkdown writes the build status as markdown. Links to the commands
+// are relative to the directory of cfg.pathMD. With cfg.gap set, a section
+// listing the packages whose constraints disagree with their build result is
+// added. With cfg.groupByCategory set, each section is subdivided by command
+// category. With cfg.warnings set, passing commands whose build emitted
+// warnings are listed separately, with their warnings. With
+// cfg.implicatedFiles set, failing commands list the files named in their
+// errors. With cfg.emoji set, the main section headers carry emoji. With cfg.tagImpact set, the effects of the additional build tags
+// are listed. With cfg.maxPerSection set, each section lists only its first
+// commands, but counts all of them.
+func writeMarkdown(w io.Writer, cfg config, info reportInfo, status BuildStatus) error {
+	base := "."
+	if cfg.pathMD != "" && cfg.pathMD != "-" {
+		base = filepath.Dir(cfg.pathMD)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, mdHeader, info.version)
+	if info.tags != nil {
+		fmt.Fprintf(&b, "\nResolved build tags: `%s`.\n", strings.Join(info.tags, " "))
+	}
+	if info.stamp != "" {
+		fmt.Fprintf(&b, "\n%s", info.stamp)
+	}
+
+	// Category totals across all sections, for -group-by-category.
+	totals := make(map[string]int)
+	for _, set := range [][]BuildResult{status.empty, status.excluded, status.failing, status.passing, status.passingWarnings} {
+		for _, r := range set {
+			totals[category(r.dir)]++
+		}
+	}
+
+	processSet := func(header string, results []BuildResult) {
+		sort.Slice(results, func(i, j int) bool { return results[i].dir < results[j].dir })
+		prefix := ""
+		if e, ok := sectionEmoji[header]; ok && cfg.emoji {
+			prefix = e + " "
+		}
+		fmt.Fprintf(&b, "\n### %s%s (%d commands)\n", prefix, header, len(results))
+		shown := results
+		if cfg.maxPerSection > 0 && len(results) > cfg.maxPerSection {
+			shown = results[:cfg.maxPerSection]
+		}
+		group := ""
+		for i, r := range shown {
+			if cat := category(r.dir); cfg.groupByCategory && (i == 0 || cat != group) {
+				group = cat
+				n := 0
+				for _, r := range results[i:] {
+					if category(r.dir) == cat {
+						n++
+					}
+				}
+				fmt.Fprintf(&b, "\n#### %s (%d of %d commands)\n", cat, n, totals[cat])
+			}
+			link := r.dir
+			if rel, err := filepath.Rel(base, r.dir); err == nil {
+				link = filepath.ToSlash(rel)
+			}
+			fmt.Fprintf(&b, " - [%s](%s)", filepath.ToSlash(r.dir), link)
+			if len(r.tags) > 0 {
+				fmt.Fprintf(&b, " tags: %s", strings.Join(r.tags, ","))
+			}
+			if r.runtime != (runtimeOptions{}) {
+				fmt.Fprintf(&b, " runtime: `%s`", r.runtime)
+			}
+			if errors.Is(r.err, errNoArtifact) || errors.Is(r.err, errWarnings) {
+				fmt.Fprintf(&b, " (%v)", r.err)
+			}
+			if k, ok := status.known[filepath.ToSlash(r.dir)]; ok {
+				fmt.Fprintf(&b, " (tracked in %s)", k.issue)
+			}
+			if r.cacheRetry {
+				b.WriteString(" (retried after clearing the tinygo cache)")
+			}
+			if r.parallelOnly {
+				b.WriteString(" (failed only under parallelism)")
+			}
+			b.WriteString("\n")
+			for _, w := range r.warnings {
+				fmt.Fprintf(&b, "   - `%s`\n", w)
+			}
+			if len(r.implicated) > 0 {
+				fmt.Fprintf(&b, "   - implicated: `%s`\n", strings.Join(r.implicated, "`, `"))
+			}
+		}
+		if more := len(results) - len(shown); more > 0 {
+			fmt.Fprintf(&b, " - ... and %d more\n", more)
+		}
+	}
+	passing := "PASSING"
+	if len(status.targets) > 1 {
+		writeTargets(&b, status)
+		passing = "BUILDS ON ALL TARGETS"
+	}
+
+	processSet("EMPTY (no Go files)", status.empty)
+	processSet("EXCLUDED", status.excluded)
+	if cfg.knownFailures != "" {
+		failing, known := splitFailing(status)
+		processSet("FAILING", failing)
+		processSet("KNOWN FAILING", known)
+	} else {
+		processSet("FAILING", status.failing)
+	}
+	processSet(passing, status.passing)
+	if cfg.warnings && !cfg.warningsAsFailures {
+		processSet(passing+" WITH WARNINGS", status.passingWarnings)
+	}
+
+	if cfg.gap {
+		var needed, stale []BuildResult
+		for _, r := range status.failing {
+			if r.needsConstraint() {
+				needed = append(needed, r)
+			}
+		}
+		for _, r := range append(slices.Clone(status.passing), status.passingWarnings...) {
+			if r.constrained > 0 {
+				stale = append(stale, r)
+			}
+		}
+		fmt.Fprintf(&b, "\n## Constraint Gap\n\n")
+		fmt.Fprintf(&b, "%d of %d failing commands already carry the constraint.\n",
+			len(status.failing)-len(needed), len(status.failing))
+		processSet("FAILING WITHOUT CONSTRAINT", needed)
+		processSet("PASSING WITH CONSTRAINT", stale)
+	}
+
+	if cfg.tagImpact {
+		writeTagImpact(&b, status.tagImpacts)
+	}
+
+	if cfg.repeat > 1 {
+		writeBuildTimes(&b, cfg.repeat, status)
+	}
+
+	if len(status.errors) > 0 {
+		fmt.Fprintf(&b, "\n### TOOL ERRORS (%d)\n", len(status.errors))
+		for _, err := range status.errors {
+			fmt.Fprintf(&b, " - %s\n", strings.ReplaceAll(err.Error(), "\n", "\n   "))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
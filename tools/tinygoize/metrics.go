@@ -0,0 +1,78 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// labelEscaper escapes Prometheus label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetrics writes the counts of status, and the build duration of each
+// command that was built, as Prometheus gauges in the text exposition
+// format, see -metrics.
+func writeMetrics(w io.Writer, status BuildStatus) error {
+	var b strings.Builder
+	for _, g := range []struct {
+		name, help string
+		value      int
+	}{
+		{"tinygoize_passing", "Number of commands building with tinygo.", len(status.passing) + len(status.passingWarnings)},
+		{"tinygoize_failing", "Number of commands failing to build with tinygo.", len(status.failing)},
+		{"tinygoize_excluded", "Number of commands excluded by their build constraints.", len(status.excluded)},
+		{"tinygoize_modified", "Number of files whose tinygo constraint was updated.", len(status.modified)},
+	} {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", g.name, g.help, g.name, g.name, g.value)
+	}
+
+	var built []BuildResult
+	for _, set := range resultSets(status) {
+		for _, br := range set.results {
+			// Cached results were not built.
+			if br.duration > 0 {
+				built = append(built, br)
+			}
+		}
+	}
+	sort.Slice(built, func(i, j int) bool { return built[i].dir < built[j].dir })
+	b.WriteString("# HELP tinygoize_build_duration_seconds Time tinygo took to build the command.\n")
+	b.WriteString("# TYPE tinygoize_build_duration_seconds gauge\n")
+	for _, br := range built {
+		fmt.Fprintf(&b, "tinygoize_build_duration_seconds{dir=\"%s\"} %g\n", labelEscaper.Replace(filepath.ToSlash(br.dir)), br.duration.Seconds())
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeMetricsFile writes the metrics of status to path. The file is
+// replaced at once so that a collector never reads it half-written.
+func writeMetricsFile(path string, status BuildStatus) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := writeMetrics(f, status); err != nil {
+		f.Close()
+		return err
+	}
+	// CreateTemp makes the file private, but collectors may run as
+	// another user.
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	status := BuildStatus{
+		passing:         []BuildResult{{dir: "cmds/core/init", duration: 1250 * time.Millisecond}},
+		passingWarnings: []BuildResult{{dir: "cmds/core/ls"}},
+		failing:         []BuildResult{{dir: "cmds/core/ip", duration: 3 * time.Second}},
+		excluded:        []BuildResult{{dir: "cmds/core/dmesg"}},
+		modified:        []string{"cmds/core/ip/ip.go", "cmds/core/ip/link.go"},
+	}
+	var b strings.Builder
+	if err := writeMetrics(&b, status); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP tinygoize_passing Number of commands building with tinygo.
+# TYPE tinygoize_passing gauge
+tinygoize_passing 2
+# HELP tinygoize_failing Number of commands failing to build with tinygo.
+# TYPE tinygoize_failing gauge
+tinygoize_failing 1
+# HELP tinygoize_excluded Number of commands excluded by their build constraints.
+# TYPE tinygoize_excluded gauge
+tinygoize_excluded 1
+# HELP tinygoize_modified Number of files whose tinygo constraint was updated.
+# TYPE tinygoize_modified gauge
+tinygoize_modified 2
+# HELP tinygoize_build_duration_seconds Time tinygo took to build the command.
+# TYPE tinygoize_build_duration_seconds gauge
+tinygoize_build_duration_seconds{dir="cmds/core/init"} 1.25
+tinygoize_build_duration_seconds{dir="cmds/core/ip"} 3
+`
+	if b.String() != want {
+		t.Errorf("writeMetrics() = \n%s\nwant\n%s", &b, want)
+	}
+}
+
+func TestRunMetrics(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly, cfg.pathMetrics = true, "tinygoize.prom"
+
+	run(cfg, []string{"cmds/pass", "cmds/fail", "cmds/excluded"}, io.Discard, io.Discard)
+	got := readFile(t, cfg.pathMetrics)
+	for _, want := range []string{"tinygoize_passing 1\n", "tinygoize_failing 1\n", "tinygoize_excluded 1\n", `tinygoize_build_duration_seconds{dir="cmds/fail"} `} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics lack %q:\n%s", want, got)
+		}
+	}
+	if fi, err := os.Stat(cfg.pathMetrics); err != nil || fi.Mode().Perm() != 0o644 {
+		t.Errorf("metrics file mode = %v, %v, want %v", fi.Mode().Perm(), err, os.FileMode(0o644))
+	}
+	// No temporary files are left behind.
+	if tmp, _ := filepath.Glob(cfg.pathMetrics + ".*"); len(tmp) > 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+}
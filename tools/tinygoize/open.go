@@ -0,0 +1,50 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// interactive reports whether tinygoize runs in an interactive session, on a
+// terminal and, but on macOS, with a display to open a browser on.
+var interactive = func() bool {
+	if !isTerminal(os.Stderr) {
+		return false
+	}
+	return runtime.GOOS == "darwin" || os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// openReport opens the report in file with the platform's opener, see -open.
+// Outside an interactive session, e.g. in CI, or without the opener, it only
+// warns.
+func openReport(file string, stderr io.Writer) {
+	if !interactive() {
+		log.Printf("not opening %s outside an interactive session", file)
+		return
+	}
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+	path, err := exec.LookPath(opener)
+	if err != nil {
+		log.Printf("not opening %s: %v", file, err)
+		return
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	c := exec.Command(path, file)
+	c.Stdout, c.Stderr = stderr, stderr
+	if err := c.Run(); err != nil {
+		log.Printf("opening %s: %v", file, err)
+	}
+}
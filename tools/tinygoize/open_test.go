@@ -0,0 +1,67 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunOpen(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly, cfg.open = true, true
+	for _, path := range []string{"", "-"} {
+		cfg.pathMD = path
+		if code := run(cfg, []string{"cmds/pass"}, io.Discard, io.Discard); code != exitUsage {
+			t.Errorf("run() with -open, -o %q = %d, want %d", path, code, exitUsage)
+		}
+	}
+
+	// A fake opener logs the file it opens.
+	bin := t.TempDir()
+	opened := filepath.Join(bin, "opened")
+	for _, opener := range []string{"xdg-open", "open"} {
+		if err := os.WriteFile(filepath.Join(bin, opener), []byte("#!/bin/sh\necho \"$@\" >> "+opened+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(filepath.ListSeparator)+os.Getenv("PATH"))
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	defer func(f func() bool) { interactive = f }(interactive)
+
+	cfg.checkOnly, cfg.pathMD = false, "status.md"
+	want, err := filepath.Abs(cfg.pathMD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		interactive bool
+		want        string
+	}{
+		{false, ""},
+		{true, want + "\n"},
+	} {
+		interactive = func() bool { return tt.interactive }
+		os.Remove(opened)
+		logs.Reset()
+		if code := run(cfg, []string{"cmds/pass"}, io.Discard, io.Discard); code != exitOK {
+			t.Fatalf("run() with -open = %d, want %d", code, exitOK)
+		}
+		got, _ := os.ReadFile(opened)
+		if string(got) != tt.want {
+			t.Errorf("run() with -open, interactive %t, opened %q, want %q", tt.interactive, got, tt.want)
+		}
+		if warned := strings.Contains(logs.String(), "interactive session"); warned == tt.interactive {
+			t.Errorf("run() with -open, interactive %t, logged %q", tt.interactive, &logs)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around a change in a patch.
+const diffContext = 3
+
+// splitLines splits s into lines, keeping their line endings.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// unifiedDiff returns a git-apply compatible diff of file from old to new, or
+// "" if they are equal. Constraint rewrites change a single region at the top
+// of a file, so the diff is a single hunk spanning all changed lines. An empty
+// old or new creates or deletes the file.
+func unifiedDiff(file string, old, new []byte) string {
+	a, b := splitLines(string(old)), splitLines(string(new))
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	if prefix == len(a) && prefix == len(b) {
+		return ""
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	start := max(prefix-diffContext, 0)
+	aEnd := len(a) - suffix + min(suffix, diffContext)
+	bEnd := len(b) - suffix + min(suffix, diffContext)
+	// An empty range starts at the line before it.
+	hunkStart := func(n int) int {
+		if n == 0 {
+			return start
+		}
+		return start + 1
+	}
+
+	var d strings.Builder
+	fmt.Fprintf(&d, "diff --git a/%s b/%s\n", file, file)
+	switch {
+	case len(old) == 0:
+		fmt.Fprintf(&d, "new file mode 100644\n--- /dev/null\n+++ b/%s\n", file)
+	case len(new) == 0:
+		fmt.Fprintf(&d, "deleted file mode 100644\n--- a/%s\n+++ /dev/null\n", file)
+	default:
+		fmt.Fprintf(&d, "--- a/%s\n+++ b/%s\n", file, file)
+	}
+	fmt.Fprintf(&d, "@@ -%d,%d +%d,%d @@\n", hunkStart(aEnd-start), aEnd-start, hunkStart(bEnd-start), bEnd-start)
+	writeLine := func(mark, line string) {
+		d.WriteString(mark + line)
+		if !strings.HasSuffix(line, "\n") {
+			d.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	for _, line := range a[start:prefix] {
+		writeLine(" ", line)
+	}
+	for _, line := range a[prefix : len(a)-suffix] {
+		writeLine("-", line)
+	}
+	for _, line := range b[prefix : len(b)-suffix] {
+		writeLine("+", line)
+	}
+	for _, line := range a[len(a)-suffix : aEnd] {
+		writeLine(" ", line)
+	}
+	return d.String()
+}
+
+// writePatch writes the constraint changes of status, which must have been
+// computed without writing them, as a patch to path.
+func writePatch(path string, status BuildStatus) error {
+	patch, err := patchOf(status)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(patch), 0o644)
+}
+
+// diffExit prints the constraint changes of status as a patch to stdout,
+// unless -quiet is set, and returns the exit status of -diff-exit: as with
+// `git diff --exit-code`, exitUpdates if any file would change, or exitError
+// if some packages could not be processed.
+func diffExit(cfg config, status BuildStatus, stdout, stderr io.Writer) int {
+	patch, err := patchOf(status)
+	if err != nil {
+		return fatalError(cfg, stderr, exitError, fmt.Errorf("computing the diff: %w", err))
+	}
+	if !cfg.quiet {
+		io.WriteString(stdout, patch)
+	}
+	for _, err := range status.errors {
+		fmt.Fprintf(stderr, "%v\n", err)
+	}
+	switch {
+	case len(status.errors) > 0:
+		return exitError
+	case len(status.modified) > 0:
+		return exitUpdates
+	}
+	return exitOK
+}
+
+// patchOf returns the constraint changes of status, which must have been
+// computed without writing them, as a patch.
+func patchOf(status BuildStatus) (string, error) {
+	builds := make(map[string]bool)
+	for _, set := range [][]BuildResult{status.passing, status.passingWarnings, status.excluded} {
+		for _, br := range set {
+			builds[br.dir] = true
+		}
+	}
+
+	var patch strings.Builder
+	for _, file := range status.modified {
+		src, err := os.ReadFile(file)
+		var out []byte
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// A gate file to create.
+			out, _, err = gateSource(filepath.Dir(file))
+		case err != nil:
+		case isGate(file, src):
+			// A gate file to delete.
+		default:
+			out, _, err = rewriteConstraints(file, src, builds[filepath.Dir(file)])
+		}
+		if err != nil {
+			return "", err
+		}
+		patch.WriteString(unifiedDiff(filepath.ToSlash(file), src, out))
+	}
+	return patch.String(), nil
+}
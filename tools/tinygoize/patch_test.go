@@ -0,0 +1,180 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "equal",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+		},
+		{
+			name: "insert",
+			old:  "// c\n\npackage main\n\nfunc main() {}\n",
+			new:  "// c\n//go:build x\n\npackage main\n\nfunc main() {}\n",
+			want: "diff --git a/f.go b/f.go\n--- a/f.go\n+++ b/f.go\n" +
+				"@@ -1,4 +1,5 @@\n" +
+				" // c\n" +
+				"+//go:build x\n" +
+				" \n" +
+				" package main\n" +
+				" \n",
+		},
+		{
+			name: "change past context",
+			old:  "1\n2\n3\n4\n5\nold\n6\n",
+			new:  "1\n2\n3\n4\n5\nnew\n6\n",
+			want: "diff --git a/f.go b/f.go\n--- a/f.go\n+++ b/f.go\n" +
+				"@@ -3,5 +3,5 @@\n" +
+				" 3\n 4\n 5\n-old\n+new\n 6\n",
+		},
+		{
+			name: "new file",
+			new:  "a\nb\n",
+			want: "diff --git a/f.go b/f.go\nnew file mode 100644\n--- /dev/null\n+++ b/f.go\n" +
+				"@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "deleted file",
+			old:  "a\n",
+			want: "diff --git a/f.go b/f.go\ndeleted file mode 100644\n--- a/f.go\n+++ /dev/null\n" +
+				"@@ -1,1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "no newline at end",
+			old:  "a\nb",
+			new:  "a\nc",
+			want: "diff --git a/f.go b/f.go\n--- a/f.go\n+++ b/f.go\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("f.go", []byte(tt.old), []byte(tt.new)); got != tt.want {
+				t.Errorf("unifiedDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunPatch(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.patch = "constraints.patch"
+	dirs := []string{"cmds/pass", "cmds/fail", "cmds/excluded"}
+	pass, fail := readFile(t, "cmds/pass/main.go"), readFile(t, "cmds/fail/main.go")
+
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitUpdates {
+		t.Fatalf("run() = %d, want %d", code, exitUpdates)
+	}
+	if readFile(t, "cmds/pass/main.go") != pass || readFile(t, "cmds/fail/main.go") != fail {
+		t.Fatalf("-patch modified the sources")
+	}
+
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not found")
+	}
+	if out, err := exec.Command(git, "apply", cfg.patch).CombinedOutput(); err != nil {
+		t.Fatalf("git apply: %v\n%s\npatch:\n%s", err, out, readFile(t, cfg.patch))
+	}
+	// Once applied, nothing is left to do.
+	if err := os.Remove(cfg.patch); err != nil {
+		t.Fatal(err)
+	}
+	cfg.patch, cfg.checkOnly = "", true
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitOK {
+		t.Errorf("run() after applying the patch = %d, want %d", code, exitOK)
+	}
+}
+
+func TestRunPatchGate(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.patch, cfg.gateFile = "constraints.patch", "tinygo.go"
+	dirs := []string{"cmds/pass", "cmds/fail", "cmds/excluded"}
+	if err := os.WriteFile("cmds/pass/tinygo.go", []byte(fmt.Sprintf(gateTemplate, "main")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitUpdates {
+		t.Fatalf("run() = %d, want %d", code, exitUpdates)
+	}
+	if _, err := os.Stat("cmds/fail/tinygo.go"); !os.IsNotExist(err) {
+		t.Fatalf("-patch created the gate file: %v", err)
+	}
+
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not found")
+	}
+	if out, err := exec.Command(git, "apply", cfg.patch).CombinedOutput(); err != nil {
+		t.Fatalf("git apply: %v\n%s\npatch:\n%s", err, out, readFile(t, cfg.patch))
+	}
+	if _, err := os.Stat("cmds/pass/tinygo.go"); !os.IsNotExist(err) {
+		t.Errorf("the patch did not delete the gate of a passing package: %v", err)
+	}
+	if err := os.Remove(cfg.patch); err != nil {
+		t.Fatal(err)
+	}
+	cfg.patch, cfg.checkOnly = "", true
+	if code := run(cfg, dirs, io.Discard, io.Discard); code != exitOK {
+		t.Errorf("run() after applying the patch = %d, want %d", code, exitOK)
+	}
+}
+
+func TestRunDiffExit(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.diffExit = true
+	dirs := []string{"cmds/pass", "cmds/fail", "cmds/excluded"}
+	pass, fail := readFile(t, "cmds/pass/main.go"), readFile(t, "cmds/fail/main.go")
+
+	var b strings.Builder
+	if code := run(cfg, dirs, &b, io.Discard); code != exitUpdates {
+		t.Fatalf("run() = %d, want %d", code, exitUpdates)
+	}
+	if readFile(t, "cmds/pass/main.go") != pass || readFile(t, "cmds/fail/main.go") != fail {
+		t.Fatalf("-diff-exit modified the sources")
+	}
+	// Only the patch is printed, no report.
+	for _, want := range []string{"--- a/cmds/fail/main.go\n", "--- a/cmds/pass/main.go\n"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, &b)
+		}
+	}
+	if strings.Contains(b.String(), "###") {
+		t.Errorf("output has a report:\n%s", &b)
+	}
+
+	cfg.quiet = true
+	b.Reset()
+	if code := run(cfg, dirs, &b, io.Discard); code != exitUpdates || b.Len() != 0 {
+		t.Errorf("run() with -quiet = %d, output %q; want %d and no output", code, &b, exitUpdates)
+	}
+
+	// Swapped, no file would change.
+	for file, src := range map[string]string{"cmds/pass/main.go": fail, "cmds/fail/main.go": pass} {
+		if err := os.WriteFile(file, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg.quiet = false
+	b.Reset()
+	if code := run(cfg, dirs, &b, io.Discard); code != exitOK || b.Len() != 0 {
+		t.Errorf("run() of up to date constraints = %d, output %q; want %d and no output", code, &b, exitOK)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// depCounts returns the number of transitive dependencies of the package in
+// each of dirs, as reported by `go list`.
+func depCounts(cfg config, dirs []string) (map[string]int, error) {
+	tags := append([]string{"tinygo.enable"}, tinygoTags...)
+	args := []string{"list", "-e", "-tags", strings.Join(tags, ","), "-f", "{{.Dir}}{{range .Deps}} {{.}}{{end}}"}
+	abs := make(map[string]string)
+	for _, dir := range dirs {
+		a, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		abs[a] = dir
+		// go list takes a relative directory for an import path
+		// unless it starts with ".".
+		if !filepath.IsAbs(dir) {
+			dir = "." + string(filepath.Separator) + dir
+		}
+		args = append(args, dir)
+	}
+	c := exec.Command("go", args...)
+	c.Env = append(os.Environ(), buildEnv(cfg)...)
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if msg := bytes.TrimSpace(stderr.Bytes()); err != nil && len(msg) > 0 {
+		return nil, fmt.Errorf("go list: %w: %s", err, msg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("go list: %w", err)
+	}
+
+	counts := make(map[string]int)
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if dir, ok := abs[fields[0]]; ok {
+			counts[dir] = len(fields) - 1
+		}
+	}
+	if len(counts) != len(abs) {
+		return nil, fmt.Errorf("go list reported %d of %d packages", len(counts), len(abs))
+	}
+	return counts, nil
+}
+
+// depOrder returns dirs in dependency order for -dep-order: a package whose
+// dependencies are a subset of another's is built first, so the libraries
+// shared by many commands are compiled, and cached, early by the smaller
+// commands rather than by many large ones at once. Ordering by the number of
+// dependencies is such an order. Packages with as many dependencies keep
+// their order in dirs.
+func depOrder(cfg config, dirs []string) ([]string, error) {
+	counts, err := depCounts(cfg, dirs)
+	if err != nil {
+		return nil, err
+	}
+	ordered := append([]string{}, dirs...)
+	sort.SliceStable(ordered, func(i, j int) bool { return counts[ordered[i]] < counts[ordered[j]] })
+	return ordered, nil
+}
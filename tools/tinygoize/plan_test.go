@@ -0,0 +1,47 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestRunPlan(t *testing.T) {
+	_, cfg := testTree(t)
+	if err := os.MkdirAll("cmds/big", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	big := copyright + "\npackage main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println() }\n"
+	if err := os.WriteFile("cmds/big/main.go", []byte(big), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.plan = true
+	dirs := []string{"cmds/big", "cmds/fail", "cmds/pass"}
+
+	var stdout bytes.Buffer
+	if code := run(cfg, dirs, &stdout, io.Discard); code != exitOK {
+		t.Fatalf("run(-plan) = %d, want %d", code, exitOK)
+	}
+	// cmds/fail and cmds/pass import nothing and keep their order.
+	if want := "cmds/fail\ncmds/pass\ncmds/big\n"; stdout.String() != want {
+		t.Errorf("run(-plan) = %q, want %q", &stdout, want)
+	}
+
+	// Without go, the packages are built in the order given.
+	t.Setenv("PATH", "")
+	if _, err := depOrder(cfg, dirs); err == nil {
+		t.Errorf("depOrder() without go = nil, want error")
+	}
+	stdout.Reset()
+	if code := run(cfg, dirs, &stdout, io.Discard); code != exitOK {
+		t.Fatalf("run(-plan) without go = %d, want %d", code, exitOK)
+	}
+	if want := "cmds/big\ncmds/fail\ncmds/pass\n"; stdout.String() != want {
+		t.Errorf("run(-plan) without go = %q, want %q", &stdout, want)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Package statuses in a Report.
+const (
+	statusPassing  = "passing"
+	statusFailing  = "failing"
+	statusExcluded = "excluded"
+	statusEmpty    = "empty"
+	// statusWarnings is a passing package whose build emitted warnings.
+	statusWarnings = "passing-with-warnings"
+)
+
+// Report is the JSON report of a run, see -json.
+type Report struct {
+	Tool     string          `json:"tool"`
+	Tinygo   string          `json:"tinygo"`
+	Tags     []string        `json:"tags"`
+	Targets  []string        `json:"targets"`
+	Packages []PackageReport `json:"packages"`
+}
+
+// PackageReport is the status of a single package in a Report.
+type PackageReport struct {
+	Dir    string   `json:"dir"`
+	Status string   `json:"status"`
+	Tags   []string `json:"tags,omitempty"`
+	// Warnings are the warnings of the build, see -warnings.
+	Warnings []string `json:"warnings,omitempty"`
+	// CacheRetry is set if the package was built again after clearing the
+	// tinygo cache, see -retry-clear-cache.
+	CacheRetry bool `json:"cache_retry,omitempty"`
+	// ParallelOnly is set if the package failed to build alongside others,
+	// but built on its own, see -confirm-failures.
+	ParallelOnly bool `json:"parallel_only,omitempty"`
+	// Implicated are the source files named in the errors of a failing
+	// build, see -implicated-files.
+	Implicated []string `json:"implicated_files,omitempty"`
+	// BuildTimes are the statistics of the build durations with -repeat.
+	BuildTimes *BuildTimes `json:"build_times,omitempty"`
+	// KnownIssue is the issue tracking a known failure, see
+	// -known-failures.
+	KnownIssue string `json:"known_issue,omitempty"`
+}
+
+// resultSet is a set of results of the same report status.
+type resultSet struct {
+	status  string
+	results []BuildResult
+}
+
+// resultSets returns the results of status by report status.
+func resultSets(status BuildStatus) []resultSet {
+	return []resultSet{
+		{statusPassing, status.passing},
+		{statusWarnings, status.passingWarnings},
+		{statusFailing, status.failing},
+		{statusExcluded, status.excluded},
+		{statusEmpty, status.empty},
+	}
+}
+
+// newReport returns the report of status, with packages sorted by dir.
+func newReport(cfg config, info reportInfo, status BuildStatus) Report {
+	r := Report{
+		Tool:    toolVersion(),
+		Tinygo:  info.version,
+		Tags:    info.tags,
+		Targets: cfg.targets,
+	}
+	for _, set := range resultSets(status) {
+		for _, br := range set.results {
+			p := newPackageReport(set.status, br)
+			p.KnownIssue = status.known[p.Dir].issue
+			r.Packages = append(r.Packages, p)
+		}
+	}
+	sort.Slice(r.Packages, func(i, j int) bool { return r.Packages[i].Dir < r.Packages[j].Dir })
+	return r
+}
+
+// newPackageReport returns the report of br, whose status is status.
+func newPackageReport(status string, br BuildResult) PackageReport {
+	return PackageReport{
+		Dir:          filepath.ToSlash(br.dir),
+		Status:       status,
+		Tags:         br.tags,
+		Warnings:     br.warnings,
+		CacheRetry:   br.cacheRetry,
+		ParallelOnly: br.parallelOnly,
+		Implicated:   br.implicated,
+		BuildTimes:   buildTimes(br.durations),
+	}
+}
+
+// writeReport writes r as indented JSON to path.
+func writeReport(path string, r Report) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// readReport reads a report written by writeReport.
+func readReport(path string) (Report, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Report{}, err
+	}
+	return parseReport(path, b)
+}
+
+// headReport reads the report at path as committed at HEAD of the git
+// repository of the current directory, see -compare-head.
+func headReport(path string) (Report, error) {
+	if filepath.IsAbs(path) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return Report{}, err
+		}
+		if path, err = filepath.Rel(wd, path); err != nil {
+			return Report{}, err
+		}
+	}
+	rev := "HEAD:./" + filepath.ToSlash(path)
+	b, err := exec.Command("git", "show", rev).Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
+			err = errors.New(strings.TrimSpace(string(ee.Stderr)))
+		}
+		return Report{}, fmt.Errorf("git show %s: %w", rev, err)
+	}
+	return parseReport(rev, b)
+}
+
+// parseReport parses the report b read from name.
+func parseReport(name string, b []byte) (Report, error) {
+	var r Report
+	if err := json.Unmarshal(b, &r); err != nil {
+		return r, fmt.Errorf("%s: %w", name, err)
+	}
+	return r, nil
+}
+
+// compareReports writes the differences between an old and a new report. A
+// change of the tinygo version or build tags is flagged before the status
+// changes, since it may explain them.
+func compareReports(w io.Writer, old, cur Report) {
+	if old.Tinygo != cur.Tinygo {
+		fmt.Fprintf(w, "  tinygo version changed: %s -> %s\n", old.Tinygo, cur.Tinygo)
+	}
+	if added, removed := diffTags(old.Tags, cur.Tags); len(added)+len(removed) > 0 {
+		fmt.Fprintf(w, "  build tags changed:")
+		for _, tag := range added {
+			fmt.Fprintf(w, " +%s", tag)
+		}
+		for _, tag := range removed {
+			fmt.Fprintf(w, " -%s", tag)
+		}
+		fmt.Fprintf(w, "\n  status changes may be due to the build tags rather than code\n")
+	}
+
+	was := make(map[string]string, len(old.Packages))
+	for _, p := range old.Packages {
+		was[p.Dir] = p.Status
+	}
+	changed := 0
+	for _, p := range cur.Packages {
+		if prev, ok := was[p.Dir]; ok && prev != p.Status {
+			fmt.Fprintf(w, "  %s: %s -> %s\n", p.Dir, prev, p.Status)
+			changed++
+		}
+	}
+	if changed == 0 {
+		fmt.Fprintf(w, "  no status changes\n")
+	}
+}
+
+// diffTags returns the tags in cur but not old, and in old but not cur.
+func diffTags(old, cur []string) (added, removed []string) {
+	for _, tag := range cur {
+		if !slices.Contains(old, tag) {
+			added = append(added, tag)
+		}
+	}
+	for _, tag := range old {
+		if !slices.Contains(cur, tag) {
+			removed = append(removed, tag)
+		}
+	}
+	return added, removed
+}
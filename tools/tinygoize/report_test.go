@@ -0,0 +1,47 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompareReports(t *testing.T) {
+	old := Report{
+		Tinygo: "0.32.0",
+		Tags:   []string{"linux", "tinygo", "gc.conservative"},
+		Packages: []PackageReport{
+			{Dir: "cmds/core/ls", Status: statusFailing},
+			{Dir: "cmds/core/cat", Status: statusPassing},
+			{Dir: "cmds/core/gone", Status: statusPassing},
+		},
+	}
+	cur := Report{
+		Tinygo: "0.33.0",
+		Tags:   []string{"linux", "tinygo", "gc.precise"},
+		Packages: []PackageReport{
+			{Dir: "cmds/core/cat", Status: statusPassing},
+			{Dir: "cmds/core/ls", Status: statusPassing},
+			{Dir: "cmds/core/new", Status: statusFailing},
+		},
+	}
+
+	var b bytes.Buffer
+	compareReports(&b, old, cur)
+	want := "  tinygo version changed: 0.32.0 -> 0.33.0\n" +
+		"  build tags changed: +gc.precise -gc.conservative\n" +
+		"  status changes may be due to the build tags rather than code\n" +
+		"  cmds/core/ls: failing -> passing\n"
+	if b.String() != want {
+		t.Errorf("compareReports() = %q, want %q", &b, want)
+	}
+
+	b.Reset()
+	compareReports(&b, cur, cur)
+	if want := "  no status changes\n"; b.String() != want {
+		t.Errorf("compareReports(same) = %q, want %q", &b, want)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeRepro writes a shell script to path with a line reproducing the build
+// of each failing package of status, see -repro-file. A matrix build gets a
+// line for each target a package fails on. The lines are run from the
+// directory tinygoize was run in.
+func writeRepro(path string, cfg config, status BuildStatus) error {
+	sets := []TargetStatus{{target: cfg.target, status: status}}
+	if len(status.targets) > 0 {
+		sets = status.targets
+	}
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	for _, ts := range sets {
+		c := cfg
+		c.target = ts.target
+		for _, br := range ts.status.failing {
+			line, err := reproLine(c, br)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(&b, line)
+		}
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o755)
+}
+
+// reproLine returns a shell line running the build of br as buildCommand
+// assembles it, in a subshell so that the lines can follow one another.
+func reproLine(cfg config, br BuildResult) (string, error) {
+	c, _, err := buildCommand(cfg, br)
+	if err != nil {
+		return "", err
+	}
+	words := buildEnv(cfg)
+	words = append(words, c.Args...)
+	for i, w := range words {
+		words[i] = shellQuote(w)
+	}
+	return fmt.Sprintf("(cd %s && %s)", shellQuote(filepath.ToSlash(br.dir)), strings.Join(words, " ")), nil
+}
+
+// shellQuote returns s quoted for sh, or as is if it needs no quoting.
+func shellQuote(s string) string {
+	safe := s != ""
+	for _, r := range s {
+		if !strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./,=:+@%", r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
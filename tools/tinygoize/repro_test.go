@@ -0,0 +1,65 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"tinygo", "tinygo"},
+		{"-tags", "-tags"},
+		{"tinygo.enable,purego", "tinygo.enable,purego"},
+		{"GOOS=linux", "GOOS=linux"},
+		{"", "''"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+	} {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRunReproFile(t *testing.T) {
+	root, cfg := testTree(t)
+	cfg.checkOnly = true
+	cfg.reproFile = "repro.sh"
+	// A tinygo path that must be quoted.
+	tinygo := filepath.Join(root, "tiny go's", "tinygo")
+	if err := os.MkdirAll(filepath.Dir(tinygo), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(cfg.tinygo, tinygo); err != nil {
+		t.Fatal(err)
+	}
+	cfg.tinygo = tinygo
+
+	run(cfg, []string{"cmds/pass", "cmds/fail"}, io.Discard, io.Discard)
+	script := readFile(t, cfg.reproFile)
+	lines := strings.Split(strings.TrimSpace(script), "\n")
+	if len(lines) != 2 || lines[0] != "#!/bin/sh" {
+		t.Fatalf("script has %d lines, want the interpreter and the failing package:\n%s", len(lines), script)
+	}
+	want := "(cd cmds/fail && GOOS=linux CGO_ENABLED=0 GOARCH=amd64 " + shellQuote(tinygo) + " build -tags tinygo.enable)"
+	if lines[1] != want {
+		t.Errorf("line = %s, want %s", lines[1], want)
+	}
+
+	// The line reproduces the failure.
+	out, err := exec.Command("sh", "-c", lines[1]).CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "fake tinygo error") {
+		t.Errorf("%s: %v, output %q; want the build to fail", lines[1], err, out)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// readSizeBaseline reads a -size-baseline file, a JSON object mapping the
+// directory of each command to the size of its binary in bytes, e.g.
+//
+//	{"cmds/core/ls": 1261568}
+func readSizeBaseline(path string) (map[string]int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]int64
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// Clean the dirs as run does those given.
+	baseline := make(map[string]int64, len(raw))
+	for dir, size := range raw {
+		baseline[filepath.ToSlash(filepath.Clean(dir))] = size
+	}
+	return baseline, nil
+}
+
+// sizeRegression is a binary that grew by more than -size-threshold.
+type sizeRegression struct {
+	dir       string
+	old, size int64
+}
+
+// growth returns how much the binary grew, in percent.
+func (r sizeRegression) growth() float64 {
+	return float64(r.size-r.old) * 100 / float64(r.old)
+}
+
+// sizeRegressions returns the passing commands whose binaries grew by more
+// than threshold percent over their size in baseline, sorted by directory.
+// Commands missing from the baseline are new and not compared.
+func sizeRegressions(status BuildStatus, baseline map[string]int64, threshold float64) []sizeRegression {
+	var regressions []sizeRegression
+	for _, br := range append(status.passing, status.passingWarnings...) {
+		old, ok := baseline[filepath.ToSlash(br.dir)]
+		if !ok || old <= 0 || br.size == 0 {
+			continue
+		}
+		r := sizeRegression{dir: filepath.ToSlash(br.dir), old: old, size: br.size}
+		if r.growth() > threshold {
+			regressions = append(regressions, r)
+		}
+	}
+	sort.Slice(regressions, func(i, j int) bool { return regressions[i].dir < regressions[j].dir })
+	return regressions
+}
+
+// writeSizeRegressions lists regressions against the baseline file.
+func writeSizeRegressions(w io.Writer, baseline string, threshold float64, regressions []sizeRegression) {
+	fmt.Fprintf(w, "Binaries more than %g%% larger than in %s:\n", threshold, baseline)
+	for _, r := range regressions {
+		fmt.Fprintf(w, "  %s: %d -> %d bytes (+%.1f%%)\n", r.dir, r.old, r.size, r.growth())
+	}
+}
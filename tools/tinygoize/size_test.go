@@ -0,0 +1,82 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSizeRegressions(t *testing.T) {
+	status := BuildStatus{
+		passing: []BuildResult{
+			{dir: "cmds/core/ls", size: 1100},
+			{dir: "cmds/core/cat", size: 1050},
+			{dir: "cmds/core/new", size: 5000},
+		},
+		passingWarnings: []BuildResult{{dir: "cmds/core/cp", size: 2000}},
+		failing:         []BuildResult{{dir: "cmds/core/ip"}},
+	}
+	baseline := map[string]int64{
+		"cmds/core/ls":  1000,
+		"cmds/core/cat": 1000,
+		"cmds/core/cp":  1000,
+		"cmds/core/ip":  1000,
+	}
+	got := sizeRegressions(status, baseline, 5)
+	want := []sizeRegression{
+		{dir: "cmds/core/cp", old: 1000, size: 2000},
+		{dir: "cmds/core/ls", old: 1000, size: 1100},
+	}
+	if !cmp.Equal(got, want, cmp.AllowUnexported(sizeRegression{})) {
+		t.Errorf("sizeRegressions() = %+v, want %+v", got, want)
+	}
+
+	var out bytes.Buffer
+	writeSizeRegressions(&out, "sizes.json", 5, got)
+	if want := "Binaries more than 5% larger than in sizes.json:\n  cmds/core/cp: 1000 -> 2000 bytes (+100.0%)\n  cmds/core/ls: 1000 -> 1100 bytes (+10.0%)\n"; out.String() != want {
+		t.Errorf("writeSizeRegressions() = %q, want %q", &out, want)
+	}
+}
+
+func TestRunSizeBaseline(t *testing.T) {
+	_, cfg := testTree(t)
+	// cmds/pass needlessly carries the constraint, which is no error here.
+	cfg.checkOnly, cfg.staleSeverity = true, severityWarn
+	// The fake tinygo writes 7 byte binaries.
+	if err := os.WriteFile("sizes.json", []byte(`{"./cmds/pass/": 5}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.sizeBaseline = "sizes.json"
+	cfg.sizeThreshold = 10
+
+	if code := run(cfg, []string{"cmds/pass"}, io.Discard, io.Discard); code != exitUsage {
+		t.Errorf("run(-size-baseline) without -o-dir = %d, want %d", code, exitUsage)
+	}
+
+	cfg.outDir = "bin"
+	var stderr bytes.Buffer
+	if code := run(cfg, []string{"cmds/pass"}, io.Discard, &stderr); code != exitSize {
+		t.Errorf("run(-size-baseline) = %d, want %d", code, exitSize)
+	}
+	if want := "cmds/pass: 5 -> 7 bytes (+40.0%)"; !strings.Contains(stderr.String(), want) {
+		t.Errorf("run(-size-baseline) stderr lacks %q:\n%s", want, &stderr)
+	}
+
+	cfg.sizeThreshold = 50
+	if code := run(cfg, []string{"cmds/pass"}, io.Discard, io.Discard); code != exitOK {
+		t.Errorf("run(-size-threshold 50) = %d, want %d", code, exitOK)
+	}
+
+	cfg.sizeBaseline = "missing.json"
+	if code := run(cfg, []string{"cmds/pass"}, io.Discard, io.Discard); code != exitSetup {
+		t.Errorf("run(-size-baseline missing.json) = %d, want %d", code, exitSetup)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// statusError is the status of a streamed package that could not be
+// processed, see -stream-json. The aggregate reports list these only as tool
+// errors.
+const statusError = "error"
+
+// StreamedPackage is an element of the array written by -stream-json: the
+// status of a package as its build completed.
+type StreamedPackage struct {
+	PackageReport
+	// Target is the GOOS/GOARCH pair built for. It is only set when
+	// building several -targets, each of which streams its results.
+	Target string `json:"target,omitempty"`
+	// Error is the tool error of a package with the error status.
+	Error string `json:"error,omitempty"`
+}
+
+// jsonStream writes a JSON array one element at a time, see -stream-json.
+// Each element is written whole as it is added, so that a consumer can
+// process it before the run ends, and close ends the array however the run
+// ends, e.g. when it is interrupted.
+type jsonStream struct {
+	mu     sync.Mutex
+	w      io.Writer
+	n      int
+	closed bool
+	// err is the first error writing to w, after which nothing more is
+	// written.
+	err error
+}
+
+// newJSONStream starts an array on w.
+func newJSONStream(w io.Writer) *jsonStream {
+	s := &jsonStream{w: w}
+	_, s.err = io.WriteString(w, "[")
+	return s
+}
+
+// add writes v as the next element of the array, unless it is closed.
+func (s *jsonStream) add(v any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.err != nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		s.err = err
+		return
+	}
+	sep := "\n"
+	if s.n > 0 {
+		sep = ",\n"
+	}
+	// A single write, so that a reader never sees half an element.
+	_, s.err = fmt.Fprintf(s.w, "%s%s", sep, b)
+	s.n++
+}
+
+// addResult writes the result of a worker building for cfg.target.
+func (s *jsonStream) addResult(cfg config, res WorkerResult) {
+	p := StreamedPackage{PackageReport: newPackageReport(workerStatus(res), res.br)}
+	if len(cfg.targets) > 1 {
+		p.Target = cfg.target
+	}
+	if res.err != nil {
+		p.Error = res.err.Error()
+	}
+	s.add(p)
+}
+
+// close ends the array and returns the first error writing it. Elements
+// added later, e.g. by workers finishing after an interrupt, are dropped.
+func (s *jsonStream) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return s.err
+	}
+	s.closed = true
+	if s.err == nil {
+		_, s.err = io.WriteString(s.w, "\n]\n")
+	}
+	return s.err
+}
+
+// workerStatus returns the report status of res, as buildDirs sorts it.
+func workerStatus(res WorkerResult) string {
+	switch {
+	case res.err != nil:
+		return statusError
+	case res.br.excluded:
+		return statusExcluded
+	case res.br.err != nil:
+		return statusFailing
+	case len(res.br.warnings) > 0:
+		return statusWarnings
+	default:
+		return statusPassing
+	}
+}
+
+// closeOnSignal closes s when the run is interrupted or terminated, and then
+// dies of the signal as it would have, so that the array written so far
+// stays well-formed. The function returned stops watching for the signals.
+func closeOnSignal(s *jsonStream) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			s.close()
+			signal.Stop(sigs)
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Signal(sig)
+			}
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
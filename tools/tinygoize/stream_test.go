@@ -0,0 +1,109 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestJSONStream(t *testing.T) {
+	var b strings.Builder
+	s := newJSONStream(&b)
+	if err := s.close(); err != nil || b.String() != "[\n]\n" {
+		t.Errorf("empty stream = %q, %v; want an empty array", &b, err)
+	}
+
+	b.Reset()
+	s = newJSONStream(&b)
+	s.add(map[string]int{"a": 1})
+	if want := "[\n{\"a\":1}"; b.String() != want {
+		t.Errorf("stream after one element = %q, want %q", &b, want)
+	}
+	s.add(map[string]int{"b": 2})
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	// Once closed, the stream is complete.
+	s.add(map[string]int{"c": 3})
+	s.close()
+	if want := "[\n{\"a\":1},\n{\"b\":2}\n]\n"; b.String() != want {
+		t.Errorf("stream = %q, want %q", &b, want)
+	}
+}
+
+func TestRunStreamJSON(t *testing.T) {
+	_, cfg := testTree(t)
+	if err := os.MkdirAll("cmds/empty", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg.checkOnly, cfg.streamJSON, cfg.pathMD = true, true, "stream.json"
+	cfg.targets = []string{"linux/amd64", "linux/arm64"}
+
+	var stdout strings.Builder
+	if code := run(cfg, []string{"cmds/pass", "cmds/fail", "cmds/excluded", "cmds/empty"}, &stdout, io.Discard); code != exitUpdates {
+		t.Fatalf("run() = %d, want %d", code, exitUpdates)
+	}
+	if strings.Contains(stdout.String(), "###") {
+		t.Errorf("run() wrote a markdown summary:\n%s", &stdout)
+	}
+	var got []StreamedPackage
+	if err := json.Unmarshal([]byte(readFile(t, cfg.pathMD)), &got); err != nil {
+		t.Fatalf("streamed JSON: %v", err)
+	}
+	var lines []string
+	for _, p := range got {
+		lines = append(lines, p.Dir+" "+p.Target+" "+p.Status)
+	}
+	sort.Strings(lines)
+	want := []string{
+		"cmds/empty  empty",
+		"cmds/excluded linux/amd64 excluded",
+		"cmds/excluded linux/arm64 excluded",
+		"cmds/fail linux/amd64 failing",
+		"cmds/fail linux/arm64 failing",
+		"cmds/pass linux/amd64 passing",
+		"cmds/pass linux/arm64 passing",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("streamed packages:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	cfg.diffExit = true
+	if code := run(cfg, []string{"cmds/pass"}, io.Discard, io.Discard); code != exitUsage {
+		t.Errorf("run() with -diff-exit = %d, want %d", code, exitUsage)
+	}
+}
+
+// TestStreamInterrupt interrupts a process streaming to stdout, which must
+// still have written a complete array.
+func TestStreamInterrupt(t *testing.T) {
+	if os.Getenv("TINYGOIZE_STREAM_INTERRUPT") == "1" {
+		s := newJSONStream(os.Stdout)
+		closeOnSignal(s)
+		s.add(StreamedPackage{PackageReport: PackageReport{Dir: "cmds/pass", Status: statusPassing}})
+		syscall.Kill(os.Getpid(), syscall.SIGINT)
+		select {}
+	}
+
+	c := exec.Command(os.Args[0], "-test.run=^TestStreamInterrupt$")
+	c.Env = append(os.Environ(), "TINYGOIZE_STREAM_INTERRUPT=1")
+	out, err := c.Output()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Sys().(syscall.WaitStatus).Signal() != syscall.SIGINT {
+		t.Fatalf("interrupted process: %v, want death by SIGINT", err)
+	}
+	var got []StreamedPackage
+	if err := json.Unmarshal(out, &got); err != nil || len(got) != 1 || got[0].Dir != "cmds/pass" {
+		t.Errorf("interrupted stream = %q (%v), want an array of cmds/pass", out, err)
+	}
+}
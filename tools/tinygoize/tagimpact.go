@@ -0,0 +1,95 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// tagImpact is the effect of one of the addBuildTags of a command on its
+// build.
+type tagImpact struct {
+	dir, tag string
+	// with and without are set if the command builds with and without the
+	// tag.
+	with, without bool
+}
+
+// tagImpacts builds each command of status given tags by addBuildTags once
+// without each of its tags, and compares the outcome with that of the build
+// with all of them. The binaries of these builds are not kept.
+func tagImpacts(cfg config, status BuildStatus) []tagImpact {
+	var impacts []tagImpact
+	for _, set := range [][]BuildResult{status.passing, status.passingWarnings, status.failing} {
+		for _, br := range set {
+			for _, tag := range br.tags {
+				// Warnings are no build failure here.
+				with := br.err == nil || errors.Is(br.err, errWarnings)
+				impacts = append(impacts, tagImpact{dir: br.dir, tag: tag, with: with})
+			}
+		}
+	}
+	sort.Slice(impacts, func(i, j int) bool {
+		if impacts[i].tag != impacts[j].tag {
+			return impacts[i].tag < impacts[j].tag
+		}
+		return impacts[i].dir < impacts[j].dir
+	})
+
+	cfg.outDir = ""
+	sem := make(chan struct{}, cfg.jobs)
+	var wg sync.WaitGroup
+	for i := range impacts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(ti *tagImpact) {
+			defer func() { <-sem; wg.Done() }()
+			tags := slices.DeleteFunc(slices.Clone(buildTags(ti.dir)), func(t string) bool { return t == ti.tag })
+			ti.without = buildWithTags(cfg, ti.dir, tags).err == nil
+		}(&impacts[i])
+	}
+	wg.Wait()
+	return impacts
+}
+
+// writeTagImpact writes the Tag impact section: for each tag, the commands
+// it lets build or breaks. A tag changing no outcome may be obsolete.
+func writeTagImpact(b *strings.Builder, impacts []tagImpact) {
+	fmt.Fprintf(b, "\n## Tag impact\n\n")
+	if len(impacts) == 0 {
+		fmt.Fprintf(b, "No command was built with additional tags.\n")
+		return
+	}
+	for i := 0; i < len(impacts); {
+		tag := impacts[i].tag
+		var total int
+		var needed, broken []string
+		for ; i < len(impacts) && impacts[i].tag == tag; i++ {
+			ti := impacts[i]
+			total++
+			switch {
+			case ti.with && !ti.without:
+				needed = append(needed, filepath.ToSlash(ti.dir))
+			case !ti.with && ti.without:
+				broken = append(broken, filepath.ToSlash(ti.dir))
+			}
+		}
+		if len(needed) > 0 {
+			fmt.Fprintf(b, " - `%s` lets %d of %d commands build: %s\n", tag, len(needed), total, strings.Join(needed, ", "))
+		}
+		if len(broken) > 0 {
+			fmt.Fprintf(b, " - `%s` breaks the build of %d of %d commands: %s\n", tag, len(broken), total, strings.Join(broken, ", "))
+		}
+		if len(needed) == 0 && len(broken) == 0 {
+			fmt.Fprintf(b, " - `%s` changes the outcome of none of %d commands and may be obsolete\n", tag, total)
+		}
+	}
+}
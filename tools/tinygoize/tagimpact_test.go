@@ -0,0 +1,63 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunTagImpact(t *testing.T) {
+	_, cfg := testTree(t)
+	cfg.checkOnly = true
+	cfg.tagImpact = true
+	if err := os.MkdirAll("cmds/asm", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("cmds/asm/main.go", []byte(copyright+"\npackage main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("cmds/asm/NEEDTAG", []byte("noasm"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	addBuildTags["asm"] = []string{"noasm"}
+	addBuildTags["pass"] = []string{"noasm", "purego"}
+	t.Cleanup(func() {
+		delete(addBuildTags, "asm")
+		delete(addBuildTags, "pass")
+	})
+
+	var stdout bytes.Buffer
+	run(cfg, []string{"cmds/asm", "cmds/pass", "cmds/fail"}, &stdout, io.Discard)
+	want := "\n## Tag impact\n\n" +
+		" - `noasm` lets 1 of 2 commands build: cmds/asm\n" +
+		" - `purego` changes the outcome of none of 1 commands and may be obsolete\n"
+	if !strings.Contains(stdout.String(), want) {
+		t.Errorf("markdown lacks %q:\n%s", want, &stdout)
+	}
+}
+
+func TestWriteTagImpact(t *testing.T) {
+	var b strings.Builder
+	writeTagImpact(&b, []tagImpact{
+		{dir: "cmds/a", tag: "netgo", with: false, without: true},
+		{dir: "cmds/b", tag: "netgo", with: true, without: false},
+	})
+	want := "\n## Tag impact\n\n" +
+		" - `netgo` lets 1 of 2 commands build: cmds/b\n" +
+		" - `netgo` breaks the build of 1 of 2 commands: cmds/a\n"
+	if b.String() != want {
+		t.Errorf("writeTagImpact() = %q, want %q", b.String(), want)
+	}
+
+	b.Reset()
+	writeTagImpact(&b, nil)
+	if want := "\n## Tag impact\n\nNo command was built with additional tags.\n"; b.String() != want {
+		t.Errorf("writeTagImpact(nil) = %q, want %q", b.String(), want)
+	}
+}
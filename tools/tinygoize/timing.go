@@ -0,0 +1,85 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// repeatBuild builds dir cfg.repeat-1 more times after br, recording the
+// duration of each build, see -repeat. The outcome of br stands: only the
+// timings of the further builds are kept, unless one runs out of disk
+// space.
+func repeatBuild(cfg config, dir string, br BuildResult) BuildResult {
+	br.durations = []time.Duration{br.duration}
+	for i := 1; i < cfg.repeat; i++ {
+		r := build(cfg, dir)
+		if errors.Is(r.err, errNoSpace) {
+			return r
+		}
+		br.durations = append(br.durations, r.duration)
+	}
+	return br
+}
+
+// BuildTimes are the statistics of the durations of the builds of a
+// package, see -repeat.
+type BuildTimes struct {
+	Builds        int     `json:"builds"`
+	MinSeconds    float64 `json:"min_seconds"`
+	MedianSeconds float64 `json:"median_seconds"`
+	MaxSeconds    float64 `json:"max_seconds"`
+}
+
+// buildTimes returns the statistics of durations, or nil if there are none.
+// The median of an even number of durations is the mean of the middle two.
+func buildTimes(durations []time.Duration) *BuildTimes {
+	if len(durations) == 0 {
+		return nil
+	}
+	d := slices.Clone(durations)
+	slices.Sort(d)
+	median := d[len(d)/2]
+	if len(d)%2 == 0 {
+		median = (d[len(d)/2-1] + median) / 2
+	}
+	return &BuildTimes{
+		Builds:        len(d),
+		MinSeconds:    d[0].Seconds(),
+		MedianSeconds: median.Seconds(),
+		MaxSeconds:    d[len(d)-1].Seconds(),
+	}
+}
+
+// writeBuildTimes writes the Build times section: the minimum, median, and
+// maximum build duration of each command built repeatedly.
+func writeBuildTimes(b *strings.Builder, repeat int, status BuildStatus) {
+	var built []BuildResult
+	for _, set := range resultSets(status) {
+		for _, br := range set.results {
+			if len(br.durations) > 0 {
+				built = append(built, br)
+			}
+		}
+	}
+	sort.Slice(built, func(i, j int) bool { return built[i].dir < built[j].dir })
+
+	fmt.Fprintf(b, "\n## Build times (%d builds each)\n\n", repeat)
+	if len(built) == 0 {
+		fmt.Fprintf(b, "No command was built.\n")
+		return
+	}
+	fmt.Fprintf(b, "| Command | Min | Median | Max |\n|---|---|---|---|\n")
+	for _, br := range built {
+		bt := buildTimes(br.durations)
+		fmt.Fprintf(b, "| %s | %.2fs | %.2fs | %.2fs |\n", filepath.ToSlash(br.dir), bt.MinSeconds, bt.MedianSeconds, bt.MaxSeconds)
+	}
+}
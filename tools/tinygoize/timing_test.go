@@ -0,0 +1,72 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildTimes(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		durations []time.Duration
+		want      *BuildTimes
+	}{
+		{"none", nil, nil},
+		{"one", []time.Duration{2 * time.Second}, &BuildTimes{Builds: 1, MinSeconds: 2, MedianSeconds: 2, MaxSeconds: 2}},
+		{"odd", []time.Duration{3 * time.Second, time.Second, 2 * time.Second}, &BuildTimes{Builds: 3, MinSeconds: 1, MedianSeconds: 2, MaxSeconds: 3}},
+		{"even", []time.Duration{4 * time.Second, time.Second, 2 * time.Second, 3 * time.Second}, &BuildTimes{Builds: 4, MinSeconds: 1, MedianSeconds: 2.5, MaxSeconds: 4}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildTimes(tt.durations); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildTimes(%v) = %+v, want %+v", tt.durations, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunRepeat(t *testing.T) {
+	_, cfg := testTree(t)
+	logFile := filepath.Join(t.TempDir(), "log")
+	t.Setenv("RAMP_LOG", logFile)
+	cfg.repeat, cfg.pathJSON, cfg.cachePath = 3, "report.json", "cache.json"
+
+	var b strings.Builder
+	if code := run(cfg, []string{"cmds/pass", "cmds/fail", "cmds/excluded"}, &b, io.Discard); code != exitOK {
+		t.Fatalf("run() = %d, want %d", code, exitOK)
+	}
+	// The cache is not used, so both packages are built three times.
+	if got := countBuilds(t, logFile); got != 6 {
+		t.Errorf("built %d times, want 6", got)
+	}
+	md := b.String()
+	for _, want := range []string{"## Build times (3 builds each)\n", "| cmds/fail | ", "| cmds/pass | "} {
+		if !strings.Contains(md, want) {
+			t.Errorf("report lacks %q:\n%s", want, md)
+		}
+	}
+	if strings.Contains(md, "| cmds/excluded |") {
+		t.Errorf("report times the excluded package:\n%s", md)
+	}
+
+	var r Report
+	if err := json.Unmarshal([]byte(readFile(t, cfg.pathJSON)), &r); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range r.Packages {
+		switch {
+		case p.Status == statusExcluded && p.BuildTimes != nil:
+			t.Errorf("%s: build times %+v, want none", p.Dir, p.BuildTimes)
+		case p.Status != statusExcluded && (p.BuildTimes == nil || p.BuildTimes.Builds != 3):
+			t.Errorf("%s: build times %+v, want 3 builds", p.Dir, p.BuildTimes)
+		}
+	}
+}